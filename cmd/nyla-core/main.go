@@ -1,32 +1,123 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/sunwolfengineering/nyla-core/internal/config"
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
 	"github.com/sunwolfengineering/nyla-core/internal/server"
 	"github.com/sunwolfengineering/nyla-core/internal/storage"
 )
 
+// Version is the build version, set at link time by the Makefile
+var Version = "dev"
+
+// commands are the one-off subcommands, run as nyla-core <command> [flags].
+// Without one, nyla-core serves.
+var commands = map[string]func(args []string) error{
+	"privacy": runPrivacy,
+	"scrub":   runScrub,
+}
+
 func main() {
-	var port = flag.String("port", "8080", "port to listen on")
-	flag.Parse()
+	args := os.Args[1:]
+	cmd := run
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			cmd, args = c, args[1:]
+		}
+	}
+	if err := cmd(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run starts the server and blocks until it is shut down by SIGINT/SIGTERM
+// or fails. Returning instead of exiting lets deferred cleanup run.
+func run(args []string) error {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
 
 	// Initialize database with built-in migrations
-	db, err := storage.NewDB("nyla.db")
+	db, err := storage.NewDBWithMigrations(cfg.Database.Path, cfg.Database.MigrationsPath)
 	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	defer db.Close()
 
+	// Record the active IP anonymization mode alongside the site's settings
+	if err := db.SetSiteSetting(context.Background(), "ip_anonymization", cfg.Privacy.IPMode()); err != nil {
+		return err
+	}
+
+	// privacy.retention_days governs events and sessions; other data types
+	// keep their own policies
+	for _, dataType := range []string{"events", "sessions"} {
+		if err := db.SetRetentionDays(context.Background(), dataType, cfg.Privacy.RetentionDays); err != nil {
+			return err
+		}
+	}
+
+	// An explicit consent_policy or respect_dnt replaces the stored policy;
+	// otherwise it is kept, so changes made in site_config survive restarts
+	if cfg.Privacy.ConsentExplicit() {
+		err = db.SetSiteSetting(context.Background(), "consent_policy", cfg.Privacy.Consent())
+	} else {
+		err = db.InitSiteSetting(context.Background(), "consent_policy", cfg.Privacy.Consent())
+	}
+	if err != nil {
+		return err
+	}
+
+	// Start background jobs; the rollup backfills missing days on its first run,
+	// the retention job purges expired data and the salt job destroys old
+	// visitor-hashing salts
+	scheduler := jobs.NewScheduler(cfg.Jobs.StuckAfter)
+	scheduler.Add(jobs.NewRollupJob(db), time.Hour)
+	scheduler.Add(jobs.NewRetentionJob(db), 24*time.Hour)
+	scheduler.Add(jobs.NewSaltJob(db), time.Hour)
+	scheduler.Start(context.Background())
+	// Deferred after db.Close so jobs stop before the database closes
+	defer scheduler.Stop()
+
 	// Create unified server
-	srv := server.New(db)
+	srv := server.New(db, cfg, scheduler, Version)
 
-	fmt.Printf("🚀 nyla-core server starting on port %s\n", *port)
-	fmt.Printf("📊 Dashboard: http://localhost:%s\n", *port)
-	fmt.Printf("🔗 API: http://localhost:%s/api/v1\n", *port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Fatal(http.ListenAndServe(":"+*port, srv))
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	fmt.Printf("🚀 nyla-core server starting on port %d\n", cfg.Server.Port)
+	fmt.Printf("📊 Dashboard: http://localhost:%d\n", cfg.Server.Port)
+	fmt.Printf("🔗 API: http://localhost:%d/api/v1\n", cfg.Server.Port)
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, draining in-flight requests")
+	if err := srv.Shutdown(context.Background()); err != nil {
+		return fmt.Errorf("failed to shut down cleanly: %w", err)
+	}
+	log.Println("Server stopped")
+	return nil
 }
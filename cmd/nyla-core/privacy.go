@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/config"
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+const privacyUsage = "usage: nyla-core privacy find|export|delete [-session ID] [-url PATTERN] [-from TIME] [-to TIME]"
+
+// runPrivacy handles data subject requests: it finds, exports or deletes
+// the events and sessions matching a session ID, URL pattern or time range.
+// Every action is recorded in privacy_logs.
+func runPrivacy(args []string) error {
+	if len(args) == 0 {
+		return errors.New(privacyUsage)
+	}
+	action := args[0]
+	if action != storage.PrivacyActionFind && action != storage.PrivacyActionExport && action != storage.PrivacyActionDelete {
+		return fmt.Errorf("unknown privacy action %q\n%s", action, privacyUsage)
+	}
+
+	fs := flag.NewFlagSet("nyla-core privacy "+action, flag.ContinueOnError)
+	sessionID := fs.String("session", "", "select a session by ID")
+	urlPattern := fs.String("url", "", "select sessions that viewed URLs matching a glob pattern, such as /users/jane*")
+	from := fs.String("from", "", "select data from this RFC3339 time or YYYY-MM-DD date")
+	to := fs.String("to", "", "select data before this RFC3339 time, or up to and including this YYYY-MM-DD date")
+	output := fs.String("o", "", "export: write the JSON export to this file instead of stdout")
+	yes := fs.Bool("yes", false, "delete: confirm the deletion; without it the matching data is only counted")
+	cfg, err := config.LoadCommand(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	sel := storage.DataSelector{SessionID: *sessionID, URL: *urlPattern}
+	if sel.From, err = parseSelectorTime(*from, false); err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	if sel.To, err = parseSelectorTime(*to, true); err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if err := sel.Validate(); err != nil {
+		return err
+	}
+
+	db, err := storage.NewDBWithMigrations(cfg.Database.Path, cfg.Database.MigrationsPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	switch {
+	case action == storage.PrivacyActionExport:
+		export, err := db.ExportData(ctx, sel)
+		if err != nil {
+			return fmt.Errorf("failed to export data: %w", err)
+		}
+		var w io.Writer = os.Stdout
+		if *output != "" {
+			f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+			if err != nil {
+				return fmt.Errorf("failed to create export file: %w", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+		if *output != "" {
+			fmt.Printf("Exported %d events and %d sessions to %s\n", len(export.Events), len(export.Sessions), *output)
+		}
+		return nil
+
+	case action == storage.PrivacyActionDelete && *yes:
+		match, err := db.DeleteData(ctx, sel)
+		if err != nil {
+			return fmt.Errorf("failed to delete data: %w", err)
+		}
+		if err := printMatch(match); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %d events and %d sessions\n", match.Events, match.Sessions)
+		return nil
+
+	default:
+		match, err := db.FindData(ctx, sel)
+		if err != nil {
+			return fmt.Errorf("failed to find data: %w", err)
+		}
+		if err := printMatch(match); err != nil {
+			return err
+		}
+		if action == storage.PrivacyActionDelete {
+			fmt.Println("Nothing was deleted: re-run with -yes to delete this data")
+		}
+		return nil
+	}
+}
+
+// parseSelectorTime parses an RFC3339 time or a YYYY-MM-DD date in UTC.
+// When end is set, a date selects up to the end of that day.
+func parseSelectorTime(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, errors.New("want an RFC3339 time or a YYYY-MM-DD date")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// printMatch prints the counts of a find or delete
+func printMatch(match *storage.DataMatch) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "EVENTS\t%d\n", match.Events)
+	fmt.Fprintf(w, "SESSIONS\t%d\n", match.Sessions)
+	if match.FirstSeen != nil {
+		fmt.Fprintf(w, "FIRST SEEN\t%s\n", match.FirstSeen.Format(time.RFC3339))
+		fmt.Fprintf(w, "LAST SEEN\t%s\n", match.LastSeen.Format(time.RFC3339))
+	}
+	for _, day := range match.Days {
+		fmt.Fprintf(w, "ADJUSTED\t%s\n", day)
+	}
+	return w.Flush()
+}
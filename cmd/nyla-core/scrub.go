@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sunwolfengineering/nyla-core/internal/config"
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// runScrub rewrites stored URLs, referrers, titles and campaigns with the
+// configured scrubber, for data collected before scrubbing was enabled or
+// tightened. Changes are recorded in privacy_logs.
+func runScrub(args []string) error {
+	fs := flag.NewFlagSet("nyla-core scrub", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "count the values that would change without writing them")
+	cfg, err := config.LoadCommand(fs, args)
+	if err != nil {
+		return err
+	}
+	scrubber, err := cfg.Privacy.Scrubber()
+	if err != nil {
+		return err
+	}
+
+	db, err := storage.NewDBWithMigrations(cfg.Database.Path, cfg.Database.MigrationsPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	results, err := db.RewriteStoredText(context.Background(), scrubber, *dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to scrub stored data: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COLUMN\tSCANNED\tCHANGED")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s.%s\t%d\t%d\n", r.Table, r.Column, r.Scanned, r.Changed)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Println("Dry run: nothing was written")
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/bots"
+	"github.com/sunwolfengineering/nyla-core/pkg/clientip"
+	"github.com/sunwolfengineering/nyla-core/pkg/geo"
+	"github.com/sunwolfengineering/nyla-core/pkg/privacy"
+	"github.com/sunwolfengineering/nyla-core/pkg/referrer"
+)
+
+// Config is the complete nyla-core configuration. It mirrors the config.yaml
+// schema in specs/deployment.md.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Site      SiteConfig      `yaml:"site"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Security  SecurityConfig  `yaml:"security"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Privacy   PrivacyConfig   `yaml:"privacy"`
+	Sessions  SessionsConfig  `yaml:"sessions"`
+	Jobs      JobsConfig      `yaml:"jobs"`
+	Referrers ReferrersConfig `yaml:"referrers"`
+	Bots      BotsConfig      `yaml:"bots"`
+	GeoIP     GeoIPConfig     `yaml:"geoip"`
+	// Logging is accepted so config files written to the original schema
+	// still load, but it is not implemented; see Unimplemented
+	Logging LoggingConfig `yaml:"logging"`
+}
+
+// ServerConfig holds HTTP server settings
+type ServerConfig struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// APIBaseURL is the base URL the dashboard uses to reach the API
+	APIBaseURL string `yaml:"api_base_url"`
+	// TrustedProxies lists the CIDR ranges of reverse proxies whose
+	// forwarding headers are believed. Requests from other peers are
+	// attributed to the peer.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// ClientIPHeaders enables headers, in order of preference, that name
+	// the client directly: Forwarded, CF-Connecting-IP, True-Client-IP or
+	// X-Real-IP. X-Forwarded-For is always used.
+	ClientIPHeaders []string `yaml:"client_ip_headers"`
+}
+
+// Addr returns the host:port the server listens on
+func (c ServerConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// ClientIP returns the resolver for client addresses behind TrustedProxies
+func (c ServerConfig) ClientIP() (*clientip.Resolver, error) {
+	trusted, err := clientip.ParseNetworks(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return clientip.New(trusted, c.ClientIPHeaders)
+}
+
+// SiteConfig describes the tracked site
+type SiteConfig struct {
+	// Domains are the site's own hostnames. Referrers from them or their
+	// subdomains are internal and dropped.
+	Domains []string `yaml:"domains"`
+}
+
+// DatabaseConfig holds SQLite settings
+type DatabaseConfig struct {
+	Path           string `yaml:"path"`
+	MigrationsPath string `yaml:"migrations_path"`
+	// Backup and VacuumInterval are accepted but not implemented
+	Backup         BackupConfig  `yaml:"backup"`
+	VacuumInterval time.Duration `yaml:"vacuum_interval"`
+}
+
+// BackupConfig holds database backup settings. Backups are not implemented.
+type BackupConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Retain   int           `yaml:"retain"`
+}
+
+// SecurityConfig holds credentials and origin restrictions
+type SecurityConfig struct {
+	APIKey         string   `yaml:"api_key"`
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// EncryptionKey and RateLimits are accepted but not implemented
+	EncryptionKey string           `yaml:"encryption_key"`
+	RateLimits    RateLimitsConfig `yaml:"rate_limits"`
+}
+
+// RateLimitsConfig holds rate limits in "<count>/<unit>" form. Rate limiting
+// is not implemented.
+type RateLimitsConfig struct {
+	Collect string `yaml:"collect"`
+	Query   string `yaml:"query"`
+}
+
+// CORSConfig holds the CORS headers sent to browsers. Allowed origins come
+// from security.allowed_origins.
+type CORSConfig struct {
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+}
+
+// PrivacyConfig holds privacy defaults
+type PrivacyConfig struct {
+	IPAnonymization string `yaml:"ip_anonymization"`
+	// RetentionDays is how long events and sessions are kept. It is
+	// written to their retention_policies at startup; 0 keeps them forever.
+	RetentionDays int `yaml:"retention_days"`
+	// RespectDNT picks the consent policy when ConsentPolicy is empty: drop
+	// when true, ignore when false. It is nil unless set explicitly, which
+	// overwrites the stored policy at startup like ConsentPolicy does.
+	RespectDNT *bool `yaml:"respect_dnt"`
+	// ConsentPolicy handles hits sent with Do Not Track or Global Privacy
+	// Control: drop, anonymize or ignore. When set it overwrites
+	// consent_policy in site_config.settings at startup; when empty the
+	// setting follows RespectDNT.
+	ConsentPolicy string `yaml:"consent_policy"`
+	// PIIPatterns names the detectors redacting personal data in URLs,
+	// referrers and titles: email, phone and credit_card
+	PIIPatterns []string `yaml:"pii_patterns"`
+	// QueryDenylist names query parameters whose values are redacted
+	QueryDenylist []string `yaml:"query_denylist"`
+	// QueryAllowlist names query parameters exempt from the denylist and
+	// kept by StripQuery
+	QueryAllowlist []string `yaml:"query_allowlist"`
+	// StripQuery removes every query parameter except utm_*, ref, source
+	// and the allowlist
+	StripQuery bool `yaml:"strip_query"`
+	// StoreUserAgent keeps the raw user agent in event metadata. It is off by
+	// default because the full string helps fingerprint visitors.
+	StoreUserAgent bool `yaml:"store_user_agent"`
+}
+
+// IPMode returns the parsed IP anonymization mode. Validate rejects unknown
+// modes, so invalid values fall back to truncation.
+func (p PrivacyConfig) IPMode() privacy.IPMode {
+	mode, err := privacy.ParseIPMode(p.IPAnonymization)
+	if err != nil {
+		return privacy.IPModeTruncate
+	}
+	return mode
+}
+
+// Consent returns the parsed consent policy. An empty policy follows
+// RespectDNT: ignore when it is false, drop otherwise. Validate rejects
+// unknown policies, so invalid values fall back to dropping.
+func (p PrivacyConfig) Consent() privacy.ConsentPolicy {
+	if p.ConsentPolicy == "" {
+		if p.RespectDNT != nil && !*p.RespectDNT {
+			return privacy.ConsentIgnore
+		}
+		return privacy.ConsentDrop
+	}
+	policy, err := privacy.ParseConsentPolicy(p.ConsentPolicy)
+	if err != nil {
+		return privacy.ConsentDrop
+	}
+	return policy
+}
+
+// ConsentExplicit reports whether the consent policy was configured, through
+// ConsentPolicy or RespectDNT, rather than left at its default
+func (p PrivacyConfig) ConsentExplicit() bool {
+	return p.ConsentPolicy != "" || p.RespectDNT != nil
+}
+
+// Scrubber returns the scrubber for URLs, referrers and titles
+func (p PrivacyConfig) Scrubber() (*privacy.Scrubber, error) {
+	return privacy.NewScrubber(privacy.ScrubOptions{
+		Patterns:       p.PIIPatterns,
+		QueryDenylist:  p.QueryDenylist,
+		QueryAllowlist: p.QueryAllowlist,
+		StripQuery:     p.StripQuery,
+	})
+}
+
+// SessionsConfig holds sessionization settings
+type SessionsConfig struct {
+	// Timeout is the inactivity period after which a visitor's next hit
+	// starts a new session
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// JobsConfig holds background job settings
+type JobsConfig struct {
+	// StuckAfter is how long a job run may last before /health reports the
+	// job stuck and the service unhealthy
+	StuckAfter time.Duration `yaml:"stuck_after"`
+}
+
+// ReferrersConfig holds referrer classification settings
+type ReferrersConfig struct {
+	// SourcesFile names a JSON source list that extends the built-in one
+	SourcesFile string `yaml:"sources_file"`
+}
+
+// Sources returns the built-in referrer source list extended with
+// SourcesFile, if set
+func (r ReferrersConfig) Sources() (referrer.List, error) {
+	if r.SourcesFile == "" {
+		return referrer.DefaultList(), nil
+	}
+	return referrer.LoadList(r.SourcesFile)
+}
+
+// BotsConfig holds bot filtering settings
+type BotsConfig struct {
+	// Mode is drop, store or off
+	Mode string `yaml:"mode"`
+	// IPRanges and IPRangesFile list CIDR ranges, such as datacenters,
+	// whose hits are treated as bots
+	IPRanges     []string `yaml:"ip_ranges"`
+	IPRangesFile string   `yaml:"ip_ranges_file"`
+}
+
+// BotMode returns the parsed bot filtering mode. Validate rejects unknown
+// modes, so invalid values fall back to dropping bots.
+func (b BotsConfig) BotMode() bots.Mode {
+	mode, err := bots.ParseMode(b.Mode)
+	if err != nil {
+		return bots.ModeDrop
+	}
+	return mode
+}
+
+// Ranges returns IPRanges followed by the ranges in IPRangesFile, if set
+func (b BotsConfig) Ranges() ([]*net.IPNet, error) {
+	ranges, err := bots.ParseRanges(b.IPRanges)
+	if err != nil {
+		return nil, err
+	}
+	if b.IPRangesFile == "" {
+		return ranges, nil
+	}
+	fromFile, err := bots.LoadRanges(b.IPRangesFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(ranges, fromFile...), nil
+}
+
+// GeoIPConfig holds GeoIP lookup settings. The local database is tried
+// first; the HTTP provider is a fallback used only when Host is set.
+type GeoIPConfig struct {
+	// Database is the path of a MaxMind or DB-IP MMDB file
+	Database string `yaml:"database"`
+	Proto    string `yaml:"proto"`
+	Host     string `yaml:"host"`
+	// Timeout bounds each HTTP lookup
+	Timeout time.Duration `yaml:"timeout"`
+	// CacheSize and CacheTTL bound the cache of HTTP lookups. A CacheSize
+	// of 0 disables caching.
+	CacheSize int           `yaml:"cache_size"`
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+}
+
+// Client returns the HTTP provider, or nil if Host is unset
+func (g GeoIPConfig) Client() *geo.Client {
+	if g.Host == "" {
+		return nil
+	}
+	cacheSize := g.CacheSize
+	if cacheSize == 0 {
+		// geo.Config treats 0 as unset and negative sizes as off
+		cacheSize = -1
+	}
+	return geo.NewClient(geo.Config{
+		Proto:     g.Proto,
+		Host:      g.Host,
+		Timeout:   g.Timeout,
+		CacheSize: cacheSize,
+		CacheTTL:  g.CacheTTL,
+	})
+}
+
+// LoggingConfig holds log output settings. Logs are always written as text
+// to stderr; these settings are not implemented.
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	Output string `yaml:"output"`
+}
+
+// Unimplemented returns the keys that are set but have no effect, in
+// schema order. They are kept so existing config files still load.
+func (c *Config) Unimplemented() []string {
+	var keys []string
+	for _, k := range []struct {
+		key string
+		set bool
+	}{
+		{"database.backup", c.Database.Backup != BackupConfig{}},
+		{"database.vacuum_interval", c.Database.VacuumInterval != 0},
+		{"security.encryption_key", c.Security.EncryptionKey != ""},
+		{"security.rate_limits", c.Security.RateLimits != RateLimitsConfig{}},
+		{"logging", c.Logging != LoggingConfig{}},
+	} {
+		if k.set {
+			keys = append(keys, k.key)
+		}
+	}
+	return keys
+}
+
+// Default returns the built-in configuration used before the config file,
+// environment and flags are applied
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            8080,
+			ReadTimeout:     5 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+			APIBaseURL:      "https://api.localhost",
+			TrustedProxies:  append([]string(nil), clientip.DefaultTrustedProxies...),
+		},
+		Database: DatabaseConfig{
+			Path:           "nyla.db",
+			MigrationsPath: "migrations",
+		},
+		Security: SecurityConfig{
+			AllowedOrigins: []string{"https://localhost"},
+		},
+		CORS: CORSConfig{
+			AllowedHeaders:   []string{"Content-Type", "HX-Request", "HX-Target", "HX-Current-URL", "HX-Trigger", "HX-Trigger-Name", "HX-History-Restore-Request"},
+			ExposedHeaders:   []string{"HX-Redirect", "HX-Location", "HX-Push", "HX-Refresh", "HX-Trigger", "HX-Trigger-After-Settle", "HX-Trigger-After-Swap"},
+			AllowCredentials: true,
+		},
+		Privacy: PrivacyConfig{
+			IPAnonymization: string(privacy.IPModeTruncate),
+			RetentionDays:   90,
+			PIIPatterns:     []string{"email", "phone", "credit_card"},
+			QueryDenylist:   append([]string(nil), privacy.DefaultQueryDenylist...),
+		},
+		Sessions: SessionsConfig{
+			Timeout: 30 * time.Minute,
+		},
+		Jobs: JobsConfig{
+			StuckAfter: 6 * time.Hour,
+		},
+		Bots: BotsConfig{
+			Mode: string(bots.ModeDrop),
+		},
+		GeoIP: GeoIPConfig{
+			Proto:     "http",
+			Timeout:   geo.DefaultTimeout,
+			CacheSize: geo.DefaultCacheSize,
+			CacheTTL:  geo.DefaultCacheTTL,
+		},
+	}
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		addf("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"sessions.timeout", c.Sessions.Timeout},
+		{"jobs.stuck_after", c.Jobs.StuckAfter},
+		{"geoip.timeout", c.GeoIP.Timeout},
+		{"geoip.cache_ttl", c.GeoIP.CacheTTL},
+	} {
+		if d.value <= 0 {
+			addf("%s must be positive, got %s", d.name, d.value)
+		}
+	}
+	if _, err := clientip.ParseNetworks(c.Server.TrustedProxies); err != nil {
+		addf("server.trusted_proxies: %v", err)
+	}
+	if _, err := clientip.New(nil, c.Server.ClientIPHeaders); err != nil {
+		addf("server.client_ip_headers: %v", err)
+	}
+	if c.Database.Path == "" {
+		addf("database.path is required")
+	}
+	if _, err := privacy.ParseIPMode(c.Privacy.IPAnonymization); err != nil {
+		addf("privacy.ip_anonymization: %v", err)
+	}
+	if c.Privacy.ConsentPolicy != "" {
+		if _, err := privacy.ParseConsentPolicy(c.Privacy.ConsentPolicy); err != nil {
+			addf("privacy.consent_policy: %v", err)
+		}
+	}
+	if c.Privacy.RetentionDays < 0 {
+		addf("privacy.retention_days must not be negative, got %d", c.Privacy.RetentionDays)
+	}
+	if _, err := c.Referrers.Sources(); err != nil {
+		addf("referrers.sources_file: %v", err)
+	}
+	if c.GeoIP.Database != "" {
+		if _, err := os.Stat(c.GeoIP.Database); err != nil {
+			addf("geoip.database: %v", err)
+		}
+	}
+	if c.GeoIP.CacheSize < 0 {
+		addf("geoip.cache_size must not be negative (0 disables caching), got %d", c.GeoIP.CacheSize)
+	}
+	if _, err := bots.ParseMode(c.Bots.Mode); err != nil {
+		addf("bots.mode: %v", err)
+	}
+	if _, err := c.Bots.Ranges(); err != nil {
+		addf("bots.ip_ranges: %v", err)
+	}
+	if _, err := c.Privacy.Scrubber(); err != nil {
+		addf("privacy.pii_patterns: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/bots"
+	"github.com/sunwolfengineering/nyla-core/pkg/privacy"
+)
+
+// env returns a lookup function backed by a map
+func env(vars map[string]string) lookupFunc {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+// writeConfigFile writes content to a config.yaml in a temporary directory
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+	assert.Equal(t, ":8080", cfg.Server.Addr())
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  host: 0.0.0.0
+  port: 3000
+  read_timeout: 5s
+  write_timeout: 10s
+  shutdown_timeout: 45s
+
+database:
+  path: /data/nyla.db
+
+security:
+  allowed_origins:
+    - https://app.getnyla.app
+    - https://dashboard.getnyla.app
+
+privacy:
+  ip_anonymization: false
+  retention_days: 30
+  pii_patterns:
+    - email
+
+sessions:
+  timeout: 45m
+
+jobs:
+  stuck_after: 12h
+
+site:
+  domains: [example.com, app.example.com]
+`)
+
+	cfg, err := load([]string{"-config", path}, env(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, "0.0.0.0:3000", cfg.Server.Addr())
+	assert.Equal(t, 45*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, 60*time.Second, cfg.Server.IdleTimeout, "Unset keys keep their defaults")
+	assert.Equal(t, "/data/nyla.db", cfg.Database.Path)
+	assert.Equal(t, []string{"https://app.getnyla.app", "https://dashboard.getnyla.app"}, cfg.Security.AllowedOrigins)
+	assert.Equal(t, privacy.IPModeNone, cfg.Privacy.IPMode(), "Boolean false still disables anonymization")
+	assert.Equal(t, 30, cfg.Privacy.RetentionDays)
+	assert.Equal(t, []string{"email"}, cfg.Privacy.PIIPatterns)
+	assert.Equal(t, 45*time.Minute, cfg.Sessions.Timeout)
+	assert.Equal(t, 12*time.Hour, cfg.Jobs.StuckAfter)
+	assert.Equal(t, []string{"example.com", "app.example.com"}, cfg.Site.Domains)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  port: 3000
+  write_timeout: 20s
+database:
+  path: /data/from-file.db
+`)
+
+	cfg, err := load(
+		[]string{"-port", "4000"},
+		env(map[string]string{
+			"NYLA_CONFIG":           path,
+			"NYLA_PORT":             "3500",
+			"NYLA_DB_PATH":          "/data/from-env.db",
+			"NYLA_ALLOWED_ORIGINS":  "https://a.example, https://b.example",
+			"NYLA_RESPECT_DNT":      "false",
+			"NYLA_IP_ANONYMIZATION": "drop",
+			"NYLA_STORE_USER_AGENT": "true",
+		}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4000, cfg.Server.Port, "Flags override env and file")
+	assert.Equal(t, "/data/from-env.db", cfg.Database.Path, "Env overrides file")
+	assert.Equal(t, 20*time.Second, cfg.Server.WriteTimeout, "File overrides defaults")
+	assert.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.Security.AllowedOrigins)
+	require.NotNil(t, cfg.Privacy.RespectDNT)
+	assert.False(t, *cfg.Privacy.RespectDNT)
+	assert.Equal(t, privacy.ConsentIgnore, cfg.Privacy.Consent(), "The consent policy follows respect_dnt")
+	assert.Equal(t, privacy.IPModeDrop, cfg.Privacy.IPMode())
+	assert.True(t, cfg.Privacy.StoreUserAgent)
+}
+
+func TestConsentPolicy(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, privacy.ConsentDrop, cfg.Privacy.Consent())
+	assert.False(t, cfg.Privacy.ConsentExplicit(), "The default leaves the stored policy alone")
+
+	cfg, err = load(nil, env(map[string]string{"NYLA_RESPECT_DNT": "true"}))
+	require.NoError(t, err)
+	assert.Equal(t, privacy.ConsentDrop, cfg.Privacy.Consent())
+	assert.True(t, cfg.Privacy.ConsentExplicit(), "An explicit respect_dnt overwrites the stored policy")
+
+	path := writeConfigFile(t, "privacy:\n  respect_dnt: false\n")
+	cfg, err = load([]string{"-config", path}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, privacy.ConsentIgnore, cfg.Privacy.Consent())
+	assert.True(t, cfg.Privacy.ConsentExplicit())
+
+	cfg, err = load(nil, env(map[string]string{
+		"NYLA_RESPECT_DNT":    "false",
+		"NYLA_CONSENT_POLICY": "Anonymize",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, privacy.ConsentAnonymize, cfg.Privacy.Consent(), "An explicit policy wins over respect_dnt")
+}
+
+func TestScrubbing(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	s, err := cfg.Privacy.Scrubber()
+	require.NoError(t, err)
+	assert.Equal(t, "/reset?token=REDACTED&page=2", s.URL("/reset?token=abc&page=2"))
+
+	cfg, err = load(nil, env(map[string]string{
+		"NYLA_PII_PATTERNS":    "email",
+		"NYLA_QUERY_DENYLIST":  "session",
+		"NYLA_QUERY_ALLOWLIST": "page",
+		"NYLA_STRIP_QUERY":     "true",
+	}))
+	require.NoError(t, err)
+	s, err = cfg.Privacy.Scrubber()
+	require.NoError(t, err)
+	assert.Equal(t, "/?page=2&utm_source=REDACTED", s.URL("/?token=abc&page=2&utm_source=jane@example.com"))
+}
+
+func TestLoadCommandFlags(t *testing.T) {
+	fs := flag.NewFlagSet("nyla-core scrub", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "")
+	cfg, err := loadFlags(fs, []string{"-db", "/data/other.db", "-dry-run"}, env(nil))
+	require.NoError(t, err)
+	assert.True(t, *dryRun)
+	assert.Equal(t, "/data/other.db", cfg.Database.Path)
+}
+
+func TestLoadLegacyEnv(t *testing.T) {
+	cfg, err := load(nil, env(map[string]string{
+		"API_BASE_URL":           "http://localhost:9876",
+		"CORS_ALLOWED_ORIGINS":   "http://localhost:8080",
+		"CORS_ALLOW_CREDENTIALS": "false",
+		"GEOIP_HOST":             "geo.internal:8080",
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "http://localhost:9876", cfg.Server.APIBaseURL)
+	assert.Equal(t, []string{"http://localhost:8080"}, cfg.Security.AllowedOrigins)
+	assert.False(t, cfg.CORS.AllowCredentials)
+	assert.Equal(t, "geo.internal:8080", cfg.GeoIP.Host)
+
+	// NYLA_* names win over legacy ones
+	cfg, err = load(nil, env(map[string]string{
+		"NYLA_API_BASE_URL": "https://api.example.com",
+		"API_BASE_URL":      "http://localhost:9876",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.example.com", cfg.Server.APIBaseURL)
+}
+
+func TestReferrerSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sources.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"referral": {"Partner": ["partner.io"]}}`), 0644))
+
+	cfg, err := load(nil, env(map[string]string{
+		"NYLA_REFERRER_SOURCES_FILE": path,
+		"NYLA_SITE_DOMAINS":          "example.com,www.example.com",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, cfg.Site.Domains)
+
+	sources, err := cfg.Referrers.Sources()
+	require.NoError(t, err)
+	_, ok := sources.Lookup("partner.io")
+	assert.True(t, ok)
+}
+
+func TestBots(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, bots.ModeDrop, cfg.Bots.BotMode())
+
+	path := filepath.Join(t.TempDir(), "ranges.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# Datacenter\n192.0.2.0/24\n"), 0644))
+
+	cfg, err = load(nil, env(map[string]string{
+		"NYLA_BOT_MODE":           "store",
+		"NYLA_BOT_IP_RANGES":      "203.0.113.0/24, 2001:db8::/32",
+		"NYLA_BOT_IP_RANGES_FILE": path,
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, bots.ModeStore, cfg.Bots.BotMode())
+
+	ranges, err := cfg.Bots.Ranges()
+	require.NoError(t, err)
+	require.Len(t, ranges, 3)
+	assert.Equal(t, "192.0.2.0/24", ranges[2].String())
+}
+
+func TestGeoIP(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Nil(t, cfg.GeoIP.Client(), "The HTTP provider is off unless a host is set")
+
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	cfg, err = load(nil, env(map[string]string{
+		"NYLA_GEOIP_DATABASE":   path,
+		"NYLA_GEOIP_HOST":       "geo.internal:8080",
+		"NYLA_GEOIP_TIMEOUT":    "500ms",
+		"NYLA_GEOIP_CACHE_SIZE": "100",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, path, cfg.GeoIP.Database)
+	assert.Equal(t, 500*time.Millisecond, cfg.GeoIP.Timeout)
+	assert.Equal(t, 100, cfg.GeoIP.CacheSize)
+	assert.NotNil(t, cfg.GeoIP.Client())
+}
+
+func TestGeoIPCacheOff(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"country_iso":"GB"}`))
+	}))
+	defer srv.Close()
+
+	cfg, err := load(nil, env(map[string]string{
+		"NYLA_GEOIP_HOST":       strings.TrimPrefix(srv.URL, "http://"),
+		"NYLA_GEOIP_CACHE_SIZE": "0",
+	}))
+	require.NoError(t, err)
+	client := cfg.GeoIP.Client()
+	for i := 0; i < 2; i++ {
+		_, err := client.Resolve(context.Background(), net.ParseIP("81.2.69.160"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, requests, "A cache_size of 0 disables caching")
+}
+
+func TestClientIP(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.0/8", "::1/128"}, cfg.Server.TrustedProxies)
+
+	cfg, err = load(nil, env(map[string]string{
+		"NYLA_TRUSTED_PROXIES":   "10.0.0.0/8, 173.245.48.0/20",
+		"NYLA_CLIENT_IP_HEADERS": "CF-Connecting-IP",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "173.245.48.0/20"}, cfg.Server.TrustedProxies)
+
+	r, err := cfg.Server.ClientIP()
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/api/v1/collect", nil)
+	req.RemoteAddr = "10.1.2.3:40000"
+	req.Header.Set("CF-Connecting-IP", "203.0.113.7")
+	ip, err := r.ClientIP(req)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip.String())
+}
+
+func TestUnimplementedKeys(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Unimplemented(), "Defaults set no unimplemented keys")
+
+	// Files written to the original schema still load
+	path := writeConfigFile(t, `
+database:
+  backup:
+    path: /backup
+    interval: 24h
+    retain: 7
+  vacuum_interval: 168h
+security:
+  encryption_key: xxx
+  rate_limits:
+    collect: 100/minute
+    query: 60/minute
+logging:
+  level: info
+  format: json
+  output: stdout
+`)
+	cfg, err = load([]string{"-config", path}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"database.backup", "database.vacuum_interval",
+		"security.encryption_key", "security.rate_limits", "logging",
+	}, cfg.Unimplemented())
+
+	cfg, err = load(nil, env(map[string]string{"NYLA_LOG_LEVEL": "debug"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"logging"}, cfg.Unimplemented())
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+	}{
+		{name: "Invalid port flag", args: []string{"-port", "70000"}},
+		{name: "Invalid env value", env: map[string]string{"NYLA_RETENTION_DAYS": "ninety"}},
+		{name: "Invalid timeout", env: map[string]string{"NYLA_SHUTDOWN_TIMEOUT": "0s"}},
+		{name: "Negative retention", env: map[string]string{"NYLA_RETENTION_DAYS": "-1"}},
+		{name: "Zero stuck threshold", env: map[string]string{"NYLA_JOB_STUCK_AFTER": "0"}},
+		{name: "Unknown PII pattern in env", env: map[string]string{"NYLA_PII_PATTERNS": "email,ssn"}},
+		{name: "Unknown consent policy", env: map[string]string{"NYLA_CONSENT_POLICY": "hash"}},
+		{name: "Unknown IP anonymization mode", env: map[string]string{"NYLA_IP_ANONYMIZATION": "hash"}},
+		{name: "Missing config file", args: []string{"-config", "/does/not/exist.yaml"}},
+		{name: "Unknown key in file", file: "server:\n  prot: 3000\n"},
+		{name: "Unknown PII pattern", file: "privacy:\n  pii_patterns: [ssn]\n"},
+		{name: "Missing referrer sources", env: map[string]string{"NYLA_REFERRER_SOURCES_FILE": "/does/not/exist.json"}},
+		{name: "Missing GeoIP database", env: map[string]string{"NYLA_GEOIP_DATABASE": "/does/not/exist.mmdb"}},
+		{name: "Invalid GeoIP timeout", env: map[string]string{"NYLA_GEOIP_TIMEOUT": "0s"}},
+		{name: "Negative GeoIP cache size", env: map[string]string{"NYLA_GEOIP_CACHE_SIZE": "-1"}},
+		{name: "Unknown bot mode", env: map[string]string{"NYLA_BOT_MODE": "tag"}},
+		{name: "Invalid bot IP range", file: "bots:\n  ip_ranges: [10.0.0.0/33]\n"},
+		{name: "Invalid trusted proxy", env: map[string]string{"NYLA_TRUSTED_PROXIES": "proxy.internal"}},
+		{name: "Unknown client IP header", file: "server:\n  client_ip_headers: [X-Client-IP]\n"},
+		{name: "Missing bot IP ranges file", env: map[string]string{"NYLA_BOT_IP_RANGES_FILE": "/does/not/exist.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append(args, "-config", writeConfigFile(t, tt.file))
+			}
+			_, err := load(args, env(tt.env))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValidateReportsProblemsInOrder(t *testing.T) {
+	_, err := load(nil, env(map[string]string{
+		"NYLA_GEOIP_TIMEOUT":    "0s",
+		"NYLA_READ_TIMEOUT":     "0s",
+		"NYLA_SESSION_TIMEOUT":  "-1m",
+		"NYLA_SHUTDOWN_TIMEOUT": "0s",
+	}))
+	require.Error(t, err)
+	assert.Equal(t, "invalid configuration: "+
+		"server.read_timeout must be positive, got 0s; "+
+		"server.shutdown_timeout must be positive, got 0s; "+
+		"sessions.timeout must be positive, got -1m0s; "+
+		"geoip.timeout must be positive, got 0s", err.Error())
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, in increasing order of precedence,
+// the built-in defaults, the YAML file named by -config or NYLA_CONFIG,
+// NYLA_* environment variables and command line flags in args
+func Load(args []string) (*Config, error) {
+	return load(args, os.LookupEnv)
+}
+
+// LoadCommand is Load for a subcommand with flags of its own. They are
+// defined on fs before the call and parsed alongside the configuration flags.
+func LoadCommand(fs *flag.FlagSet, args []string) (*Config, error) {
+	return loadFlags(fs, args, os.LookupEnv)
+}
+
+// lookupFunc matches os.LookupEnv so tests can supply their own environment
+type lookupFunc func(key string) (string, bool)
+
+func load(args []string, lookup lookupFunc) (*Config, error) {
+	return loadFlags(flag.NewFlagSet("nyla-core", flag.ContinueOnError), args, lookup)
+}
+
+func loadFlags(fs *flag.FlagSet, args []string, lookup lookupFunc) (*Config, error) {
+	cfg := Default()
+
+	configPath := fs.String("config", "", "path to config.yaml (env NYLA_CONFIG)")
+	host := fs.String("host", "", "interface to listen on")
+	port := fs.Int("port", 0, "port to listen on")
+	dbPath := fs.String("db", "", "path to the SQLite database")
+	readTimeout := fs.Duration("read-timeout", 0, "maximum duration for reading a request")
+	writeTimeout := fs.Duration("write-timeout", 0, "maximum duration for writing a response")
+	idleTimeout := fs.Duration("idle-timeout", 0, "maximum keep-alive idle time")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "maximum time to drain requests on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path, _ = lookup("NYLA_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(lookup); err != nil {
+		return nil, err
+	}
+
+	// Only flags given on the command line override earlier sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "host":
+			cfg.Server.Host = *host
+		case "port":
+			cfg.Server.Port = *port
+		case "db":
+			cfg.Database.Path = *dbPath
+		case "read-timeout":
+			cfg.Server.ReadTimeout = *readTimeout
+		case "write-timeout":
+			cfg.Server.WriteTimeout = *writeTimeout
+		case "idle-timeout":
+			cfg.Server.IdleTimeout = *idleTimeout
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = *shutdownTimeout
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	for _, key := range cfg.Unimplemented() {
+		log.Printf("Warning: %s is not implemented and has no effect", key)
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the YAML file at path onto cfg
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// envVar binds an environment variable to a config field. Legacy names are
+// still honoured so existing deployments keep working.
+type envVar struct {
+	names []string
+	set   func(c *Config, value string) error
+}
+
+var envVars = []envVar{
+	{[]string{"NYLA_HOST"}, func(c *Config, v string) error { c.Server.Host = v; return nil }},
+	{[]string{"NYLA_PORT", "PORT"}, func(c *Config, v string) error { return setInt(&c.Server.Port, v) }},
+	{[]string{"NYLA_READ_TIMEOUT"}, func(c *Config, v string) error { return setDuration(&c.Server.ReadTimeout, v) }},
+	{[]string{"NYLA_WRITE_TIMEOUT"}, func(c *Config, v string) error { return setDuration(&c.Server.WriteTimeout, v) }},
+	{[]string{"NYLA_IDLE_TIMEOUT"}, func(c *Config, v string) error { return setDuration(&c.Server.IdleTimeout, v) }},
+	{[]string{"NYLA_SHUTDOWN_TIMEOUT"}, func(c *Config, v string) error { return setDuration(&c.Server.ShutdownTimeout, v) }},
+	{[]string{"NYLA_API_BASE_URL", "API_BASE_URL"}, func(c *Config, v string) error { c.Server.APIBaseURL = v; return nil }},
+	{[]string{"NYLA_TRUSTED_PROXIES"}, func(c *Config, v string) error { c.Server.TrustedProxies = splitList(v); return nil }},
+	{[]string{"NYLA_CLIENT_IP_HEADERS"}, func(c *Config, v string) error { c.Server.ClientIPHeaders = splitList(v); return nil }},
+	{[]string{"NYLA_SITE_DOMAINS"}, func(c *Config, v string) error { c.Site.Domains = splitList(v); return nil }},
+	{[]string{"NYLA_DB_PATH"}, func(c *Config, v string) error { c.Database.Path = v; return nil }},
+	{[]string{"NYLA_MIGRATIONS_PATH"}, func(c *Config, v string) error { c.Database.MigrationsPath = v; return nil }},
+	{[]string{"NYLA_DB_BACKUP_PATH"}, func(c *Config, v string) error { c.Database.Backup.Path = v; return nil }},
+	{[]string{"NYLA_API_KEY"}, func(c *Config, v string) error { c.Security.APIKey = v; return nil }},
+	{[]string{"NYLA_ENCRYPTION_KEY"}, func(c *Config, v string) error { c.Security.EncryptionKey = v; return nil }},
+	{[]string{"NYLA_ALLOWED_ORIGINS", "CORS_ALLOWED_ORIGINS"}, func(c *Config, v string) error { c.Security.AllowedOrigins = splitList(v); return nil }},
+	{[]string{"NYLA_CORS_ALLOWED_HEADERS", "CORS_ALLOWED_HEADERS"}, func(c *Config, v string) error { c.CORS.AllowedHeaders = splitList(v); return nil }},
+	{[]string{"NYLA_CORS_EXPOSED_HEADERS", "CORS_EXPOSED_HEADERS"}, func(c *Config, v string) error { c.CORS.ExposedHeaders = splitList(v); return nil }},
+	{[]string{"NYLA_CORS_ALLOW_CREDENTIALS", "CORS_ALLOW_CREDENTIALS"}, func(c *Config, v string) error { return setBool(&c.CORS.AllowCredentials, v) }},
+	{[]string{"NYLA_IP_ANONYMIZATION"}, func(c *Config, v string) error { c.Privacy.IPAnonymization = v; return nil }},
+	{[]string{"NYLA_RETENTION_DAYS"}, func(c *Config, v string) error { return setInt(&c.Privacy.RetentionDays, v) }},
+	{[]string{"NYLA_RESPECT_DNT"}, func(c *Config, v string) error { return setBoolPtr(&c.Privacy.RespectDNT, v) }},
+	{[]string{"NYLA_PII_PATTERNS"}, func(c *Config, v string) error { c.Privacy.PIIPatterns = splitList(v); return nil }},
+	{[]string{"NYLA_QUERY_DENYLIST"}, func(c *Config, v string) error { c.Privacy.QueryDenylist = splitList(v); return nil }},
+	{[]string{"NYLA_QUERY_ALLOWLIST"}, func(c *Config, v string) error { c.Privacy.QueryAllowlist = splitList(v); return nil }},
+	{[]string{"NYLA_STRIP_QUERY"}, func(c *Config, v string) error { return setBool(&c.Privacy.StripQuery, v) }},
+	{[]string{"NYLA_CONSENT_POLICY"}, func(c *Config, v string) error { c.Privacy.ConsentPolicy = v; return nil }},
+	{[]string{"NYLA_STORE_USER_AGENT"}, func(c *Config, v string) error { return setBool(&c.Privacy.StoreUserAgent, v) }},
+	{[]string{"NYLA_SESSION_TIMEOUT"}, func(c *Config, v string) error { return setDuration(&c.Sessions.Timeout, v) }},
+	{[]string{"NYLA_JOB_STUCK_AFTER"}, func(c *Config, v string) error { return setDuration(&c.Jobs.StuckAfter, v) }},
+	{[]string{"NYLA_REFERRER_SOURCES_FILE"}, func(c *Config, v string) error { c.Referrers.SourcesFile = v; return nil }},
+	{[]string{"NYLA_BOT_MODE"}, func(c *Config, v string) error { c.Bots.Mode = v; return nil }},
+	{[]string{"NYLA_BOT_IP_RANGES"}, func(c *Config, v string) error { c.Bots.IPRanges = splitList(v); return nil }},
+	{[]string{"NYLA_BOT_IP_RANGES_FILE"}, func(c *Config, v string) error { c.Bots.IPRangesFile = v; return nil }},
+	{[]string{"NYLA_GEOIP_DATABASE"}, func(c *Config, v string) error { c.GeoIP.Database = v; return nil }},
+	{[]string{"NYLA_GEOIP_PROTO", "GEOIP_PROTO"}, func(c *Config, v string) error { c.GeoIP.Proto = v; return nil }},
+	{[]string{"NYLA_GEOIP_HOST", "GEOIP_HOST"}, func(c *Config, v string) error { c.GeoIP.Host = v; return nil }},
+	{[]string{"NYLA_GEOIP_TIMEOUT"}, func(c *Config, v string) error { return setDuration(&c.GeoIP.Timeout, v) }},
+	{[]string{"NYLA_GEOIP_CACHE_SIZE"}, func(c *Config, v string) error { return setInt(&c.GeoIP.CacheSize, v) }},
+	{[]string{"NYLA_GEOIP_CACHE_TTL"}, func(c *Config, v string) error { return setDuration(&c.GeoIP.CacheTTL, v) }},
+	{[]string{"NYLA_LOG_LEVEL"}, func(c *Config, v string) error { c.Logging.Level = v; return nil }},
+	{[]string{"NYLA_LOG_FORMAT"}, func(c *Config, v string) error { c.Logging.Format = v; return nil }},
+}
+
+// applyEnv overlays environment variables onto cfg. The first name of each
+// variable that is set wins.
+func (c *Config) applyEnv(lookup lookupFunc) error {
+	for _, ev := range envVars {
+		for _, name := range ev.names {
+			value, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := ev.set(c, value); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+func setBoolPtr(dst **bool, v string) error {
+	var b bool
+	if err := setBool(&b, v); err != nil {
+		return err
+	}
+	*dst = &b
+	return nil
+}
+
+func setDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+const (
+	// defaultPurgeChunkSize is the number of rows deleted per statement
+	defaultPurgeChunkSize = 1000
+	// defaultPurgePause is the pause between chunks that lets writers in
+	defaultPurgePause = 10 * time.Millisecond
+)
+
+// RetentionJob deletes events and sessions older than their retention
+// policy, records each purge in privacy_logs and then runs DB.Cleanup.
+// Raw data is only purged once its day has been rolled up so historical
+// stats survive the purge.
+type RetentionJob struct {
+	DB        *storage.DB
+	ChunkSize int
+	Pause     time.Duration
+	// Now returns the current time; defaults to time.Now
+	Now func() time.Time
+}
+
+// NewRetentionJob creates a retention job for db
+func NewRetentionJob(db *storage.DB) *RetentionJob {
+	return &RetentionJob{
+		DB:        db,
+		ChunkSize: defaultPurgeChunkSize,
+		Pause:     defaultPurgePause,
+		Now:       time.Now,
+	}
+}
+
+// Name implements Job
+func (j *RetentionJob) Name() string {
+	return "retention"
+}
+
+// Run implements Job
+func (j *RetentionJob) Run(ctx context.Context) error {
+	policies, err := j.DB.GetRetentionPolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	watermark, err := j.DB.RollupWatermark(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := j.Now()
+	for _, policy := range policies {
+		if policy.RetentionDays <= 0 {
+			continue // keep forever
+		}
+		if !storage.IsPurgeable(policy.DataType) {
+			log.Printf("Retention policy for unsupported data type %q ignored", policy.DataType)
+			continue
+		}
+		cutoff := policy.Cutoff(now)
+		if watermark.Before(cutoff) {
+			cutoff = watermark
+		}
+		deleted, err := j.purge(ctx, policy.DataType, cutoff)
+		if deleted > 0 {
+			if logErr := j.DB.LogPrivacyAction(ctx, "retention_purge", policy.DataType, "", map[string]interface{}{
+				"deleted":        deleted,
+				"retention_days": policy.RetentionDays,
+				"cutoff":         cutoff.Format(time.RFC3339),
+			}); logErr != nil && err == nil {
+				err = logErr
+			}
+			log.Printf("Retention purged %d %s older than %d days", deleted, policy.DataType, policy.RetentionDays)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return j.DB.Cleanup(ctx)
+}
+
+// purge deletes rows of dataType older than cutoff in chunks. It returns the number
+// of rows deleted even when it fails part way.
+func (j *RetentionJob) purge(ctx context.Context, dataType string, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		deleted, err := j.DB.PurgeChunk(ctx, dataType, cutoff, j.ChunkSize)
+		total += deleted
+		if err != nil {
+			return total, fmt.Errorf("retention purge: %w", err)
+		}
+		if deleted < int64(j.ChunkSize) {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(j.Pause):
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestRetentionJobPurgesExpiredData(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var events []*storage.Event
+	// Default policies keep 90 days of events and sessions
+	for i := 0; i < 5; i++ {
+		events = append(events, &storage.Event{
+			Type: "pageview", Timestamp: now.AddDate(0, 0, -100).Add(time.Duration(i) * time.Minute),
+			URL: "/old", SessionID: "expired", VisitorID: "expired",
+		})
+	}
+	events = append(events, &storage.Event{
+		Type: "pageview", Timestamp: now.AddDate(0, 0, -10), URL: "/recent", SessionID: "kept", VisitorID: "kept",
+	})
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	// Nothing is purged before its day has been rolled up
+	job := NewRetentionJob(db)
+	job.Now = func() time.Time { return now }
+	require.NoError(t, job.Run(ctx))
+	session, err := db.GetSessionByID(ctx, "expired")
+	require.NoError(t, err)
+	assert.NotNil(t, session, "Data that was not rolled up yet must be kept")
+
+	rollup := NewRollupJob(db)
+	rollup.Now = func() time.Time { return now }
+	require.NoError(t, rollup.Run(ctx))
+
+	job.ChunkSize = 2 // force several chunks
+	job.Pause = 0
+	require.NoError(t, job.Run(ctx))
+
+	session, err = db.GetSessionByID(ctx, "expired")
+	require.NoError(t, err)
+	assert.Nil(t, session, "Expired session should be purged")
+
+	session, err = db.GetSessionByID(ctx, "kept")
+	require.NoError(t, err)
+	assert.NotNil(t, session, "Recent session should be kept")
+
+	stats, err := db.GetHistoricalStats(ctx, now.AddDate(0, 0, -120), now, storage.ResolutionMonth)
+	require.NoError(t, err)
+	assert.Equal(t, 6, stats.Totals.Pageviews, "Purged days are still reported from aggregates")
+
+	logs, err := db.GetPrivacyLogs(ctx, "retention_purge", 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 2)
+
+	deleted := map[string]float64{}
+	for _, entry := range logs {
+		deleted[entry.DataType] = entry.Metadata["deleted"].(float64)
+		assert.Equal(t, float64(90), entry.Metadata["retention_days"])
+	}
+	assert.Equal(t, map[string]float64{"events": 5, "sessions": 1}, deleted)
+
+	// Nothing left to purge, so nothing is logged
+	require.NoError(t, job.Run(ctx))
+	logs, err = db.GetPrivacyLogs(ctx, "retention_purge", 10)
+	require.NoError(t, err)
+	assert.Len(t, logs, 2)
+}
@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// RollupJob writes daily_aggregates for finished days. Each run backfills
+// every finished day that has events but no aggregate, then recomputes
+// yesterday so events that arrive late are included.
+type RollupJob struct {
+	DB *storage.DB
+	// Now returns the current time; defaults to time.Now
+	Now func() time.Time
+}
+
+// NewRollupJob creates a rollup job for db
+func NewRollupJob(db *storage.DB) *RollupJob {
+	return &RollupJob{DB: db, Now: time.Now}
+}
+
+// Name implements Job
+func (j *RollupJob) Name() string {
+	return "rollup"
+}
+
+// Run implements Job
+func (j *RollupJob) Run(ctx context.Context) error {
+	today := storage.ResolutionDay.Truncate(j.Now())
+	yesterday := today.AddDate(0, 0, -1)
+
+	pending, err := j.DB.DaysPendingRollup(ctx, today)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 1 {
+		log.Printf("Rollup backfilling %d days from %s", len(pending), pending[0].Format("2006-01-02"))
+	}
+
+	// Pending days are oldest first, which keeps the rollup watermark valid
+	rolledYesterday := false
+	for _, day := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := j.DB.RollupDay(ctx, day); err != nil {
+			return fmt.Errorf("rollup of %s: %w", day.Format("2006-01-02"), err)
+		}
+		rolledYesterday = rolledYesterday || day.Equal(yesterday)
+	}
+
+	if !rolledYesterday {
+		if _, err := j.DB.RollupDay(ctx, yesterday); err != nil {
+			return fmt.Errorf("rollup of %s: %w", yesterday.Format("2006-01-02"), err)
+		}
+	}
+
+	return nil
+}
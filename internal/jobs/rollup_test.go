@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// setupTestDB opens a database in a temporary directory with the
+// repository's migrations applied
+func setupTestDB(t *testing.T) *storage.DB {
+	db, err := storage.NewDBWithMigrations(filepath.Join(t.TempDir(), "nyla.db"), filepath.Join("..", "..", "migrations"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRollupJobBackfillsFinishedDays(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	today := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	events := []*storage.Event{
+		{Type: "pageview", Timestamp: today.AddDate(0, 0, -4).Add(time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: today.AddDate(0, 0, -2).Add(time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "pageview", Timestamp: today.Add(time.Hour), URL: "/", SessionID: "c", VisitorID: "c"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	job := NewRollupJob(db)
+	job.Now = func() time.Time { return today.Add(2 * time.Hour) }
+	require.NoError(t, job.Run(ctx))
+
+	aggregates, err := db.GetDailyAggregates(ctx, today.AddDate(0, 0, -7), today.AddDate(0, 0, 1))
+	require.NoError(t, err)
+
+	var dates []string
+	for _, agg := range aggregates {
+		dates = append(dates, agg.Date.Format("2006-01-02"))
+	}
+	assert.Equal(t, []string{"2024-03-11", "2024-03-13", "2024-03-14"}, dates,
+		"Days with events plus yesterday are rolled up; today is not")
+
+	// Late event for yesterday is picked up on the next run
+	late := &storage.Event{Type: "pageview", Timestamp: today.Add(-time.Hour), URL: "/", SessionID: "d", VisitorID: "d"}
+	require.NoError(t, db.InsertEvent(ctx, late))
+	require.NoError(t, job.Run(ctx))
+
+	aggregates, err = db.GetDailyAggregates(ctx, today.AddDate(0, 0, -1), today)
+	require.NoError(t, err)
+	require.Len(t, aggregates, 1)
+	assert.Equal(t, 1, aggregates[0].Pageviews)
+}
@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// SaltJob rotates the daily visitor-hashing salt. Each run creates today's
+// salt if needed and destroys every salt older than yesterday, recording the
+// destruction in privacy_logs.
+type SaltJob struct {
+	DB *storage.DB
+	// Now returns the current time; defaults to time.Now
+	Now func() time.Time
+}
+
+// NewSaltJob creates a salt rotation job for db
+func NewSaltJob(db *storage.DB) *SaltJob {
+	return &SaltJob{DB: db, Now: time.Now}
+}
+
+// Name implements Job
+func (j *SaltJob) Name() string {
+	return "salts"
+}
+
+// Run implements Job
+func (j *SaltJob) Run(ctx context.Context) error {
+	now := j.Now()
+	if _, err := j.DB.DailySalt(ctx, now); err != nil {
+		return err
+	}
+
+	yesterday := storage.ResolutionDay.Truncate(now).AddDate(0, 0, -1)
+	deleted, err := j.DB.DeleteSaltsBefore(ctx, yesterday)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return nil
+	}
+
+	return j.DB.LogPrivacyAction(ctx, "salt_destroyed", "salts", "", map[string]interface{}{
+		"deleted": deleted,
+		"before":  yesterday.Format("2006-01-02"),
+	})
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaltJobKeepsTodayAndYesterday(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	today := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	for days := 3; days >= 1; days-- {
+		_, err := db.DailySalt(ctx, today.AddDate(0, 0, -days))
+		require.NoError(t, err)
+	}
+
+	job := NewSaltJob(db)
+	job.Now = func() time.Time { return today.Add(time.Hour) }
+	require.NoError(t, job.Run(ctx))
+
+	deleted, err := db.DeleteSaltsBefore(ctx, today.AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Zero(t, deleted, "Older salts were already destroyed")
+
+	deleted, err = db.DeleteSaltsBefore(ctx, today.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted, "Yesterday's and today's salts are kept")
+
+	logs, err := db.GetPrivacyLogs(ctx, "salt_destroyed", 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, float64(2), logs[0].Metadata["deleted"])
+}
@@ -0,0 +1,155 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a unit of background work run periodically by the Scheduler
+type Job interface {
+	// Name identifies the job in logs
+	Name() string
+	// Run performs one pass of the job. It should return promptly once ctx
+	// is cancelled.
+	Run(ctx context.Context) error
+}
+
+// JobStatus is a snapshot of a job's recent runs
+type JobStatus struct {
+	Name         string        `json:"name"`
+	Interval     time.Duration `json:"-"`
+	StuckAfter   time.Duration `json:"-"`
+	Running      bool          `json:"running"`
+	Runs         int           `json:"runs"`
+	Failures     int           `json:"failures"`
+	LastStarted  time.Time     `json:"last_started,omitempty"`
+	LastFinished time.Time     `json:"last_finished,omitempty"`
+	LastError    string        `json:"last_error,omitempty"`
+}
+
+// Stuck reports whether the current run has taken longer than StuckAfter.
+// It is independent of the interval, since a run may legitimately outlast
+// it, as the rollup's first backfill does. A zero StuckAfter never reports
+// a job stuck.
+func (s JobStatus) Stuck(now time.Time) bool {
+	return s.Running && s.StuckAfter > 0 && now.Sub(s.LastStarted) > s.StuckAfter
+}
+
+// entry is a job registered with its run interval and run state
+type entry struct {
+	job      Job
+	interval time.Duration
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// snapshot returns a copy of the entry's status
+func (e *entry) snapshot() JobStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// Scheduler runs registered jobs on fixed intervals, each in its own
+// goroutine. Every job runs once immediately when the scheduler starts.
+type Scheduler struct {
+	entries    []*entry
+	stuckAfter time.Duration
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewScheduler creates an empty scheduler. Its jobs are reported stuck once
+// a run lasts longer than stuckAfter; zero disables the check.
+func NewScheduler(stuckAfter time.Duration) *Scheduler {
+	return &Scheduler{stuckAfter: stuckAfter}
+}
+
+// Add registers a job to run every interval. Jobs must be added before Start.
+func (s *Scheduler) Add(job Job, interval time.Duration) {
+	s.entries = append(s.entries, &entry{
+		job:      job,
+		interval: interval,
+		status:   JobStatus{Name: job.Name(), Interval: interval, StuckAfter: s.stuckAfter},
+	})
+}
+
+// Start launches all registered jobs
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.loop(ctx, e)
+	}
+}
+
+// Stop cancels running jobs and waits for them to return
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Status returns a snapshot of every registered job, in registration order
+func (s *Scheduler) Status() []JobStatus {
+	statuses := make([]JobStatus, 0, len(s.entries))
+	for _, e := range s.entries {
+		statuses = append(statuses, e.snapshot())
+	}
+	return statuses
+}
+
+// loop runs a single job until ctx is cancelled
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		s.run(ctx, e)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run executes one pass of a job, records its outcome and logs failures
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	start := time.Now()
+	e.mu.Lock()
+	e.status.Running = true
+	e.status.LastStarted = start
+	e.mu.Unlock()
+
+	err := e.job.Run(ctx)
+
+	e.mu.Lock()
+	e.status.Running = false
+	e.status.LastFinished = time.Now()
+	e.status.Runs++
+	if err != nil && ctx.Err() == nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+	} else if err == nil {
+		e.status.LastError = ""
+	}
+	e.mu.Unlock()
+
+	name := e.job.Name()
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Job %s interrupted by shutdown", name)
+			return
+		}
+		log.Printf("Job %s failed after %s: %v", name, time.Since(start), err)
+		return
+	}
+	log.Printf("Job %s completed in %s", name, time.Since(start))
+}
@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingJob counts its runs and blocks until cancelled when block is set
+type countingJob struct {
+	runs  atomic.Int32
+	block bool
+}
+
+func (j *countingJob) Name() string { return "counting" }
+
+func (j *countingJob) Run(ctx context.Context) error {
+	j.runs.Add(1)
+	if j.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestSchedulerRunsJobImmediatelyAndOnInterval(t *testing.T) {
+	job := &countingJob{}
+	s := NewScheduler(time.Hour)
+	s.Add(job, 10*time.Millisecond)
+	s.Start(context.Background())
+
+	assert.Eventually(t, func() bool { return job.runs.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	s.Stop()
+
+	runs := job.runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, runs, job.runs.Load(), "Job should not run after Stop")
+}
+
+func TestSchedulerStopCancelsRunningJob(t *testing.T) {
+	job := &countingJob{block: true}
+	s := NewScheduler(time.Hour)
+	s.Add(job, time.Hour)
+	s.Start(context.Background())
+
+	assert.Eventually(t, func() bool { return job.runs.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop should return once the running job is cancelled")
+	}
+}
+
+// failingJob always fails
+type failingJob struct{}
+
+func (failingJob) Name() string { return "failing" }
+
+func (failingJob) Run(ctx context.Context) error { return errors.New("boom") }
+
+func TestSchedulerStatus(t *testing.T) {
+	ok := &countingJob{}
+	s := NewScheduler(time.Hour)
+	s.Add(ok, time.Hour)
+	s.Add(failingJob{}, time.Hour)
+
+	statuses := s.Status()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "counting", statuses[0].Name)
+	assert.Zero(t, statuses[0].Runs, "Nothing has run before Start")
+
+	s.Start(context.Background())
+	defer s.Stop()
+
+	assert.Eventually(t, func() bool {
+		statuses := s.Status()
+		return statuses[0].Runs == 1 && statuses[1].Runs == 1
+	}, time.Second, 5*time.Millisecond)
+
+	statuses = s.Status()
+	assert.False(t, statuses[0].Running)
+	assert.Zero(t, statuses[0].Failures)
+	assert.Empty(t, statuses[0].LastError)
+
+	assert.Equal(t, 1, statuses[1].Failures)
+	assert.Equal(t, "boom", statuses[1].LastError)
+}
+
+func TestJobStatusStuck(t *testing.T) {
+	now := time.Now()
+	status := JobStatus{Interval: time.Hour, StuckAfter: 6 * time.Hour, Running: true, LastStarted: now.Add(-7 * time.Hour)}
+	assert.True(t, status.Stuck(now))
+
+	status.LastStarted = now.Add(-2 * time.Hour)
+	assert.False(t, status.Stuck(now), "Outlasting the interval alone is not stuck")
+
+	status.Running = false
+	status.LastStarted = now.Add(-7 * time.Hour)
+	assert.False(t, status.Stuck(now), "Idle jobs are never stuck")
+
+	status = JobStatus{Interval: time.Hour, Running: true, LastStarted: now.Add(-7 * time.Hour)}
+	assert.False(t, status.Stuck(now), "A zero threshold disables the check")
+}
+
+func TestSchedulerStuckAfter(t *testing.T) {
+	s := NewScheduler(6 * time.Hour)
+	s.Add(&countingJob{}, time.Hour)
+	statuses := s.Status()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, time.Hour, statuses[0].Interval)
+	assert.Equal(t, 6*time.Hour, statuses[0].StuckAfter)
+}
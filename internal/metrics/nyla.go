@@ -0,0 +1,158 @@
+// Package metrics defines nyla-core's Prometheus metrics
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+var (
+	// httpBuckets are latency buckets in seconds for HTTP requests
+	httpBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// insertBuckets are latency buckets in seconds for SQLite writes, which
+	// are usually well under a millisecond
+	insertBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1}
+)
+
+// Metrics holds the application's metrics
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	CollectRequests     *prometheus.CounterVec
+	EventsInserted      *prometheus.CounterVec
+	InsertDuration      *prometheus.HistogramVec
+	HTTPRequestDuration *prometheus.HistogramVec
+	BotHits             *prometheus.CounterVec
+	// GeoLookupFailures is only registered by OnGeoLookupError, so it is
+	// not exported while GeoIP is off
+	GeoLookupFailures prometheus.Counter
+
+	geoOnce sync.Once
+}
+
+// New registers the application's metrics. It installs storage hooks on db and
+// exports DB file sizes and job counters read at scrape time. The scheduler
+// may be nil.
+func New(db *storage.DB, scheduler *jobs.Scheduler) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		CollectRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nyla_collect_requests_total",
+			Help: "Collect requests by HTTP status code.",
+		}, []string{"status"}),
+		EventsInserted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nyla_events_inserted_total",
+			Help: "Events written to the database by event type.",
+		}, []string{"type"}),
+		InsertDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nyla_insert_duration_seconds",
+			Help:    "Latency of event inserts by operation (single or batch).",
+			Buckets: insertBuckets,
+		}, []string{"op"}),
+		HTTPRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nyla_http_request_duration_seconds",
+			Help:    "HTTP request latency by route and method.",
+			Buckets: httpBuckets,
+		}, []string{"route", "method"}),
+		BotHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nyla_bot_hits_total",
+			Help: "Collect requests filtered as bot traffic by reason.",
+		}, []string{"reason"}),
+		GeoLookupFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nyla_geo_lookup_failures_total",
+			Help: "Failed GeoIP lookups.",
+		}),
+	}
+	m.Registry.MustRegister(m.CollectRequests, m.EventsInserted, m.InsertDuration, m.HTTPRequestDuration, m.BotHits)
+
+	if db != nil {
+		db.SetHooks(storage.Hooks{AfterInsert: m.observeInsert})
+
+		m.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "nyla_db_size_bytes",
+			Help: "Size of the SQLite database file.",
+		}, func() float64 {
+			dbBytes, _, err := db.FileSizes()
+			if err != nil {
+				log.Printf("Metrics: %v", err)
+			}
+			return float64(dbBytes)
+		}))
+		m.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "nyla_db_wal_size_bytes",
+			Help: "Size of the SQLite write-ahead log.",
+		}, func() float64 {
+			_, walBytes, err := db.FileSizes()
+			if err != nil {
+				log.Printf("Metrics: %v", err)
+			}
+			return float64(walBytes)
+		}))
+	}
+
+	if scheduler != nil {
+		m.Registry.MustRegister(&jobCollector{scheduler: scheduler})
+	}
+
+	return m
+}
+
+// Handler serves the registered metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// OnGeoLookupError registers nyla_geo_lookup_failures_total and returns a
+// callback counting failures, for a resolver's OnLookupError
+func (m *Metrics) OnGeoLookupError() func(ip string, err error) {
+	m.geoOnce.Do(func() { m.Registry.MustRegister(m.GeoLookupFailures) })
+	return func(ip string, err error) { m.GeoLookupFailures.Inc() }
+}
+
+// observeInsert records insert latency and, on success, the inserted events
+func (m *Metrics) observeInsert(events []*storage.Event, duration time.Duration, err error) {
+	op := "single"
+	if len(events) != 1 {
+		op = "batch"
+	}
+	m.InsertDuration.WithLabelValues(op).Observe(duration.Seconds())
+	if err != nil {
+		return
+	}
+	for _, event := range events {
+		m.EventsInserted.WithLabelValues(event.Type).Inc()
+	}
+}
+
+var (
+	jobRunsDesc = prometheus.NewDesc("nyla_job_runs_total",
+		"Background job runs.", []string{"job"}, nil)
+	jobFailuresDesc = prometheus.NewDesc("nyla_job_failures_total",
+		"Background job runs that returned an error.", []string{"job"}, nil)
+)
+
+// jobCollector exports the run counts the scheduler already keeps, read at
+// scrape time
+type jobCollector struct {
+	scheduler *jobs.Scheduler
+}
+
+func (c *jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobRunsDesc
+	ch <- jobFailuresDesc
+}
+
+func (c *jobCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.scheduler.Status() {
+		ch <- prometheus.MustNewConstMetric(jobRunsDesc, prometheus.CounterValue, float64(s.Runs), s.Name)
+		ch <- prometheus.MustNewConstMetric(jobFailuresDesc, prometheus.CounterValue, float64(s.Failures), s.Name)
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// failingJob always returns an error
+type failingJob struct{}
+
+func (failingJob) Name() string                  { return "rollup" }
+func (failingJob) Run(ctx context.Context) error { return errors.New("boom") }
+
+func TestJobCounters(t *testing.T) {
+	scheduler := jobs.NewScheduler(time.Hour)
+	scheduler.Add(failingJob{}, time.Hour)
+	scheduler.Start(context.Background())
+	require.Eventually(t, func() bool { return scheduler.Status()[0].Runs == 1 }, time.Second, 5*time.Millisecond)
+	scheduler.Stop()
+
+	m := New(nil, scheduler)
+	expected := `# HELP nyla_job_failures_total Background job runs that returned an error.
+# TYPE nyla_job_failures_total counter
+nyla_job_failures_total{job="rollup"} 1
+# HELP nyla_job_runs_total Background job runs.
+# TYPE nyla_job_runs_total counter
+nyla_job_runs_total{job="rollup"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry, strings.NewReader(expected),
+		"nyla_job_runs_total", "nyla_job_failures_total"))
+}
+
+func TestObserveInsert(t *testing.T) {
+	m := New(nil, nil)
+	m.observeInsert([]*storage.Event{{Type: storage.EventTypePageview}}, time.Millisecond, nil)
+	m.observeInsert([]*storage.Event{{Type: storage.EventTypePageview}, {Type: storage.EventTypeCustom}}, time.Millisecond, nil)
+	m.observeInsert([]*storage.Event{{Type: storage.EventTypeCustom}}, time.Millisecond, errors.New("disk full"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.EventsInserted.WithLabelValues(storage.EventTypePageview)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.EventsInserted.WithLabelValues(storage.EventTypeCustom)), "Failed inserts are not counted")
+	assert.Equal(t, 2, testutil.CollectAndCount(m.InsertDuration), "Single and batch latencies")
+}
+
+func TestGeoLookupFailures(t *testing.T) {
+	m := New(nil, nil)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, w.Body.String(), "nyla_geo_lookup_failures_total", "Not exported while GeoIP is off")
+
+	onError := m.OnGeoLookupError()
+	m.OnGeoLookupError()("192.0.2.1", errors.New("timeout"))
+	onError("192.0.2.2", errors.New("timeout"))
+
+	w = httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, w.Body.String(), "nyla_geo_lookup_failures_total 2\n")
+}
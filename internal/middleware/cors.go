@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
+
+	"github.com/sunwolfengineering/nyla-core/internal/config"
 )
 
 // CORSConfig holds CORS configuration
@@ -14,21 +16,13 @@ type CORSConfig struct {
 	AllowCredentials string
 }
 
-// getEnvDefault returns the value of the environment variable or a default
-func getEnvDefault(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return def
-}
-
-// NewCORSConfig creates a new CORS configuration from environment variables
-func NewCORSConfig() *CORSConfig {
+// NewCORSConfig creates a new CORS configuration from the loaded config
+func NewCORSConfig(cfg *config.Config) *CORSConfig {
 	return &CORSConfig{
-		AllowedOrigins:   getEnvDefault("CORS_ALLOWED_ORIGINS", "https://localhost"),
-		AllowedHeaders:   getEnvDefault("CORS_ALLOWED_HEADERS", "Content-Type,HX-Request,HX-Target,HX-Current-URL,HX-Trigger,HX-Trigger-Name,HX-History-Restore-Request"),
-		ExposedHeaders:   getEnvDefault("CORS_EXPOSED_HEADERS", "HX-Redirect,HX-Location,HX-Push,HX-Refresh,HX-Trigger,HX-Trigger-After-Settle,HX-Trigger-After-Swap"),
-		AllowCredentials: getEnvDefault("CORS_ALLOW_CREDENTIALS", "true"),
+		AllowedOrigins:   strings.Join(cfg.Security.AllowedOrigins, ","),
+		AllowedHeaders:   strings.Join(cfg.CORS.AllowedHeaders, ","),
+		ExposedHeaders:   strings.Join(cfg.CORS.ExposedHeaders, ","),
+		AllowCredentials: strconv.FormatBool(cfg.CORS.AllowCredentials),
 	}
 }
 
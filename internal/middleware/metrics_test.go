@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/metrics"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := metrics.New(nil, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/collect", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") == "" {
+			http.Error(w, "missing url", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /api/v1/stats/realtime", func(w http.ResponseWriter, r *http.Request) {})
+	handler := Metrics(m, mux)
+
+	for _, target := range []string{
+		"/api/v1/collect?url=/",
+		"/api/v1/collect?url=/about",
+		"/api/v1/collect",
+		"/api/v1/stats/realtime",
+		"/no/such/route",
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.CollectRequests.WithLabelValues("200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.CollectRequests.WithLabelValues("400")))
+	assert.Equal(t, uint64(3), observations(t, m, "/api/v1/collect"))
+	assert.Equal(t, uint64(1), observations(t, m, "/api/v1/stats/realtime"))
+	assert.Equal(t, uint64(1), observations(t, m, "unmatched"))
+}
+
+// observations returns the number of GET requests recorded for route
+func observations(t *testing.T, m *metrics.Metrics, route string) uint64 {
+	var metric dto.Metric
+	require.NoError(t, m.HTTPRequestDuration.WithLabelValues(route, "GET").(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
@@ -1,57 +1,183 @@
 package server
 
 import (
+	"context"
+	"log"
+	"net"
 	"net/http"
-	"os"
+	"time"
 
+	"github.com/sunwolfengineering/nyla-core/internal/config"
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
+	"github.com/sunwolfengineering/nyla-core/internal/metrics"
 	"github.com/sunwolfengineering/nyla-core/internal/middleware"
+	"github.com/sunwolfengineering/nyla-core/internal/sessions"
 	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/bots"
+	"github.com/sunwolfengineering/nyla-core/pkg/geo"
 	"github.com/sunwolfengineering/nyla-core/pkg/handlers"
+	"github.com/sunwolfengineering/nyla-core/pkg/referrer"
 )
 
 // Server represents the unified HTTP server
 type Server struct {
 	db *storage.DB
+	config  *config.Config
 	mux    *http.ServeMux
 	handler http.Handler
+	httpServer *http.Server
+	scheduler  *jobs.Scheduler
+	version    string
+	startedAt  time.Time
+	metrics    *metrics.Metrics
+	// geoDB is the local GeoIP database, closed on shutdown
+	geoDB *geo.MMDB
 }
 
-// New creates a new unified server instance
-func New(db *storage.DB) *Server {
+// New creates a new unified server instance. The scheduler, which may be nil,
+// and version are reported by the health check.
+func New(db *storage.DB, cfg *config.Config, scheduler *jobs.Scheduler, version string) *Server {
 	s := &Server{
 		db: db,
+		config:  cfg,
 		mux:    http.NewServeMux(),
+		scheduler: scheduler,
+		version:   version,
+		startedAt: time.Now(),
+		metrics:   metrics.New(db, scheduler),
 	}
 	
 	s.setupRoutes()
 	s.setupMiddleware()
 	
+	s.httpServer = &http.Server{
+		Addr:         cfg.Server.Addr(),
+		Handler:      s.handler,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	
 	return s
 }
 
-// setupRoutes configures all API and UI routes
+// geoResolver builds the GeoIP resolver from the local database, falling
+// back to the HTTP provider. It returns nil when neither is configured.
+func (s *Server) geoResolver() geo.GeoResolver {
+	var resolvers []geo.GeoResolver
+	if path := s.config.GeoIP.Database; path != "" {
+		db, err := geo.OpenMMDB(path)
+		if err != nil {
+			log.Printf("GeoIP database unavailable: %v", err)
+		} else {
+			db.OnLookupError = s.metrics.OnGeoLookupError()
+			s.geoDB = db
+			resolvers = append(resolvers, db)
+		}
+	}
+	if client := s.config.GeoIP.Client(); client != nil {
+		client.OnLookupError = s.metrics.OnGeoLookupError()
+		resolvers = append(resolvers, client)
+	}
+	if len(resolvers) == 0 {
+		return nil
+	}
+	return geo.Chain(resolvers...)
+}
+
+// setupRoutes configures all API and UI routes. Validate has checked these
+// settings, so errors here mean the files changed since startup; they are
+// logged and the server runs without that feature.
 func (s *Server) setupRoutes() {
 	// Initialize handlers
-	apiHandlers := &handlers.Handlers{DB: s.db}
+	sessionService := sessions.NewService(s.db, s.config.Sessions.Timeout)
+	sources, err := s.config.Referrers.Sources()
+	if err != nil {
+		log.Printf("Using built-in referrer sources: %v", err)
+		sources = referrer.DefaultList()
+	}
+	sessionService.Referrers = referrer.NewClassifier(sources, s.config.Site.Domains)
+
+	apiHandlers := &handlers.Handlers{
+		DB:             s.db,
+		Sessions:       sessionService,
+		IPMode:         s.config.Privacy.IPMode(),
+		StoreUserAgent: s.config.Privacy.StoreUserAgent,
+		BotMode:        s.config.Bots.BotMode(),
+		OnBot:          func(reason string) { s.metrics.BotHits.WithLabelValues(reason).Inc() },
+		Geo:            s.geoResolver(),
+		DefaultConsent: s.config.Privacy.Consent(),
+		APIKey:         s.config.Security.APIKey,
+	}
+	if apiHandlers.BotMode != bots.ModeOff {
+		ranges, err := s.config.Bots.Ranges()
+		if err != nil {
+			log.Printf("Ignoring bot IP ranges: %v", err)
+			ranges = nil
+		}
+		apiHandlers.Bots = bots.NewDetector(ranges)
+	}
+	if apiHandlers.Scrubber, err = s.config.Privacy.Scrubber(); err != nil {
+		log.Printf("Storing URLs unscrubbed: %v", err)
+	}
+	if apiHandlers.ClientIP, err = s.config.Server.ClientIP(); err != nil {
+		log.Printf("Ignoring forwarding headers: %v", err)
+	}
+	
+	uiHandlers := &handlers.UIHandlers{APIBaseURL: s.config.Server.APIBaseURL}
 	
-	apiBaseURL := os.Getenv("API_BASE_URL")
-	if apiBaseURL == "" {
-		apiBaseURL = "https://api.localhost"
+	healthHandlers := &handlers.HealthHandlers{
+		DB:        s.db,
+		Version:   s.version,
+		StartedAt: s.startedAt,
 	}
-	uiHandlers := &handlers.UIHandlers{APIBaseURL: apiBaseURL}
+	if s.scheduler != nil {
+		healthHandlers.Jobs = s.scheduler
+	}
+	
+	// Health check
+	s.mux.HandleFunc("GET /health", healthHandlers.GetHealth)
+	
+	// Prometheus metrics
+	s.mux.Handle("GET /metrics", s.metrics.Handler())
 	
 	// API routes at /api/v1/*
 	s.mux.HandleFunc("GET /api/v1/collect", apiHandlers.GetCollectV1)
+	s.mux.HandleFunc("POST /api/v1/collect", apiHandlers.PostCollectV1)
 	s.mux.HandleFunc("GET /api/v1/stats/realtime", apiHandlers.GetStatsRealtimeV1)
+	s.mux.HandleFunc("GET /api/v1/stats/historical", apiHandlers.GetStatsHistoricalV1)
+	s.mux.HandleFunc("GET /api/v1/stats/engagement", apiHandlers.GetStatsEngagementV1)
+	s.mux.HandleFunc("GET /api/v1/stats/events", apiHandlers.GetStatsEventsV1)
+	s.mux.HandleFunc("GET /api/v1/stats/events/{name}/properties/{property}", apiHandlers.GetStatsEventPropertiesV1)
+	s.mux.HandleFunc("GET /api/v1/stats/goals", apiHandlers.GetStatsGoalsV1)
+	s.mux.HandleFunc("GET /api/v1/stats/utm/{dimension}", apiHandlers.GetStatsUTMV1)
+	s.mux.HandleFunc("GET /api/v1/stats/sources", apiHandlers.GetStatsSourcesV1)
+	s.mux.HandleFunc("GET /api/v1/stats/channels", apiHandlers.GetStatsChannelsV1)
+	s.mux.HandleFunc("GET /api/v1/stats/devices/{dimension}", apiHandlers.GetStatsDevicesV1)
+	s.mux.HandleFunc("GET /api/v1/stats/countries", apiHandlers.GetStatsCountriesV1)
+	s.mux.HandleFunc("GET /api/v1/stats/bots", apiHandlers.GetStatsBotsV1)
+	s.mux.HandleFunc("GET /api/v1/stats/privacy", apiHandlers.GetStatsPrivacyV1)
+	s.mux.HandleFunc("GET /api/v1/goals", apiHandlers.GetGoalsV1)
+	s.mux.HandleFunc("POST /api/v1/goals", apiHandlers.PostGoalsV1)
+	s.mux.HandleFunc("DELETE /api/v1/goals/{id}", apiHandlers.DeleteGoalV1)
+	s.mux.HandleFunc("GET /api/v1/stats/funnels", apiHandlers.GetStatsFunnelsV1)
+	s.mux.HandleFunc("GET /api/v1/stats/funnels/{id}", apiHandlers.GetStatsFunnelV1)
+	s.mux.HandleFunc("GET /api/v1/funnels", apiHandlers.GetFunnelsV1)
+	s.mux.HandleFunc("POST /api/v1/funnels", apiHandlers.PostFunnelsV1)
+	s.mux.HandleFunc("DELETE /api/v1/funnels/{id}", apiHandlers.DeleteFunnelV1)
+	s.mux.HandleFunc("GET /api/v1/privacy/data", apiHandlers.GetPrivacyDataV1)
+	s.mux.HandleFunc("GET /api/v1/privacy/export", apiHandlers.GetPrivacyExportV1)
+	s.mux.HandleFunc("DELETE /api/v1/privacy/data", apiHandlers.DeletePrivacyDataV1)
 	
 	// UI routes
 	s.mux.HandleFunc("GET /", uiHandlers.DashboardHandler)
+	s.mux.HandleFunc("GET /funnels", uiHandlers.FunnelsHandler)
 }
 
 // setupMiddleware configures middleware stack
 func (s *Server) setupMiddleware() {
-	corsConfig := middleware.NewCORSConfig()
-	s.handler = corsConfig.CORS(s.mux)
+	corsConfig := middleware.NewCORSConfig(s.config)
+	s.handler = corsConfig.CORS(middleware.Metrics(s.metrics, s.mux))
 }
 
 // Handler returns the configured HTTP handler
@@ -64,7 +190,26 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handler.ServeHTTP(w, r)
 }
 
-// ListenAndServe starts the server on the specified address
-func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.handler)
+// ListenAndServe starts the server on the configured address. It returns
+// http.ErrServerClosed after Shutdown.
+func (s *Server) ListenAndServe() error {
+	return s.httpServer.ListenAndServe()
+}
+
+// Serve accepts connections on l. It returns http.ErrServerClosed after
+// Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	return s.httpServer.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish, for at most the configured shutdown timeout
+func (s *Server) Shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Server.ShutdownTimeout)
+	defer cancel()
+	err := s.httpServer.Shutdown(ctx)
+	if s.geoDB != nil {
+		s.geoDB.Close()
+	}
+	return err
 }
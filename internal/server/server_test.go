@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/config"
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestServerLifecycle(t *testing.T) {
+	db, err := storage.NewDBWithMigrations(filepath.Join(t.TempDir(), "nyla.db"), filepath.Join("..", "..", "migrations"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	cfg := config.Default()
+	cfg.Server.ShutdownTimeout = time.Second
+	srv := New(db, cfg, jobs.NewScheduler(cfg.Jobs.StuckAfter), "test")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	// A browser hit is stored; Go's default client is filtered as a bot
+	req, err := http.NewRequest("GET", "http://"+listener.Addr().String()+"/api/v1/collect?url=https://example.com", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+	req.Header.Set("Accept-Language", "en-GB")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get("http://" + listener.Addr().String() + "/api/v1/collect?url=https://example.com")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get("http://" + listener.Addr().String() + "/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get("http://" + listener.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `nyla_collect_requests_total{status="200"} 2`)
+	assert.Contains(t, string(body), `nyla_events_inserted_total{type="pageview"} 1`)
+	assert.Contains(t, string(body), `nyla_bot_hits_total{reason="crawler"} 1`)
+	assert.Contains(t, string(body), "nyla_db_size_bytes ")
+	assert.NotContains(t, string(body), "nyla_geo_lookup_failures_total", "Not exported while GeoIP is off")
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+
+	select {
+	case err := <-serveErr:
+		assert.True(t, errors.Is(err, http.ErrServerClosed), "Serve should return ErrServerClosed, got %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/")
+	assert.Error(t, err, "Server should no longer accept connections")
+}
+
+func TestNewAppliesTimeouts(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = 9999
+	cfg.Server.ReadTimeout = time.Second
+	cfg.Server.WriteTimeout = 2 * time.Second
+	cfg.Server.IdleTimeout = 3 * time.Second
+	srv := New(nil, cfg, nil, "test")
+
+	assert.Equal(t, "127.0.0.1:9999", srv.httpServer.Addr)
+	assert.Equal(t, time.Second, srv.httpServer.ReadTimeout)
+	assert.Equal(t, 2*time.Second, srv.httpServer.WriteTimeout)
+	assert.Equal(t, 3*time.Second, srv.httpServer.IdleTimeout)
+}
@@ -0,0 +1,193 @@
+// Package sessions groups a visitor's hits into sessions
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/referrer"
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// DefaultTimeout is the inactivity period after which a visitor's next hit
+// starts a new session
+const DefaultTimeout = 30 * time.Minute
+
+// defaultReferrers classifies referrers with the built-in source list when
+// a Service has no classifier
+var defaultReferrers = referrer.NewClassifier(referrer.DefaultList(), nil)
+
+// Service assigns events to sessions and stores them. A visitor's hit joins
+// their most recent session unless the session has been inactive for longer
+// than Timeout or the hit arrives from a different campaign or external
+// referrer. Engagement heartbeats only extend an active session; they never
+// start one.
+type Service struct {
+	DB      *storage.DB
+	Timeout time.Duration
+	// Referrers classifies the referrer of each hit. Nil uses the built-in
+	// source list with no site domains.
+	Referrers *referrer.Classifier
+
+	// mu serializes assignment and insert so concurrent hits from the same
+	// visitor can't both start a session
+	mu sync.Mutex
+}
+
+// NewService creates a session service. A non-positive timeout uses
+// DefaultTimeout.
+func NewService(db *storage.DB, timeout time.Duration) *Service {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Service{DB: db, Timeout: timeout}
+}
+
+// Record assigns each event with a VisitorID to a session and stores the
+// events in a single transaction. Events without a VisitorID keep their
+// SessionID as given. Engagement events without an active session are
+// dropped. Events are enriched with the UTM parameters of their URL and the
+// classification of their referrer; internal referrers are dropped.
+func (s *Service) Record(ctx context.Context, events []*storage.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Sessions touched earlier in this batch, by visitor
+	open := make(map[string]*storage.Session)
+	kept := make([]*storage.Event, 0, len(events))
+	for _, event := range events {
+		s.enrich(event)
+		ok, err := s.assign(ctx, event, open)
+		if err != nil {
+			return err
+		}
+		if ok {
+			kept = append(kept, event)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return s.DB.InsertEvents(ctx, kept)
+}
+
+// enrich sets the event's UTM parameters, unless already set, and classifies
+// its referrer
+func (s *Service) enrich(event *storage.Event) {
+	if event.UTM.IsZero() {
+		event.UTM = utm.Parse(event.URL)
+	}
+
+	classifier := s.Referrers
+	if classifier == nil {
+		classifier = defaultReferrers
+	}
+	result := classifier.Classify(event.Referrer, event.URL, event.UTM)
+	if result.Internal {
+		event.Referrer = ""
+	}
+	event.ReferrerHost = result.Host
+	event.Source = result.Source
+	event.Channel = result.Channel
+}
+
+// assign sets the event's session ID, starting a session when needed. It
+// reports false for engagement events that have no session to extend.
+func (s *Service) assign(ctx context.Context, event *storage.Event, open map[string]*storage.Session) (bool, error) {
+	engagement := event.Type == storage.EventTypeEngagement
+	if event.VisitorID == "" {
+		return !engagement || event.SessionID != "", nil
+	}
+	if event.Campaign == "" {
+		event.Campaign = campaignKey(event.UTM)
+	}
+
+	session, ok := open[event.VisitorID]
+	if !ok {
+		var err error
+		session, err = s.DB.LastSession(ctx, event.VisitorID)
+		if err != nil {
+			return false, fmt.Errorf("failed to find session: %w", err)
+		}
+	}
+
+	if engagement {
+		if session == nil || s.expired(session, event) {
+			return false, nil
+		}
+	} else if session == nil || s.startsNewSession(session, event) {
+		id, err := newSessionID()
+		if err != nil {
+			return false, err
+		}
+		session = &storage.Session{
+			ID:           id,
+			StartedAt:    event.Timestamp,
+			VisitorID:    event.VisitorID,
+			Referrer:     event.Referrer,
+			ReferrerHost: event.ReferrerHost,
+			Campaign:     event.Campaign,
+		}
+	}
+
+	if session.EndedAt == nil || event.Timestamp.After(*session.EndedAt) {
+		endedAt := event.Timestamp
+		session.EndedAt = &endedAt
+	}
+	open[event.VisitorID] = session
+	event.SessionID = session.ID
+	return true, nil
+}
+
+// expired reports whether session was inactive for longer than the timeout
+// before event
+func (s *Service) expired(session *storage.Session, event *storage.Event) bool {
+	lastActive := session.StartedAt
+	if session.EndedAt != nil {
+		lastActive = *session.EndedAt
+	}
+	return event.Timestamp.Sub(lastActive) > s.Timeout
+}
+
+// startsNewSession reports whether event can't continue session
+func (s *Service) startsNewSession(session *storage.Session, event *storage.Event) bool {
+	if s.expired(session, event) {
+		return true
+	}
+	if event.Campaign != "" && event.Campaign != session.Campaign {
+		return true
+	}
+	if event.ReferrerHost != "" && event.ReferrerHost != session.ReferrerHost {
+		return true
+	}
+	return false
+}
+
+// Campaign returns a key identifying the UTM campaign in rawURL, or an empty
+// string if it has no UTM parameters
+func Campaign(rawURL string) string {
+	return campaignKey(utm.Parse(rawURL))
+}
+
+// campaignKey identifies the campaign of p by its source, medium and name
+func campaignKey(p utm.Params) string {
+	if p.Source == "" && p.Medium == "" && p.Campaign == "" {
+		return ""
+	}
+	return strings.Join([]string{p.Source, p.Medium, p.Campaign}, "/")
+}
+
+// newSessionID returns a random session ID
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,250 @@
+package sessions
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/referrer"
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// setupTestDB opens a database in a temporary directory with the
+// repository's migrations applied
+func setupTestDB(t *testing.T) *storage.DB {
+	db, err := storage.NewDBWithMigrations(filepath.Join(t.TempDir(), "nyla.db"), filepath.Join("..", "..", "migrations"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRecordSessionBoundaries(t *testing.T) {
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	pageview := func(offset time.Duration, url, referrer string) *storage.Event {
+		return &storage.Event{
+			Type:      "pageview",
+			Timestamp: start.Add(offset),
+			URL:       url,
+			Referrer:  referrer,
+			VisitorID: "visitor",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		first       *storage.Event
+		second      *storage.Event
+		sameSession bool
+	}{
+		{
+			name:        "Within timeout",
+			first:       pageview(0, "https://example.com/", ""),
+			second:      pageview(29*time.Minute, "https://example.com/pricing", "https://example.com/"),
+			sameSession: true,
+		},
+		{
+			name:        "After timeout",
+			first:       pageview(0, "https://example.com/", ""),
+			second:      pageview(31*time.Minute, "https://example.com/pricing", ""),
+			sameSession: false,
+		},
+		{
+			name:        "New campaign",
+			first:       pageview(0, "https://example.com/?utm_source=news&utm_campaign=spring", ""),
+			second:      pageview(time.Minute, "https://example.com/?utm_source=news&utm_campaign=summer", ""),
+			sameSession: false,
+		},
+		{
+			name:        "Same campaign",
+			first:       pageview(0, "https://example.com/?utm_source=news&utm_campaign=spring", ""),
+			second:      pageview(time.Minute, "https://example.com/?utm_source=news&utm_campaign=spring", ""),
+			sameSession: true,
+		},
+		{
+			name:        "New external referrer",
+			first:       pageview(0, "https://example.com/", "https://search.example/"),
+			second:      pageview(time.Minute, "https://example.com/", "https://social.example/post"),
+			sameSession: false,
+		},
+		{
+			name:        "Same external referrer",
+			first:       pageview(0, "https://example.com/", "https://search.example/?q=a"),
+			second:      pageview(time.Minute, "https://example.com/", "https://search.example/?q=b"),
+			sameSession: true,
+		},
+		{
+			name:        "Internal referrer",
+			first:       pageview(0, "https://example.com/", "https://search.example/"),
+			second:      pageview(time.Minute, "https://example.com/docs", "https://example.com/"),
+			sameSession: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			service := NewService(db, 30*time.Minute)
+			ctx := context.Background()
+
+			// Record separately so the second hit is matched against the stored session
+			require.NoError(t, service.Record(ctx, []*storage.Event{tt.first}))
+			require.NoError(t, service.Record(ctx, []*storage.Event{tt.second}))
+
+			require.NotEmpty(t, tt.first.SessionID)
+			require.NotEmpty(t, tt.second.SessionID)
+			if tt.sameSession {
+				assert.Equal(t, tt.first.SessionID, tt.second.SessionID)
+			} else {
+				assert.NotEqual(t, tt.first.SessionID, tt.second.SessionID)
+			}
+		})
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(db, 30*time.Minute)
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	events := []*storage.Event{
+		{Type: "pageview", Timestamp: start, URL: "/", VisitorID: "a"},
+		{Type: "pageview", Timestamp: start.Add(10 * time.Minute), URL: "/docs", VisitorID: "a"},
+		{Type: "pageview", Timestamp: start.Add(time.Hour), URL: "/", VisitorID: "a"},
+		{Type: "pageview", Timestamp: start, URL: "/", VisitorID: "b"},
+		{Type: "pageview", Timestamp: start, URL: "/", SessionID: "given"},
+	}
+	require.NoError(t, service.Record(ctx, events))
+
+	assert.Equal(t, events[0].SessionID, events[1].SessionID, "Hits within the timeout share a session")
+	assert.NotEqual(t, events[1].SessionID, events[2].SessionID, "A gap longer than the timeout starts a session")
+	assert.NotEqual(t, events[0].SessionID, events[3].SessionID, "Visitors don't share sessions")
+	assert.Equal(t, "given", events[4].SessionID, "Events without a visitor keep their session")
+
+	session, err := db.GetSessionByID(ctx, events[0].SessionID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, "a", session.VisitorID)
+	assert.Equal(t, 2, session.PagesViewed)
+	require.NotNil(t, session.Duration)
+	assert.Equal(t, 600, *session.Duration)
+}
+
+func TestRecordEngagement(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(db, 30*time.Minute)
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	pageview := &storage.Event{Type: storage.EventTypePageview, Timestamp: start, URL: "https://example.com/", VisitorID: "a"}
+	ping := &storage.Event{Type: storage.EventTypeEngagement, Timestamp: start.Add(2 * time.Minute), URL: "https://example.com/", VisitorID: "a"}
+	require.NoError(t, service.Record(ctx, []*storage.Event{pageview, ping}))
+	assert.Equal(t, pageview.SessionID, ping.SessionID, "Engagement joins the active session")
+
+	session, err := db.GetSessionByID(ctx, pageview.SessionID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, 1, session.PagesViewed)
+	require.NotNil(t, session.Duration)
+	assert.Equal(t, 120, *session.Duration, "Engagement extends the session")
+
+	late := &storage.Event{Type: storage.EventTypeEngagement, Timestamp: start.Add(time.Hour), URL: "https://example.com/", VisitorID: "a"}
+	orphan := &storage.Event{Type: storage.EventTypeEngagement, Timestamp: start, URL: "https://example.com/", VisitorID: "b"}
+	require.NoError(t, service.Record(ctx, []*storage.Event{late, orphan}))
+	assert.Empty(t, late.SessionID, "Engagement after the timeout is dropped")
+	assert.Empty(t, orphan.SessionID, "Engagement never starts a session")
+
+	session, err = db.LastSession(ctx, "b")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+}
+
+func TestRecordUTMAttribution(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(db, 30*time.Minute)
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	// A later hit from the same campaign with another term stays in the
+	// session, which keeps the entry page's parameters
+	entry := &storage.Event{Type: storage.EventTypePageview, Timestamp: start, VisitorID: "a",
+		URL: "https://example.com/?utm_source=news&utm_medium=email&utm_campaign=spring&utm_term=shoes"}
+	next := &storage.Event{Type: storage.EventTypePageview, Timestamp: start.Add(time.Minute), VisitorID: "a",
+		URL: "https://example.com/pricing?utm_source=news&utm_medium=email&utm_campaign=spring&utm_term=boots"}
+	plain := &storage.Event{Type: storage.EventTypePageview, Timestamp: start.Add(2 * time.Minute), VisitorID: "a",
+		URL: "https://example.com/docs"}
+	require.NoError(t, service.Record(ctx, []*storage.Event{entry, next, plain}))
+
+	assert.Equal(t, "shoes", entry.UTM.Term)
+	assert.Equal(t, "boots", next.UTM.Term)
+	assert.True(t, plain.UTM.IsZero())
+	assert.Equal(t, entry.SessionID, plain.SessionID)
+
+	session, err := db.GetSessionByID(ctx, entry.SessionID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, utm.Params{Source: "news", Medium: "email", Campaign: "spring", Term: "shoes"}, session.UTM)
+
+	// ref is an alias for utm_source
+	ref := &storage.Event{Type: storage.EventTypePageview, Timestamp: start, VisitorID: "b",
+		URL: "https://example.com/?ref=producthunt"}
+	require.NoError(t, service.Record(ctx, []*storage.Event{ref}))
+	session, err = db.GetSessionByID(ctx, ref.SessionID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, "producthunt", session.UTM.Source)
+}
+
+func TestRecordClassifiesReferrers(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(db, 30*time.Minute)
+	service.Referrers = referrer.NewClassifier(referrer.DefaultList(), []string{"example.com"})
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	search := &storage.Event{Type: storage.EventTypePageview, Timestamp: start, VisitorID: "a",
+		URL: "https://example.com/", Referrer: "https://www.google.com/search?q=nyla"}
+	// A referrer from another of the site's hosts is internal, so it is
+	// dropped and doesn't start a session
+	internal := &storage.Event{Type: storage.EventTypePageview, Timestamp: start.Add(time.Minute), VisitorID: "a",
+		URL: "https://app.example.com/signup", Referrer: "https://docs.example.com/start"}
+	require.NoError(t, service.Record(ctx, []*storage.Event{search, internal}))
+
+	assert.Equal(t, "google.com", search.ReferrerHost)
+	assert.Equal(t, "Google", search.Source)
+	assert.Equal(t, referrer.ChannelSearch, search.Channel)
+	assert.Empty(t, internal.Referrer)
+	assert.Empty(t, internal.ReferrerHost)
+	assert.Equal(t, search.SessionID, internal.SessionID)
+
+	session, err := db.GetSessionByID(ctx, search.SessionID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, "google.com", session.ReferrerHost)
+	assert.Equal(t, "Google", session.Source)
+	assert.Equal(t, referrer.ChannelSearch, session.Channel)
+
+	direct := &storage.Event{Type: storage.EventTypePageview, Timestamp: start, VisitorID: "b", URL: "https://example.com/"}
+	require.NoError(t, service.Record(ctx, []*storage.Event{direct}))
+	session, err = db.GetSessionByID(ctx, direct.SessionID)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Empty(t, session.Source)
+	assert.Equal(t, referrer.ChannelDirect, session.Channel)
+}
+
+func TestCampaign(t *testing.T) {
+	assert.Equal(t, "", Campaign("https://example.com/?page=1"))
+	assert.Equal(t, "producthunt//", Campaign("https://example.com/?ref=producthunt"))
+	assert.Equal(t, "news/email/spring", Campaign("https://example.com/?utm_source=news&utm_medium=email&utm_campaign=spring"))
+	assert.Equal(t, "news//", Campaign("/landing?utm_source=news"))
+}
+
+func TestNewServiceDefaultsTimeout(t *testing.T) {
+	assert.Equal(t, DefaultTimeout, NewService(nil, 0).Timeout)
+}
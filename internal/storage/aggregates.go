@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// dateLayout is the format of daily_aggregates.date
+const dateLayout = "2006-01-02"
+
+// DailyAggregate is a rolled-up summary of one UTC day
+type DailyAggregate struct {
+	Date               time.Time `json:"date"`
+	Pageviews          int       `json:"pageviews"`
+	UniqueVisitors     int       `json:"unique_visitors"`
+	TotalSessions      int       `json:"total_sessions"`
+	AvgSessionDuration float64   `json:"avg_session_duration"`
+	BounceRate         float64   `json:"bounce_rate"`
+	PagesPerSession    float64   `json:"pages_per_session"`
+}
+
+// RollupDay computes the aggregate for the UTC day containing day from raw
+// events and sessions and upserts it into daily_aggregates. Re-running it for
+// the same day replaces the previous values.
+func (db *DB) RollupDay(ctx context.Context, day time.Time) (*DailyAggregate, error) {
+	start := ResolutionDay.Truncate(day)
+	end := start.AddDate(0, 0, 1)
+	startStr := start.Format(time.RFC3339)
+	endStr := end.Format(time.RFC3339)
+
+	agg := &DailyAggregate{Date: start}
+
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(DISTINCT visitor_id)
+		FROM events
+		WHERE site_id = ?
+		AND type = 'pageview'
+		AND timestamp >= ? AND timestamp < ?
+	`, constants.DefaultSiteID, startStr, endStr).Scan(&agg.Pageviews, &agg.UniqueVisitors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate pageviews: %w", err)
+	}
+
+	// Sessions are attributed to the day they started
+	var avgDuration, bounceRate, pagesPerSession sql.NullFloat64
+	err = db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       AVG(COALESCE(duration, 0)),
+		       AVG(CASE WHEN pages_viewed <= 1 THEN 1.0 ELSE 0.0 END),
+		       AVG(pages_viewed)
+		FROM sessions
+		WHERE site_id = ?
+		AND started_at >= ? AND started_at < ?
+	`, constants.DefaultSiteID, startStr, endStr).Scan(&agg.TotalSessions, &avgDuration, &bounceRate, &pagesPerSession)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate sessions: %w", err)
+	}
+	agg.AvgSessionDuration = avgDuration.Float64
+	agg.BounceRate = bounceRate.Float64
+	agg.PagesPerSession = pagesPerSession.Float64
+
+	_, err = db.conn.ExecContext(ctx, `
+		INSERT INTO daily_aggregates (
+			site_id, date, pageviews, unique_visitors, total_sessions,
+			avg_session_duration, bounce_rate, pages_per_session
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id, date) DO UPDATE SET
+			pageviews = excluded.pageviews,
+			unique_visitors = excluded.unique_visitors,
+			total_sessions = excluded.total_sessions,
+			avg_session_duration = excluded.avg_session_duration,
+			bounce_rate = excluded.bounce_rate,
+			pages_per_session = excluded.pages_per_session`,
+		constants.DefaultSiteID,
+		start.Format(dateLayout),
+		agg.Pageviews,
+		agg.UniqueVisitors,
+		agg.TotalSessions,
+		agg.AvgSessionDuration,
+		agg.BounceRate,
+		agg.PagesPerSession,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert daily aggregate: %w", err)
+	}
+
+	return agg, nil
+}
+
+// aggregateShare is the part of a day's aggregate contributed by some rows
+type aggregateShare struct {
+	Pageviews int
+	Visitors  int
+	Sessions  int
+	// Duration, Bounces and Pages are the sums behind the session averages
+	Duration float64
+	Bounces  float64
+	Pages    float64
+}
+
+// subtractAggregate removes share from the stored aggregate of day, keeping
+// the session averages weighted by the sessions that remain
+func subtractAggregate(ctx context.Context, conn queryer, day string, share aggregateShare) error {
+	var agg DailyAggregate
+	err := conn.QueryRowContext(ctx, `
+		SELECT pageviews, unique_visitors, total_sessions,
+		       COALESCE(avg_session_duration, 0), COALESCE(bounce_rate, 0),
+		       COALESCE(pages_per_session, 0)
+		FROM daily_aggregates
+		WHERE site_id = ? AND date = ?`,
+		constants.DefaultSiteID, day,
+	).Scan(&agg.Pageviews, &agg.UniqueVisitors, &agg.TotalSessions,
+		&agg.AvgSessionDuration, &agg.BounceRate, &agg.PagesPerSession)
+	if err != nil {
+		return fmt.Errorf("failed to read daily aggregate %s: %w", day, err)
+	}
+
+	sessions := agg.TotalSessions - share.Sessions
+	average := func(avg, deleted float64) float64 {
+		if sessions <= 0 {
+			return 0
+		}
+		return max(0, (avg*float64(agg.TotalSessions)-deleted)/float64(sessions))
+	}
+	_, err = conn.ExecContext(ctx, `
+		UPDATE daily_aggregates SET
+			pageviews = ?,
+			unique_visitors = ?,
+			total_sessions = ?,
+			avg_session_duration = ?,
+			bounce_rate = ?,
+			pages_per_session = ?
+		WHERE site_id = ? AND date = ?`,
+		max(0, agg.Pageviews-share.Pageviews),
+		max(0, agg.UniqueVisitors-share.Visitors),
+		max(0, sessions),
+		average(agg.AvgSessionDuration, share.Duration),
+		average(agg.BounceRate, share.Bounces),
+		average(agg.PagesPerSession, share.Pages),
+		constants.DefaultSiteID, day,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update daily aggregate %s: %w", day, err)
+	}
+	return nil
+}
+
+// DaysPendingRollup returns, oldest first, the UTC days before the day
+// containing before that have events but no daily aggregate yet
+func (db *DB) DaysPendingRollup(ctx context.Context, before time.Time) ([]time.Time, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT DISTINCT date(timestamp) AS day
+		FROM events
+		WHERE site_id = ?
+		AND timestamp < ?
+		AND date(timestamp) NOT IN (
+			SELECT date FROM daily_aggregates WHERE site_id = ?
+		)
+		ORDER BY day`,
+		constants.DefaultSiteID,
+		ResolutionDay.Truncate(before).Format(time.RFC3339),
+		constants.DefaultSiteID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending rollup days: %w", err)
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var dayStr sql.NullString
+		if err := rows.Scan(&dayStr); err != nil {
+			return nil, fmt.Errorf("failed to scan pending rollup day: %w", err)
+		}
+		if !dayStr.Valid {
+			continue // unparseable timestamp
+		}
+		day, err := time.Parse(dateLayout, dayStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pending rollup day: %w", err)
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// GetDailyAggregates returns the stored aggregates for UTC days in [from, to)
+func (db *DB) GetDailyAggregates(ctx context.Context, from, to time.Time) ([]DailyAggregate, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT date, pageviews, unique_visitors, total_sessions,
+		       COALESCE(avg_session_duration, 0), COALESCE(bounce_rate, 0),
+		       COALESCE(pages_per_session, 0)
+		FROM daily_aggregates
+		WHERE site_id = ?
+		AND date >= ? AND date < ?
+		ORDER BY date`,
+		constants.DefaultSiteID,
+		from.UTC().Format(dateLayout),
+		to.UTC().Format(dateLayout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query daily aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	var aggregates []DailyAggregate
+	for rows.Next() {
+		var agg DailyAggregate
+		var dateStr string
+		if err := rows.Scan(&dateStr, &agg.Pageviews, &agg.UniqueVisitors, &agg.TotalSessions,
+			&agg.AvgSessionDuration, &agg.BounceRate, &agg.PagesPerSession); err != nil {
+			return nil, fmt.Errorf("failed to scan daily aggregate: %w", err)
+		}
+		if agg.Date, err = time.Parse(dateLayout, dateStr); err != nil {
+			return nil, fmt.Errorf("failed to parse aggregate date: %w", err)
+		}
+		aggregates = append(aggregates, agg)
+	}
+	return aggregates, rows.Err()
+}
+
+// RollupWatermark returns the start of the first day that has not been
+// rolled up. Days are rolled up oldest first, so every earlier day with
+// events has an aggregate. The zero time means nothing was rolled up yet.
+func (db *DB) RollupWatermark(ctx context.Context) (time.Time, error) {
+	var latest sql.NullString
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT MAX(date) FROM daily_aggregates WHERE site_id = ?
+	`, constants.DefaultSiteID).Scan(&latest)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get rollup watermark: %w", err)
+	}
+	if !latest.Valid {
+		return time.Time{}, nil
+	}
+	day, err := time.Parse(dateLayout, latest.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse rollup watermark: %w", err)
+	}
+	return day.AddDate(0, 0, 1), nil
+}
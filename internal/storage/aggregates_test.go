@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollupDay(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: day.Add(9 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: day.Add(9*time.Hour + 2*time.Minute), URL: "/pricing", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: day.Add(11 * time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "pageview", Timestamp: day.AddDate(0, 0, 1), URL: "/", SessionID: "c", VisitorID: "c"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	agg, err := db.RollupDay(ctx, day.Add(15*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, day, agg.Date)
+	assert.Equal(t, 3, agg.Pageviews)
+	assert.Equal(t, 2, agg.UniqueVisitors)
+	assert.Equal(t, 2, agg.TotalSessions)
+	assert.InDelta(t, 60.0, agg.AvgSessionDuration, 0.001, "Sessions of 120s and 0s")
+	assert.InDelta(t, 0.5, agg.BounceRate, 0.001, "One of two sessions viewed a single page")
+	assert.InDelta(t, 1.5, agg.PagesPerSession, 0.001)
+
+	// Re-running is idempotent
+	_, err = db.RollupDay(ctx, day)
+	require.NoError(t, err)
+
+	aggregates, err := db.GetDailyAggregates(ctx, day, day.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	require.Len(t, aggregates, 1)
+	assert.Equal(t, *agg, aggregates[0])
+}
+
+func TestDaysPendingRollup(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day1 := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	day3 := day1.AddDate(0, 0, 2)
+	today := day1.AddDate(0, 0, 4)
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: day3.Add(time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "pageview", Timestamp: day1.Add(time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: today.Add(time.Hour), URL: "/", SessionID: "c", VisitorID: "c"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	days, err := db.DaysPendingRollup(ctx, today.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{day1, day3}, days, "Finished days with events, oldest first")
+
+	_, err = db.RollupDay(ctx, day1)
+	require.NoError(t, err)
+
+	days, err = db.DaysPendingRollup(ctx, today)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{day3}, days)
+}
+
+func TestGetHistoricalStatsUsesAggregates(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day1 := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: day1.Add(9 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: day1.Add(10 * time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "pageview", Timestamp: day2.Add(9 * time.Hour), URL: "/", SessionID: "c", VisitorID: "c"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	before, err := db.GetHistoricalStats(ctx, day1, day2.AddDate(0, 0, 1), ResolutionDay)
+	require.NoError(t, err)
+
+	// Roll up day 1 and drop its raw data; results must not change
+	_, err = db.RollupDay(ctx, day1)
+	require.NoError(t, err)
+	_, err = db.conn.ExecContext(ctx, "DELETE FROM events WHERE timestamp < ?", day2.Format(time.RFC3339))
+	require.NoError(t, err)
+	_, err = db.conn.ExecContext(ctx, "DELETE FROM sessions WHERE started_at < ?", day2.Format(time.RFC3339))
+	require.NoError(t, err)
+
+	after, err := db.GetHistoricalStats(ctx, day1, day2.AddDate(0, 0, 1), ResolutionDay)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Equal(t, 2, after.Points[0].Pageviews)
+	assert.Equal(t, 1, after.Points[1].Pageviews)
+	assert.Equal(t, 3, after.Totals.UniqueVisitors)
+
+	// A range starting mid-day reads the partial day from raw events
+	partial, err := db.GetHistoricalStats(ctx, day2.Add(8*time.Hour), day2.AddDate(0, 0, 1), ResolutionDay)
+	require.NoError(t, err)
+	assert.Equal(t, 1, partial.Totals.Pageviews)
+}
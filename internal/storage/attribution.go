@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// AttributionStats summarises the sessions attributed to one source,
+// channel or UTM parameter value
+type AttributionStats struct {
+	Value string `json:"value"`
+	// Channel is set in source reports
+	Channel   string `json:"channel,omitempty"`
+	Visitors  int    `json:"visitors"`
+	Sessions  int    `json:"sessions"`
+	Pageviews int    `json:"pageviews"`
+	// Conversions counts unique visitors who completed a goal in an
+	// attributed session
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// attributionQuery groups the sessions of session table alias s for an
+// attribution report
+type attributionQuery struct {
+	// group is the SQL expression reported as Value
+	group string
+	// withChannel also groups by channel
+	withChannel bool
+	// filter is an SQL condition on s taking args
+	filter string
+	args   []interface{}
+	from   time.Time
+	to     time.Time
+	goalID int64
+	limit  int
+}
+
+// attributionStats runs an attribution report over sessions started in
+// [q.from, q.to), most visitors first
+func (db *DB) attributionStats(ctx context.Context, q attributionQuery) ([]AttributionStats, error) {
+	converted, args, err := db.conversionCondition(ctx, q.goalID)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := "''"
+	if q.withChannel {
+		channel = "COALESCE(s.channel, '')"
+	}
+	filter := "1"
+	if q.filter != "" {
+		filter = q.filter
+	}
+
+	args = append(args, constants.DefaultSiteID, q.from.UTC().Format(time.RFC3339), q.to.UTC().Format(time.RFC3339))
+	args = append(args, q.args...)
+	args = append(args, q.limit)
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+q.group+`, `+channel+`,
+		       COUNT(DISTINCT s.visitor_id),
+		       COUNT(*),
+		       SUM(s.pages_viewed),
+		       COUNT(DISTINCT CASE WHEN `+converted+` THEN s.visitor_id END)
+		FROM sessions s
+		WHERE s.site_id = ?
+		AND s.started_at >= ? AND s.started_at < ?
+		AND `+filter+`
+		GROUP BY 1, 2
+		ORDER BY 3 DESC, 1
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attribution stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []AttributionStats
+	for rows.Next() {
+		var s AttributionStats
+		if err := rows.Scan(&s.Value, &s.Channel, &s.Visitors, &s.Sessions, &s.Pageviews, &s.Conversions); err != nil {
+			return nil, fmt.Errorf("failed to scan attribution stats: %w", err)
+		}
+		if s.Visitors > 0 {
+			s.ConversionRate = float64(s.Conversions) / float64(s.Visitors)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// conversionCondition returns an SQL condition on session s that holds when
+// the session completed the goal with the given ID, or any goal when goalID
+// is zero. It returns ErrGoalNotFound for unknown goals.
+func (db *DB) conversionCondition(ctx context.Context, goalID int64) (string, []interface{}, error) {
+	goals, err := db.GetGoals(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, goal := range goals {
+		if goalID != 0 && goal.ID != goalID {
+			continue
+		}
+		condition, err := matchCondition(goal.Kind)
+		if err != nil {
+			return "", nil, fmt.Errorf("goal %d: %w", goal.ID, err)
+		}
+		conditions = append(conditions, condition)
+		args = append(args, goal.Match)
+	}
+	if len(conditions) == 0 {
+		if goalID != 0 {
+			return "", nil, ErrGoalNotFound
+		}
+		return "0", nil, nil
+	}
+
+	return `EXISTS (
+			SELECT 1 FROM events e
+			WHERE e.session_id = s.id
+			AND (` + strings.Join(conditions, " OR ") + `))`, args, nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// BotHit is a hit classified as a bot, stored apart from visitor events
+type BotHit struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	URL       string    `json:"url,omitempty"`
+	// Name identifies the bot where possible, such as Googlebot
+	Name string `json:"name,omitempty"`
+	// Reason is how the hit was recognized; see package bots
+	Reason string `json:"reason"`
+}
+
+// BotStats summarises the hits of one bot over a time range
+type BotStats struct {
+	Name     string    `json:"name"`
+	Reason   string    `json:"reason"`
+	Hits     int       `json:"hits"`
+	Pages    int       `json:"pages"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// InsertBotHits stores bot hits in one transaction
+func (db *DB) InsertBotHits(ctx context.Context, hits []*BotHit) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, hit := range hits {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO bot_hits (site_id, timestamp, type, url, name, reason)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			constants.DefaultSiteID, hit.Timestamp.UTC().Format(time.RFC3339), hit.Type,
+			nullString(hit.URL), nullString(hit.Name), hit.Reason)
+		if err != nil {
+			return fmt.Errorf("bot hit %d: failed to insert: %w", i, err)
+		}
+		if hit.ID, err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("bot hit %d: failed to get inserted ID: %w", i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit bot hits: %w", err)
+	}
+	return nil
+}
+
+// GetBotStats groups bot hits in [from, to) by bot name and reason, most
+// hits first
+func (db *DB) GetBotStats(ctx context.Context, from, to time.Time, limit int) ([]BotStats, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT COALESCE(name, ''), reason, COUNT(*), COUNT(DISTINCT url), MAX(timestamp)
+		FROM bot_hits
+		WHERE site_id = ?
+		AND timestamp >= ? AND timestamp < ?
+		GROUP BY 1, 2
+		ORDER BY 3 DESC, 1, 2
+		LIMIT ?`,
+		constants.DefaultSiteID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bot stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []BotStats
+	for rows.Next() {
+		var s BotStats
+		var lastSeen string
+		if err := rows.Scan(&s.Name, &s.Reason, &s.Hits, &s.Pages, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan bot stats: %w", err)
+		}
+		if s.LastSeen, err = time.Parse(time.RFC3339, lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to parse bot hit timestamp: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
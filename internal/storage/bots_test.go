@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBotStats(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	hits := []*BotHit{
+		{Timestamp: day, Type: EventTypePageview, URL: "/", Name: "Googlebot", Reason: "user_agent"},
+		{Timestamp: day.Add(time.Hour), Type: EventTypePageview, URL: "/docs", Name: "Googlebot", Reason: "user_agent"},
+		{Timestamp: day.Add(2 * time.Hour), Type: EventTypePageview, URL: "/docs", Name: "Googlebot", Reason: "user_agent"},
+		{Timestamp: day, Type: EventTypePageview, URL: "/", Reason: "no_user_agent"},
+		{Timestamp: day.AddDate(0, 0, -2), Type: EventTypePageview, URL: "/", Name: "old", Reason: "crawler"},
+	}
+	require.NoError(t, db.InsertBotHits(ctx, hits))
+	assert.NotZero(t, hits[0].ID)
+
+	stats, err := db.GetBotStats(ctx, day.Add(-time.Hour), day.AddDate(0, 0, 1), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []BotStats{
+		{Name: "Googlebot", Reason: "user_agent", Hits: 3, Pages: 2, LastSeen: day.Add(2 * time.Hour)},
+		{Name: "", Reason: "no_user_agent", Hits: 1, Pages: 1, LastSeen: day},
+	}, stats)
+
+	// Bot hits never reach visitor reports
+	realtime, err := db.GetRealtimeStats(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, realtime.TotalSessions)
+}
+
+func TestBotMigrationMovesFlaggedEvents(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Store a bot and a visitor as earlier releases did, with is_bot in
+	// metadata
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+	restore := holdBackMigrations(t, migrationsDir, "010")
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	_, err = db.conn.Exec(`
+		INSERT INTO sessions (id, site_id, started_at, pages_viewed, entry_page)
+		VALUES ('bot', 'default', '2024-03-14T09:00:00Z', 1, '/'),
+		       ('human', 'default', '2024-03-14T09:00:00Z', 1, '/');
+		INSERT INTO events (site_id, type, timestamp, url, session_id, visitor_id, metadata)
+		VALUES ('default', 'pageview', '2024-03-14T09:00:00Z', '/', 'bot', 'bot',
+		        '{"is_bot": true, "browser_name": "Googlebot"}'),
+		       ('default', 'pageview', '2024-03-14T09:00:00Z', '/', 'human', 'human',
+		        '{"is_bot": false}')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	restore()
+	db, err = NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var count int
+	require.NoError(t, db.conn.QueryRow("SELECT COUNT(*) FROM events").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	session, err := db.GetSessionByID(ctx, "bot")
+	require.NoError(t, err)
+	assert.Nil(t, session, "Bot sessions are removed")
+	session, err = db.GetSessionByID(ctx, "human")
+	require.NoError(t, err)
+	assert.NotNil(t, session)
+
+	stats, err := db.GetBotStats(ctx, day, day.AddDate(0, 0, 1), 10)
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, "Googlebot", stats[0].Name)
+	assert.Equal(t, "user_agent", stats[0].Reason)
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// Actions a consent policy takes on opted-out hits, as counted in
+// consent_counts
+const (
+	ConsentActionDropped    = "dropped"
+	ConsentActionAnonymized = "anonymized"
+	ConsentActionIgnored    = "ignored"
+)
+
+// ConsentCount is the number of opted-out hits with one signal and action
+type ConsentCount struct {
+	Signal string `json:"signal"`
+	Action string `json:"action"`
+	Hits   int    `json:"hits"`
+}
+
+// ConsentStats summarises opted-out traffic over whole days
+type ConsentStats struct {
+	// Hits is every hit received: stored events plus dropped hits
+	Hits int `json:"hits"`
+	// OptedOut is the hits sent with an opt-out signal
+	OptedOut int            `json:"opted_out"`
+	Counts   []ConsentCount `json:"counts"`
+}
+
+// Share returns the fraction of hits that opted out
+func (s ConsentStats) Share() float64 {
+	if s.Hits == 0 {
+		return 0
+	}
+	return float64(s.OptedOut) / float64(s.Hits)
+}
+
+// CountConsent adds n opted-out hits to the count for day, signal and action
+func (db *DB) CountConsent(ctx context.Context, day time.Time, signal, action string, n int) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO consent_counts (site_id, date, signal, action, hits)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (site_id, date, signal, action) DO UPDATE SET hits = hits + excluded.hits`,
+		constants.DefaultSiteID, day.UTC().Format(time.DateOnly), signal, action, n)
+	if err != nil {
+		return fmt.Errorf("failed to count opted-out hits: %w", err)
+	}
+	return nil
+}
+
+// GetConsentStats summarises opted-out traffic on the UTC days overlapping
+// [from, to). Counts are kept per day, so partial days are widened to whole
+// ones and the event total covers the same days.
+func (db *DB) GetConsentStats(ctx context.Context, from, to time.Time) (*ConsentStats, error) {
+	start := from.UTC().Truncate(24 * time.Hour)
+	end := to.UTC().Truncate(24 * time.Hour)
+	if end.Before(to) {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT signal, action, SUM(hits)
+		FROM consent_counts
+		WHERE site_id = ?
+		AND date >= ? AND date < ?
+		GROUP BY signal, action
+		ORDER BY 3 DESC, signal, action`,
+		constants.DefaultSiteID, start.Format(time.DateOnly), end.Format(time.DateOnly))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query consent counts: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &ConsentStats{}
+	dropped := 0
+	for rows.Next() {
+		var c ConsentCount
+		if err := rows.Scan(&c.Signal, &c.Action, &c.Hits); err != nil {
+			return nil, fmt.Errorf("failed to scan consent counts: %w", err)
+		}
+		stats.OptedOut += c.Hits
+		if c.Action == ConsentActionDropped {
+			dropped += c.Hits
+		}
+		stats.Counts = append(stats.Counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read consent counts: %w", err)
+	}
+
+	err = db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM events
+		WHERE site_id = ?
+		AND timestamp >= ? AND timestamp < ?`,
+		constants.DefaultSiteID, start.Format(time.RFC3339), end.Format(time.RFC3339),
+	).Scan(&stats.Hits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count events: %w", err)
+	}
+	stats.Hits += dropped
+	return stats, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsentStats(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Hour), URL: "/docs", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypeCustom, Name: "signup", Timestamp: day.Add(time.Hour), URL: "/docs", SessionID: "a", VisitorID: "a"},
+		// An anonymized opted-out hit is stored without a visitor
+		{Type: EventTypePageview, Timestamp: day.Add(2 * time.Hour), URL: "/"},
+		// The next day is outside the range
+		{Type: EventTypePageview, Timestamp: day.AddDate(0, 0, 1), URL: "/", SessionID: "b", VisitorID: "b"},
+	}))
+
+	require.NoError(t, db.CountConsent(ctx, day, "dnt", ConsentActionDropped, 2))
+	require.NoError(t, db.CountConsent(ctx, day.Add(time.Hour), "dnt", ConsentActionDropped, 1))
+	require.NoError(t, db.CountConsent(ctx, day, "gpc", ConsentActionAnonymized, 1))
+	require.NoError(t, db.CountConsent(ctx, day.AddDate(0, 0, 1), "gpc", ConsentActionDropped, 5))
+
+	// Partial days are widened to the whole day
+	stats, err := db.GetConsentStats(ctx, day.Add(8*time.Hour), day.Add(9*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []ConsentCount{
+		{Signal: "dnt", Action: ConsentActionDropped, Hits: 3},
+		{Signal: "gpc", Action: ConsentActionAnonymized, Hits: 1},
+	}, stats.Counts)
+	assert.Equal(t, 4, stats.OptedOut)
+	assert.Equal(t, 7, stats.Hits, "Stored events plus dropped hits")
+	assert.InDelta(t, 4.0/7.0, stats.Share(), 0.0001)
+
+	stats, err = db.GetConsentStats(ctx, day.AddDate(0, 0, -7), day.AddDate(0, 0, -6))
+	require.NoError(t, err)
+	assert.Empty(t, stats.Counts)
+	assert.Zero(t, stats.Share())
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// CountryStats summarises the pageviews from one country
+type CountryStats struct {
+	// Country is an ISO 3166-1 alpha-2 code
+	Country   string `json:"country"`
+	Visitors  int    `json:"visitors"`
+	Pageviews int    `json:"pageviews"`
+}
+
+// GetCountryStats groups pageviews in [from, to) by country, most visitors
+// first. Pageviews without a known country are left out.
+func (db *DB) GetCountryStats(ctx context.Context, from, to time.Time, limit int) ([]CountryStats, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT country, COUNT(DISTINCT visitor_id), COUNT(*)
+		FROM events
+		WHERE site_id = ?
+		AND type = ?
+		AND timestamp >= ? AND timestamp < ?
+		AND country IS NOT NULL AND country != ''
+		GROUP BY country
+		ORDER BY 2 DESC, country
+		LIMIT ?`,
+		constants.DefaultSiteID, EventTypePageview,
+		from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query country stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []CountryStats
+	for rows.Next() {
+		var s CountryStats
+		if err := rows.Scan(&s.Country, &s.Visitors, &s.Pageviews); err != nil {
+			return nil, fmt.Errorf("failed to scan country stats: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCountryStats(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "a", VisitorID: "a", Country: "GB", Region: "England", City: "London"},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a", Country: "GB", Region: "England", City: "London"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "b", VisitorID: "b", Country: "SE"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "c", VisitorID: "c", Country: "DE"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "d", VisitorID: "d", Country: "DE"},
+		// Unknown countries, custom events and pageviews outside the range
+		// aren't counted
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "e", VisitorID: "e"},
+		{Type: EventTypeCustom, Timestamp: day, URL: "/", SessionID: "b", VisitorID: "b", Name: "Signup", Country: "SE"},
+		{Type: EventTypePageview, Timestamp: day.AddDate(0, 0, -2), URL: "/", SessionID: "old", VisitorID: "old", Country: "SE"},
+	}))
+
+	stats, err := db.GetCountryStats(ctx, day.Add(-time.Hour), day.AddDate(0, 0, 1), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []CountryStats{
+		{Country: "DE", Visitors: 2, Pageviews: 2},
+		{Country: "GB", Visitors: 1, Pageviews: 2},
+		{Country: "SE", Visitors: 1, Pageviews: 1},
+	}, stats)
+
+	var region, city string
+	require.NoError(t, db.conn.QueryRow(`SELECT region, city FROM events WHERE session_id = 'a' LIMIT 1`).Scan(&region, &city))
+	assert.Equal(t, "England", region)
+	assert.Equal(t, "London", city)
+}
@@ -6,19 +6,42 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	_ "modernc.org/sqlite"
 
 	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+	"github.com/sunwolfengineering/nyla-core/pkg/device"
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
 )
 
 // DB represents the database connection and operations
 type DB struct {
-	conn *sql.DB
-	path string
+	conn  *sql.DB
+	path  string
+	hooks Hooks
+	salts saltCache
 }
 
+// Hooks observe storage operations, for example to record metrics. Nil
+// fields are skipped.
+type Hooks struct {
+	// AfterInsert is called after InsertEvent or InsertEvents with the events
+	// written, how long the write took and its error
+	AfterInsert func(events []*Event, duration time.Duration, err error)
+}
+
+// Event types with special handling in storage and sessionization
+const (
+	EventTypePageview = "pageview"
+	// EventTypeEngagement is a heartbeat sent while a page stays open. It
+	// extends the visitor's session but is not stored as an event.
+	EventTypeEngagement = "engagement"
+	// EventTypeCustom is a named custom event with optional properties
+	EventTypeCustom = "event"
+)
+
 // Event represents an analytics event
 type Event struct {
 	ID        int64             `json:"id"`
@@ -29,8 +52,29 @@ type Event struct {
 	Title     string            `json:"title,omitempty"`
 	Referrer  string            `json:"referrer,omitempty"`
 	SessionID string            `json:"session_id,omitempty"`
+	VisitorID string            `json:"visitor_id,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 	CreatedAt time.Time         `json:"created_at"`
+	// Name and Properties describe custom events
+	Name       string                 `json:"name,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	// UTM holds the campaign parameters of URL
+	UTM utm.Params `json:"utm"`
+	// ReferrerHost, Source and Channel classify Referrer; see package
+	// referrer
+	ReferrerHost string `json:"referrer_host,omitempty"`
+	Source       string `json:"source,omitempty"`
+	Channel      string `json:"channel,omitempty"`
+	// Device describes the client's device, browser, OS and screen size
+	Device device.Info `json:"device"`
+	// Country, Region and City locate the client. Country is an ISO 3166-1
+	// alpha-2 code.
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+	// Campaign identifies the UTM campaign of the hit. It is not stored on
+	// the event; it is recorded on the session the event starts.
+	Campaign string `json:"-"`
 }
 
 // Session represents a user session
@@ -44,7 +88,16 @@ type Session struct {
 	EntryPage        string            `json:"entry_page,omitempty"`
 	ExitPage         string            `json:"exit_page,omitempty"`
 	Referrer         string            `json:"referrer,omitempty"`
+	VisitorID        string            `json:"visitor_id,omitempty"`
+	Campaign         string            `json:"campaign,omitempty"`
 	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	// UTM holds the campaign parameters of the entry page
+	UTM utm.Params `json:"utm"`
+	// ReferrerHost is the host of the first external referrer. Source and
+	// Channel are those of the entry page.
+	ReferrerHost string `json:"referrer_host,omitempty"`
+	Source       string `json:"source,omitempty"`
+	Channel      string `json:"channel,omitempty"`
 }
 
 // RealtimeStats represents real-time statistics
@@ -130,8 +183,77 @@ func (db *DB) Close() error {
 	return nil
 }
 
+// execer is satisfied by both *sql.DB and *sql.Tx so single and batch inserts
+// can share the same statement
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so reads and writes can
+// run alone or as part of a larger transaction
+type queryer interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// SetHooks installs hooks that observe storage operations. It must be called
+// before the DB is used concurrently.
+func (db *DB) SetHooks(hooks Hooks) {
+	db.hooks = hooks
+}
+
+// afterInsert runs the AfterInsert hook, if any
+func (db *DB) afterInsert(events []*Event, start time.Time, err error) {
+	if db.hooks.AfterInsert != nil {
+		db.hooks.AfterInsert(events, time.Since(start), err)
+	}
+}
+
 // InsertEvent inserts a new event into the database
 func (db *DB) InsertEvent(ctx context.Context, event *Event) error {
+	start := time.Now()
+	err := insertEvent(ctx, db.conn, event)
+	db.afterInsert([]*Event{event}, start, err)
+	return err
+}
+
+// InsertEvents inserts a batch of events in a single transaction. Either all
+// events are stored or none are.
+func (db *DB) InsertEvents(ctx context.Context, events []*Event) error {
+	start := time.Now()
+	err := db.insertEvents(ctx, events)
+	db.afterInsert(events, start, err)
+	return err
+}
+
+// insertEvents writes events in one transaction
+func (db *DB) insertEvents(ctx context.Context, events []*Event) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, event := range events {
+		if err := insertEvent(ctx, tx, event); err != nil {
+			return fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit events: %w", err)
+	}
+	return nil
+}
+
+// insertEvent writes a single event using the given executor
+func insertEvent(ctx context.Context, conn execer, event *Event) error {
 	// Always use default site ID for single-site architecture
 	event.SiteID = constants.DefaultSiteID
 	
@@ -145,21 +267,63 @@ func (db *DB) InsertEvent(ctx context.Context, event *Event) error {
 		metadataJSON = string(data)
 	}
 	
+	var propertiesJSON sql.NullString
+	if len(event.Properties) > 0 {
+		data, err := json.Marshal(event.Properties)
+		if err != nil {
+			return fmt.Errorf("failed to marshal properties: %w", err)
+		}
+		propertiesJSON = sql.NullString{String: string(data), Valid: true}
+	}
+	
+	// Engagement heartbeats only extend their session
+	if event.Type == EventTypeEngagement {
+		if event.SessionID == "" {
+			return nil
+		}
+		return upsertSession(ctx, conn, event)
+	}
+	
 	query := `
 		INSERT INTO events (
-			site_id, type, timestamp, url, title, referrer, session_id, metadata
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+			site_id, type, timestamp, url, title, referrer, session_id, visitor_id, metadata,
+			name, properties,
+			utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+			referrer_host, source, channel,
+			device_type, browser, browser_version, os, os_version, screen_class,
+			country, region, city
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
-	result, err := db.conn.ExecContext(
+	result, err := conn.ExecContext(
 		ctx, query,
 		event.SiteID,
 		event.Type,
-		event.Timestamp.Format(time.RFC3339),
+		event.Timestamp.UTC().Format(time.RFC3339),
 		event.URL,
 		event.Title,
 		event.Referrer,
-		event.SessionID,
+		nullString(event.SessionID),
+		nullString(event.VisitorID),
 		metadataJSON,
+		nullString(event.Name),
+		propertiesJSON,
+		nullString(event.UTM.Source),
+		nullString(event.UTM.Medium),
+		nullString(event.UTM.Campaign),
+		nullString(event.UTM.Term),
+		nullString(event.UTM.Content),
+		nullString(event.ReferrerHost),
+		nullString(event.Source),
+		nullString(event.Channel),
+		nullString(event.Device.Type),
+		nullString(event.Device.Browser),
+		nullString(event.Device.BrowserVersion),
+		nullString(event.Device.OS),
+		nullString(event.Device.OSVersion),
+		nullString(event.Device.Screen),
+		nullString(event.Country),
+		nullString(event.Region),
+		nullString(event.City),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert event: %w", err)
@@ -170,9 +334,99 @@ func (db *DB) InsertEvent(ctx context.Context, event *Event) error {
 		return fmt.Errorf("failed to get inserted event ID: %w", err)
 	}
 	
+	if event.SessionID != "" {
+		if err := upsertSession(ctx, conn, event); err != nil {
+			return err
+		}
+	}
+	
 	return nil
 }
 
+// upsertSession creates the event's session or extends it to cover the
+// event. Only pageviews count towards pages viewed and entry/exit pages, and
+// the session takes its UTM parameters, source and channel from its entry
+// page.
+func upsertSession(ctx context.Context, conn execer, event *Event) error {
+	timestamp := event.Timestamp.UTC().Format(time.RFC3339)
+
+	var pages int
+	var page sql.NullString
+	var params utm.Params
+	var source, channel string
+	if event.Type == EventTypePageview {
+		pages = 1
+		page = sql.NullString{String: event.URL, Valid: true}
+		params = event.UTM
+		source, channel = event.Source, event.Channel
+	}
+
+	_, err := conn.ExecContext(ctx, `
+		INSERT INTO sessions (
+			id, site_id, visitor_id, started_at, ended_at, duration,
+			pages_viewed, entry_page, exit_page, referrer, campaign,
+			utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+			referrer_host, source, channel
+		) VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			started_at = MIN(started_at, excluded.started_at),
+			ended_at = MAX(COALESCE(ended_at, started_at), excluded.ended_at),
+			pages_viewed = pages_viewed + excluded.pages_viewed,
+			referrer = COALESCE(referrer, excluded.referrer),
+			campaign = COALESCE(campaign, excluded.campaign),
+			referrer_host = COALESCE(referrer_host, excluded.referrer_host),
+			entry_page = CASE WHEN `+newEntrySQL+` THEN excluded.entry_page ELSE entry_page END,
+			utm_source = CASE WHEN `+newEntrySQL+` THEN excluded.utm_source ELSE utm_source END,
+			utm_medium = CASE WHEN `+newEntrySQL+` THEN excluded.utm_medium ELSE utm_medium END,
+			utm_campaign = CASE WHEN `+newEntrySQL+` THEN excluded.utm_campaign ELSE utm_campaign END,
+			utm_term = CASE WHEN `+newEntrySQL+` THEN excluded.utm_term ELSE utm_term END,
+			utm_content = CASE WHEN `+newEntrySQL+` THEN excluded.utm_content ELSE utm_content END,
+			source = CASE WHEN `+newEntrySQL+` THEN excluded.source ELSE source END,
+			channel = CASE WHEN `+newEntrySQL+` THEN excluded.channel ELSE channel END,
+			exit_page = CASE
+				WHEN excluded.exit_page IS NOT NULL
+				 AND (exit_page IS NULL OR excluded.ended_at >= COALESCE(ended_at, started_at))
+				THEN excluded.exit_page ELSE exit_page END,
+			duration = CAST(
+				strftime('%s', MAX(COALESCE(ended_at, started_at), excluded.ended_at)) -
+				strftime('%s', MIN(started_at, excluded.started_at)) AS INTEGER
+			)`,
+		event.SessionID,
+		event.SiteID,
+		nullString(event.VisitorID),
+		timestamp,
+		timestamp,
+		pages,
+		page,
+		page,
+		nullString(event.Referrer),
+		nullString(event.Campaign),
+		nullString(params.Source),
+		nullString(params.Medium),
+		nullString(params.Campaign),
+		nullString(params.Term),
+		nullString(params.Content),
+		nullString(event.ReferrerHost),
+		nullString(source),
+		nullString(channel),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// newEntrySQL is true in a session upsert when the incoming pageview becomes
+// the session's entry page. SET expressions all see the row before the
+// update, so it holds for every column that follows the entry page.
+const newEntrySQL = `(excluded.entry_page IS NOT NULL
+				 AND (entry_page IS NULL OR excluded.started_at < started_at))`
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 // GetRealtimeStats returns real-time analytics statistics
 func (db *DB) GetRealtimeStats(ctx context.Context) (*RealtimeStats, error) {
 	stats := &RealtimeStats{}
@@ -180,11 +434,11 @@ func (db *DB) GetRealtimeStats(ctx context.Context) (*RealtimeStats, error) {
 	// Get active visitors (last 30 minutes)
 	// Use datetime comparison with ISO8601 timestamps
 	err := db.conn.QueryRowContext(ctx, `
-		SELECT COUNT(DISTINCT session_id) 
+		SELECT COUNT(DISTINCT visitor_id) 
 		FROM events 
 		WHERE site_id = ? 
 		AND timestamp >= datetime('now', '-30 minutes')
-		AND session_id IS NOT NULL
+		AND visitor_id IS NOT NULL
 	`, constants.DefaultSiteID).Scan(&stats.ActiveVisitors)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get active visitors: %w", err)
@@ -219,7 +473,7 @@ func (db *DB) GetRealtimeStats(ctx context.Context) (*RealtimeStats, error) {
 // GetPopularPages returns the most popular pages in the last 24 hours
 func (db *DB) GetPopularPages(ctx context.Context, limit int) ([]map[string]interface{}, error) {
 	query := `
-		SELECT url, COUNT(*) as pageviews, COUNT(DISTINCT session_id) as unique_views
+		SELECT url, COUNT(*) as pageviews, COUNT(DISTINCT visitor_id) as unique_views
 		FROM events 
 		WHERE site_id = ? 
 		AND type = 'pageview'
@@ -254,22 +508,48 @@ func (db *DB) GetPopularPages(ctx context.Context, limit int) ([]map[string]inte
 	return pages, nil
 }
 
+// sessionColumns are the columns read by scanSession, in order
+const sessionColumns = `id, site_id, started_at, ended_at, duration, pages_viewed,
+		       entry_page, exit_page, referrer, visitor_id, campaign, metadata,
+		       utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+		       referrer_host, source, channel`
+
 // GetSessionByID retrieves a session by its ID
 func (db *DB) GetSessionByID(ctx context.Context, sessionID string) (*Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions 
+		WHERE id = ? AND site_id = ?`
+	
+	return scanSession(db.conn.QueryRowContext(ctx, query, sessionID, constants.DefaultSiteID))
+}
+
+// LastSession returns the visitor's most recently active session, or nil if
+// the visitor has none
+func (db *DB) LastSession(ctx context.Context, visitorID string) (*Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE visitor_id = ? AND site_id = ?
+		ORDER BY ended_at DESC
+		LIMIT 1`
+	
+	return scanSession(db.conn.QueryRowContext(ctx, query, visitorID, constants.DefaultSiteID))
+}
+
+// scanSession reads a session selected with sessionColumns. It returns nil
+// without an error when there is no row.
+func scanSession(row rowScanner) (*Session, error) {
 	session := &Session{}
 	var metadataJSON sql.NullString
 	var endedAtStr sql.NullString
 	var startedAtStr string
 	var duration sql.NullInt64
-	var entryPage, exitPage, referrer sql.NullString
+	var entryPage, exitPage, referrer, visitorID, campaign sql.NullString
+	var utmSource, utmMedium, utmCampaign, utmTerm, utmContent sql.NullString
+	var referrerHost, source, channel sql.NullString
 	
-	query := `
-		SELECT id, site_id, started_at, ended_at, duration, pages_viewed,
-		       entry_page, exit_page, referrer, metadata
-		FROM sessions 
-		WHERE id = ? AND site_id = ?`
-	
-	err := db.conn.QueryRowContext(ctx, query, sessionID, constants.DefaultSiteID).Scan(
+	err := row.Scan(
 		&session.ID,
 		&session.SiteID,
 		&startedAtStr,
@@ -279,7 +559,17 @@ func (db *DB) GetSessionByID(ctx context.Context, sessionID string) (*Session, e
 		&entryPage,
 		&exitPage,
 		&referrer,
+		&visitorID,
+		&campaign,
 		&metadataJSON,
+		&utmSource,
+		&utmMedium,
+		&utmCampaign,
+		&utmTerm,
+		&utmContent,
+		&referrerHost,
+		&source,
+		&channel,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -306,15 +596,21 @@ func (db *DB) GetSessionByID(ctx context.Context, sessionID string) (*Session, e
 		d := int(duration.Int64)
 		session.Duration = &d
 	}
-	if entryPage.Valid {
-		session.EntryPage = entryPage.String
-	}
-	if exitPage.Valid {
-		session.ExitPage = exitPage.String
-	}
-	if referrer.Valid {
-		session.Referrer = referrer.String
+	session.EntryPage = entryPage.String
+	session.ExitPage = exitPage.String
+	session.Referrer = referrer.String
+	session.VisitorID = visitorID.String
+	session.Campaign = campaign.String
+	session.UTM = utm.Params{
+		Source:   utmSource.String,
+		Medium:   utmMedium.String,
+		Campaign: utmCampaign.String,
+		Term:     utmTerm.String,
+		Content:  utmContent.String,
 	}
+	session.ReferrerHost = referrerHost.String
+	session.Source = source.String
+	session.Channel = channel.String
 	
 	// Parse metadata JSON
 	if metadataJSON.Valid && metadataJSON.String != "" {
@@ -326,6 +622,25 @@ func (db *DB) GetSessionByID(ctx context.Context, sessionID string) (*Session, e
 	return session, nil
 }
 
+// FileSizes returns the size in bytes of the database file and its
+// write-ahead log. A missing WAL file counts as zero.
+func (db *DB) FileSizes() (dbBytes, walBytes int64, err error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat database file: %w", err)
+	}
+	dbBytes = info.Size()
+
+	info, err = os.Stat(db.path + "-wal")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dbBytes, 0, nil
+		}
+		return dbBytes, 0, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	return dbBytes, info.Size(), nil
+}
+
 // Ping checks if the database connection is healthy
 func (db *DB) Ping(ctx context.Context) error {
 	return db.conn.PingContext(ctx)
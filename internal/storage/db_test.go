@@ -11,107 +11,51 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// setupTestMigrations creates a temporary migrations directory with the initial schema
+// setupTestMigrations creates a temporary migrations directory holding a copy
+// of the repository's migrations so tests run against the real schema
 func setupTestMigrations(t *testing.T) string {
 	tempDir, err := os.MkdirTemp("", "test_migrations")
 	require.NoError(t, err)
 	
-	// Create a simplified test migration
-	migration := `
-		CREATE TABLE IF NOT EXISTS schema_migrations (
-			version INTEGER PRIMARY KEY,
-			applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
-		);
-		
-		CREATE TABLE site_config (
-			id TEXT PRIMARY KEY DEFAULT 'default',
-			name TEXT NOT NULL DEFAULT 'My Site',
-			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			settings TEXT NOT NULL DEFAULT '{}',
-			CHECK (id = 'default')
-		);
-		
-		INSERT INTO site_config (id, name) VALUES ('default', 'My Site')
-		ON CONFLICT DO NOTHING;
-		
-		CREATE TABLE events (
-			id INTEGER PRIMARY KEY,
-			site_id TEXT NOT NULL DEFAULT 'default',
-			type TEXT NOT NULL,
-			timestamp TEXT NOT NULL,
-			url TEXT,
-			title TEXT,
-			referrer TEXT,
-			session_id TEXT,
-			metadata TEXT,
-			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(site_id) REFERENCES site_config(id),
-			CHECK (site_id = 'default')
-		) STRICT;
-		
-		CREATE INDEX idx_events_timestamp ON events(timestamp);
-		CREATE INDEX idx_events_type_timestamp ON events(type, timestamp);
-		CREATE INDEX idx_events_session ON events(session_id, timestamp);
-		CREATE INDEX idx_events_url ON events(url, timestamp);
-		
-		CREATE TABLE sessions (
-			id TEXT PRIMARY KEY,
-			site_id TEXT NOT NULL DEFAULT 'default',
-			started_at TEXT NOT NULL,
-			ended_at TEXT,
-			duration INTEGER,
-			pages_viewed INTEGER DEFAULT 0,
-			entry_page TEXT,
-			exit_page TEXT,
-			referrer TEXT,
-			metadata TEXT,
-			FOREIGN KEY(site_id) REFERENCES site_config(id),
-			CHECK (site_id = 'default')
-		) STRICT;
-		
-		CREATE INDEX idx_sessions_time ON sessions(started_at);
-		CREATE INDEX idx_sessions_duration ON sessions(duration);
-		
-		CREATE TRIGGER update_session_stats
-		AFTER INSERT ON events
-		WHEN NEW.type = 'pageview'
-		BEGIN
-			INSERT INTO sessions (
-				id, 
-				site_id, 
-				started_at, 
-				ended_at,
-				pages_viewed,
-				entry_page
-			) VALUES (
-				NEW.session_id,
-				NEW.site_id,
-				NEW.timestamp,
-				NEW.timestamp,
-				1,
-				NEW.url
-			)
-			ON CONFLICT(id) DO UPDATE SET 
-				pages_viewed = pages_viewed + 1,
-				ended_at = NEW.timestamp,
-				exit_page = NEW.url,
-				duration = CAST(
-					(strftime('%s', NEW.timestamp) - 
-					 strftime('%s', started_at)) AS INTEGER
-				);
-		END;
-		
-		INSERT INTO schema_migrations (version) VALUES (1);
-	`
-	
-	migrationPath := filepath.Join(tempDir, "001_test_schema.sql")
-	err = os.WriteFile(migrationPath, []byte(migration), 0644)
+	files, err := filepath.Glob(filepath.Join("..", "..", "migrations", "*.sql"))
 	require.NoError(t, err)
+	require.NotEmpty(t, files, "Should find repository migrations")
+	
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(tempDir, filepath.Base(file)), content, 0644)
+		require.NoError(t, err)
+	}
 	
 	return tempDir
 }
 
+// holdBackMigrations removes the migrations in dir from version first
+// onwards so a test can store data under an older schema. The returned
+// function puts them back.
+func holdBackMigrations(t *testing.T, dir, first string) func() {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	require.NoError(t, err)
+
+	held := make(map[string][]byte)
+	for _, file := range files {
+		if filepath.Base(file) < first {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		held[file] = content
+		require.NoError(t, os.Remove(file))
+	}
+
+	return func() {
+		for file, content := range held {
+			require.NoError(t, os.WriteFile(file, content, 0644))
+		}
+	}
+}
+
 func TestNewDB(t *testing.T) {
 	// Create temporary database file
 	dbPath := "test_nyla.db"
@@ -156,7 +100,7 @@ func TestInsertEvent(t *testing.T) {
 		Timestamp: time.Now(),
 		URL:       "/test-page",
 		Title:     "Test Page",
-		SessionID: "test-session-123",
+		SessionID: "test-session-123", VisitorID: "test-session-123",
 		Metadata: map[string]interface{}{
 			"browser": "Chrome",
 			"os":      "macOS",
@@ -193,19 +137,19 @@ func TestGetRealtimeStats(t *testing.T) {
 			Type:      "pageview",
 			Timestamp: time.Now().Add(-10 * time.Minute),
 			URL:       "/page1",
-			SessionID: "session1",
+			SessionID: "session1", VisitorID: "session1",
 		},
 		{
 			Type:      "pageview",
 			Timestamp: time.Now().Add(-5 * time.Minute),
 			URL:       "/page2",
-			SessionID: "session2",
+			SessionID: "session2", VisitorID: "session2",
 		},
 		{
 			Type:      "pageview",
 			Timestamp: time.Now().Add(-1 * time.Hour), // Too old for active visitors
 			URL:       "/page3",
-			SessionID: "session3",
+			SessionID: "session3", VisitorID: "session3",
 		},
 	}
 	
@@ -249,11 +193,11 @@ func TestGetPopularPages(t *testing.T) {
 	
 	// Insert test events for popular pages
 	events := []*Event{
-		{Type: "pageview", Timestamp: time.Now(), URL: "/popular", SessionID: "s1"},
-		{Type: "pageview", Timestamp: time.Now(), URL: "/popular", SessionID: "s2"},
-		{Type: "pageview", Timestamp: time.Now(), URL: "/popular", SessionID: "s3"},
-		{Type: "pageview", Timestamp: time.Now(), URL: "/less-popular", SessionID: "s4"},
-		{Type: "pageview", Timestamp: time.Now(), URL: "/less-popular", SessionID: "s5"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/popular", SessionID: "s1", VisitorID: "s1"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/popular", SessionID: "s2", VisitorID: "s2"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/popular", SessionID: "s3", VisitorID: "s3"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/less-popular", SessionID: "s4", VisitorID: "s4"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/less-popular", SessionID: "s5", VisitorID: "s5"},
 	}
 	
 	for _, event := range events {
@@ -308,7 +252,7 @@ func TestSessionCreation(t *testing.T) {
 	err = db.InsertEvent(ctx, event)
 	require.NoError(t, err)
 	
-	// Check that session was created by trigger
+	// Check that session was created with the event
 	session, err := db.GetSessionByID(ctx, sessionID)
 	require.NoError(t, err)
 	require.NotNil(t, session)
@@ -340,3 +284,226 @@ func TestCleanup(t *testing.T) {
 	err = db.Cleanup(ctx)
 	assert.NoError(t, err)
 }
+
+func TestInsertEvents(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: time.Now(), URL: "/batch-1", SessionID: "batch-session", VisitorID: "batch-session"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/batch-2", SessionID: "batch-session", VisitorID: "batch-session"},
+		{Type: "signup", Timestamp: time.Now(), URL: "/batch-2", SessionID: "batch-session", VisitorID: "batch-session"},
+	}
+
+	err = db.InsertEvents(ctx, events)
+	require.NoError(t, err)
+
+	for _, event := range events {
+		assert.NotZero(t, event.ID)
+		assert.Equal(t, "default", event.SiteID)
+	}
+
+	// Trigger should have folded both pageviews into one session
+	session, err := db.GetSessionByID(ctx, "batch-session")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, 2, session.PagesViewed)
+}
+
+func TestInsertEventsRollsBackOnError(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: time.Now(), URL: "/ok", SessionID: "s1", VisitorID: "s1"},
+		// Channels can't be marshalled, so this event fails mid-batch
+		{Type: "pageview", Timestamp: time.Now(), URL: "/bad", SessionID: "s1", VisitorID: "s1",
+			Metadata: map[string]interface{}{"bad": make(chan int)}},
+	}
+
+	err = db.InsertEvents(ctx, events)
+	require.Error(t, err)
+
+	stats, err := db.GetRealtimeStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.PageviewsToday, "No events should be stored when the batch fails")
+}
+
+func TestInsertHooks(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var calls, inserted int
+	var lastErr error
+	db.SetHooks(Hooks{
+		AfterInsert: func(events []*Event, duration time.Duration, err error) {
+			calls++
+			inserted += len(events)
+			lastErr = err
+		},
+	})
+
+	ctx := context.Background()
+	require.NoError(t, db.InsertEvent(ctx, &Event{Type: "pageview", Timestamp: time.Now(), URL: "/"}))
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: "pageview", Timestamp: time.Now(), URL: "/a"},
+		{Type: "pageview", Timestamp: time.Now(), URL: "/b"},
+	}))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 3, inserted)
+	assert.NoError(t, lastErr)
+
+	err = db.InsertEvent(ctx, &Event{Type: "pageview", Timestamp: time.Now(),
+		Metadata: map[string]interface{}{"bad": make(chan int)}})
+	require.Error(t, err)
+	assert.Equal(t, err, lastErr, "Hooks see insert failures")
+}
+
+func TestFileSizes(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	dbBytes, walBytes, err := db.FileSizes()
+	require.NoError(t, err)
+	assert.Positive(t, dbBytes)
+	assert.GreaterOrEqual(t, walBytes, int64(0))
+}
+
+func TestSessionStatsFromEvents(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	// Events arrive out of order; a custom event extends the session without
+	// counting as a page
+	events := []*Event{
+		{Type: "pageview", Timestamp: start.Add(2 * time.Minute), URL: "/pricing", SessionID: "s1", VisitorID: "v1"},
+		{Type: "pageview", Timestamp: start, URL: "/", Referrer: "https://news.example/", SessionID: "s1", VisitorID: "v1", Campaign: "newsletter"},
+		{Type: "signup", Timestamp: start.Add(5 * time.Minute), URL: "/signup", SessionID: "s1", VisitorID: "v1"},
+		{Type: "pageview", Timestamp: start.Add(3 * time.Hour), URL: "/", SessionID: "s2", VisitorID: "v1"},
+		{Type: "pageview", Timestamp: start.Add(time.Hour), URL: "/", SessionID: "s3", VisitorID: "v2"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	session, err := db.GetSessionByID(ctx, "s1")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, start, session.StartedAt)
+	require.NotNil(t, session.EndedAt)
+	assert.Equal(t, start.Add(5*time.Minute), *session.EndedAt)
+	require.NotNil(t, session.Duration)
+	assert.Equal(t, 300, *session.Duration)
+	assert.Equal(t, 2, session.PagesViewed)
+	assert.Equal(t, "/", session.EntryPage)
+	assert.Equal(t, "/pricing", session.ExitPage)
+	assert.Equal(t, "v1", session.VisitorID)
+	assert.Equal(t, "https://news.example/", session.Referrer, "Attribution is filled in by later events")
+	assert.Equal(t, "newsletter", session.Campaign)
+
+	last, err := db.LastSession(ctx, "v1")
+	require.NoError(t, err)
+	require.NotNil(t, last)
+	assert.Equal(t, "s2", last.ID)
+
+	none, err := db.LastSession(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestEngagementExtendsSession(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	events := []*Event{
+		{Type: EventTypePageview, Timestamp: start, URL: "/article", SessionID: "s1", VisitorID: "v1"},
+		{Type: EventTypeEngagement, Timestamp: start.Add(90 * time.Second), URL: "/article", SessionID: "s1", VisitorID: "v1"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+	assert.Zero(t, events[1].ID, "Engagement is not stored as an event")
+
+	session, err := db.GetSessionByID(ctx, "s1")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, 1, session.PagesViewed, "Engagement is not a pageview")
+	require.NotNil(t, session.Duration)
+	assert.Equal(t, 90, *session.Duration, "Time on the last page counts toward duration")
+
+	var count int
+	require.NoError(t, db.conn.QueryRow("SELECT COUNT(*) FROM events").Scan(&count))
+	assert.Equal(t, 1, count)
+}
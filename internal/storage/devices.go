@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+	"github.com/sunwolfengineering/nyla-core/pkg/device"
+)
+
+// backfillDevices parses the user agents kept in metadata before migration
+// 009 added the device columns. Events stored without the raw user agent
+// keep the browser and OS names recorded at the time.
+func backfillDevices(tx *sql.Tx) error {
+	return backfillTable(tx, "events", "metadata IS NOT NULL AND json_valid(metadata)",
+		[]string{
+			"json_extract(metadata, '$.user_agent')",
+			"json_extract(metadata, '$.browser_name')",
+			"json_extract(metadata, '$.os_name')",
+		},
+		[]string{"device_type", "browser", "browser_version", "os", "os_version"},
+		func(values []string) []interface{} {
+			var info device.Info
+			if values[0] != "" {
+				info = device.Parse(values[0])
+			}
+			browserName, osName := info.Browser, info.OS
+			if browserName == "" {
+				browserName = values[1]
+			}
+			if osName == "" {
+				osName = values[2]
+			}
+			return []interface{}{
+				nullString(info.Type), nullString(browserName), nullString(info.BrowserVersion),
+				nullString(osName), nullString(info.OSVersion),
+			}
+		})
+}
+
+// Device dimensions reports can group by. The version dimensions group by
+// name and major version together, e.g. "Chrome 120".
+const (
+	DeviceDimensionType           = "device"
+	DeviceDimensionBrowser        = "browser"
+	DeviceDimensionBrowserVersion = "browser_version"
+	DeviceDimensionOS             = "os"
+	DeviceDimensionOSVersion      = "os_version"
+	DeviceDimensionScreen         = "screen"
+)
+
+// deviceDimension is how a device dimension is grouped and filtered
+type deviceDimension struct {
+	// value is the SQL expression reported as Value
+	value string
+	// column must be set for an event to be counted
+	column string
+}
+
+// deviceDimensions maps device dimensions to their event columns
+var deviceDimensions = map[string]deviceDimension{
+	DeviceDimensionType:           {value: "device_type", column: "device_type"},
+	DeviceDimensionBrowser:        {value: "browser", column: "browser"},
+	DeviceDimensionBrowserVersion: {value: "TRIM(browser || ' ' || COALESCE(browser_version, ''))", column: "browser"},
+	DeviceDimensionOS:             {value: "os", column: "os"},
+	DeviceDimensionOSVersion:      {value: "TRIM(os || ' ' || COALESCE(os_version, ''))", column: "os"},
+	DeviceDimensionScreen:         {value: "screen_class", column: "screen_class"},
+}
+
+// ValidDeviceDimension reports whether pageviews can be grouped by the named
+// device dimension
+func ValidDeviceDimension(dimension string) bool {
+	_, ok := deviceDimensions[dimension]
+	return ok
+}
+
+// DeviceStats summarises the pageviews of one device type, browser, OS or
+// screen size
+type DeviceStats struct {
+	Value     string `json:"value"`
+	Visitors  int    `json:"visitors"`
+	Pageviews int    `json:"pageviews"`
+}
+
+// DeviceQuery selects a device breakdown
+type DeviceQuery struct {
+	Dimension string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// GetDeviceStats groups pageviews in [from, to) by a device dimension, most
+// visitors first. Pageviews without a value for the dimension are left out.
+func (db *DB) GetDeviceStats(ctx context.Context, q DeviceQuery) ([]DeviceStats, error) {
+	dim, ok := deviceDimensions[q.Dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown device dimension %q", q.Dimension)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+dim.value+` AS value, COUNT(DISTINCT visitor_id), COUNT(*)
+		FROM events
+		WHERE site_id = ?
+		AND type = ?
+		AND timestamp >= ? AND timestamp < ?
+		AND `+dim.column+` IS NOT NULL AND `+dim.column+` != ''
+		GROUP BY value
+		ORDER BY 2 DESC, value
+		LIMIT ?`,
+		constants.DefaultSiteID, EventTypePageview,
+		q.From.UTC().Format(time.RFC3339), q.To.UTC().Format(time.RFC3339), q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []DeviceStats
+	for rows.Next() {
+		var s DeviceStats
+		if err := rows.Scan(&s.Value, &s.Visitors, &s.Pageviews); err != nil {
+			return nil, fmt.Errorf("failed to scan device stats: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
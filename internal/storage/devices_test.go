@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/device"
+)
+
+func TestGetDeviceStats(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	chrome := device.Info{Type: device.TypeDesktop, Browser: "Chrome", BrowserVersion: "120", OS: "Windows", OSVersion: "10", Screen: device.ScreenDesktop}
+	oldChrome := device.Info{Type: device.TypeDesktop, Browser: "Chrome", BrowserVersion: "119", OS: "macOS", OSVersion: "10"}
+	safari := device.Info{Type: device.TypeMobile, Browser: "Safari", BrowserVersion: "17", OS: "iOS", OSVersion: "17", Screen: device.ScreenMobile}
+
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "a", VisitorID: "a", Device: chrome},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a", Device: chrome},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "b", VisitorID: "b", Device: oldChrome},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "c", VisitorID: "c", Device: safari},
+		// Custom events and pageviews outside the range aren't counted
+		{Type: EventTypeCustom, Timestamp: day, URL: "/", SessionID: "c", VisitorID: "c", Name: "Signup", Device: safari},
+		{Type: EventTypePageview, Timestamp: day.AddDate(0, 0, -2), URL: "/", SessionID: "old", VisitorID: "old", Device: safari},
+	}))
+
+	query := func(dimension string) []DeviceStats {
+		stats, err := db.GetDeviceStats(ctx, DeviceQuery{
+			Dimension: dimension, From: day.Add(-time.Hour), To: day.AddDate(0, 0, 1), Limit: 10,
+		})
+		require.NoError(t, err)
+		return stats
+	}
+
+	t.Run("Device types", func(t *testing.T) {
+		assert.Equal(t, []DeviceStats{
+			{Value: device.TypeDesktop, Visitors: 2, Pageviews: 3},
+			{Value: device.TypeMobile, Visitors: 1, Pageviews: 1},
+		}, query(DeviceDimensionType))
+	})
+
+	t.Run("Browser versions", func(t *testing.T) {
+		assert.Equal(t, []DeviceStats{
+			{Value: "Chrome 119", Visitors: 1, Pageviews: 1},
+			{Value: "Chrome 120", Visitors: 1, Pageviews: 2},
+			{Value: "Safari 17", Visitors: 1, Pageviews: 1},
+		}, query(DeviceDimensionBrowserVersion))
+	})
+
+	t.Run("Screens skip unknown sizes", func(t *testing.T) {
+		stats := query(DeviceDimensionScreen)
+		require.Len(t, stats, 2)
+		assert.Equal(t, device.ScreenDesktop, stats[0].Value)
+	})
+
+	t.Run("Unknown dimension", func(t *testing.T) {
+		_, err := db.GetDeviceStats(ctx, DeviceQuery{Dimension: "model"})
+		assert.Error(t, err)
+	})
+}
+
+func TestDeviceMigrationBackfill(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Store events as they were before device columns existed: with the raw
+	// user agent, or only the parsed names
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+	restore := holdBackMigrations(t, migrationsDir, "009")
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`
+		INSERT INTO events (site_id, type, timestamp, url, session_id, metadata)
+		VALUES ('default', 'pageview', '2024-03-14T09:00:00Z', '/', 'ua',
+		        '{"user_agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "browser_name": "Safari", "os_name": "iOS"}'),
+		       ('default', 'pageview', '2024-03-14T09:00:00Z', '/', 'names',
+		        '{"browser_name": "Firefox", "os_name": "Linux"}'),
+		       ('default', 'pageview', '2024-03-14T09:00:00Z', '/', 'none', NULL)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	restore()
+	db, err = NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var deviceType, browser, browserVersion, osName string
+	require.NoError(t, db.conn.QueryRow(
+		"SELECT device_type, browser, browser_version, os FROM events WHERE session_id = 'ua'",
+	).Scan(&deviceType, &browser, &browserVersion, &osName))
+	assert.Equal(t, device.TypeMobile, deviceType)
+	assert.Equal(t, "Safari", browser)
+	assert.Equal(t, "17", browserVersion)
+	assert.Equal(t, "iOS", osName)
+
+	require.NoError(t, db.conn.QueryRow(
+		"SELECT browser, os FROM events WHERE session_id = 'names'",
+	).Scan(&browser, &osName))
+	assert.Equal(t, "Firefox", browser)
+	assert.Equal(t, "Linux", osName)
+
+	var count int
+	require.NoError(t, db.conn.QueryRow(
+		"SELECT COUNT(*) FROM events WHERE session_id = 'none' AND browser IS NULL",
+	).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// CustomEventCount summarises one custom event name over a time range
+type CustomEventCount struct {
+	Name     string `json:"name"`
+	Events   int    `json:"events"`
+	Visitors int    `json:"visitors"`
+}
+
+// PropertyValueCount summarises one value of a custom event property
+type PropertyValueCount struct {
+	Value    string `json:"value"`
+	Events   int    `json:"events"`
+	Visitors int    `json:"visitors"`
+}
+
+// EventQuery selects custom events. Properties filters on exact property
+// values, compared as text.
+type EventQuery struct {
+	Name       string
+	From       time.Time
+	To         time.Time
+	Properties map[string]string
+	Limit      int
+}
+
+// propertyTextSQL renders the property at JSON path :path as text. Booleans
+// render as true/false rather than SQLite's 1/0.
+const propertyTextSQL = `CASE json_type(properties, %[1]s)
+	WHEN 'true' THEN 'true'
+	WHEN 'false' THEN 'false'
+	ELSE CAST(json_extract(properties, %[1]s) AS TEXT) END`
+
+// PropertyPath returns the JSON1 path of a top-level custom event property
+func PropertyPath(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("property name is required")
+	}
+	if strings.ContainsAny(key, "\"\\") {
+		return "", fmt.Errorf("property name must not contain quotes or backslashes")
+	}
+	return `$."` + key + `"`, nil
+}
+
+// GetCustomEvents returns the custom event names seen in [from, to), most
+// frequent first
+func (db *DB) GetCustomEvents(ctx context.Context, from, to time.Time, limit int) ([]CustomEventCount, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT name, COUNT(*), COUNT(DISTINCT visitor_id)
+		FROM events
+		WHERE site_id = ?
+		AND type = ?
+		AND timestamp >= ? AND timestamp < ?
+		GROUP BY name
+		ORDER BY COUNT(*) DESC, name
+		LIMIT ?`,
+		constants.DefaultSiteID, EventTypeCustom,
+		from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom events: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []CustomEventCount
+	for rows.Next() {
+		var c CustomEventCount
+		if err := rows.Scan(&c.Name, &c.Events, &c.Visitors); err != nil {
+			return nil, fmt.Errorf("failed to scan custom event count: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// GetEventPropertyValues breaks down the custom events named name in
+// [from, to) by the value of property. Events without the property are not
+// counted.
+func (db *DB) GetEventPropertyValues(ctx context.Context, name, property string, from, to time.Time, limit int) ([]PropertyValueCount, error) {
+	path, err := PropertyPath(property)
+	if err != nil {
+		return nil, err
+	}
+
+	value := fmt.Sprintf(propertyTextSQL, "?")
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT `+value+` AS value, COUNT(*), COUNT(DISTINCT visitor_id)
+		FROM events
+		WHERE site_id = ?
+		AND type = ?
+		AND name = ?
+		AND timestamp >= ? AND timestamp < ?
+		AND json_type(properties, ?) IS NOT NULL
+		GROUP BY value
+		ORDER BY COUNT(*) DESC, value
+		LIMIT ?`,
+		path, path,
+		constants.DefaultSiteID, EventTypeCustom, name,
+		from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339),
+		path, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event properties: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []PropertyValueCount
+	for rows.Next() {
+		var c PropertyValueCount
+		if err := rows.Scan(&c.Value, &c.Events, &c.Visitors); err != nil {
+			return nil, fmt.Errorf("failed to scan property value count: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// QueryCustomEvents returns the custom events matching q, newest first
+func (db *DB) QueryCustomEvents(ctx context.Context, q EventQuery) ([]*Event, error) {
+	where := []string{"site_id = ?", "type = ?", "timestamp >= ?", "timestamp < ?"}
+	args := []interface{}{
+		constants.DefaultSiteID, EventTypeCustom,
+		q.From.UTC().Format(time.RFC3339), q.To.UTC().Format(time.RFC3339),
+	}
+	if q.Name != "" {
+		where = append(where, "name = ?")
+		args = append(args, q.Name)
+	}
+	for key, value := range q.Properties {
+		path, err := PropertyPath(key)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf(propertyTextSQL, "?")+" = ?")
+		args = append(args, path, path, value)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, timestamp, COALESCE(url, ''), COALESCE(session_id, ''),
+		       COALESCE(visitor_id, ''), COALESCE(name, ''), COALESCE(properties, '')
+		FROM events
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query custom events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		event := &Event{SiteID: constants.DefaultSiteID, Type: EventTypeCustom}
+		var timestamp, properties string
+		if err := rows.Scan(&event.ID, &timestamp, &event.URL, &event.SessionID,
+			&event.VisitorID, &event.Name, &properties); err != nil {
+			return nil, fmt.Errorf("failed to scan custom event: %w", err)
+		}
+		if event.Timestamp, err = time.Parse(time.RFC3339, timestamp); err != nil {
+			return nil, fmt.Errorf("failed to parse event timestamp: %w", err)
+		}
+		if properties != "" {
+			if err := json.Unmarshal([]byte(properties), &event.Properties); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal event properties: %w", err)
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomEventQueries(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	custom := func(offset time.Duration, visitor, name string, props map[string]interface{}) *Event {
+		return &Event{
+			Type: EventTypeCustom, Timestamp: day.Add(offset), URL: "/pricing",
+			SessionID: visitor, VisitorID: visitor, Name: name, Properties: props,
+		}
+	}
+
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day, URL: "/pricing", SessionID: "a", VisitorID: "a"},
+		custom(time.Hour, "a", "Signup", map[string]interface{}{"plan": "pro", "seats": 5, "trial": true}),
+		custom(2*time.Hour, "b", "Signup", map[string]interface{}{"plan": "free", "seats": 1, "trial": false}),
+		custom(3*time.Hour, "c", "Signup", map[string]interface{}{"plan": "pro", "seats": 5}),
+		custom(4*time.Hour, "c", "Download", nil),
+	}))
+
+	t.Run("Names", func(t *testing.T) {
+		counts, err := db.GetCustomEvents(ctx, day, day.AddDate(0, 0, 1), 10)
+		require.NoError(t, err)
+		assert.Equal(t, []CustomEventCount{
+			{Name: "Signup", Events: 3, Visitors: 3},
+			{Name: "Download", Events: 1, Visitors: 1},
+		}, counts)
+	})
+
+	t.Run("Property breakdown", func(t *testing.T) {
+		counts, err := db.GetEventPropertyValues(ctx, "Signup", "plan", day, day.AddDate(0, 0, 1), 10)
+		require.NoError(t, err)
+		assert.Equal(t, []PropertyValueCount{
+			{Value: "pro", Events: 2, Visitors: 2},
+			{Value: "free", Events: 1, Visitors: 1},
+		}, counts)
+
+		counts, err = db.GetEventPropertyValues(ctx, "Signup", "trial", day, day.AddDate(0, 0, 1), 10)
+		require.NoError(t, err)
+		assert.Equal(t, []PropertyValueCount{
+			{Value: "false", Events: 1, Visitors: 1},
+			{Value: "true", Events: 1, Visitors: 1},
+		}, counts, "Booleans render as true/false and missing properties are skipped")
+	})
+
+	t.Run("Filter by property", func(t *testing.T) {
+		events, err := db.QueryCustomEvents(ctx, EventQuery{
+			Name:       "Signup",
+			From:       day,
+			To:         day.AddDate(0, 0, 1),
+			Properties: map[string]string{"plan": "pro", "seats": "5"},
+			Limit:      10,
+		})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, "c", events[0].VisitorID, "Newest first")
+		assert.Equal(t, "pro", events[0].Properties["plan"])
+		assert.Equal(t, float64(5), events[0].Properties["seats"])
+	})
+
+	t.Run("Invalid property name", func(t *testing.T) {
+		_, err := db.GetEventPropertyValues(ctx, "Signup", `pl"an`, day, day.AddDate(0, 0, 1), 10)
+		assert.Error(t, err)
+	})
+}
+
+func TestPropertyPath(t *testing.T) {
+	path, err := PropertyPath("plan type")
+	require.NoError(t, err)
+	assert.Equal(t, `$."plan type"`, path)
+
+	_, err = PropertyPath("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,369 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// Funnel size limits
+const (
+	MinFunnelSteps = 2
+	MaxFunnelSteps = 10
+)
+
+var (
+	// ErrFunnelExists is returned when creating a funnel whose name is taken
+	ErrFunnelExists = errors.New("funnel already exists")
+	// ErrFunnelNotFound is returned when a funnel ID doesn't exist
+	ErrFunnelNotFound = errors.New("funnel not found")
+)
+
+// FunnelStep is one step of a funnel. Steps match events like goals do: Kind
+// is GoalKindEvent or GoalKindPageview and Match is an event name or a URL
+// path pattern.
+type FunnelStep struct {
+	Kind  string `json:"kind"`
+	Match string `json:"match"`
+	Label string `json:"label,omitempty"`
+}
+
+// DisplayLabel returns the step's label, or its match when it has none
+func (s FunnelStep) DisplayLabel() string {
+	if s.Label != "" {
+		return s.Label
+	}
+	return s.Match
+}
+
+// Funnel is an ordered sequence of steps a session is expected to complete
+type Funnel struct {
+	ID    int64        `json:"id"`
+	Name  string       `json:"name"`
+	Steps []FunnelStep `json:"steps"`
+	// WindowSeconds limits the time from the first to the last step. Zero
+	// allows the whole session.
+	WindowSeconds int       `json:"window_seconds,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Validate checks that the funnel is well formed
+func (f *Funnel) Validate() error {
+	if strings.TrimSpace(f.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(f.Steps) < MinFunnelSteps || len(f.Steps) > MaxFunnelSteps {
+		return fmt.Errorf("funnels must have between %d and %d steps", MinFunnelSteps, MaxFunnelSteps)
+	}
+	for i, step := range f.Steps {
+		if err := validateMatch(step.Kind, step.Match); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	if f.WindowSeconds < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	return nil
+}
+
+// FunnelStepResult reports how many sessions reached a funnel step
+type FunnelStepResult struct {
+	FunnelStep
+	Sessions int `json:"sessions"`
+	// DropOff counts sessions that reached this step but not the next
+	DropOff int `json:"drop_off"`
+	// ConversionRate is the share of sessions entering the funnel that
+	// reached this step
+	ConversionRate float64 `json:"conversion_rate"`
+	// StepRate is the share of sessions reaching the previous step that
+	// reached this one
+	StepRate float64 `json:"step_rate"`
+}
+
+// FunnelReport holds a funnel's results over a time range
+type FunnelReport struct {
+	Funnel Funnel             `json:"funnel"`
+	From   time.Time          `json:"from"`
+	To     time.Time          `json:"to"`
+	Steps  []FunnelStepResult `json:"steps"`
+}
+
+// CreateFunnel validates and stores funnel with its steps, setting its ID
+func (db *DB) CreateFunnel(ctx context.Context, funnel *Funnel) error {
+	if err := funnel.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var window sql.NullInt64
+	if funnel.WindowSeconds > 0 {
+		window = sql.NullInt64{Int64: int64(funnel.WindowSeconds), Valid: true}
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO funnels (site_id, name, window_seconds)
+		VALUES (?, ?, ?)
+		ON CONFLICT(site_id, name) DO NOTHING`,
+		constants.DefaultSiteID, funnel.Name, window)
+	if err != nil {
+		return fmt.Errorf("failed to create funnel: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to create funnel: %w", err)
+	} else if n == 0 {
+		return ErrFunnelExists
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get funnel ID: %w", err)
+	}
+
+	for i, step := range funnel.Steps {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO funnel_steps (funnel_id, position, kind, match, label)
+			VALUES (?, ?, ?, ?, ?)`,
+			id, i, step.Kind, step.Match, nullString(step.Label))
+		if err != nil {
+			return fmt.Errorf("failed to create funnel step: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit funnel: %w", err)
+	}
+	funnel.ID = id
+	funnel.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	return nil
+}
+
+// GetFunnels returns all funnels with their steps, ordered by name
+func (db *DB) GetFunnels(ctx context.Context) ([]Funnel, error) {
+	return db.queryFunnels(ctx, "")
+}
+
+// GetFunnel returns the funnel with the given ID
+func (db *DB) GetFunnel(ctx context.Context, id int64) (*Funnel, error) {
+	funnels, err := db.queryFunnels(ctx, "AND f.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(funnels) == 0 {
+		return nil, ErrFunnelNotFound
+	}
+	return &funnels[0], nil
+}
+
+// queryFunnels loads funnels matching the extra condition with their steps
+func (db *DB) queryFunnels(ctx context.Context, condition string, args ...interface{}) ([]Funnel, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT f.id, f.name, COALESCE(f.window_seconds, 0), f.created_at,
+		       s.kind, s.match, COALESCE(s.label, '')
+		FROM funnels f
+		JOIN funnel_steps s ON s.funnel_id = f.id
+		WHERE f.site_id = ? `+condition+`
+		ORDER BY f.name, f.id, s.position`,
+		append([]interface{}{constants.DefaultSiteID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query funnels: %w", err)
+	}
+	defer rows.Close()
+
+	var funnels []Funnel
+	for rows.Next() {
+		var f Funnel
+		var step FunnelStep
+		var createdAt string
+		if err := rows.Scan(&f.ID, &f.Name, &f.WindowSeconds, &createdAt,
+			&step.Kind, &step.Match, &step.Label); err != nil {
+			return nil, fmt.Errorf("failed to scan funnel: %w", err)
+		}
+		if n := len(funnels); n > 0 && funnels[n-1].ID == f.ID {
+			funnels[n-1].Steps = append(funnels[n-1].Steps, step)
+			continue
+		}
+		// CURRENT_TIMESTAMP is "YYYY-MM-DD HH:MM:SS" in UTC
+		if f.CreatedAt, err = time.Parse(time.DateTime, createdAt); err != nil {
+			return nil, fmt.Errorf("failed to parse funnel created_at: %w", err)
+		}
+		f.Steps = []FunnelStep{step}
+		funnels = append(funnels, f)
+	}
+	return funnels, rows.Err()
+}
+
+// DeleteFunnel removes the funnel with the given ID and its steps
+func (db *DB) DeleteFunnel(ctx context.Context, id int64) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// foreign_keys is set per connection, so don't rely on the cascade
+	if _, err := tx.ExecContext(ctx, `DELETE FROM funnel_steps WHERE funnel_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete funnel steps: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM funnels WHERE site_id = ? AND id = ?`,
+		constants.DefaultSiteID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete funnel: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete funnel: %w", err)
+	}
+	if n == 0 {
+		return ErrFunnelNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit funnel deletion: %w", err)
+	}
+	return nil
+}
+
+// funnelHit is an event that matches at least one funnel step
+type funnelHit struct {
+	at    time.Time
+	steps []bool
+}
+
+// GetFunnelReport computes how many sessions reached each step of the funnel
+// in order, using events in [from, to). Other events may occur between
+// steps. A session reaches step n when it completed steps 1 to n in order,
+// within the funnel's window of the first step if it has one.
+func (db *DB) GetFunnelReport(ctx context.Context, id int64, from, to time.Time) (*FunnelReport, error) {
+	funnel, err := db.GetFunnel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// One flag column per step, and only events matching some step
+	var flags, conditions []string
+	var matches []interface{}
+	for _, step := range funnel.Steps {
+		condition, err := matchCondition(step.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("funnel %d: %w", funnel.ID, err)
+		}
+		flags = append(flags, "CASE WHEN "+condition+" THEN 1 ELSE 0 END")
+		conditions = append(conditions, condition)
+		matches = append(matches, step.Match)
+	}
+
+	args := append([]interface{}{}, matches...)
+	args = append(args, constants.DefaultSiteID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	args = append(args, matches...)
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT session_id, timestamp, `+strings.Join(flags, ", ")+`
+		FROM events
+		WHERE site_id = ?
+		AND session_id IS NOT NULL
+		AND timestamp >= ? AND timestamp < ?
+		AND (`+strings.Join(conditions, " OR ")+`)
+		ORDER BY session_id, timestamp, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query funnel events: %w", err)
+	}
+	defer rows.Close()
+
+	window := time.Duration(funnel.WindowSeconds) * time.Second
+	reached := make([]int, len(funnel.Steps))
+	count := func(hits []funnelHit) {
+		for i := 0; i < funnelDepth(hits, len(funnel.Steps), window); i++ {
+			reached[i]++
+		}
+	}
+
+	var session string
+	var hits []funnelHit
+	dest := make([]interface{}, 2+len(funnel.Steps))
+	flagValues := make([]int, len(funnel.Steps))
+	for rows.Next() {
+		var sessionID, timestamp string
+		dest[0], dest[1] = &sessionID, &timestamp
+		for i := range flagValues {
+			dest[2+i] = &flagValues[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan funnel event: %w", err)
+		}
+		at, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse event timestamp: %w", err)
+		}
+
+		if sessionID != session {
+			count(hits)
+			session, hits = sessionID, hits[:0]
+		}
+		hit := funnelHit{at: at, steps: make([]bool, len(flagValues))}
+		for i, v := range flagValues {
+			hit.steps[i] = v == 1
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read funnel events: %w", err)
+	}
+	count(hits)
+
+	report := &FunnelReport{Funnel: *funnel, From: from, To: to}
+	for i, step := range funnel.Steps {
+		result := FunnelStepResult{FunnelStep: step, Sessions: reached[i]}
+		if i+1 < len(reached) {
+			result.DropOff = reached[i] - reached[i+1]
+		}
+		if reached[0] > 0 {
+			result.ConversionRate = float64(reached[i]) / float64(reached[0])
+		}
+		switch {
+		case i == 0 && reached[0] > 0:
+			result.StepRate = 1
+		case i > 0 && reached[i-1] > 0:
+			result.StepRate = float64(reached[i]) / float64(reached[i-1])
+		}
+		report.Steps = append(report.Steps, result)
+	}
+	return report, nil
+}
+
+// funnelDepth returns how many steps a session's hits complete in order.
+// Each hit matching the first step is tried as a start, so a start whose
+// window expires doesn't hide a later, complete attempt.
+func funnelDepth(hits []funnelHit, steps int, window time.Duration) int {
+	best := 0
+	for i, start := range hits {
+		if !start.steps[0] {
+			continue
+		}
+		depth := 1
+		for _, hit := range hits[i+1:] {
+			if depth == steps {
+				break
+			}
+			if window > 0 && hit.at.Sub(start.at) > window {
+				break
+			}
+			if hit.steps[depth] {
+				depth++
+			}
+		}
+		if depth > best {
+			best = depth
+		}
+		if best == steps {
+			break
+		}
+	}
+	return best
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunnels(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	funnel := &Funnel{
+		Name: "Signup",
+		Steps: []FunnelStep{
+			{Kind: GoalKindPageview, Match: "/", Label: "Landing"},
+			{Kind: GoalKindPageview, Match: "/pricing"},
+			{Kind: GoalKindEvent, Match: "Signup"},
+		},
+		WindowSeconds: 600,
+	}
+	require.NoError(t, db.CreateFunnel(ctx, funnel))
+	assert.NotZero(t, funnel.ID)
+
+	t.Run("Validation", func(t *testing.T) {
+		assert.ErrorIs(t, db.CreateFunnel(ctx, &Funnel{Name: "Signup", Steps: funnel.Steps}), ErrFunnelExists)
+		assert.Error(t, db.CreateFunnel(ctx, &Funnel{Name: "Short", Steps: funnel.Steps[:1]}))
+		assert.Error(t, db.CreateFunnel(ctx, &Funnel{Name: "Bad step", Steps: []FunnelStep{
+			{Kind: GoalKindPageview, Match: "/"}, {Kind: GoalKindPageview, Match: "pricing"},
+		}}))
+	})
+
+	pageview := func(session string, offset time.Duration, url string) *Event {
+		return &Event{Type: EventTypePageview, Timestamp: day.Add(offset), URL: url, SessionID: session, VisitorID: session}
+	}
+	signup := func(session string, offset time.Duration) *Event {
+		return &Event{Type: EventTypeCustom, Timestamp: day.Add(offset), URL: "/pricing", SessionID: session, VisitorID: session, Name: "Signup"}
+	}
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		// Completes the funnel with an unrelated page in between
+		pageview("a", 0, "https://example.com/"),
+		pageview("a", time.Minute, "https://example.com/about"),
+		pageview("a", 2*time.Minute, "https://example.com/pricing"),
+		signup("a", 3*time.Minute),
+		// Drops off at pricing
+		pageview("b", 0, "https://example.com/"),
+		pageview("b", time.Minute, "https://example.com/pricing"),
+		// Out of order: pricing before landing doesn't count
+		pageview("c", 0, "https://example.com/pricing"),
+		pageview("c", time.Minute, "https://example.com/"),
+		// Signup outside the window of the first landing, but within the
+		// window of the second
+		pageview("d", 0, "https://example.com/"),
+		pageview("d", 20*time.Minute, "https://example.com/"),
+		pageview("d", 21*time.Minute, "https://example.com/pricing"),
+		signup("d", 22*time.Minute),
+		// Window expires before signup
+		pageview("e", 0, "https://example.com/"),
+		pageview("e", time.Minute, "https://example.com/pricing"),
+		signup("e", 30*time.Minute),
+		// Never enters the funnel
+		pageview("f", 0, "https://example.com/pricing"),
+	}))
+
+	t.Run("Report", func(t *testing.T) {
+		report, err := db.GetFunnelReport(ctx, funnel.ID, day, day.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, report.Steps, 3)
+
+		assert.Equal(t, "Landing", report.Steps[0].DisplayLabel())
+		assert.Equal(t, 5, report.Steps[0].Sessions)
+		assert.Equal(t, 1, report.Steps[0].DropOff)
+		assert.InDelta(t, 1, report.Steps[0].StepRate, 0.001)
+
+		assert.Equal(t, "/pricing", report.Steps[1].DisplayLabel())
+		assert.Equal(t, 4, report.Steps[1].Sessions)
+		assert.Equal(t, 2, report.Steps[1].DropOff)
+
+		assert.Equal(t, 2, report.Steps[2].Sessions)
+		assert.Zero(t, report.Steps[2].DropOff)
+		assert.InDelta(t, 0.4, report.Steps[2].ConversionRate, 0.001)
+		assert.InDelta(t, 0.5, report.Steps[2].StepRate, 0.001)
+	})
+
+	t.Run("Range excludes events", func(t *testing.T) {
+		report, err := db.GetFunnelReport(ctx, funnel.ID, day.Add(24*time.Hour), day.Add(48*time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, report.Steps[0].Sessions)
+		assert.Zero(t, report.Steps[0].StepRate)
+	})
+
+	t.Run("List and delete", func(t *testing.T) {
+		funnels, err := db.GetFunnels(ctx)
+		require.NoError(t, err)
+		require.Len(t, funnels, 1)
+		assert.Len(t, funnels[0].Steps, 3)
+		assert.Equal(t, 600, funnels[0].WindowSeconds)
+
+		require.NoError(t, db.DeleteFunnel(ctx, funnel.ID))
+		assert.ErrorIs(t, db.DeleteFunnel(ctx, funnel.ID), ErrFunnelNotFound)
+		_, err = db.GetFunnelReport(ctx, funnel.ID, day, day.Add(time.Hour))
+		assert.ErrorIs(t, err, ErrFunnelNotFound)
+	})
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// Goal kinds
+const (
+	// GoalKindEvent goals are completed by a custom event with a given name
+	GoalKindEvent = "event"
+	// GoalKindPageview goals are completed by a pageview whose path matches
+	// a glob pattern such as /blog/*. As in SQLite's GLOB, * also matches /.
+	GoalKindPageview = "pageview"
+)
+
+var (
+	// ErrGoalExists is returned when creating a goal whose name is taken
+	ErrGoalExists = errors.New("goal already exists")
+	// ErrGoalNotFound is returned when a goal ID doesn't exist
+	ErrGoalNotFound = errors.New("goal not found")
+)
+
+func init() {
+	// url_path lets pageview goals match on the path of stored URLs, which
+	// may be absolute or relative
+	sqlite.MustRegisterDeterministicScalarFunction("url_path", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			raw, ok := args[0].(string)
+			if !ok {
+				return nil, nil
+			}
+			return urlPath(raw), nil
+		})
+}
+
+// urlPath returns the path of rawURL without query or fragment
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.Path == "" && u.Host != "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// Goal is a conversion target
+type Goal struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Match     string    `json:"match"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks that the goal is well formed
+func (g *Goal) Validate() error {
+	if strings.TrimSpace(g.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	return validateMatch(g.Kind, g.Match)
+}
+
+// validateMatch checks the kind and match of a goal or funnel step
+func validateMatch(kind, match string) error {
+	switch kind {
+	case GoalKindEvent:
+		if match == "" {
+			return fmt.Errorf("match must be an event name")
+		}
+	case GoalKindPageview:
+		if !strings.HasPrefix(match, "/") {
+			return fmt.Errorf("match must be a path pattern starting with /")
+		}
+		if _, err := path.Match(match, ""); err != nil {
+			return fmt.Errorf("invalid path pattern: %w", err)
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s", GoalKindEvent, GoalKindPageview)
+	}
+	return nil
+}
+
+// matchCondition returns the SQL condition selecting events that complete a
+// goal or funnel step of the given kind. It has one placeholder for the match.
+func matchCondition(kind string) (string, error) {
+	switch kind {
+	case GoalKindEvent:
+		return "(type = '" + EventTypeCustom + "' AND name = ?)", nil
+	case GoalKindPageview:
+		return "(type = '" + EventTypePageview + "' AND url_path(url) GLOB ?)", nil
+	}
+	return "", fmt.Errorf("unknown kind %q", kind)
+}
+
+// GoalStats reports completions of a goal over a time range
+type GoalStats struct {
+	Goal
+	// Completions counts every matching event
+	Completions int `json:"completions"`
+	// Conversions counts unique visitors who completed the goal
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// GoalReport holds the stats of every goal over a time range
+type GoalReport struct {
+	From     time.Time   `json:"from"`
+	To       time.Time   `json:"to"`
+	Visitors int         `json:"visitors"`
+	Goals    []GoalStats `json:"goals"`
+}
+
+// CreateGoal validates and stores goal, setting its ID
+func (db *DB) CreateGoal(ctx context.Context, goal *Goal) error {
+	if err := goal.Validate(); err != nil {
+		return err
+	}
+
+	result, err := db.conn.ExecContext(ctx, `
+		INSERT INTO goals (site_id, name, kind, match)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(site_id, name) DO NOTHING`,
+		constants.DefaultSiteID, goal.Name, goal.Kind, goal.Match)
+	if err != nil {
+		return fmt.Errorf("failed to create goal: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to create goal: %w", err)
+	} else if n == 0 {
+		return ErrGoalExists
+	}
+
+	goal.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get goal ID: %w", err)
+	}
+	goal.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	return nil
+}
+
+// GetGoals returns all goals ordered by name
+func (db *DB) GetGoals(ctx context.Context) ([]Goal, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, name, kind, match, created_at
+		FROM goals
+		WHERE site_id = ?
+		ORDER BY name`, constants.DefaultSiteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query goals: %w", err)
+	}
+	defer rows.Close()
+
+	var goals []Goal
+	for rows.Next() {
+		var g Goal
+		var createdAt string
+		if err := rows.Scan(&g.ID, &g.Name, &g.Kind, &g.Match, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan goal: %w", err)
+		}
+		// CURRENT_TIMESTAMP is "YYYY-MM-DD HH:MM:SS" in UTC
+		if g.CreatedAt, err = time.Parse(time.DateTime, createdAt); err != nil {
+			return nil, fmt.Errorf("failed to parse goal created_at: %w", err)
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+// DeleteGoal removes the goal with the given ID
+func (db *DB) DeleteGoal(ctx context.Context, id int64) error {
+	result, err := db.conn.ExecContext(ctx, `DELETE FROM goals WHERE site_id = ? AND id = ?`,
+		constants.DefaultSiteID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete goal: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete goal: %w", err)
+	}
+	if n == 0 {
+		return ErrGoalNotFound
+	}
+	return nil
+}
+
+// GetGoalStats reports completions and conversion rate of every goal in
+// [from, to). The conversion rate is the share of unique visitors in the
+// range who completed the goal. Goals are computed from raw events, so
+// ranges older than the events retention period report no completions.
+func (db *DB) GetGoalStats(ctx context.Context, from, to time.Time) (*GoalReport, error) {
+	goals, err := db.GetGoals(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fromStr := from.UTC().Format(time.RFC3339)
+	toStr := to.UTC().Format(time.RFC3339)
+	report := &GoalReport{From: from, To: to, Goals: make([]GoalStats, 0, len(goals))}
+
+	err = db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT visitor_id)
+		FROM events
+		WHERE site_id = ?
+		AND timestamp >= ? AND timestamp < ?
+	`, constants.DefaultSiteID, fromStr, toStr).Scan(&report.Visitors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count visitors: %w", err)
+	}
+
+	for _, goal := range goals {
+		condition, err := matchCondition(goal.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("goal %d: %w", goal.ID, err)
+		}
+
+		stats := GoalStats{Goal: goal}
+		err = db.conn.QueryRowContext(ctx, `
+			SELECT COUNT(*), COUNT(DISTINCT visitor_id)
+			FROM events
+			WHERE site_id = ?
+			AND timestamp >= ? AND timestamp < ?
+			AND `+condition,
+			constants.DefaultSiteID, fromStr, toStr, goal.Match,
+		).Scan(&stats.Completions, &stats.Conversions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count completions of goal %q: %w", goal.Name, err)
+		}
+		if report.Visitors > 0 {
+			stats.ConversionRate = float64(stats.Conversions) / float64(report.Visitors)
+		}
+		report.Goals = append(report.Goals, stats)
+	}
+	return report, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoals(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	signup := &Goal{Name: "Signed up", Kind: GoalKindEvent, Match: "Signup"}
+	docs := &Goal{Name: "Read docs", Kind: GoalKindPageview, Match: "/docs/*"}
+	require.NoError(t, db.CreateGoal(ctx, signup))
+	require.NoError(t, db.CreateGoal(ctx, docs))
+	assert.NotZero(t, signup.ID)
+
+	t.Run("Duplicate name", func(t *testing.T) {
+		err := db.CreateGoal(ctx, &Goal{Name: "Signed up", Kind: GoalKindEvent, Match: "Other"})
+		assert.ErrorIs(t, err, ErrGoalExists)
+	})
+
+	t.Run("Invalid goals", func(t *testing.T) {
+		assert.Error(t, db.CreateGoal(ctx, &Goal{Name: "", Kind: GoalKindEvent, Match: "x"}))
+		assert.Error(t, db.CreateGoal(ctx, &Goal{Name: "Bad kind", Kind: "click", Match: "x"}))
+		assert.Error(t, db.CreateGoal(ctx, &Goal{Name: "Relative", Kind: GoalKindPageview, Match: "docs"}))
+		assert.Error(t, db.CreateGoal(ctx, &Goal{Name: "Bad glob", Kind: GoalKindPageview, Match: "/docs/[a"}))
+	})
+
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day, URL: "https://example.com/docs/intro?ref=nav", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/docs/api/events", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day, URL: "https://example.com/", SessionID: "b", VisitorID: "b"},
+		{Type: EventTypeCustom, Timestamp: day.Add(time.Minute), URL: "/", SessionID: "b", VisitorID: "b", Name: "Signup"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/pricing", SessionID: "c", VisitorID: "c"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/docs", SessionID: "d", VisitorID: "d"},
+	}))
+
+	t.Run("Stats", func(t *testing.T) {
+		report, err := db.GetGoalStats(ctx, day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		assert.Equal(t, 4, report.Visitors)
+		require.Len(t, report.Goals, 2)
+
+		// Ordered by name
+		assert.Equal(t, "Read docs", report.Goals[0].Name)
+		assert.Equal(t, 2, report.Goals[0].Completions)
+		assert.Equal(t, 1, report.Goals[0].Conversions, "/docs itself doesn't match /docs/*")
+		assert.InDelta(t, 0.25, report.Goals[0].ConversionRate, 0.001)
+
+		assert.Equal(t, "Signed up", report.Goals[1].Name)
+		assert.Equal(t, 1, report.Goals[1].Completions)
+		assert.InDelta(t, 0.25, report.Goals[1].ConversionRate, 0.001)
+	})
+
+	t.Run("Empty range", func(t *testing.T) {
+		report, err := db.GetGoalStats(ctx, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		assert.Zero(t, report.Visitors)
+		assert.Zero(t, report.Goals[0].ConversionRate)
+	})
+
+	t.Run("List and delete", func(t *testing.T) {
+		goals, err := db.GetGoals(ctx)
+		require.NoError(t, err)
+		require.Len(t, goals, 2)
+		assert.False(t, goals[0].CreatedAt.IsZero())
+
+		require.NoError(t, db.DeleteGoal(ctx, signup.ID))
+		assert.ErrorIs(t, db.DeleteGoal(ctx, signup.ID), ErrGoalNotFound)
+
+		goals, err = db.GetGoals(ctx)
+		require.NoError(t, err)
+		assert.Len(t, goals, 1)
+	})
+}
+
+func TestURLPath(t *testing.T) {
+	assert.Equal(t, "/docs/intro", urlPath("https://example.com/docs/intro?ref=nav#top"))
+	assert.Equal(t, "/", urlPath("https://example.com"))
+	assert.Equal(t, "/pricing", urlPath("/pricing?plan=pro"))
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// HealthStats summarizes ingestion activity for health checks
+type HealthStats struct {
+	EventsToday int `json:"events_today"`
+	ActiveSites int `json:"active_sites"`
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 if no
+// migrations have been applied
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := db.conn.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// GetHealthStats counts events recorded since the start of the current UTC
+// day and the sites they belong to
+func (db *DB) GetHealthStats(ctx context.Context, now time.Time) (*HealthStats, error) {
+	today := now.UTC().Truncate(24 * time.Hour).Format(time.RFC3339)
+
+	stats := &HealthStats{}
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(DISTINCT site_id)
+		FROM events
+		WHERE timestamp >= ?
+	`, today).Scan(&stats.EventsToday, &stats.ActiveSites)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get health stats: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	version, err := db.SchemaVersion(context.Background())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, version, 1)
+}
+
+func TestGetHealthStats(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: now.Add(-time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: now.Add(-14 * time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "pageview", Timestamp: now.Add(-16 * time.Hour), URL: "/", SessionID: "c", VisitorID: "c"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	stats, err := db.GetHealthStats(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.EventsToday, "Events before UTC midnight are not counted")
+	assert.Equal(t, 1, stats.ActiveSites)
+}
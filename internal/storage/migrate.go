@@ -12,6 +12,17 @@ import (
 	"strings"
 )
 
+// backfills fill the columns a migration adds from data already stored,
+// such as URLs and user agents, with the same Go parsing as ingestion. Each
+// runs right after its migration's SQL, in the same transaction, so the
+// SQL files stay plain SQLite. A database migrated by other means, such as
+// the sqlite3 CLI, keeps these columns empty for existing rows.
+var backfills = map[int]func(tx *sql.Tx) error{
+	7: backfillUTM,
+	8: backfillSources,
+	9: backfillDevices,
+}
+
 // MigrationRunner handles database migrations
 type MigrationRunner struct {
 	db *sql.DB
@@ -187,6 +198,13 @@ func (m *MigrationRunner) applyMigration(migration Migration) error {
 	if err != nil {
 		return fmt.Errorf("failed to execute migration SQL: %w", err)
 	}
+
+	// Fill new columns from stored data the SQL can't parse
+	if backfill, ok := backfills[migration.Version]; ok {
+		if err := backfill(tx); err != nil {
+			return fmt.Errorf("failed to backfill: %w", err)
+		}
+	}
 	
 	// Record migration in schema_migrations table
 	_, err = tx.Exec(
@@ -230,3 +248,65 @@ func (m *MigrationRunner) Status(migrationsPath string) error {
 	
 	return nil
 }
+
+// backfillBatchSize is the number of rows a backfill reads per query
+const backfillBatchSize = 500
+
+// backfillTable pages through the rows of table matching where in rowid
+// order. For each row, fn receives the values of the columns expressions
+// and returns the values to write to the set columns.
+func backfillTable(tx *sql.Tx, table, where string, columns, set []string, fn func(values []string) []interface{}) error {
+	// Table, columns and conditions come from the backfills, never from input
+	selectQuery := fmt.Sprintf(`
+		SELECT rowid, %s FROM %s
+		WHERE rowid > ? AND (%s)
+		ORDER BY rowid
+		LIMIT ?`, strings.Join(columns, ", "), table, where)
+	assignments := make([]string, len(set))
+	for i, column := range set {
+		assignments[i] = column + " = ?"
+	}
+	updateQuery := fmt.Sprintf(`UPDATE %s SET %s WHERE rowid = ?`, table, strings.Join(assignments, ", "))
+
+	type update struct {
+		rowid  int64
+		values []interface{}
+	}
+	var last int64
+	for {
+		rows, err := tx.Query(selectQuery, last, backfillBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		var updates []update
+		for rows.Next() {
+			raw := make([]sql.NullString, len(columns))
+			dest := []interface{}{&last}
+			for i := range raw {
+				dest = append(dest, &raw[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s: %w", table, err)
+			}
+			values := make([]string, len(raw))
+			for i, v := range raw {
+				values[i] = v.String
+			}
+			updates = append(updates, update{last, fn(values)})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+
+		for _, u := range updates {
+			if _, err := tx.Exec(updateQuery, append(u.values, u.rowid)...); err != nil {
+				return fmt.Errorf("failed to backfill %s: %w", table, err)
+			}
+		}
+		if len(updates) < backfillBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+	"github.com/sunwolfengineering/nyla-core/pkg/device"
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// Privacy log actions of data subject requests
+const (
+	PrivacyActionFind   = "find"
+	PrivacyActionExport = "export"
+	PrivacyActionDelete = "delete"
+)
+
+// ErrEmptySelector is returned for a DataSelector without any criteria,
+// which would select every stored event
+var ErrEmptySelector = errors.New("selector needs a session ID, URL pattern or time range")
+
+// DataSelector selects the stored data of a data subject request. Set
+// criteria are combined, so a session ID and a time range select the part of
+// the session in that range.
+//
+// An event matches when it meets every criterion. A session matches when it
+// has a matching event or itself meets the criteria, and sessions are always
+// selected whole: every event of a matching session is selected with it, so
+// a URL pattern selects every visit that viewed a matching page.
+type DataSelector struct {
+	SessionID string `json:"session_id,omitempty"`
+	// URL is a glob pattern such as /users/jane* matched against the full
+	// URL and against its path. As in SQLite's GLOB, it is case sensitive
+	// and * also matches /.
+	URL string `json:"url,omitempty"`
+	// From and To bound event timestamps and session starts to [From, To).
+	// Either may be zero to leave that side open.
+	From time.Time `json:"-"`
+	To   time.Time `json:"-"`
+}
+
+// MarshalJSON leaves out unset criteria, including open sides of the time
+// range
+func (s DataSelector) MarshalJSON() ([]byte, error) {
+	type criteria struct {
+		SessionID string     `json:"session_id,omitempty"`
+		URL       string     `json:"url,omitempty"`
+		From      *time.Time `json:"from,omitempty"`
+		To        *time.Time `json:"to,omitempty"`
+	}
+	c := criteria{SessionID: s.SessionID, URL: s.URL}
+	if !s.From.IsZero() {
+		from := s.From.UTC()
+		c.From = &from
+	}
+	if !s.To.IsZero() {
+		to := s.To.UTC()
+		c.To = &to
+	}
+	return json.Marshal(c)
+}
+
+// Validate reports whether the selector has criteria and a valid time range
+func (s DataSelector) Validate() error {
+	if s.SessionID == "" && s.URL == "" && s.From.IsZero() && s.To.IsZero() {
+		return ErrEmptySelector
+	}
+	if !s.From.IsZero() && !s.To.IsZero() && !s.From.Before(s.To) {
+		return errors.New("from must be before to")
+	}
+	return nil
+}
+
+// logEntry returns the data_type and identifier recorded in privacy_logs,
+// naming the most specific criterion. The full selector goes in metadata.
+func (s DataSelector) logEntry() (dataType, identifier string) {
+	switch {
+	case s.SessionID != "":
+		return "session", s.SessionID
+	case s.URL != "":
+		return "url", s.URL
+	}
+	var from, to string
+	if !s.From.IsZero() {
+		from = s.From.UTC().Format(time.RFC3339)
+	}
+	if !s.To.IsZero() {
+		to = s.To.UTC().Format(time.RFC3339)
+	}
+	return "time_range", from + "/" + to
+}
+
+// conditions returns the SQL conditions and arguments selecting events
+// (onSessions false) or sessions (onSessions true) by their own columns
+func (s DataSelector) conditions(onSessions bool) ([]string, []interface{}) {
+	id, urlConds, timeColumn := "session_id", []string{"url"}, "timestamp"
+	if onSessions {
+		id, urlConds, timeColumn = "id", []string{"entry_page", "exit_page"}, "started_at"
+	}
+
+	where := []string{"site_id = ?"}
+	args := []interface{}{constants.DefaultSiteID}
+	if s.SessionID != "" {
+		where = append(where, id+" = ?")
+		args = append(args, s.SessionID)
+	}
+	if s.URL != "" {
+		var or []string
+		for _, c := range urlConds {
+			or = append(or, c+" GLOB ?", "url_path("+c+") GLOB ?")
+			args = append(args, s.URL, s.URL)
+		}
+		where = append(where, "("+strings.Join(or, " OR ")+")")
+	}
+	if !s.From.IsZero() {
+		where = append(where, timeColumn+" >= ?")
+		args = append(args, s.From.UTC().Format(time.RFC3339))
+	}
+	if !s.To.IsZero() {
+		where = append(where, timeColumn+" < ?")
+		args = append(args, s.To.UTC().Format(time.RFC3339))
+	}
+	return where, args
+}
+
+// selection is the resolved set of data a selector selects
+type selection struct {
+	// sessions holds the IDs of the selected sessions as a JSON array, for
+	// use with json_each
+	sessions string
+	// eventsWhere selects the matching events and every event of a selected
+	// session
+	eventsWhere string
+	eventsArgs  []interface{}
+}
+
+// resolve finds the sessions a selector selects. Resolving them once keeps
+// the selection stable while its events are deleted.
+func (s DataSelector) resolve(ctx context.Context, conn queryer) (*selection, error) {
+	eventWhere, eventArgs := s.conditions(false)
+	sessionWhere, sessionArgs := s.conditions(true)
+	args := append(append([]interface{}{}, eventArgs...), sessionArgs...)
+	rows, err := conn.QueryContext(ctx, `
+		SELECT session_id FROM events
+		WHERE `+strings.Join(eventWhere, " AND ")+` AND session_id IS NOT NULL
+		UNION
+		SELECT id FROM sessions
+		WHERE `+strings.Join(sessionWhere, " AND "), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select sessions: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan session ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to select sessions: %w", err)
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session IDs: %w", err)
+	}
+
+	return &selection{
+		sessions: string(data),
+		eventsWhere: "site_id = ? AND ((" + strings.Join(eventWhere, " AND ") + ")" +
+			" OR session_id IN (SELECT value FROM json_each(?)))",
+		eventsArgs: append(append([]interface{}{constants.DefaultSiteID}, eventArgs...), string(data)),
+	}, nil
+}
+
+// DataMatch summarizes the stored data a selector selects
+type DataMatch struct {
+	Selector DataSelector `json:"selector"`
+	Events   int          `json:"events"`
+	Sessions int          `json:"sessions"`
+	// FirstSeen and LastSeen span the selected events; they are nil when
+	// no events are selected
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// Days lists, for deletions, the UTC days whose daily aggregates were
+	// adjusted
+	Days []string `json:"days,omitempty"`
+}
+
+// count fills in the event and session counts of a selection
+func (m *DataMatch) count(ctx context.Context, conn queryer, sel *selection) error {
+	var first, last sql.NullString
+	err := conn.QueryRowContext(ctx, `
+		SELECT COUNT(*), MIN(timestamp), MAX(timestamp)
+		FROM events
+		WHERE `+sel.eventsWhere, sel.eventsArgs...).Scan(&m.Events, &first, &last)
+	if err != nil {
+		return fmt.Errorf("failed to count selected events: %w", err)
+	}
+	for _, t := range []struct {
+		value sql.NullString
+		dst   **time.Time
+	}{{first, &m.FirstSeen}, {last, &m.LastSeen}} {
+		if !t.value.Valid {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value.String)
+		if err != nil {
+			return fmt.Errorf("failed to parse event timestamp: %w", err)
+		}
+		*t.dst = &parsed
+	}
+
+	err = conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sessions
+		WHERE site_id = ? AND id IN (SELECT value FROM json_each(?))`,
+		constants.DefaultSiteID, sel.sessions).Scan(&m.Sessions)
+	if err != nil {
+		return fmt.Errorf("failed to count selected sessions: %w", err)
+	}
+	return nil
+}
+
+// metadata returns the privacy log metadata recording a match
+func (m *DataMatch) metadata() map[string]interface{} {
+	metadata := map[string]interface{}{
+		"selector": m.Selector,
+		"events":   m.Events,
+		"sessions": m.Sessions,
+	}
+	if len(m.Days) > 0 {
+		metadata["days"] = m.Days
+	}
+	return metadata
+}
+
+// FindData counts the events and sessions sel selects and records the
+// lookup in privacy_logs
+func (db *DB) FindData(ctx context.Context, sel DataSelector) (*DataMatch, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, err
+	}
+	resolved, err := sel.resolve(ctx, db.conn)
+	if err != nil {
+		return nil, err
+	}
+	match := &DataMatch{Selector: sel}
+	if err := match.count(ctx, db.conn, resolved); err != nil {
+		return nil, err
+	}
+
+	dataType, identifier := sel.logEntry()
+	if err := db.LogPrivacyAction(ctx, PrivacyActionFind, dataType, identifier, match.metadata()); err != nil {
+		return nil, err
+	}
+	return match, nil
+}
+
+// DataExport holds every stored event and session a selector selects
+type DataExport struct {
+	Selector   DataSelector `json:"selector"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Sessions   []*Session   `json:"sessions"`
+	Events     []*Event     `json:"events"`
+}
+
+// ExportData returns the events and sessions sel selects, oldest first, and
+// records the export in privacy_logs. It reads from one transaction so
+// sessions and events are consistent with each other.
+func (db *DB) ExportData(ctx context.Context, sel DataSelector) (*DataExport, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, err
+	}
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resolved, err := sel.resolve(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	export := &DataExport{Selector: sel, ExportedAt: time.Now().UTC(), Sessions: []*Session{}, Events: []*Event{}}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE site_id = ? AND id IN (SELECT value FROM json_each(?))
+		ORDER BY started_at, id`, constants.DefaultSiteID, resolved.sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query selected sessions: %w", err)
+	}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Sessions = append(export.Sessions, session)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query selected sessions: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT `+eventColumns+`
+		FROM events
+		WHERE `+resolved.eventsWhere+`
+		ORDER BY timestamp, id`, resolved.eventsArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query selected events: %w", err)
+	}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Events = append(export.Events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query selected events: %w", err)
+	}
+
+	dataType, identifier := sel.logEntry()
+	metadata := map[string]interface{}{
+		"selector": sel,
+		"events":   len(export.Events),
+		"sessions": len(export.Sessions),
+	}
+	if err := logPrivacyAction(ctx, tx, PrivacyActionExport, dataType, identifier, metadata); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit export: %w", err)
+	}
+	return export, nil
+}
+
+// DeleteData deletes the events and sessions sel selects in one
+// transaction. Their contribution is subtracted from the daily aggregates
+// already rolled up in the same transaction, and the deletion is recorded in
+// privacy_logs. Aggregates aren't rebuilt from the remaining rows, since
+// retention may already have purged part of a day. Days not rolled up yet
+// are left to the rollup job.
+func (db *DB) DeleteData(ctx context.Context, sel DataSelector) (*DataMatch, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, err
+	}
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resolved, err := sel.resolve(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	match := &DataMatch{Selector: sel}
+	if err := match.count(ctx, tx, resolved); err != nil {
+		return nil, err
+	}
+
+	shares, visitors, err := aggregateShares(ctx, tx, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM events WHERE `+resolved.eventsWhere, resolved.eventsArgs...); err != nil {
+		return nil, fmt.Errorf("failed to delete events: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM sessions
+		WHERE site_id = ? AND id IN (SELECT value FROM json_each(?))`,
+		constants.DefaultSiteID, resolved.sessions); err != nil {
+		return nil, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	// A deleted visitor stops counting towards a day only once none of
+	// their pageviews that day remain
+	for day, ids := range visitors {
+		start, err := time.Parse(dateLayout, day)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse affected day: %w", err)
+		}
+		for _, id := range ids {
+			var remaining bool
+			err := tx.QueryRowContext(ctx, `
+				SELECT EXISTS (
+					SELECT 1 FROM events
+					WHERE site_id = ? AND type = 'pageview' AND visitor_id = ?
+					AND timestamp >= ? AND timestamp < ?
+				)`,
+				constants.DefaultSiteID, id,
+				start.Format(time.RFC3339), start.AddDate(0, 0, 1).Format(time.RFC3339),
+			).Scan(&remaining)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check remaining visitor: %w", err)
+			}
+			if !remaining {
+				shares[day].Visitors++
+			}
+		}
+	}
+	for day, share := range shares {
+		if err := subtractAggregate(ctx, tx, day, *share); err != nil {
+			return nil, err
+		}
+		match.Days = append(match.Days, day)
+	}
+	sort.Strings(match.Days)
+
+	dataType, identifier := sel.logEntry()
+	if err := logPrivacyAction(ctx, tx, PrivacyActionDelete, dataType, identifier, match.metadata()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	return match, nil
+}
+
+// aggregateShares measures what the selected rows contributed to days that
+// are already rolled up, before they are deleted. Visitor counts depend on
+// what remains after the deletion, so for each day the deleted pageviews'
+// visitor IDs are returned instead.
+func aggregateShares(ctx context.Context, conn queryer, sel *selection) (map[string]*aggregateShare, map[string][]string, error) {
+	shares := make(map[string]*aggregateShare)
+	share := func(day string) *aggregateShare {
+		if shares[day] == nil {
+			shares[day] = &aggregateShare{}
+		}
+		return shares[day]
+	}
+	rolledUp := `IN (SELECT date FROM daily_aggregates WHERE site_id = ?)`
+
+	// Aggregates count pageviews by event day
+	rows, err := conn.QueryContext(ctx, `
+		SELECT date(timestamp), COUNT(*), json_group_array(DISTINCT visitor_id)
+		FROM events
+		WHERE `+sel.eventsWhere+`
+		AND type = 'pageview'
+		AND date(timestamp) `+rolledUp+`
+		GROUP BY 1`,
+		append(append([]interface{}{}, sel.eventsArgs...), constants.DefaultSiteID)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to measure deleted pageviews: %w", err)
+	}
+	visitors := make(map[string][]string)
+	for rows.Next() {
+		var day, ids string
+		var pageviews int
+		if err := rows.Scan(&day, &pageviews, &ids); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan deleted pageviews: %w", err)
+		}
+		share(day).Pageviews = pageviews
+		var list []*string
+		if err := json.Unmarshal([]byte(ids), &list); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to unmarshal deleted visitors: %w", err)
+		}
+		for _, id := range list {
+			if id != nil {
+				visitors[day] = append(visitors[day], *id)
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to measure deleted pageviews: %w", err)
+	}
+
+	// and sessions by the day they started
+	rows, err = conn.QueryContext(ctx, `
+		SELECT date(started_at), COUNT(*),
+		       SUM(COALESCE(duration, 0)),
+		       SUM(CASE WHEN pages_viewed <= 1 THEN 1.0 ELSE 0.0 END),
+		       COALESCE(SUM(pages_viewed), 0)
+		FROM sessions
+		WHERE site_id = ? AND id IN (SELECT value FROM json_each(?))
+		AND date(started_at) `+rolledUp+`
+		GROUP BY 1`,
+		constants.DefaultSiteID, sel.sessions, constants.DefaultSiteID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to measure deleted sessions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day string
+		var s aggregateShare
+		if err := rows.Scan(&day, &s.Sessions, &s.Duration, &s.Bounces, &s.Pages); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan deleted sessions: %w", err)
+		}
+		d := share(day)
+		d.Sessions, d.Duration, d.Bounces, d.Pages = s.Sessions, s.Duration, s.Bounces, s.Pages
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to measure deleted sessions: %w", err)
+	}
+	return shares, visitors, nil
+}
+
+// eventColumns are the columns read by scanEvent, in order
+const eventColumns = `id, site_id, type, timestamp, url, title, referrer, session_id,
+		       visitor_id, metadata, name, properties,
+		       utm_source, utm_medium, utm_campaign, utm_term, utm_content,
+		       referrer_host, source, channel,
+		       device_type, browser, browser_version, os, os_version, screen_class,
+		       country, region, city, created_at`
+
+// scanEvent reads an event selected with eventColumns
+func scanEvent(row rowScanner) (*Event, error) {
+	event := &Event{}
+	var timestamp, createdAt string
+	var metadataJSON, propertiesJSON sql.NullString
+	var text [23]sql.NullString
+	err := row.Scan(&event.ID, &event.SiteID, &event.Type, &timestamp,
+		&text[0], &text[1], &text[2], &text[3], &text[4], &metadataJSON,
+		&text[5], &propertiesJSON,
+		&text[6], &text[7], &text[8], &text[9], &text[10],
+		&text[11], &text[12], &text[13],
+		&text[14], &text[15], &text[16], &text[17], &text[18], &text[19],
+		&text[20], &text[21], &text[22], &createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan event: %w", err)
+	}
+
+	if event.Timestamp, err = time.Parse(time.RFC3339, timestamp); err != nil {
+		return nil, fmt.Errorf("failed to parse event timestamp: %w", err)
+	}
+	// created_at is set by SQLite's CURRENT_TIMESTAMP
+	if event.CreatedAt, err = time.Parse(time.DateTime, createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse event created_at: %w", err)
+	}
+	event.URL = text[0].String
+	event.Title = text[1].String
+	event.Referrer = text[2].String
+	event.SessionID = text[3].String
+	event.VisitorID = text[4].String
+	event.Name = text[5].String
+	event.UTM = utm.Params{
+		Source:   text[6].String,
+		Medium:   text[7].String,
+		Campaign: text[8].String,
+		Term:     text[9].String,
+		Content:  text[10].String,
+	}
+	event.ReferrerHost = text[11].String
+	event.Source = text[12].String
+	event.Channel = text[13].String
+	event.Device = device.Info{
+		Type:           text[14].String,
+		Browser:        text[15].String,
+		BrowserVersion: text[16].String,
+		OS:             text[17].String,
+		OSVersion:      text[18].String,
+		Screen:         text[19].String,
+	}
+	event.Country = text[20].String
+	event.Region = text[21].String
+	event.City = text[22].String
+
+	if metadataJSON.Valid && metadataJSON.String != "" {
+		if err := json.Unmarshal([]byte(metadataJSON.String), &event.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event metadata: %w", err)
+		}
+	}
+	if propertiesJSON.Valid && propertiesJSON.String != "" {
+		if err := json.Unmarshal([]byte(propertiesJSON.String), &event.Properties); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event properties: %w", err)
+		}
+	}
+	return event, nil
+}
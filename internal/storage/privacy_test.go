@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataSelectorValidate(t *testing.T) {
+	day := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+
+	assert.ErrorIs(t, DataSelector{}.Validate(), ErrEmptySelector)
+	assert.Error(t, DataSelector{From: day, To: day}.Validate())
+	assert.NoError(t, DataSelector{SessionID: "a"}.Validate())
+	assert.NoError(t, DataSelector{URL: "/users/*"}.Validate())
+	assert.NoError(t, DataSelector{From: day}.Validate(), "Open-ended ranges are allowed")
+}
+
+func TestFindExportDeleteData(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day.Add(9 * time.Hour), URL: "https://example.com/", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day.Add(9*time.Hour + time.Minute), URL: "https://example.com/users/jane?tab=orders", Title: "Jane", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypeCustom, Timestamp: day.Add(9*time.Hour + 2*time.Minute), URL: "https://example.com/", Name: "signup", Properties: map[string]interface{}{"plan": "pro"}, SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day.Add(11 * time.Hour), URL: "https://example.com/pricing", SessionID: "b", VisitorID: "b"},
+		{Type: EventTypePageview, Timestamp: day.AddDate(0, 0, 1), URL: "/users/jane", SessionID: "c", VisitorID: "c"},
+	}))
+	// The first day is rolled up; the second is still pending
+	_, err = db.RollupDay(ctx, day)
+	require.NoError(t, err)
+
+	t.Run("Find", func(t *testing.T) {
+		match, err := db.FindData(ctx, DataSelector{URL: "/users/jane*"})
+		require.NoError(t, err)
+		assert.Equal(t, 4, match.Events, "Every event of a matching session is selected")
+		assert.Equal(t, 2, match.Sessions)
+		require.NotNil(t, match.FirstSeen)
+		assert.Equal(t, day.Add(9*time.Hour), *match.FirstSeen)
+		assert.Equal(t, day.AddDate(0, 0, 1), *match.LastSeen)
+
+		match, err = db.FindData(ctx, DataSelector{URL: "/users/jane*", To: day.AddDate(0, 0, 1)})
+		require.NoError(t, err)
+		assert.Equal(t, 3, match.Events)
+		assert.Equal(t, 1, match.Sessions)
+
+		match, err = db.FindData(ctx, DataSelector{SessionID: "missing"})
+		require.NoError(t, err)
+		assert.Zero(t, match.Events)
+		assert.Nil(t, match.FirstSeen)
+
+		_, err = db.FindData(ctx, DataSelector{})
+		assert.ErrorIs(t, err, ErrEmptySelector)
+
+		logs, err := db.GetPrivacyLogs(ctx, PrivacyActionFind, 10)
+		require.NoError(t, err)
+		require.Len(t, logs, 3, "Invalid selectors are not logged")
+		assert.Equal(t, "session", logs[0].DataType)
+		assert.Equal(t, "missing", logs[0].Identifier)
+		assert.Equal(t, "url", logs[1].DataType)
+		assert.Equal(t, "/users/jane*", logs[1].Identifier)
+		assert.Equal(t, map[string]interface{}{"url": "/users/jane*", "to": "2024-03-12T00:00:00Z"}, logs[1].Metadata["selector"])
+		assert.Equal(t, float64(3), logs[1].Metadata["events"])
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		export, err := db.ExportData(ctx, DataSelector{SessionID: "a"})
+		require.NoError(t, err)
+		require.Len(t, export.Sessions, 1)
+		assert.Equal(t, "a", export.Sessions[0].ID)
+		assert.Equal(t, 2, export.Sessions[0].PagesViewed)
+		require.Len(t, export.Events, 3)
+		assert.Equal(t, "https://example.com/users/jane?tab=orders", export.Events[1].URL)
+		assert.Equal(t, "Jane", export.Events[1].Title)
+		assert.Equal(t, "signup", export.Events[2].Name)
+		assert.Equal(t, map[string]interface{}{"plan": "pro"}, export.Events[2].Properties)
+		assert.Equal(t, day.Add(9*time.Hour), export.Events[0].Timestamp)
+
+		logs, err := db.GetPrivacyLogs(ctx, PrivacyActionExport, 10)
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		assert.Equal(t, "a", logs[0].Identifier)
+		assert.Equal(t, float64(3), logs[0].Metadata["events"])
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		match, err := db.DeleteData(ctx, DataSelector{URL: "/users/jane*"})
+		require.NoError(t, err)
+		assert.Equal(t, 4, match.Events)
+		assert.Equal(t, 2, match.Sessions)
+		assert.Equal(t, []string{"2024-03-11"}, match.Days, "Only rolled up days are adjusted")
+
+		for _, id := range []string{"a", "c"} {
+			session, err := db.GetSessionByID(ctx, id)
+			require.NoError(t, err)
+			assert.Nil(t, session, id)
+		}
+		session, err := db.GetSessionByID(ctx, "b")
+		require.NoError(t, err)
+		assert.NotNil(t, session)
+
+		aggregates, err := db.GetDailyAggregates(ctx, day, day.AddDate(0, 0, 7))
+		require.NoError(t, err)
+		require.Len(t, aggregates, 1, "Deleting must not roll up pending days")
+		assert.Equal(t, 1, aggregates[0].Pageviews)
+		assert.Equal(t, 1, aggregates[0].UniqueVisitors)
+		assert.Equal(t, 1, aggregates[0].TotalSessions)
+
+		match, err = db.FindData(ctx, DataSelector{URL: "/users/jane*"})
+		require.NoError(t, err)
+		assert.Zero(t, match.Events)
+
+		logs, err := db.GetPrivacyLogs(ctx, PrivacyActionDelete, 10)
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		assert.Equal(t, "url", logs[0].DataType)
+		assert.Equal(t, float64(4), logs[0].Metadata["events"])
+		assert.Equal(t, []interface{}{"2024-03-11"}, logs[0].Metadata["days"])
+	})
+
+	t.Run("Delete time range", func(t *testing.T) {
+		match, err := db.DeleteData(ctx, DataSelector{From: day, To: day.AddDate(0, 0, 1)})
+		require.NoError(t, err)
+		assert.Equal(t, 1, match.Events)
+		assert.Equal(t, 1, match.Sessions)
+
+		aggregates, err := db.GetDailyAggregates(ctx, day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, aggregates, 1)
+		assert.Zero(t, aggregates[0].Pageviews)
+		assert.Zero(t, aggregates[0].TotalSessions)
+	})
+}
+
+func TestDeleteDataKeepsPurgedShareOfAggregates(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		// Expires first: purged by retention before the deletion
+		{Type: EventTypePageview, Timestamp: day.Add(1 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day.Add(1*time.Hour + 4*time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a"},
+		// Deleted on request
+		{Type: EventTypePageview, Timestamp: day.Add(9 * time.Hour), URL: "/users/jane", SessionID: "b", VisitorID: "b"},
+		{Type: EventTypePageview, Timestamp: day.Add(9*time.Hour + 2*time.Minute), URL: "/pricing", SessionID: "b", VisitorID: "b"},
+		// Kept; visitor b's later visit keeps them counted as a visitor
+		{Type: EventTypePageview, Timestamp: day.Add(15 * time.Hour), URL: "/", SessionID: "c", VisitorID: "c"},
+		{Type: EventTypePageview, Timestamp: day.Add(16 * time.Hour), URL: "/", SessionID: "d", VisitorID: "b"},
+	}))
+	_, err = db.RollupDay(ctx, day)
+	require.NoError(t, err)
+
+	// The retention cutoff falls mid-day, so part of the day is purged
+	cutoff := day.Add(2 * time.Hour)
+	_, err = db.PurgeChunk(ctx, "events", cutoff, 100)
+	require.NoError(t, err)
+	_, err = db.PurgeChunk(ctx, "sessions", cutoff, 100)
+	require.NoError(t, err)
+
+	match, err := db.DeleteData(ctx, DataSelector{SessionID: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024-03-11"}, match.Days)
+
+	aggregates, err := db.GetDailyAggregates(ctx, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Len(t, aggregates, 1)
+	agg := aggregates[0]
+	assert.Equal(t, 4, agg.Pageviews, "Purged pageviews stay counted")
+	assert.Equal(t, 3, agg.UniqueVisitors, "Visitor b still has a pageview that day")
+	assert.Equal(t, 3, agg.TotalSessions)
+	assert.InDelta(t, 80.0, agg.AvgSessionDuration, 0.001, "Sessions a (240s), c and d (0s)")
+	assert.InDelta(t, 2.0/3, agg.BounceRate, 0.001)
+	assert.InDelta(t, 4.0/3, agg.PagesPerSession, 0.001)
+
+	_, err = db.DeleteData(ctx, DataSelector{URL: "/*", From: day})
+	require.NoError(t, err)
+	aggregates, err = db.GetDailyAggregates(ctx, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 2, aggregates[0].Pageviews, "Only session a's purged pageviews remain")
+	assert.Equal(t, 1, aggregates[0].UniqueVisitors)
+	assert.Equal(t, 1, aggregates[0].TotalSessions)
+	assert.InDelta(t, 240.0, aggregates[0].AvgSessionDuration, 0.001)
+}
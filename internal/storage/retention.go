@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// RetentionPolicy is how long a type of data is kept
+type RetentionPolicy struct {
+	DataType      string `json:"data_type"`
+	RetentionDays int    `json:"retention_days"`
+}
+
+// Cutoff returns the time before which data covered by the policy expires
+func (p RetentionPolicy) Cutoff(now time.Time) time.Time {
+	return now.UTC().AddDate(0, 0, -p.RetentionDays)
+}
+
+// purgeQueries maps data types to a statement deleting up to ? expired rows.
+// Deleting by rowid in bounded chunks keeps each write transaction short.
+var purgeQueries = map[string]string{
+	"events": `
+		DELETE FROM events WHERE id IN (
+			SELECT id FROM events
+			WHERE site_id = ? AND timestamp < ?
+			LIMIT ?
+		)`,
+	"sessions": `
+		DELETE FROM sessions WHERE rowid IN (
+			SELECT rowid FROM sessions
+			WHERE site_id = ? AND COALESCE(ended_at, started_at) < ?
+			LIMIT ?
+		)`,
+	"bot_hits": `
+		DELETE FROM bot_hits WHERE id IN (
+			SELECT id FROM bot_hits
+			WHERE site_id = ? AND timestamp < ?
+			LIMIT ?
+		)`,
+}
+
+// PrivacyLog is an entry in privacy_logs
+type PrivacyLog struct {
+	ID          int64                  `json:"id"`
+	Action      string                 `json:"action"`
+	DataType    string                 `json:"data_type"`
+	Identifier  string                 `json:"identifier,omitempty"`
+	PerformedAt string                 `json:"performed_at"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// IsPurgeable reports whether retention can be enforced for dataType
+func IsPurgeable(dataType string) bool {
+	_, ok := purgeQueries[dataType]
+	return ok
+}
+
+// GetRetentionPolicies returns the configured retention policies
+func (db *DB) GetRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT data_type, retention_days
+		FROM retention_policies
+		WHERE site_id = ?
+		ORDER BY data_type`, constants.DefaultSiteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []RetentionPolicy
+	for rows.Next() {
+		var p RetentionPolicy
+		if err := rows.Scan(&p.DataType, &p.RetentionDays); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// SetRetentionDays sets how long dataType is kept, creating its policy if
+// needed. Zero or fewer days keep the data forever.
+func (db *DB) SetRetentionDays(ctx context.Context, dataType string, days int) error {
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO retention_policies (site_id, data_type, retention_days)
+		VALUES (?, ?, ?)
+		ON CONFLICT(site_id, data_type) DO UPDATE SET
+			retention_days = excluded.retention_days,
+			updated_at = CURRENT_TIMESTAMP`,
+		constants.DefaultSiteID, dataType, days)
+	if err != nil {
+		return fmt.Errorf("failed to set %s retention: %w", dataType, err)
+	}
+	return nil
+}
+
+// PurgeChunk deletes up to limit rows of dataType older than cutoff and
+// returns how many were deleted. Callers loop until fewer than limit rows
+// are deleted.
+func (db *DB) PurgeChunk(ctx context.Context, dataType string, cutoff time.Time, limit int) (int64, error) {
+	query, ok := purgeQueries[dataType]
+	if !ok {
+		return 0, fmt.Errorf("unsupported retention data type %q", dataType)
+	}
+
+	result, err := db.conn.ExecContext(ctx, query,
+		constants.DefaultSiteID, cutoff.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", dataType, err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged %s: %w", dataType, err)
+	}
+	return deleted, nil
+}
+
+// LogPrivacyAction records an action on personal data in privacy_logs
+func (db *DB) LogPrivacyAction(ctx context.Context, action, dataType, identifier string, metadata map[string]interface{}) error {
+	return logPrivacyAction(ctx, db.conn, action, dataType, identifier, metadata)
+}
+
+// logPrivacyAction writes a privacy log entry using conn, so it can commit
+// together with the action it records
+func logPrivacyAction(ctx context.Context, conn execer, action, dataType, identifier string, metadata map[string]interface{}) error {
+	var metadataJSON string
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal privacy log metadata: %w", err)
+		}
+		metadataJSON = string(data)
+	}
+
+	_, err := conn.ExecContext(ctx, `
+		INSERT INTO privacy_logs (site_id, action, data_type, identifier, metadata)
+		VALUES (?, ?, ?, ?, ?)`,
+		constants.DefaultSiteID, action, dataType, identifier, metadataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to write privacy log: %w", err)
+	}
+	return nil
+}
+
+// GetPrivacyLogs returns the most recent privacy log entries, optionally
+// filtered by action
+func (db *DB) GetPrivacyLogs(ctx context.Context, action string, limit int) ([]PrivacyLog, error) {
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, action, data_type, COALESCE(identifier, ''), performed_at, COALESCE(metadata, '')
+		FROM privacy_logs
+		WHERE site_id = ?
+		AND (? = '' OR action = ?)
+		ORDER BY id DESC
+		LIMIT ?`,
+		constants.DefaultSiteID, action, action, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query privacy logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []PrivacyLog
+	for rows.Next() {
+		var entry PrivacyLog
+		var metadataJSON string
+		if err := rows.Scan(&entry.ID, &entry.Action, &entry.DataType, &entry.Identifier,
+			&entry.PerformedAt, &metadataJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan privacy log: %w", err)
+		}
+		if metadataJSON != "" {
+			if err := json.Unmarshal([]byte(metadataJSON), &entry.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal privacy log metadata: %w", err)
+			}
+		}
+		logs = append(logs, entry)
+	}
+	return logs, rows.Err()
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRetentionPolicies(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	policies, err := db.GetRetentionPolicies(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []RetentionPolicy{
+		{DataType: "bot_hits", RetentionDays: 30},
+		{DataType: "events", RetentionDays: 90},
+		{DataType: "sessions", RetentionDays: 90},
+	}, policies)
+
+	require.NoError(t, db.SetRetentionDays(context.Background(), "events", 30))
+	policies, err = db.GetRetentionPolicies(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []RetentionPolicy{
+		{DataType: "bot_hits", RetentionDays: 30},
+		{DataType: "events", RetentionDays: 30},
+		{DataType: "sessions", RetentionDays: 90},
+	}, policies)
+}
+
+func TestPurgeChunk(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	cutoff := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: cutoff.Add(-3 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: cutoff.Add(-2 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: cutoff.Add(-1 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: cutoff.Add(time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	deleted, err := db.PurgeChunk(ctx, "events", cutoff, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted, "Chunk size bounds each delete")
+
+	deleted, err = db.PurgeChunk(ctx, "events", cutoff, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	deleted, err = db.PurgeChunk(ctx, "sessions", cutoff, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted, "Session a ended before the cutoff")
+
+	_, err = db.PurgeChunk(ctx, "site_config", cutoff, 10)
+	assert.Error(t, err, "Unsupported data types are rejected")
+	assert.False(t, IsPurgeable("site_config"))
+}
+
+func TestLogPrivacyAction(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	require.NoError(t, db.LogPrivacyAction(ctx, "delete", "events", "session-1", map[string]interface{}{"deleted": 3}))
+	require.NoError(t, db.LogPrivacyAction(ctx, "anonymize", "events", "", nil))
+
+	logs, err := db.GetPrivacyLogs(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 2)
+	assert.Equal(t, "anonymize", logs[0].Action, "Most recent first")
+	assert.Nil(t, logs[0].Metadata)
+
+	logs, err = db.GetPrivacyLogs(ctx, "delete", 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "session-1", logs[0].Identifier)
+	assert.Equal(t, float64(3), logs[0].Metadata["deleted"])
+}
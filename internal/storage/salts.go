@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// saltBytes is the length of a daily salt
+const saltBytes = 32
+
+// saltCache holds the salt for the current day so hashing a hit doesn't
+// need a query
+type saltCache struct {
+	mu   sync.Mutex
+	date string
+	salt []byte
+}
+
+// DailySalt returns the secret salt for the UTC day containing now, creating
+// it on first use. The salt is random and never leaves the database.
+func (db *DB) DailySalt(ctx context.Context, now time.Time) ([]byte, error) {
+	date := now.UTC().Format(dateLayout)
+
+	db.salts.mu.Lock()
+	defer db.salts.mu.Unlock()
+	if db.salts.date == date {
+		return db.salts.salt, nil
+	}
+
+	salt := make([]byte, saltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	// Keep the existing salt if another writer created it first
+	_, err := db.conn.ExecContext(ctx,
+		"INSERT INTO salts (date, salt) VALUES (?, ?) ON CONFLICT(date) DO NOTHING",
+		date, salt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store salt: %w", err)
+	}
+
+	err = db.conn.QueryRowContext(ctx, "SELECT salt FROM salts WHERE date = ?", date).Scan(&salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get salt: %w", err)
+	}
+
+	db.salts.date = date
+	db.salts.salt = salt
+	return salt, nil
+}
+
+// DeleteSaltsBefore destroys salts for days before the UTC day containing
+// before and returns how many were deleted
+func (db *DB) DeleteSaltsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := db.conn.ExecContext(ctx,
+		"DELETE FROM salts WHERE date < ?", before.UTC().Format(dateLayout),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete salts: %w", err)
+	}
+	return result.RowsAffected()
+}
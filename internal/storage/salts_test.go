@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDailySalt(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	salt, err := db.DailySalt(ctx, day)
+	require.NoError(t, err)
+	assert.Len(t, salt, saltBytes)
+
+	same, err := db.DailySalt(ctx, day.Add(10*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, salt, same, "The salt is stable within a UTC day")
+
+	next, err := db.DailySalt(ctx, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.NotEqual(t, salt, next, "Each day gets a new salt")
+
+	// A fresh connection reads the stored salt rather than generating one
+	reopened, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer reopened.Close()
+	stored, err := reopened.DailySalt(ctx, day)
+	require.NoError(t, err)
+	assert.Equal(t, salt, stored)
+
+	deleted, err := db.DeleteSaltsBefore(ctx, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
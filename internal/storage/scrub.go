@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// scrubBatchSize is the number of rows read and rewritten per transaction
+const scrubBatchSize = 500
+
+// Rewriter rewrites stored URLs and free text, such as a privacy.Scrubber
+type Rewriter interface {
+	URL(string) string
+	Text(string) string
+}
+
+// scrubColumn is a stored column that may hold personal data
+type scrubColumn struct {
+	table, column string
+	// url columns hold URLs; the rest hold free text
+	url bool
+}
+
+// scrubColumns lists the columns RewriteStoredText rewrites, grouped by table
+var scrubColumns = []scrubColumn{
+	{"events", "url", true},
+	{"events", "referrer", true},
+	{"events", "title", false},
+	{"events", "utm_source", false},
+	{"events", "utm_medium", false},
+	{"events", "utm_campaign", false},
+	{"events", "utm_term", false},
+	{"events", "utm_content", false},
+	{"sessions", "entry_page", true},
+	{"sessions", "exit_page", true},
+	{"sessions", "referrer", true},
+	{"sessions", "campaign", false},
+	{"sessions", "utm_source", false},
+	{"sessions", "utm_medium", false},
+	{"sessions", "utm_campaign", false},
+	{"sessions", "utm_term", false},
+	{"sessions", "utm_content", false},
+	{"bot_hits", "url", true},
+}
+
+// ScrubResult counts the values of one column a rewrite scanned and changed
+type ScrubResult struct {
+	Table   string `json:"table"`
+	Column  string `json:"column"`
+	Scanned int    `json:"scanned"`
+	Changed int    `json:"changed"`
+}
+
+// RewriteStoredText runs every stored URL, referrer, title and campaign
+// value through rw, in batches of short transactions. Unless dryRun is set,
+// changed values are written back and each batch is recorded in
+// privacy_logs with the action "scrub", in the same transaction, so an
+// interrupted run leaves no rewritten rows unlogged.
+func (db *DB) RewriteStoredText(ctx context.Context, rw Rewriter, dryRun bool) ([]ScrubResult, error) {
+	var results []ScrubResult
+	for _, c := range scrubColumns {
+		fn := rw.Text
+		if c.url {
+			fn = rw.URL
+		}
+		result, err := db.rewriteColumn(ctx, c, fn, dryRun)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// rewriteColumn rewrites one column in rowid order, logging each batch
+// under the table's name with the column as identifier
+func (db *DB) rewriteColumn(ctx context.Context, c scrubColumn, fn func(string) string, dryRun bool) (ScrubResult, error) {
+	result := ScrubResult{Table: c.table, Column: c.column}
+	// Table and column names come from scrubColumns, never from input
+	selectQuery := fmt.Sprintf(`
+		SELECT rowid, %[2]s FROM %[1]s
+		WHERE rowid > ? AND %[2]s IS NOT NULL AND %[2]s != ''
+		ORDER BY rowid
+		LIMIT ?`, c.table, c.column)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, c.table, c.column)
+
+	type update struct {
+		rowid int64
+		value string
+	}
+	var last int64
+	for {
+		rows, err := db.conn.QueryContext(ctx, selectQuery, last, scrubBatchSize)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+		}
+		var updates []update
+		n := 0
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&last, &value); err != nil {
+				rows.Close()
+				return result, fmt.Errorf("failed to scan %s.%s: %w", c.table, c.column, err)
+			}
+			n++
+			if scrubbed := fn(value); scrubbed != value {
+				updates = append(updates, update{last, scrubbed})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return result, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+		}
+		result.Scanned += n
+		result.Changed += len(updates)
+
+		if !dryRun && len(updates) > 0 {
+			tx, err := db.conn.BeginTx(ctx, nil)
+			if err != nil {
+				return result, fmt.Errorf("failed to start transaction: %w", err)
+			}
+			for _, u := range updates {
+				if _, err := tx.ExecContext(ctx, updateQuery, u.value, u.rowid); err != nil {
+					tx.Rollback()
+					return result, fmt.Errorf("failed to rewrite %s.%s: %w", c.table, c.column, err)
+				}
+			}
+			metadata := map[string]interface{}{
+				"rows_changed": len(updates),
+				"first_rowid":  updates[0].rowid,
+				"last_rowid":   updates[len(updates)-1].rowid,
+			}
+			if err := logPrivacyAction(ctx, tx, "scrub", c.table, c.column, metadata); err != nil {
+				tx.Rollback()
+				return result, err
+			}
+			if err := tx.Commit(); err != nil {
+				return result, fmt.Errorf("failed to commit rewrite of %s.%s: %w", c.table, c.column, err)
+			}
+		}
+		if n < scrubBatchSize {
+			return result, nil
+		}
+	}
+}
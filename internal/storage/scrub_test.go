@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redactJane replaces a known address in URLs and text
+type redactJane struct{}
+
+func (redactJane) URL(s string) string  { return strings.ReplaceAll(s, "jane@example.com", "REDACTED") }
+func (redactJane) Text(s string) string { return strings.ReplaceAll(s, "jane@example.com", "[text]") }
+
+func TestRewriteStoredText(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		{Type: EventTypePageview, Timestamp: day, URL: "/users/jane@example.com", Title: "Hi jane@example.com", SessionID: "a", VisitorID: "a"},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/pricing", Title: "Pricing", SessionID: "a", VisitorID: "a"},
+	}))
+	require.NoError(t, db.InsertBotHits(ctx, []*BotHit{
+		{Timestamp: day, Type: EventTypePageview, URL: "/?email=jane@example.com", Reason: "crawler"},
+	}))
+
+	changed := func(results []ScrubResult) map[string]int {
+		m := make(map[string]int)
+		for _, r := range results {
+			if r.Changed > 0 {
+				m[r.Table+"."+r.Column] = r.Changed
+			}
+		}
+		return m
+	}
+	expected := map[string]int{
+		"events.url":          1,
+		"events.title":        1,
+		"sessions.entry_page": 1,
+		"bot_hits.url":        1,
+	}
+
+	results, err := db.RewriteStoredText(ctx, redactJane{}, true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, changed(results))
+	logs, err := db.GetPrivacyLogs(ctx, "scrub", 10)
+	require.NoError(t, err)
+	assert.Empty(t, logs, "Dry runs neither write nor log")
+
+	results, err = db.RewriteStoredText(ctx, redactJane{}, false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, changed(results))
+
+	session, err := db.GetSessionByID(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "/users/REDACTED", session.EntryPage)
+	assert.Equal(t, "/pricing", session.ExitPage)
+
+	logs, err = db.GetPrivacyLogs(ctx, "scrub", 10)
+	require.NoError(t, err)
+	require.Len(t, logs, 4, "One entry per rewritten batch")
+	logged := make(map[string]int)
+	for _, l := range logs {
+		logged[l.DataType+"."+l.Identifier] = int(l.Metadata["rows_changed"].(float64))
+		assert.Equal(t, l.Metadata["first_rowid"], l.Metadata["last_rowid"])
+	}
+	assert.Equal(t, expected, logged)
+
+	results, err = db.RewriteStoredText(ctx, redactJane{}, false)
+	require.NoError(t, err)
+	assert.Empty(t, changed(results), "Scrubbed values are stable")
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// GetSiteSettings returns the site's settings from site_config
+func (db *DB) GetSiteSettings(ctx context.Context) (map[string]interface{}, error) {
+	var raw string
+	err := db.conn.QueryRowContext(ctx,
+		"SELECT settings FROM site_config WHERE id = ?", constants.DefaultSiteID,
+	).Scan(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get site settings: %w", err)
+	}
+
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal site settings: %w", err)
+	}
+	return settings, nil
+}
+
+// SetSiteSetting stores a single key in the site's settings, leaving other
+// keys untouched
+func (db *DB) SetSiteSetting(ctx context.Context, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal site setting %s: %w", key, err)
+	}
+
+	_, err = db.conn.ExecContext(ctx, `
+		UPDATE site_config
+		SET settings = json_set(settings, '$.' || ?, json(?)),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, key, string(data), constants.DefaultSiteID)
+	if err != nil {
+		return fmt.Errorf("failed to set site setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// InitSiteSetting stores a key in the site's settings unless it is already
+// set, so values changed in site_config survive restarts
+func (db *DB) InitSiteSetting(ctx context.Context, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal site setting %s: %w", key, err)
+	}
+
+	_, err = db.conn.ExecContext(ctx, `
+		UPDATE site_config
+		SET settings = json_insert(settings, '$.' || ?, json(?)),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		AND json_type(settings, '$.' || ?) IS NULL
+	`, key, string(data), constants.DefaultSiteID, key)
+	if err != nil {
+		return fmt.Errorf("failed to initialize site setting %s: %w", key, err)
+	}
+	return nil
+}
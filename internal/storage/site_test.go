@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteSettings(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	settings, err := db.GetSiteSettings(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, settings)
+
+	require.NoError(t, db.SetSiteSetting(ctx, "ip_anonymization", "truncate"))
+	require.NoError(t, db.SetSiteSetting(ctx, "retention_days", 90))
+	require.NoError(t, db.SetSiteSetting(ctx, "ip_anonymization", "drop"))
+	require.NoError(t, db.InitSiteSetting(ctx, "consent_policy", "drop"))
+	require.NoError(t, db.InitSiteSetting(ctx, "consent_policy", "ignore"))
+	require.NoError(t, db.InitSiteSetting(ctx, "retention_days", 30))
+
+	settings, err = db.GetSiteSettings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"ip_anonymization": "drop",
+		"retention_days":   float64(90),
+		"consent_policy":   "drop",
+	}, settings, "InitSiteSetting keeps existing values")
+}
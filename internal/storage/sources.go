@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/referrer"
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// backfillSources classifies the referrers stored before migration 008
+// added the source columns. Only the built-in source list is used, since
+// site.domains and referrers.sources_file aren't known to migrations.
+func backfillSources(tx *sql.Tx) error {
+	classifier := referrer.NewClassifier(referrer.DefaultList(), nil)
+	set := []string{"referrer_host", "source", "channel"}
+	classify := func(values []string) []interface{} {
+		result := classifier.Classify(values[0], values[1], utm.Params{Source: values[2], Medium: values[3]})
+		return []interface{}{nullString(result.Host), nullString(result.Source), nullString(result.Channel)}
+	}
+	if err := backfillTable(tx, "events", "1",
+		[]string{"referrer", "url", "utm_source", "utm_medium"}, set, classify); err != nil {
+		return err
+	}
+	return backfillTable(tx, "sessions", "entry_page IS NOT NULL",
+		[]string{"referrer", "entry_page", "utm_source", "utm_medium"}, set, classify)
+}
+
+// SourceQuery selects a sources breakdown. Channel, when set, keeps only
+// sessions from that channel. GoalID restricts conversions to one goal;
+// zero counts a conversion of any goal.
+type SourceQuery struct {
+	From    time.Time
+	To      time.Time
+	Channel string
+	GoalID  int64
+	Limit   int
+}
+
+// GetSourceStats groups sessions started in [from, to) by the source and
+// channel of their entry page, most visitors first. Direct sessions have an
+// empty Value.
+func (db *DB) GetSourceStats(ctx context.Context, q SourceQuery) ([]AttributionStats, error) {
+	aq := attributionQuery{
+		group:       "COALESCE(s.source, '')",
+		withChannel: true,
+		filter:      "s.channel IS NOT NULL",
+		from:        q.From,
+		to:          q.To,
+		goalID:      q.GoalID,
+		limit:       q.Limit,
+	}
+	if q.Channel != "" {
+		aq.filter = "s.channel = ?"
+		aq.args = []interface{}{q.Channel}
+	}
+	return db.attributionStats(ctx, aq)
+}
+
+// GetChannelStats groups sessions started in [from, to) by the channel of
+// their entry page, most visitors first. goalID restricts conversions as in
+// SourceQuery.
+func (db *DB) GetChannelStats(ctx context.Context, from, to time.Time, goalID int64) ([]AttributionStats, error) {
+	return db.attributionStats(ctx, attributionQuery{
+		group:  "s.channel",
+		filter: "s.channel IS NOT NULL",
+		from:   from,
+		to:     to,
+		goalID: goalID,
+		limit:  -1,
+	})
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSourceStats(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.CreateGoal(ctx, &Goal{Name: "Signed up", Kind: GoalKindEvent, Match: "Signup"}))
+
+	google := func(session string, offset time.Duration) *Event {
+		return &Event{Type: EventTypePageview, Timestamp: day.Add(offset), URL: "/", SessionID: session, VisitorID: session,
+			Referrer: "https://www.google.com/", ReferrerHost: "google.com", Source: "Google", Channel: "search"}
+	}
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		google("a", 0),
+		{Type: EventTypeCustom, Timestamp: day.Add(time.Minute), URL: "/", SessionID: "a", VisitorID: "a", Name: "Signup"},
+		google("b", 0),
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "c", VisitorID: "c",
+			Referrer: "https://t.co/x", ReferrerHost: "t.co", Source: "Twitter", Channel: "social"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "d", VisitorID: "d", Channel: "direct"},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/docs", SessionID: "d", VisitorID: "d", Channel: "direct"},
+	}))
+
+	from, to := day.Add(-time.Hour), day.AddDate(0, 0, 1)
+
+	t.Run("Sources", func(t *testing.T) {
+		stats, err := db.GetSourceStats(ctx, SourceQuery{From: from, To: to, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, stats, 3)
+
+		assert.Equal(t, AttributionStats{
+			Value: "Google", Channel: "search", Visitors: 2, Sessions: 2, Pageviews: 2, Conversions: 1, ConversionRate: 0.5,
+		}, stats[0])
+		assert.Equal(t, "", stats[1].Value, "Direct sessions have no source")
+		assert.Equal(t, "direct", stats[1].Channel)
+		assert.Equal(t, 2, stats[1].Pageviews)
+		assert.Equal(t, "Twitter", stats[2].Value)
+	})
+
+	t.Run("Channel filter", func(t *testing.T) {
+		stats, err := db.GetSourceStats(ctx, SourceQuery{From: from, To: to, Channel: "social", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.Equal(t, "Twitter", stats[0].Value)
+	})
+
+	t.Run("Channels", func(t *testing.T) {
+		stats, err := db.GetChannelStats(ctx, from, to, 0)
+		require.NoError(t, err)
+		require.Len(t, stats, 3)
+		assert.Equal(t, "search", stats[0].Value)
+		assert.Equal(t, 2, stats[0].Visitors)
+		assert.Empty(t, stats[0].Channel)
+	})
+}
+
+func TestSourcesMigrationBackfill(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Apply only the migrations from before source columns existed and
+	// store legacy rows
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+	restore := holdBackMigrations(t, migrationsDir, "008")
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`
+		INSERT INTO sessions (id, site_id, started_at, entry_page, referrer)
+		VALUES ('search', 'default', '2024-03-14T09:00:00Z', 'https://example.com/', 'https://www.bing.com/search?q=x'),
+		       ('self', 'default', '2024-03-14T09:00:00Z', 'https://example.com/docs', 'https://example.com/'),
+		       ('direct', 'default', '2024-03-14T09:00:00Z', 'https://example.com/', NULL)`)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`
+		INSERT INTO events (site_id, type, timestamp, url, referrer, session_id)
+		VALUES ('default', 'pageview', '2024-03-14T09:00:00Z', 'https://example.com/', 'https://www.bing.com/search?q=x', 'search')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	restore()
+	db, err = NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	session, err := db.GetSessionByID(ctx, "search")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, "bing.com", session.ReferrerHost)
+	assert.Equal(t, "Bing", session.Source)
+	assert.Equal(t, "search", session.Channel)
+
+	session, err = db.GetSessionByID(ctx, "self")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Empty(t, session.ReferrerHost, "Self-referrals have no host")
+	assert.Equal(t, "direct", session.Channel)
+
+	session, err = db.GetSessionByID(ctx, "direct")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, "direct", session.Channel)
+
+	var source, channel string
+	require.NoError(t, db.conn.QueryRow("SELECT source, channel FROM events WHERE session_id = 'search'").Scan(&source, &channel))
+	assert.Equal(t, "Bing", source)
+	assert.Equal(t, "search", channel)
+}
@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+)
+
+// Resolution is the bucket size of a historical time series
+type Resolution string
+
+const (
+	ResolutionHour  Resolution = "hour"
+	ResolutionDay   Resolution = "day"
+	ResolutionWeek  Resolution = "week"
+	ResolutionMonth Resolution = "month"
+)
+
+// MaxTimeSeriesBuckets caps the number of buckets a single query may return
+const MaxTimeSeriesBuckets = 1000
+
+// ParseResolution validates a resolution name
+func ParseResolution(s string) (Resolution, error) {
+	switch r := Resolution(s); r {
+	case ResolutionHour, ResolutionDay, ResolutionWeek, ResolutionMonth:
+		return r, nil
+	}
+	return "", fmt.Errorf("invalid resolution %q: must be hour, day, week or month", s)
+}
+
+// bucketExpr returns the SQLite expression mapping a timestamp column to the
+// key of its bucket. Keys match the layout returned by Truncate.
+func (r Resolution) bucketExpr(column string) string {
+	switch r {
+	case ResolutionHour:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%dT%%H:00:00Z', %s)", column)
+	case ResolutionWeek:
+		// Weeks start on Monday
+		return fmt.Sprintf("date(%s, '-6 days', 'weekday 1')", column)
+	case ResolutionMonth:
+		return fmt.Sprintf("strftime('%%Y-%%m-01', %s)", column)
+	default:
+		return fmt.Sprintf("date(%s)", column)
+	}
+}
+
+// Truncate returns the start of the bucket containing t, in UTC
+func (r Resolution) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	switch r {
+	case ResolutionHour:
+		return t.Truncate(time.Hour)
+	case ResolutionWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		return day.AddDate(0, 0, -offset)
+	case ResolutionMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Next returns the start of the bucket following the one starting at t
+func (r Resolution) Next(t time.Time) time.Time {
+	switch r {
+	case ResolutionHour:
+		return t.Add(time.Hour)
+	case ResolutionWeek:
+		return t.AddDate(0, 0, 7)
+	case ResolutionMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// key formats a bucket start the same way bucketExpr does in SQL
+func (r Resolution) key(t time.Time) string {
+	if r == ResolutionHour {
+		return t.Format("2006-01-02T15:00:00Z")
+	}
+	return t.Format("2006-01-02")
+}
+
+// TimeSeriesPoint holds the metrics for one bucket of a historical query.
+// Session metrics cover sessions that started in the bucket.
+type TimeSeriesPoint struct {
+	Start          time.Time `json:"start"`
+	Pageviews      int       `json:"pageviews"`
+	UniqueVisitors int       `json:"unique_visitors"`
+	Sessions       int       `json:"sessions"`
+	// BounceRate is the share of sessions with at most one pageview, 0-1
+	BounceRate float64 `json:"bounce_rate"`
+	// AvgSessionDuration is in seconds
+	AvgSessionDuration float64 `json:"avg_session_duration"`
+	PagesPerSession    float64 `json:"pages_per_session"`
+
+	// Sums behind the session ratios, so points can be merged
+	bounces       float64
+	durationTotal float64
+	pagesTotal    float64
+}
+
+// addSessions adds n sessions with the given bounce, duration and page sums
+func (p *TimeSeriesPoint) addSessions(n int, bounces, duration, pages float64) {
+	p.Sessions += n
+	p.bounces += bounces
+	p.durationTotal += duration
+	p.pagesTotal += pages
+}
+
+// add merges the counts of other into p. Unique visitors are not merged as
+// they can't be summed across buckets.
+func (p *TimeSeriesPoint) add(other *TimeSeriesPoint) {
+	p.Pageviews += other.Pageviews
+	p.addSessions(other.Sessions, other.bounces, other.durationTotal, other.pagesTotal)
+}
+
+// computeRates derives the session ratios from the accumulated sums
+func (p *TimeSeriesPoint) computeRates() {
+	if p.Sessions == 0 {
+		return
+	}
+	n := float64(p.Sessions)
+	p.BounceRate = p.bounces / n
+	p.AvgSessionDuration = p.durationTotal / n
+	p.PagesPerSession = p.pagesTotal / n
+}
+
+// HistoricalStats is a time series over [From, To)
+type HistoricalStats struct {
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Resolution Resolution        `json:"resolution"`
+	Points     []TimeSeriesPoint `json:"points"`
+	Totals     TimeSeriesPoint   `json:"totals"`
+}
+
+// BucketCount returns how many buckets a query over [from, to) produces
+func (r Resolution) BucketCount(from, to time.Time) int {
+	count := 0
+	for b := r.Truncate(from); b.Before(to); b = r.Next(b) {
+		count++
+		if count > MaxTimeSeriesBuckets {
+			break
+		}
+	}
+	return count
+}
+
+// GetHistoricalStats returns pageviews, unique visitors and sessions per
+// bucket for [from, to). Buckets without data are returned with zero values.
+// Whole days that have been rolled up are read from daily_aggregates; hourly
+// queries and days not yet rolled up scan raw events.
+func (db *DB) GetHistoricalStats(ctx context.Context, from, to time.Time, resolution Resolution) (*HistoricalStats, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if n := resolution.BucketCount(from, to); n > MaxTimeSeriesBuckets {
+		return nil, fmt.Errorf("query spans more than %d %s buckets", MaxTimeSeriesBuckets, resolution)
+	}
+
+	from, to = from.UTC(), to.UTC()
+	points := make(pointSet)
+	totalVisitors := 0
+
+	// Split the range into [from, aggFrom) raw, [aggFrom, aggTo) aggregated
+	// and [aggTo, to) raw
+	aggFrom, aggTo := from, from
+	if resolution != ResolutionHour {
+		watermark, err := db.RollupWatermark(ctx)
+		if err != nil {
+			return nil, err
+		}
+		aggFrom = ResolutionDay.Truncate(from)
+		if aggFrom.Before(from) {
+			aggFrom = aggFrom.AddDate(0, 0, 1)
+		}
+		aggTo = ResolutionDay.Truncate(to)
+		if watermark.Before(aggTo) {
+			aggTo = watermark
+		}
+		if !aggFrom.Before(aggTo) {
+			aggFrom, aggTo = from, from
+		}
+	}
+
+	if aggFrom.Before(aggTo) {
+		aggregates, err := db.GetDailyAggregates(ctx, aggFrom, aggTo)
+		if err != nil {
+			return nil, err
+		}
+		for _, agg := range aggregates {
+			p := points.get(resolution.key(resolution.Truncate(agg.Date)))
+			p.Pageviews += agg.Pageviews
+			p.UniqueVisitors += agg.UniqueVisitors
+			n := float64(agg.TotalSessions)
+			p.addSessions(agg.TotalSessions, agg.BounceRate*n, agg.AvgSessionDuration*n, agg.PagesPerSession*n)
+			// Visitor hashes rotate daily, so daily uniques add up exactly
+			totalVisitors += agg.UniqueVisitors
+		}
+	}
+
+	for _, r := range [][2]time.Time{{from, aggFrom}, {aggTo, to}} {
+		if !r[0].Before(r[1]) {
+			continue
+		}
+		visitors, err := db.addRawBuckets(ctx, r[0], r[1], resolution, points)
+		if err != nil {
+			return nil, err
+		}
+		totalVisitors += visitors
+	}
+
+	stats := &HistoricalStats{
+		From:       from,
+		To:         to,
+		Resolution: resolution,
+		Totals:     TimeSeriesPoint{Start: from, UniqueVisitors: totalVisitors},
+	}
+	for b := resolution.Truncate(from); b.Before(to); b = resolution.Next(b) {
+		point := TimeSeriesPoint{Start: b}
+		if p, ok := points[resolution.key(b)]; ok {
+			point = *p
+			point.Start = b
+		}
+		point.computeRates()
+		stats.Totals.add(&point)
+		stats.Points = append(stats.Points, point)
+	}
+	stats.Totals.computeRates()
+
+	return stats, nil
+}
+
+// pointSet accumulates time series points by bucket key
+type pointSet map[string]*TimeSeriesPoint
+
+// get returns the point for key, creating it if needed
+func (ps pointSet) get(key string) *TimeSeriesPoint {
+	p, ok := ps[key]
+	if !ok {
+		p = &TimeSeriesPoint{}
+		ps[key] = p
+	}
+	return p
+}
+
+// addRawBuckets scans raw events and sessions in [from, to) and adds their
+// counts to points. It returns the number of unique visitors in the range.
+func (db *DB) addRawBuckets(ctx context.Context, from, to time.Time, resolution Resolution, points pointSet) (int, error) {
+	fromStr := from.UTC().Format(time.RFC3339)
+	toStr := to.UTC().Format(time.RFC3339)
+
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s AS bucket,
+		       COUNT(*) AS pageviews,
+		       COUNT(DISTINCT visitor_id) AS unique_visitors
+		FROM events
+		WHERE site_id = ?
+		AND type = 'pageview'
+		AND timestamp >= ? AND timestamp < ?
+		GROUP BY bucket`, resolution.bucketExpr("timestamp")),
+		constants.DefaultSiteID, fromStr, toStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query historical pageviews: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket string
+		var pageviews, visitors int
+		if err := rows.Scan(&bucket, &pageviews, &visitors); err != nil {
+			return 0, fmt.Errorf("failed to scan historical pageviews row: %w", err)
+		}
+		p := points.get(bucket)
+		p.Pageviews += pageviews
+		p.UniqueVisitors += visitors
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read historical pageviews: %w", err)
+	}
+
+	sessionRows, err := db.conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %s AS bucket,
+		       COUNT(*) AS sessions,
+		       SUM(CASE WHEN pages_viewed <= 1 THEN 1 ELSE 0 END) AS bounces,
+		       SUM(COALESCE(duration, 0)) AS duration,
+		       SUM(pages_viewed) AS pages
+		FROM sessions
+		WHERE site_id = ?
+		AND started_at >= ? AND started_at < ?
+		GROUP BY bucket`, resolution.bucketExpr("started_at")),
+		constants.DefaultSiteID, fromStr, toStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query historical sessions: %w", err)
+	}
+	defer sessionRows.Close()
+
+	for sessionRows.Next() {
+		var bucket string
+		var sessions int
+		var bounces, duration, pages float64
+		if err := sessionRows.Scan(&bucket, &sessions, &bounces, &duration, &pages); err != nil {
+			return 0, fmt.Errorf("failed to scan historical sessions row: %w", err)
+		}
+		points.get(bucket).addSessions(sessions, bounces, duration, pages)
+	}
+	if err := sessionRows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read historical sessions: %w", err)
+	}
+
+	// Total unique visitors can't be summed across buckets
+	var visitors int
+	err = db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT visitor_id)
+		FROM events
+		WHERE site_id = ?
+		AND type = 'pageview'
+		AND timestamp >= ? AND timestamp < ?
+	`, constants.DefaultSiteID, fromStr, toStr).Scan(&visitors)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get total unique visitors: %w", err)
+	}
+
+	return visitors, nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolution(t *testing.T) {
+	for _, valid := range []string{"hour", "day", "week", "month"} {
+		res, err := ParseResolution(valid)
+		assert.NoError(t, err)
+		assert.Equal(t, Resolution(valid), res)
+	}
+
+	_, err := ParseResolution("year")
+	assert.Error(t, err)
+}
+
+func TestResolutionTruncate(t *testing.T) {
+	// Thursday
+	ts := time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC), ResolutionHour.Truncate(ts))
+	assert.Equal(t, time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC), ResolutionDay.Truncate(ts))
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), ResolutionWeek.Truncate(ts), "Weeks start on Monday")
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), ResolutionMonth.Truncate(ts))
+
+	// Sunday belongs to the week starting the previous Monday
+	sunday := time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), ResolutionWeek.Truncate(sunday))
+}
+
+func TestGetHistoricalStats(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day1 := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	events := []*Event{
+		{Type: "pageview", Timestamp: day1.Add(9 * time.Hour), URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: day1.Add(10 * time.Hour), URL: "/pricing", SessionID: "a", VisitorID: "a"},
+		{Type: "pageview", Timestamp: day1.Add(11 * time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "signup", Timestamp: day1.Add(11 * time.Hour), URL: "/", SessionID: "b", VisitorID: "b"},
+		{Type: "pageview", Timestamp: day2.Add(9 * time.Hour), URL: "/", SessionID: "c", VisitorID: "c"},
+	}
+	require.NoError(t, db.InsertEvents(ctx, events))
+
+	t.Run("Daily buckets", func(t *testing.T) {
+		stats, err := db.GetHistoricalStats(ctx, day1, day1.AddDate(0, 0, 3), ResolutionDay)
+		require.NoError(t, err)
+		require.Len(t, stats.Points, 3, "Empty buckets should be filled")
+
+		assert.Equal(t, day1, stats.Points[0].Start)
+		assert.Equal(t, 3, stats.Points[0].Pageviews)
+		assert.Equal(t, 2, stats.Points[0].UniqueVisitors)
+		assert.Equal(t, 2, stats.Points[0].Sessions)
+		assert.InDelta(t, 0.5, stats.Points[0].BounceRate, 0.001)
+		assert.InDelta(t, 1800, stats.Points[0].AvgSessionDuration, 0.001, "Sessions of 3600s and 0s")
+		assert.InDelta(t, 1.5, stats.Points[0].PagesPerSession, 0.001)
+
+		assert.Equal(t, 1, stats.Points[1].Pageviews)
+		assert.Equal(t, 1, stats.Points[1].Sessions)
+
+		assert.Zero(t, stats.Points[2].Pageviews)
+
+		assert.Equal(t, 4, stats.Totals.Pageviews)
+		assert.Equal(t, 3, stats.Totals.UniqueVisitors)
+		assert.Equal(t, 3, stats.Totals.Sessions)
+		assert.InDelta(t, 2.0/3, stats.Totals.BounceRate, 0.001)
+		assert.InDelta(t, 1200, stats.Totals.AvgSessionDuration, 0.001)
+		assert.InDelta(t, 4.0/3, stats.Totals.PagesPerSession, 0.001)
+	})
+
+	t.Run("Hourly buckets", func(t *testing.T) {
+		stats, err := db.GetHistoricalStats(ctx, day1, day2, ResolutionHour)
+		require.NoError(t, err)
+		require.Len(t, stats.Points, 24)
+		assert.Equal(t, 1, stats.Points[9].Pageviews)
+		assert.Equal(t, 1, stats.Points[10].Pageviews)
+		assert.Equal(t, 1, stats.Points[11].Pageviews)
+	})
+
+	t.Run("Weekly buckets", func(t *testing.T) {
+		stats, err := db.GetHistoricalStats(ctx, day1, day1.AddDate(0, 0, 7), ResolutionWeek)
+		require.NoError(t, err)
+		require.Len(t, stats.Points, 1)
+		assert.Equal(t, 4, stats.Points[0].Pageviews)
+	})
+
+	t.Run("Invalid range", func(t *testing.T) {
+		_, err := db.GetHistoricalStats(ctx, day2, day1, ResolutionDay)
+		assert.Error(t, err)
+
+		_, err = db.GetHistoricalStats(ctx, day1, day1.AddDate(1, 0, 0), ResolutionHour)
+		assert.Error(t, err, "Too many buckets should be rejected")
+	})
+
+	t.Run("Session metrics from rolled-up days", func(t *testing.T) {
+		_, err := db.RollupDay(ctx, day1)
+		require.NoError(t, err)
+
+		stats, err := db.GetHistoricalStats(ctx, day1, day1.AddDate(0, 0, 3), ResolutionDay)
+		require.NoError(t, err)
+		assert.InDelta(t, 0.5, stats.Points[0].BounceRate, 0.001)
+		assert.InDelta(t, 1800, stats.Points[0].AvgSessionDuration, 0.001)
+		assert.InDelta(t, 1.5, stats.Points[0].PagesPerSession, 0.001)
+		assert.InDelta(t, 2.0/3, stats.Totals.BounceRate, 0.001, "Aggregated and raw days merge")
+		assert.InDelta(t, 4.0/3, stats.Totals.PagesPerSession, 0.001)
+	})
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// utmFields lists the UTM parameters in the order of their columns
+var utmFields = []string{utm.FieldSource, utm.FieldMedium, utm.FieldCampaign, utm.FieldTerm, utm.FieldContent}
+
+// backfillUTM fills the UTM columns added by migration 007 from stored URLs:
+// each event's own URL and each session's entry page
+func backfillUTM(tx *sql.Tx) error {
+	set := []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"}
+	params := func(values []string) []interface{} {
+		p := utm.Parse(values[0])
+		params := make([]interface{}, len(utmFields))
+		for i, field := range utmFields {
+			params[i] = nullString(p.Get(field))
+		}
+		return params
+	}
+	if err := backfillTable(tx, "events", "url LIKE '%?%'", []string{"url"}, set, params); err != nil {
+		return err
+	}
+	return backfillTable(tx, "sessions", "entry_page LIKE '%?%'", []string{"entry_page"}, set, params)
+}
+
+// utmColumns maps the UTM parameters reports can group by to their session
+// columns
+var utmColumns = map[string]string{
+	utm.FieldSource:   "utm_source",
+	utm.FieldMedium:   "utm_medium",
+	utm.FieldCampaign: "utm_campaign",
+	utm.FieldTerm:     "utm_term",
+	utm.FieldContent:  "utm_content",
+}
+
+// ValidUTMDimension reports whether sessions can be grouped by the named UTM
+// parameter
+func ValidUTMDimension(dimension string) bool {
+	_, ok := utmColumns[dimension]
+	return ok
+}
+
+// UTMQuery selects a UTM breakdown. GoalID restricts conversions to one
+// goal; zero counts a conversion of any goal.
+type UTMQuery struct {
+	Dimension string
+	From      time.Time
+	To        time.Time
+	GoalID    int64
+	Limit     int
+}
+
+// GetUTMStats groups sessions started in [from, to) by the UTM parameter of
+// their entry page, most visitors first. Sessions without the parameter are
+// left out.
+func (db *DB) GetUTMStats(ctx context.Context, q UTMQuery) ([]AttributionStats, error) {
+	column, ok := utmColumns[q.Dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown UTM dimension %q", q.Dimension)
+	}
+	return db.attributionStats(ctx, attributionQuery{
+		group:  "s." + column,
+		filter: "s." + column + " IS NOT NULL",
+		from:   q.From,
+		to:     q.To,
+		goalID: q.GoalID,
+		limit:  q.Limit,
+	})
+}
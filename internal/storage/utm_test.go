@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+func TestGetUTMStats(t *testing.T) {
+	// Create temporary database file
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Create temporary migrations directory for testing
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC)
+	news := utm.Params{Source: "news", Medium: "email", Campaign: "spring"}
+	social := utm.Params{Source: "twitter", Medium: "social", Campaign: "spring"}
+
+	signup := &Goal{Name: "Signed up", Kind: GoalKindEvent, Match: "Signup"}
+	pricing := &Goal{Name: "Pricing", Kind: GoalKindPageview, Match: "/pricing"}
+	require.NoError(t, db.CreateGoal(ctx, signup))
+	require.NoError(t, db.CreateGoal(ctx, pricing))
+
+	require.NoError(t, db.InsertEvents(ctx, []*Event{
+		// Session a enters from the newsletter and signs up; the second
+		// pageview's parameters don't change the attribution
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "a", VisitorID: "a", UTM: news},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a", UTM: social},
+		{Type: EventTypeCustom, Timestamp: day.Add(2 * time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a", Name: "Signup"},
+		// Visitor b has two newsletter sessions and views pricing in one
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "b1", VisitorID: "b", UTM: news},
+		{Type: EventTypePageview, Timestamp: day.Add(time.Hour), URL: "/pricing", SessionID: "b2", VisitorID: "b", UTM: news},
+		// Session c's entry pageview arrives after a later pageview
+		{Type: EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/blog", SessionID: "c", VisitorID: "c"},
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "c", VisitorID: "c", UTM: social},
+		// Session d has no parameters
+		{Type: EventTypePageview, Timestamp: day, URL: "/", SessionID: "d", VisitorID: "d"},
+		// Outside the range
+		{Type: EventTypePageview, Timestamp: day.AddDate(0, 0, -2), URL: "/", SessionID: "old", VisitorID: "old", UTM: news},
+	}))
+
+	query := UTMQuery{Dimension: utm.FieldSource, From: day.Add(-time.Hour), To: day.AddDate(0, 0, 1), Limit: 10}
+
+	t.Run("Sources", func(t *testing.T) {
+		stats, err := db.GetUTMStats(ctx, query)
+		require.NoError(t, err)
+		require.Len(t, stats, 2)
+
+		assert.Equal(t, AttributionStats{
+			Value: "news", Visitors: 2, Sessions: 3, Pageviews: 4, Conversions: 2, ConversionRate: 1,
+		}, stats[0])
+		assert.Equal(t, AttributionStats{
+			Value: "twitter", Visitors: 1, Sessions: 1, Pageviews: 2,
+		}, stats[1], "Session c is attributed to its entry pageview")
+	})
+
+	t.Run("Campaigns", func(t *testing.T) {
+		q := query
+		q.Dimension = utm.FieldCampaign
+		stats, err := db.GetUTMStats(ctx, q)
+		require.NoError(t, err)
+		require.Len(t, stats, 1)
+		assert.Equal(t, "spring", stats[0].Value)
+		assert.Equal(t, 3, stats[0].Visitors)
+		assert.Equal(t, 2, stats[0].Conversions)
+	})
+
+	t.Run("Single goal", func(t *testing.T) {
+		q := query
+		q.GoalID = signup.ID
+		stats, err := db.GetUTMStats(ctx, q)
+		require.NoError(t, err)
+		require.NotEmpty(t, stats)
+		assert.Equal(t, 1, stats[0].Conversions)
+		assert.InDelta(t, 0.5, stats[0].ConversionRate, 0.001)
+	})
+
+	t.Run("Unknown goal", func(t *testing.T) {
+		q := query
+		q.GoalID = 9999
+		_, err := db.GetUTMStats(ctx, q)
+		assert.ErrorIs(t, err, ErrGoalNotFound)
+	})
+
+	t.Run("Unknown dimension", func(t *testing.T) {
+		q := query
+		q.Dimension = "utm_source"
+		_, err := db.GetUTMStats(ctx, q)
+		assert.Error(t, err)
+		assert.False(t, ValidUTMDimension("utm_source"))
+		assert.True(t, ValidUTMDimension(utm.FieldContent))
+	})
+
+	t.Run("Stored on events", func(t *testing.T) {
+		var source, medium string
+		require.NoError(t, db.conn.QueryRow(
+			"SELECT utm_source, utm_medium FROM events WHERE session_id = 'a' AND url = '/'",
+		).Scan(&source, &medium))
+		assert.Equal(t, "news", source)
+		assert.Equal(t, "email", medium)
+	})
+}
+
+func TestUTMMigrationBackfill(t *testing.T) {
+	dbPath := "test_nyla.db"
+	defer os.Remove(dbPath)
+	defer os.Remove(dbPath + "-shm")
+	defer os.Remove(dbPath + "-wal")
+
+	// Apply only the migrations from before UTM columns existed and store
+	// legacy rows
+	migrationsDir := setupTestMigrations(t)
+	defer os.RemoveAll(migrationsDir)
+	restore := holdBackMigrations(t, migrationsDir, "007")
+
+	db, err := NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`
+		INSERT INTO sessions (id, site_id, started_at, entry_page)
+		VALUES ('s', 'default', '2024-03-14T09:00:00Z', '/?ref=producthunt&utm_campaign=launch')`)
+	require.NoError(t, err)
+	_, err = db.conn.Exec(`
+		INSERT INTO events (site_id, type, timestamp, url, session_id)
+		VALUES ('default', 'pageview', '2024-03-14T09:00:00Z', '/?ref=producthunt&utm_campaign=launch', 's'),
+		       ('default', 'pageview', '2024-03-14T09:01:00Z', '/docs', 's')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	restore()
+	db, err = NewDBWithMigrations(dbPath, migrationsDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	session, err := db.GetSessionByID(context.Background(), "s")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, utm.Params{Source: "producthunt", Campaign: "launch"}, session.UTM)
+
+	var tagged int
+	require.NoError(t, db.conn.QueryRow(
+		"SELECT COUNT(*) FROM events WHERE utm_source = 'producthunt' AND utm_campaign = 'launch'",
+	).Scan(&tagged))
+	assert.Equal(t, 1, tagged)
+}
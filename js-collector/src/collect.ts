@@ -87,6 +87,7 @@ function sendPageview(event: PageviewEvent) {
     title: event.title,
     referrer: event.referrer || '',
     timestamp: event.timestamp || new Date().toISOString(),
+    screen: String(window.screen.width),
   });
 
   const endpoint = getEndpoint();
@@ -0,0 +1,15 @@
+-- Nyla Analytics Core - Daily Salts
+-- Version: 002
+-- Applied: Secret per-day salts for visitor hashing
+
+-- One random salt per UTC day. Only today's and yesterday's salts are kept;
+-- once a salt is deleted, hashes made with it can no longer be linked to
+-- visitors.
+CREATE TABLE salts (
+    date TEXT PRIMARY KEY, -- YYYY-MM-DD (UTC)
+    salt BLOB NOT NULL,
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+) STRICT;
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (2);
@@ -0,0 +1,47 @@
+-- Nyla Analytics Core - Sessionization
+-- Version: 003
+-- Applied: Separate visitor and session IDs; sessions maintained in Go
+
+-- Until now session_id held the daily visitor hash, so it becomes visitor_id
+ALTER TABLE events ADD COLUMN visitor_id TEXT;
+UPDATE events SET visitor_id = session_id;
+
+CREATE INDEX idx_events_visitor ON events(visitor_id, timestamp);
+
+-- Sessions record their visitor and the campaign that started them so a
+-- new campaign or referrer can start a new session
+ALTER TABLE sessions ADD COLUMN visitor_id TEXT;
+ALTER TABLE sessions ADD COLUMN campaign TEXT;
+UPDATE sessions SET visitor_id = id;
+
+CREATE INDEX idx_sessions_visitor ON sessions(visitor_id, ended_at);
+
+-- Session stats are now written by the application in the same transaction
+-- as the event
+DROP TRIGGER update_session_stats;
+
+-- Count visitors rather than sessions
+DROP VIEW active_visitors;
+CREATE VIEW active_visitors AS
+SELECT 
+    site_id,
+    COUNT(DISTINCT visitor_id) as visitor_count
+FROM events
+WHERE timestamp >= datetime('now', '-30 minutes')
+GROUP BY site_id;
+
+DROP VIEW popular_pages;
+CREATE VIEW popular_pages AS
+SELECT 
+    site_id,
+    url,
+    COUNT(*) as pageviews,
+    COUNT(DISTINCT visitor_id) as unique_views
+FROM events
+WHERE 
+    type = 'pageview'
+    AND timestamp >= datetime('now', '-24 hours')
+GROUP BY site_id, url;
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (3);
@@ -0,0 +1,17 @@
+-- Nyla Analytics Core - Pages per Session
+-- Version: 004
+-- Applied: Roll up pages per session alongside bounce rate and duration
+
+ALTER TABLE daily_aggregates ADD COLUMN pages_per_session REAL;
+
+-- Backfill days already rolled up whose sessions are still stored
+UPDATE daily_aggregates
+SET pages_per_session = (
+    SELECT AVG(pages_viewed)
+    FROM sessions
+    WHERE sessions.site_id = daily_aggregates.site_id
+    AND date(sessions.started_at) = daily_aggregates.date
+);
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (4);
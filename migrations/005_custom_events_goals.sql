@@ -0,0 +1,32 @@
+-- Nyla Analytics Core - Custom Events and Goals
+-- Version: 005
+-- Applied: Named custom events with JSON properties; goal definitions
+
+-- Custom events (type 'event') carry a name and a JSON object of properties
+-- that can be queried with json_extract
+ALTER TABLE events ADD COLUMN name TEXT;
+ALTER TABLE events ADD COLUMN properties TEXT CHECK (properties IS NULL OR json_valid(properties));
+
+CREATE INDEX idx_events_name ON events(name, timestamp);
+
+-- Events stored before custom events existed kept their name in type
+UPDATE events
+SET name = type, type = 'event'
+WHERE type NOT IN ('pageview', 'engagement');
+
+-- A goal is completed by a custom event with the given name or by a pageview
+-- whose path matches a glob pattern
+CREATE TABLE goals (
+    id INTEGER PRIMARY KEY,
+    site_id TEXT NOT NULL DEFAULT 'default',
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('event', 'pageview')),
+    match TEXT NOT NULL, -- event name or URL path pattern
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(site_id) REFERENCES site_config(id),
+    CHECK (site_id = 'default'), -- Enforce single site in core
+    UNIQUE(site_id, name)
+) STRICT;
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (5);
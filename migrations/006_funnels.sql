@@ -0,0 +1,29 @@
+-- Nyla Analytics Core - Funnels
+-- Version: 006
+-- Applied: Ordered funnels of pageview and custom event steps
+
+CREATE TABLE funnels (
+    id INTEGER PRIMARY KEY,
+    site_id TEXT NOT NULL DEFAULT 'default',
+    name TEXT NOT NULL,
+    window_seconds INTEGER, -- max time from first to last step; NULL for the session
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(site_id) REFERENCES site_config(id),
+    CHECK (site_id = 'default'), -- Enforce single site in core
+    CHECK (window_seconds IS NULL OR window_seconds > 0),
+    UNIQUE(site_id, name)
+) STRICT;
+
+-- Steps match events like goals: a custom event name or a URL path pattern
+CREATE TABLE funnel_steps (
+    funnel_id INTEGER NOT NULL,
+    position INTEGER NOT NULL, -- 0-based order within the funnel
+    kind TEXT NOT NULL CHECK (kind IN ('event', 'pageview')),
+    match TEXT NOT NULL,
+    label TEXT,
+    PRIMARY KEY (funnel_id, position),
+    FOREIGN KEY(funnel_id) REFERENCES funnels(id) ON DELETE CASCADE
+) STRICT;
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (6);
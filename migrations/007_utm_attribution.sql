@@ -0,0 +1,33 @@
+-- Nyla Analytics Core - UTM Attribution
+-- Version: 007
+-- Applied: UTM parameters parsed into indexed columns on events and sessions
+-- Backfill: existing rows are filled in Go by nyla-core, not by this file
+
+-- UTM parameters of the hit's URL, with ref/source accepted for utm_source
+ALTER TABLE events ADD COLUMN utm_source TEXT;
+ALTER TABLE events ADD COLUMN utm_medium TEXT;
+ALTER TABLE events ADD COLUMN utm_campaign TEXT;
+ALTER TABLE events ADD COLUMN utm_term TEXT;
+ALTER TABLE events ADD COLUMN utm_content TEXT;
+
+CREATE INDEX idx_events_utm_source ON events(utm_source, timestamp);
+CREATE INDEX idx_events_utm_medium ON events(utm_medium, timestamp);
+CREATE INDEX idx_events_utm_campaign ON events(utm_campaign, timestamp);
+
+-- Sessions are attributed to the UTM parameters of their entry page
+ALTER TABLE sessions ADD COLUMN utm_source TEXT;
+ALTER TABLE sessions ADD COLUMN utm_medium TEXT;
+ALTER TABLE sessions ADD COLUMN utm_campaign TEXT;
+ALTER TABLE sessions ADD COLUMN utm_term TEXT;
+ALTER TABLE sessions ADD COLUMN utm_content TEXT;
+
+CREATE INDEX idx_sessions_utm_source ON sessions(utm_source, started_at);
+CREATE INDEX idx_sessions_utm_medium ON sessions(utm_medium, started_at);
+CREATE INDEX idx_sessions_utm_campaign ON sessions(utm_campaign, started_at);
+
+-- Stored rows are backfilled from their URLs by nyla-core right after this
+-- file runs, in the same transaction (backfillUTM in internal/storage). Applied
+-- with the sqlite3 CLI instead, the columns stay NULL for existing rows.
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (7);
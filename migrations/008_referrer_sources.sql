@@ -0,0 +1,31 @@
+-- Nyla Analytics Core - Referrer Sources
+-- Version: 008
+-- Applied: Referrer host, source name and channel on events and sessions
+-- Backfill: existing rows are filled in Go by nyla-core, not by this file
+
+-- Referrers are reduced to a host without www. and classified into a source
+-- (a known name such as Google, else the host or utm_source) and a channel:
+-- search, social, email, referral or direct
+ALTER TABLE events ADD COLUMN referrer_host TEXT;
+ALTER TABLE events ADD COLUMN source TEXT;
+ALTER TABLE events ADD COLUMN channel TEXT;
+
+CREATE INDEX idx_events_source ON events(source, timestamp);
+CREATE INDEX idx_events_channel ON events(channel, timestamp);
+
+-- A session's source and channel are those of its entry page. referrer_host
+-- is the host of the session's first external referrer.
+ALTER TABLE sessions ADD COLUMN referrer_host TEXT;
+ALTER TABLE sessions ADD COLUMN source TEXT;
+ALTER TABLE sessions ADD COLUMN channel TEXT;
+
+CREATE INDEX idx_sessions_source ON sessions(source, started_at);
+CREATE INDEX idx_sessions_channel ON sessions(channel, started_at);
+
+-- Stored rows are classified with the built-in source list by nyla-core
+-- right after this file runs, in the same transaction (backfillSources in
+-- internal/storage). Applied with the sqlite3 CLI instead, the columns stay
+-- NULL for existing rows.
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (8);
@@ -0,0 +1,26 @@
+-- Nyla Analytics Core - Device Columns
+-- Version: 009
+-- Applied: Device type, browser, OS and screen size class on events
+-- Backfill: existing rows are filled in Go by nyla-core, not by this file
+
+-- Browser and OS versions are major versions only. screen_class is mobile,
+-- tablet, laptop or desktop, from the screen width sent by the tracker.
+ALTER TABLE events ADD COLUMN device_type TEXT;
+ALTER TABLE events ADD COLUMN browser TEXT;
+ALTER TABLE events ADD COLUMN browser_version TEXT;
+ALTER TABLE events ADD COLUMN os TEXT;
+ALTER TABLE events ADD COLUMN os_version TEXT;
+ALTER TABLE events ADD COLUMN screen_class TEXT;
+
+CREATE INDEX idx_events_device_type ON events(device_type, timestamp);
+CREATE INDEX idx_events_browser ON events(browser, timestamp);
+CREATE INDEX idx_events_os ON events(os, timestamp);
+CREATE INDEX idx_events_screen_class ON events(screen_class, timestamp);
+
+-- Stored rows are backfilled from the user agent kept in metadata by nyla-core
+-- right after this file runs, in the same transaction (backfillDevices in
+-- internal/storage). Applied with the sqlite3 CLI instead, the columns stay
+-- NULL for existing rows. Screen sizes weren't collected before.
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (9);
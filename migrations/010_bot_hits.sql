@@ -0,0 +1,48 @@
+-- Nyla Analytics Core - Bot Hits
+-- Version: 010
+-- Applied: Separate table for bot traffic, moving stored bot events out of events
+
+-- Hits classified as bots are kept here, away from visitor events, when
+-- bots.mode is store. reason is how the hit was recognized, e.g. user_agent,
+-- crawler, headless, no_accept_language or ip_range; name identifies the bot
+-- where possible. No visitor or session is recorded.
+CREATE TABLE bot_hits (
+    id INTEGER PRIMARY KEY,
+    site_id TEXT NOT NULL DEFAULT 'default',
+    timestamp TEXT NOT NULL,
+    type TEXT NOT NULL,
+    url TEXT,
+    name TEXT,
+    reason TEXT NOT NULL,
+    FOREIGN KEY(site_id) REFERENCES site_config(id),
+    CHECK (site_id = 'default') -- Enforce single site in core
+) STRICT;
+
+CREATE INDEX idx_bot_hits_timestamp ON bot_hits(timestamp);
+
+INSERT INTO retention_policies (site_id, data_type, retention_days) VALUES
+    ('default', 'bot_hits', 30)
+ON CONFLICT DO NOTHING;
+
+-- Earlier releases stored bots as events flagged with is_bot in metadata.
+-- Move them here, then drop the sessions they started: the visitor hash
+-- includes the user agent, so their sessions hold no human hits.
+CREATE TEMP TABLE bot_event_ids AS
+SELECT id, session_id FROM events
+WHERE CASE WHEN json_valid(metadata) THEN json_extract(metadata, '$.is_bot') END = 1;
+
+INSERT INTO bot_hits (site_id, timestamp, type, url, name, reason)
+SELECT site_id, timestamp, type, url, COALESCE(browser, json_extract(metadata, '$.browser_name')), 'user_agent'
+FROM events
+WHERE id IN (SELECT id FROM bot_event_ids);
+
+DELETE FROM events WHERE id IN (SELECT id FROM bot_event_ids);
+
+DELETE FROM sessions
+WHERE id IN (SELECT session_id FROM bot_event_ids)
+AND NOT EXISTS (SELECT 1 FROM events e WHERE e.session_id = sessions.id);
+
+DROP TABLE bot_event_ids;
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (10);
@@ -0,0 +1,15 @@
+-- Nyla Analytics Core - Geo Columns
+-- Version: 011
+-- Applied: Country, region and city on events
+
+-- Resolved from the anonymized client IP at ingestion; the IP itself is not
+-- stored. country is an ISO 3166-1 alpha-2 code; region and city are English
+-- names. Earlier events have no stored IP to resolve and stay empty.
+ALTER TABLE events ADD COLUMN country TEXT;
+ALTER TABLE events ADD COLUMN region TEXT;
+ALTER TABLE events ADD COLUMN city TEXT;
+
+CREATE INDEX idx_events_country ON events(country, timestamp);
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (11);
@@ -0,0 +1,22 @@
+-- Nyla Analytics Core - Consent Counts
+-- Version: 012
+-- Applied: Daily counts of hits sent with Do Not Track or Global Privacy Control
+
+-- Hits carrying an opt-out signal are counted here per day, signal ('dnt' or
+-- 'gpc') and the action the consent policy took ('dropped', 'anonymized' or
+-- 'ignored'). Only the counts are kept: nothing identifies the hits, and
+-- dropped hits leave no other trace. Anonymized and ignored hits are also
+-- stored as events.
+CREATE TABLE consent_counts (
+    site_id TEXT NOT NULL DEFAULT 'default',
+    date TEXT NOT NULL,
+    signal TEXT NOT NULL,
+    action TEXT NOT NULL,
+    hits INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (site_id, date, signal, action),
+    FOREIGN KEY(site_id) REFERENCES site_config(id),
+    CHECK (site_id = 'default') -- Enforce single site in core
+) STRICT;
+
+-- Record this migration
+INSERT INTO schema_migrations (version) VALUES (12);
@@ -192,10 +192,10 @@ func genPages() {
 
 func pageName() string {
 	return fmt.Sprint(
-		string(rand.Intn(26)+65),
-		string(rand.Intn(26)+65),
-		string(rand.Intn(26)+65),
-		string(rand.Intn(26)+65),
-		string(rand.Intn(26)+65),
+		string(rune(rand.Intn(26)+65)),
+		string(rune(rand.Intn(26)+65)),
+		string(rune(rand.Intn(26)+65)),
+		string(rune(rand.Intn(26)+65)),
+		string(rune(rand.Intn(26)+65)),
 	)
 }
@@ -0,0 +1,220 @@
+// Package bots recognizes crawlers, headless browsers and other automated
+// traffic so it can be kept out of reports. A hit is a bot if the user agent
+// parser flags it, its user agent matches an embedded crawler list or names
+// a headless browser, it claims to be a browser but sends no Accept-Language
+// header, or it comes from a configured IP range such as a datacenter.
+package bots
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/mileusna/useragent"
+)
+
+// Mode controls what happens to bot hits
+type Mode string
+
+const (
+	// ModeDrop discards bot hits
+	ModeDrop Mode = "drop"
+	// ModeStore keeps bot hits apart from visitor events for the bot
+	// traffic report
+	ModeStore Mode = "store"
+	// ModeOff disables bot filtering
+	ModeOff Mode = "off"
+)
+
+// ParseMode parses a bot filtering mode
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case ModeDrop:
+		return ModeDrop, nil
+	case ModeStore:
+		return ModeStore, nil
+	case ModeOff:
+		return ModeOff, nil
+	}
+	return "", fmt.Errorf("unknown bot mode %q (want drop, store or off)", s)
+}
+
+// Reasons a hit is classified as a bot
+const (
+	ReasonNoUserAgent      = "no_user_agent"
+	ReasonHeadless         = "headless"
+	ReasonUserAgent        = "user_agent"
+	ReasonCrawler          = "crawler"
+	ReasonIPRange          = "ip_range"
+	ReasonNoAcceptLanguage = "no_accept_language"
+)
+
+// MaxNameLength caps the length of a reported bot name
+const MaxNameLength = 64
+
+//go:embed crawlers.txt
+var crawlerList string
+
+// headlessMarkers are lowercase user agent substrings of automated browsers
+var headlessMarkers = []string{"headlesschrome", "phantomjs", "selenium", "webdriver", "puppeteer", "playwright"}
+
+// Verdict is the classification of a hit
+type Verdict struct {
+	// Reason is one of the Reason constants, or empty for human traffic
+	Reason string
+	// Name identifies the bot, such as Googlebot or the matched crawler
+	// pattern. It may be empty.
+	Name string
+}
+
+// IsBot reports whether the hit was classified as a bot
+func (v Verdict) IsBot() bool {
+	return v.Reason != ""
+}
+
+// Detector classifies hits. The zero value is not usable; use NewDetector.
+type Detector struct {
+	patterns []string
+	ranges   []*net.IPNet
+}
+
+// NewDetector creates a detector using the embedded crawler list and the
+// given IP ranges
+func NewDetector(ranges []*net.IPNet) *Detector {
+	return &Detector{patterns: parsePatterns(crawlerList), ranges: ranges}
+}
+
+// Check classifies a hit from its parsed user agent, Accept-Language header
+// and client IP, which may be nil
+func (d *Detector) Check(ua useragent.UserAgent, acceptLanguage string, ip net.IP) Verdict {
+	raw := strings.TrimSpace(ua.String)
+	if raw == "" {
+		return Verdict{Reason: ReasonNoUserAgent}
+	}
+	lower := strings.ToLower(raw)
+
+	for _, marker := range headlessMarkers {
+		if strings.Contains(lower, marker) {
+			return Verdict{Reason: ReasonHeadless, Name: marker}
+		}
+	}
+	if ua.Bot {
+		return Verdict{Reason: ReasonUserAgent, Name: name(ua.Name)}
+	}
+	for _, pattern := range d.patterns {
+		if strings.Contains(lower, pattern) {
+			return Verdict{Reason: ReasonCrawler, Name: name(pattern)}
+		}
+	}
+	if ip != nil {
+		for _, r := range d.ranges {
+			if r.Contains(ip) {
+				return Verdict{Reason: ReasonIPRange, Name: name(ua.Name)}
+			}
+		}
+	}
+	// Browsers always send Accept-Language; scripts imitating them often
+	// don't
+	if strings.HasPrefix(raw, "Mozilla/") && strings.TrimSpace(acceptLanguage) == "" {
+		return Verdict{Reason: ReasonNoAcceptLanguage, Name: name(ua.Name)}
+	}
+	return Verdict{}
+}
+
+// ParseRanges parses IP ranges in CIDR notation. Bare addresses are treated
+// as single-address ranges.
+func ParseRanges(values []string) ([]*net.IPNet, error) {
+	ranges := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		r, err := parseRange(v)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// ReadRanges reads IP ranges from r, one per line. Blank lines and lines
+// starting with # are skipped.
+func ReadRanges(r io.Reader) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		v := strings.TrimSpace(scanner.Text())
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		ipNet, err := parseRange(v)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read IP ranges: %w", err)
+	}
+	return ranges, nil
+}
+
+// LoadRanges reads IP ranges from the file at path; see ReadRanges
+func LoadRanges(path string) ([]*net.IPNet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IP ranges: %w", err)
+	}
+	defer f.Close()
+
+	ranges, err := ReadRanges(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ranges, nil
+}
+
+// parseRange parses a CIDR range or a single address
+func parseRange(v string) (*net.IPNet, error) {
+	v = strings.TrimSpace(v)
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP range %q", v)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP range %q", v)
+	}
+	return ipNet, nil
+}
+
+// parsePatterns reads a pattern list, skipping blank lines and comments
+func parsePatterns(list string) []string {
+	var patterns []string
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns
+}
+
+// name trims and caps a bot name
+func name(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) > MaxNameLength {
+		v = v[:MaxNameLength]
+	}
+	return v
+}
@@ -0,0 +1,140 @@
+package bots
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mileusna/useragent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const chromeUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36"
+
+func TestCheck(t *testing.T) {
+	ranges, err := ParseRanges([]string{"203.0.113.0/24", "2001:db8::/32"})
+	require.NoError(t, err)
+	d := NewDetector(ranges)
+
+	tests := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		ip             string
+		expected       Verdict
+	}{
+		{
+			name:           "Browser",
+			userAgent:      chromeUA,
+			acceptLanguage: "en-GB,en;q=0.9",
+			ip:             "198.51.100.7",
+			expected:       Verdict{},
+		},
+		{
+			name:     "No user agent",
+			expected: Verdict{Reason: ReasonNoUserAgent},
+		},
+		{
+			name:           "Parser flag",
+			userAgent:      "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			acceptLanguage: "en",
+			expected:       Verdict{Reason: ReasonUserAgent, Name: "Googlebot"},
+		},
+		{
+			name:           "Headless browser",
+			userAgent:      "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36",
+			acceptLanguage: "en-US",
+			expected:       Verdict{Reason: ReasonHeadless, Name: "headlesschrome"},
+		},
+		{
+			name:      "HTTP library",
+			userAgent: "python-requests/2.31.0",
+			expected:  Verdict{Reason: ReasonCrawler, Name: "python-requests"},
+		},
+		{
+			name:           "Link preview",
+			userAgent:      "WhatsApp/2.23.20.0 A",
+			acceptLanguage: "en",
+			expected:       Verdict{Reason: ReasonCrawler, Name: "whatsapp"},
+		},
+		{
+			name:           "IPv4 range",
+			userAgent:      chromeUA,
+			acceptLanguage: "en",
+			ip:             "203.0.113.50",
+			expected:       Verdict{Reason: ReasonIPRange, Name: "Chrome"},
+		},
+		{
+			name:           "IPv6 range",
+			userAgent:      chromeUA,
+			acceptLanguage: "en",
+			ip:             "2001:db8::1",
+			expected:       Verdict{Reason: ReasonIPRange, Name: "Chrome"},
+		},
+		{
+			name:      "Browser without Accept-Language",
+			userAgent: chromeUA,
+			expected:  Verdict{Reason: ReasonNoAcceptLanguage, Name: "Chrome"},
+		},
+		{
+			name:      "Non-browser client without Accept-Language",
+			userAgent: "NylaImporter/1.0",
+			expected:  Verdict{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := d.Check(useragent.Parse(tt.userAgent), tt.acceptLanguage, net.ParseIP(tt.ip))
+			assert.Equal(t, tt.expected, verdict)
+			assert.Equal(t, tt.expected.Reason != "", verdict.IsBot())
+		})
+	}
+}
+
+func TestCrawlerListSparesBrowsers(t *testing.T) {
+	// In-app and niche browsers whose names resemble crawlers
+	d := NewDetector(nil)
+	for _, ua := range []string{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [Pinterest/iOS]",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 YaBrowser/24.1.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/120.0.0.0 Mobile DuckDuckGo/5 Safari/537.36",
+	} {
+		assert.False(t, d.Check(useragent.Parse(ua), "en", nil).IsBot(), ua)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode(" Store ")
+	require.NoError(t, err)
+	assert.Equal(t, ModeStore, mode)
+
+	_, err = ParseMode("tag")
+	assert.Error(t, err)
+}
+
+func TestReadRanges(t *testing.T) {
+	ranges, err := ReadRanges(strings.NewReader("# Datacenter\n192.0.2.0/24\n\n198.51.100.9\n"))
+	require.NoError(t, err)
+	require.Len(t, ranges, 2)
+	assert.True(t, ranges[1].Contains(net.ParseIP("198.51.100.9")))
+	assert.False(t, ranges[1].Contains(net.ParseIP("198.51.100.10")))
+
+	_, err = ReadRanges(strings.NewReader("192.0.2.0/24\nnot-a-range\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestLoadRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ranges.txt")
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.0/8\n"), 0644))
+
+	ranges, err := LoadRanges(path)
+	require.NoError(t, err)
+	assert.Len(t, ranges, 1)
+
+	_, err = LoadRanges(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+// Package clientip determines the address of the client behind a request
+// that may have passed through reverse proxies. Forwarding headers are only
+// believed when the connecting peer is a trusted proxy, so visitors can't
+// choose their own address by sending the headers themselves.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Headers that name the client directly and may be enabled with New. Only
+// enable the headers the trusted proxies set or overwrite.
+const (
+	// HeaderForwarded is the RFC 7239 Forwarded header. Its for= values
+	// are walked like X-Forwarded-For.
+	HeaderForwarded = "Forwarded"
+	// HeaderCFConnectingIP is set by Cloudflare
+	HeaderCFConnectingIP = "CF-Connecting-IP"
+	// HeaderTrueClientIP is set by Akamai and Cloudflare Enterprise
+	HeaderTrueClientIP = "True-Client-IP"
+	// HeaderXRealIP is set by nginx
+	HeaderXRealIP = "X-Real-IP"
+)
+
+// optionalHeaders are the headers New accepts, by canonical name
+var optionalHeaders = map[string]string{
+	http.CanonicalHeaderKey(HeaderForwarded):      HeaderForwarded,
+	http.CanonicalHeaderKey(HeaderCFConnectingIP): HeaderCFConnectingIP,
+	http.CanonicalHeaderKey(HeaderTrueClientIP):   HeaderTrueClientIP,
+	http.CanonicalHeaderKey(HeaderXRealIP):        HeaderXRealIP,
+}
+
+// DefaultTrustedProxies trusts a reverse proxy on the same host, such as
+// Caddy with reverse_proxy localhost:8080
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// Resolver finds the client address of requests
+type Resolver struct {
+	trusted []*net.IPNet
+	headers []string
+}
+
+// New creates a resolver that trusts forwarding headers from peers in the
+// trusted networks. X-Forwarded-For is always honored from trusted peers;
+// headers lists the optional headers to honor as well, in order of
+// preference, ahead of X-Forwarded-For.
+func New(trusted []*net.IPNet, headers []string) (*Resolver, error) {
+	r := &Resolver{trusted: trusted}
+	for _, h := range headers {
+		name, ok := optionalHeaders[http.CanonicalHeaderKey(strings.TrimSpace(h))]
+		if !ok {
+			return nil, fmt.Errorf("unsupported client IP header %q (want Forwarded, CF-Connecting-IP, True-Client-IP or X-Real-IP)", h)
+		}
+		r.headers = append(r.headers, name)
+	}
+	return r, nil
+}
+
+// ClientIP returns the client address of req. Requests from untrusted peers
+// are attributed to the peer. For trusted peers, the first enabled header
+// that is present names the client; otherwise X-Forwarded-For is walked from
+// the right, skipping trusted proxies, to the first untrusted hop.
+func (r *Resolver) ClientIP(req *http.Request) (net.IP, error) {
+	peer, err := RemoteIP(req)
+	if err != nil {
+		return nil, err
+	}
+	if !r.isTrusted(peer) {
+		return peer, nil
+	}
+
+	for _, h := range r.headers {
+		if h == HeaderForwarded {
+			if hops := forwardedHops(req.Header.Values(HeaderForwarded)); len(hops) > 0 {
+				return r.walk(hops, peer), nil
+			}
+			continue
+		}
+		if ip := parseIP(req.Header.Get(h)); ip != nil {
+			return ip, nil
+		}
+	}
+
+	if hops := forwardedForHops(req.Header.Values("X-Forwarded-For")); len(hops) > 0 {
+		return r.walk(hops, peer), nil
+	}
+	return peer, nil
+}
+
+// RemoteIP returns the address of the peer that sent req
+func RemoteIP(req *http.Request) (net.IP, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid remote address %q", req.RemoteAddr)
+	}
+	return ip, nil
+}
+
+// ParseNetworks parses addresses in CIDR notation. Bare addresses are
+// treated as single-address networks.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", v)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", v)
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
+
+// walk returns the rightmost untrusted hop. Each hop was appended by the
+// proxy to its right, so once an untrusted hop is reached, the values to its
+// left can't be believed. If a hop can't be parsed, the proxy that reported
+// it is the last known address. If every hop is trusted, the leftmost is the
+// client.
+func (r *Resolver) walk(hops []string, peer net.IP) net.IP {
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseIP(hops[i])
+		if ip == nil {
+			return client
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			return ip
+		}
+	}
+	return client
+}
+
+// isTrusted reports whether ip is in a trusted network
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, n := range r.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedForHops splits X-Forwarded-For values into hops, leftmost first.
+// Repeated headers are joined in order.
+func forwardedForHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedHops extracts the for= parameters of RFC 7239 Forwarded values,
+// leftmost first. Elements without one are skipped.
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseIP parses a hop, which may carry a port and, for IPv6, brackets.
+// Obfuscated and unknown RFC 7239 identifiers aren't addresses.
+func parseIP(v string) net.IP {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	if ip := net.ParseIP(v); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(v, "[]"))
+}
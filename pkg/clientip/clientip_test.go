@@ -0,0 +1,249 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	// The Caddyfile runs Caddy on the same host with reverse_proxy
+	// localhost:8080, so nyla-core sees Caddy's loopback address as the
+	// peer. Caddy replaces X-Forwarded-For with the visitor's address unless
+	// the visitor is one of its own trusted proxies, such as Cloudflare,
+	// in which case it appends to it.
+	caddy := DefaultTrustedProxies
+	cloudflare := append([]string{"173.245.48.0/20", "2400:cb00::/32"}, caddy...)
+
+	tests := []struct {
+		name       string
+		trusted    []string
+		headers    []string
+		remoteAddr string
+		header     map[string][]string
+		expected   string
+	}{
+		{
+			name:       "Caddy forwards a visitor",
+			trusted:    caddy,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "Caddy over IPv6 loopback",
+			trusted:    caddy,
+			remoteAddr: "[::1]:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"2001:db8::7"}},
+			expected:   "2001:db8::7",
+		},
+		{
+			name:       "Spoofed hop left of the visitor",
+			trusted:    caddy,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7"}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "Direct request bypassing Caddy",
+			trusted:    caddy,
+			remoteAddr: "198.51.100.9:40000",
+			header:     map[string][]string{"X-Forwarded-For": {"1.2.3.4"}},
+			expected:   "198.51.100.9",
+		},
+		{
+			name:       "No proxies trusted",
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			expected:   "127.0.0.1",
+		},
+		{
+			name:       "No forwarding header",
+			trusted:    caddy,
+			remoteAddr: "127.0.0.1:51234",
+			expected:   "127.0.0.1",
+		},
+		{
+			name:       "Cloudflare in front of Caddy",
+			trusted:    cloudflare,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.7, 173.245.48.5"}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "Repeated X-Forwarded-For headers",
+			trusted:    cloudflare,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7", "173.245.48.5"}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "Every hop trusted",
+			trusted:    cloudflare,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"173.245.48.9, 173.245.48.5"}},
+			expected:   "173.245.48.9",
+		},
+		{
+			name:       "Unparseable hop",
+			trusted:    cloudflare,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7, garbage, 173.245.48.5"}},
+			expected:   "173.245.48.5",
+		},
+		{
+			name:       "CF-Connecting-IP ignored unless enabled",
+			trusted:    cloudflare,
+			remoteAddr: "127.0.0.1:51234",
+			header: map[string][]string{
+				"Cf-Connecting-Ip": {"1.2.3.4"},
+				"X-Forwarded-For":  {"203.0.113.7, 173.245.48.5"},
+			},
+			expected: "203.0.113.7",
+		},
+		{
+			name:       "CF-Connecting-IP enabled",
+			trusted:    cloudflare,
+			headers:    []string{HeaderCFConnectingIP},
+			remoteAddr: "127.0.0.1:51234",
+			header: map[string][]string{
+				"Cf-Connecting-Ip": {"203.0.113.8"},
+				"X-Forwarded-For":  {"203.0.113.7, 173.245.48.5"},
+			},
+			expected: "203.0.113.8",
+		},
+		{
+			name:       "Enabled header from an untrusted peer",
+			trusted:    caddy,
+			headers:    []string{HeaderCFConnectingIP},
+			remoteAddr: "198.51.100.9:40000",
+			header:     map[string][]string{"Cf-Connecting-Ip": {"1.2.3.4"}},
+			expected:   "198.51.100.9",
+		},
+		{
+			name:       "Missing enabled header falls back to X-Forwarded-For",
+			trusted:    caddy,
+			headers:    []string{HeaderTrueClientIP},
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "True-Client-IP enabled",
+			trusted:    caddy,
+			headers:    []string{HeaderTrueClientIP},
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"True-Client-Ip": {"2001:db8::8"}},
+			expected:   "2001:db8::8",
+		},
+		{
+			name:       "X-Real-IP ignored unless enabled",
+			trusted:    caddy,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"X-Real-Ip": {"1.2.3.4"}},
+			expected:   "127.0.0.1",
+		},
+		{
+			name:       "Forwarded ignored unless enabled",
+			trusted:    caddy,
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"Forwarded": {"for=1.2.3.4"}},
+			expected:   "127.0.0.1",
+		},
+		{
+			name:       "Forwarded enabled",
+			trusted:    cloudflare,
+			headers:    []string{HeaderForwarded},
+			remoteAddr: "127.0.0.1:51234",
+			header: map[string][]string{
+				"Forwarded":       {`for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https, for=173.245.48.5;by=127.0.0.1`},
+				"X-Forwarded-For": {"5.6.7.8"},
+			},
+			expected: "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded IPv4 with port",
+			trusted:    caddy,
+			headers:    []string{HeaderForwarded},
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"Forwarded": {`For="203.0.113.7:4711"`}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "Forwarded obfuscated identifier",
+			trusted:    caddy,
+			headers:    []string{HeaderForwarded},
+			remoteAddr: "127.0.0.1:51234",
+			header:     map[string][]string{"Forwarded": {"for=_hidden"}},
+			expected:   "127.0.0.1",
+		},
+		{
+			name:       "Header preference follows configuration order",
+			trusted:    caddy,
+			headers:    []string{HeaderTrueClientIP, HeaderCFConnectingIP},
+			remoteAddr: "127.0.0.1:51234",
+			header: map[string][]string{
+				"Cf-Connecting-Ip": {"203.0.113.8"},
+				"True-Client-Ip":   {"203.0.113.9"},
+			},
+			expected: "203.0.113.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := ParseNetworks(tt.trusted)
+			require.NoError(t, err)
+			r, err := New(trusted, tt.headers)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "/api/v1/collect", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.header {
+				for _, v := range values {
+					req.Header.Add(name, v)
+				}
+			}
+
+			ip, err := r.ClientIP(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ip.String())
+		})
+	}
+}
+
+func TestNewRejectsUnknownHeaders(t *testing.T) {
+	_, err := New(nil, []string{"x-client-ip"})
+	assert.Error(t, err)
+
+	r, err := New(nil, []string{"cf-connecting-ip", " forwarded "})
+	require.NoError(t, err)
+	assert.Equal(t, []string{HeaderCFConnectingIP, HeaderForwarded}, r.headers)
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8", "192.0.2.1", "::1"})
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	assert.Equal(t, "192.0.2.1/32", networks[1].String())
+	assert.Equal(t, "::1/128", networks[2].String())
+
+	_, err = ParseNetworks([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseNetworks([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
+
+func TestRemoteIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "[2001:db8::1]:443"
+	ip, err := RemoteIP(req)
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", ip.String())
+
+	req.RemoteAddr = "@"
+	_, err = RemoteIP(req)
+	assert.Error(t, err)
+}
@@ -0,0 +1,140 @@
+// Package device describes the client of a hit from its user agent and
+// screen width
+package device
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mileusna/useragent"
+)
+
+// MaxValueLength caps the length of a stored browser or OS name
+const MaxValueLength = 64
+
+// Device types
+const (
+	TypeDesktop = "desktop"
+	TypeTablet  = "tablet"
+	TypeMobile  = "mobile"
+)
+
+// Screen size classes, by screen width in CSS pixels
+const (
+	ScreenMobile  = "mobile"  // narrower than 768
+	ScreenTablet  = "tablet"  // 768 to 1023
+	ScreenLaptop  = "laptop"  // 1024 to 1439
+	ScreenDesktop = "desktop" // 1440 and wider
+)
+
+// Fields name the fields of Info
+const (
+	FieldType           = "type"
+	FieldBrowser        = "browser"
+	FieldBrowserVersion = "browser_version"
+	FieldOS             = "os"
+	FieldOSVersion      = "os_version"
+	FieldScreen         = "screen"
+)
+
+// Info describes the device, browser and screen of a hit. Versions are major
+// versions only, so they say little about an individual visitor.
+type Info struct {
+	Type           string `json:"type,omitempty"`
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browser_version,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"os_version,omitempty"`
+	Screen         string `json:"screen,omitempty"`
+}
+
+// Parse describes the client with the given user agent. Screen is not set.
+func Parse(userAgent string) Info {
+	return FromUserAgent(useragent.Parse(userAgent))
+}
+
+// FromUserAgent describes a parsed user agent. Screen is not set.
+func FromUserAgent(ua useragent.UserAgent) Info {
+	info := Info{
+		Browser: clean(ua.Name),
+		OS:      clean(ua.OS),
+	}
+	if info.Browser != "" {
+		info.BrowserVersion = majorVersion(ua.VersionNo)
+	}
+	if info.OS != "" {
+		info.OSVersion = majorVersion(ua.OSVersionNo)
+	}
+	switch {
+	case ua.Tablet:
+		info.Type = TypeTablet
+	case ua.Mobile:
+		info.Type = TypeMobile
+	case ua.Desktop:
+		info.Type = TypeDesktop
+	}
+	return info
+}
+
+// ScreenClass returns the size class of a screen width in CSS pixels, or an
+// empty string for non-positive widths
+func ScreenClass(width int) string {
+	switch {
+	case width <= 0:
+		return ""
+	case width < 768:
+		return ScreenMobile
+	case width < 1024:
+		return ScreenTablet
+	case width < 1440:
+		return ScreenLaptop
+	}
+	return ScreenDesktop
+}
+
+// ParseScreen returns the size class of a screen width sent as text, or an
+// empty string if it isn't a number
+func ParseScreen(width string) string {
+	w, err := strconv.Atoi(strings.TrimSpace(width))
+	if err != nil {
+		return ""
+	}
+	return ScreenClass(w)
+}
+
+// Get returns the named field, or an empty string for unknown fields
+func (i Info) Get(field string) string {
+	switch field {
+	case FieldType:
+		return i.Type
+	case FieldBrowser:
+		return i.Browser
+	case FieldBrowserVersion:
+		return i.BrowserVersion
+	case FieldOS:
+		return i.OS
+	case FieldOSVersion:
+		return i.OSVersion
+	case FieldScreen:
+		return i.Screen
+	}
+	return ""
+}
+
+// majorVersion formats the major part of v, or returns an empty string if
+// the version is unknown
+func majorVersion(v useragent.VersionNo) string {
+	if v.Major <= 0 {
+		return ""
+	}
+	return strconv.Itoa(v.Major)
+}
+
+// clean trims a name and caps its length
+func clean(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) > MaxValueLength {
+		v = v[:MaxValueLength]
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package device
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		expected  Info
+	}{
+		{
+			name:      "Desktop Chrome",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36",
+			expected:  Info{Type: TypeDesktop, Browser: "Chrome", BrowserVersion: "120", OS: "Windows", OSVersion: "10"},
+		},
+		{
+			name:      "iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			expected:  Info{Type: TypeMobile, Browser: "Safari", BrowserVersion: "17", OS: "iOS", OSVersion: "17"},
+		},
+		{
+			name:      "iPad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			expected:  Info{Type: TypeTablet, Browser: "Safari", BrowserVersion: "16", OS: "iOS", OSVersion: "16"},
+		},
+		{
+			name:      "OS without version",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			expected:  Info{Type: TypeDesktop, Browser: "Firefox", BrowserVersion: "121", OS: "Linux"},
+		},
+		{
+			name:     "Empty",
+			expected: Info{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Parse(tt.userAgent))
+		})
+	}
+}
+
+func TestParseTruncatesNames(t *testing.T) {
+	info := Parse(strings.Repeat("x", 200))
+	assert.Len(t, info.Browser, MaxValueLength)
+}
+
+func TestScreenClass(t *testing.T) {
+	assert.Equal(t, "", ScreenClass(0))
+	assert.Equal(t, ScreenMobile, ScreenClass(390))
+	assert.Equal(t, ScreenTablet, ScreenClass(768))
+	assert.Equal(t, ScreenLaptop, ScreenClass(1366))
+	assert.Equal(t, ScreenDesktop, ScreenClass(2560))
+
+	assert.Equal(t, ScreenLaptop, ParseScreen(" 1280 "))
+	assert.Equal(t, "", ParseScreen("wide"))
+	assert.Equal(t, "", ParseScreen(""))
+}
+
+func TestGet(t *testing.T) {
+	info := Info{Type: TypeMobile, Browser: "Safari", OSVersion: "17", Screen: ScreenMobile}
+	assert.Equal(t, TypeMobile, info.Get(FieldType))
+	assert.Equal(t, "Safari", info.Get(FieldBrowser))
+	assert.Equal(t, "17", info.Get(FieldOSVersion))
+	assert.Equal(t, ScreenMobile, info.Get(FieldScreen))
+	assert.Equal(t, "", info.Get("model"))
+}
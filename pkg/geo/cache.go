@@ -0,0 +1,88 @@
+package geo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cache is a size-bounded LRU cache of lookup results that expire after a
+// TTL. A nil result records an address with no location. A nil *cache
+// stores nothing.
+type cache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached lookup result
+type cacheEntry struct {
+	key     string
+	info    *GeoInfo
+	expires time.Time
+}
+
+// newCache creates a cache of up to size results, or nil if size is not
+// positive
+func newCache(size int, ttl time.Duration) *cache {
+	if size <= 0 {
+		return nil
+	}
+	return &cache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached result for key and whether there was one
+func (c *cache) get(key string) (*GeoInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.info, true
+}
+
+// put caches the result for key, evicting the least recently used result
+// when full
+func (c *cache) put(key string, info *GeoInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.info, entry.expires = info, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, info: info, expires: expires})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,36 @@
+package geo
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed countries.txt
+var countryList string
+
+// countryNames maps ISO 3166-1 alpha-2 codes to English country names
+var countryNames = parseCountries(countryList)
+
+// CountryName returns the English name of the country with the given ISO
+// 3166-1 alpha-2 code, or the code itself if it is unknown
+func CountryName(code string) string {
+	if name, ok := countryNames[strings.ToUpper(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// parseCountries reads tab-separated codes and names, skipping comments
+func parseCountries(list string) map[string]string {
+	names := make(map[string]string)
+	for _, line := range strings.Split(list, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		code, name, ok := strings.Cut(line, "\t")
+		if ok {
+			names[code] = name
+		}
+	}
+	return names
+}
@@ -1,24 +1,67 @@
+// Package geo resolves client IP addresses to countries, regions and cities,
+// either from a local MMDB file or an HTTP GeoIP service.
 package geo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
-	"os"
-	"strings"
+	"net/url"
+	"time"
 )
 
-var (
-	GEOIP_PROTO = getEnv("GEOIP_PROTO", "http")
-	GEOIP_HOST  = getEnv("GEOIP_HOST", "localhost:8080")
+const (
+	// DefaultTimeout bounds HTTP GeoIP lookups when Config.Timeout is unset
+	DefaultTimeout = 2 * time.Second
+	// DefaultCacheSize is the number of HTTP lookups cached when
+	// Config.CacheSize is unset
+	DefaultCacheSize = 10000
+	// DefaultCacheTTL is how long HTTP lookups are cached when
+	// Config.CacheTTL is unset
+	DefaultCacheTTL = 24 * time.Hour
 )
 
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// Config holds the location of the HTTP GeoIP service
+type Config struct {
+	Proto string
+	Host  string
+	// Timeout bounds each request to the service
+	Timeout time.Duration
+	// CacheSize and CacheTTL bound the cache of lookup results. A negative
+	// CacheSize disables caching.
+	CacheSize int
+	CacheTTL  time.Duration
+}
+
+// Client looks up geo information from an HTTP GeoIP service. Results,
+// including addresses the service doesn't know, are cached.
+type Client struct {
+	config Config
+	http   *http.Client
+	cache  *cache
+
+	// OnLookupError, if set, is called whenever a lookup fails
+	OnLookupError func(ip string, err error)
+}
+
+// NewClient creates a GeoIP client for the given service
+func NewClient(config Config) *Client {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.CacheSize == 0 {
+		config.CacheSize = DefaultCacheSize
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = DefaultCacheTTL
+	}
+	return &Client{
+		config: config,
+		http:   &http.Client{Timeout: config.Timeout},
+		cache:  newCache(config.CacheSize, config.CacheTTL),
 	}
-	return defaultValue
 }
 
 type GeoInfo struct {
@@ -32,54 +75,56 @@ type GeoInfo struct {
 	Longitude  string `json:"longitude"`
 }
 
-func IPFromRequest(headers []string, r *http.Request) (net.IP, error) {
-	remoteIP := ""
-	for _, h := range headers {
-		remoteIP = r.Header.Get(h)
-		if http.CanonicalHeaderKey(h) == "X-Forwarded-For" {
-			remoteIP = ipFromForwardedForHeader(remoteIP)
-		}
-		if remoteIP != "" {
-			break
-		}
+// GetGeoInfo looks up geo information for ip
+func (c *Client) GetGeoInfo(ip string) (*GeoInfo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("invalid IP %s", ip)
 	}
+	return c.Resolve(context.Background(), parsed)
+}
 
-	if remoteIP == "" {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			return nil, err
-		}
-		remoteIP = host
+// Resolve implements GeoResolver
+func (c *Client) Resolve(ctx context.Context, ip net.IP) (*GeoInfo, error) {
+	key := ip.String()
+	if info, ok := c.cache.get(key); ok {
+		return info, nil
 	}
 
-	ip := net.ParseIP(remoteIP)
-	if ip == nil {
-		return nil, fmt.Errorf("invalid IP %s", remoteIP)
+	info, err := c.lookup(ctx, key)
+	if err != nil {
+		if c.OnLookupError != nil {
+			c.OnLookupError(key, err)
+		}
+		return nil, err
 	}
-	return ip, nil
-}
-
-func ipFromForwardedForHeader(v string) string {
-	sep := strings.Index(v, ",")
-	if sep == -1 {
-		return v
+	if info.CountryISO == "" {
+		info = nil
 	}
-	return v[:sep]
+	c.cache.put(key, info)
+	return info, nil
 }
 
-func GetGeoInfo(ip string) (*GeoInfo, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/json?ip=%s", GEOIP_PROTO, GEOIP_HOST, ip), nil)
+// lookup queries the GeoIP service
+func (c *Client) lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	u := fmt.Sprintf("%s://%s/json?ip=%s", c.config.Proto, c.config.Host, url.QueryEscape(ip))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GeoIP service returned %s", resp.Status)
+	}
 
 	var info GeoInfo
-	err = json.NewDecoder(resp.Body).Decode(&info)
-	return &info, err
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode GeoIP response: %w", err)
+	}
+	return &info, nil
 }
@@ -0,0 +1,178 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testService starts an HTTP GeoIP service that knows 81.2.69.160 and
+// counts its requests
+func testService(t *testing.T, delay time.Duration) (Config, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(delay)
+		info := GeoInfo{IP: r.URL.Query().Get("ip")}
+		switch info.IP {
+		case "81.2.69.160":
+			info.Country, info.CountryISO, info.City = "United Kingdom", "GB", "London"
+		case "192.0.2.1":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	}))
+	t.Cleanup(srv.Close)
+	return Config{Proto: "http", Host: strings.TrimPrefix(srv.URL, "http://")}, &requests
+}
+
+func TestClientResolve(t *testing.T) {
+	config, requests := testService(t, 0)
+	c := NewClient(config)
+	var failures []string
+	c.OnLookupError = func(ip string, err error) { failures = append(failures, ip) }
+
+	info, err := c.Resolve(context.Background(), net.ParseIP("81.2.69.160"))
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	assert.Equal(t, "GB", info.CountryISO)
+
+	// Results and unknown addresses are cached
+	_, err = c.Resolve(context.Background(), net.ParseIP("81.2.69.160"))
+	require.NoError(t, err)
+	info, err = c.Resolve(context.Background(), net.ParseIP("8.8.8.8"))
+	require.NoError(t, err)
+	assert.Nil(t, info)
+	_, err = c.Resolve(context.Background(), net.ParseIP("8.8.8.8"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	// Failures are reported and not cached
+	_, err = c.Resolve(context.Background(), net.ParseIP("192.0.2.1"))
+	assert.ErrorContains(t, err, "503")
+	_, err = c.Resolve(context.Background(), net.ParseIP("192.0.2.1"))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.1"}, failures)
+
+	info, err = c.GetGeoInfo("81.2.69.160")
+	require.NoError(t, err)
+	assert.Equal(t, "London", info.City)
+}
+
+func TestClientWithoutCache(t *testing.T) {
+	config, requests := testService(t, 0)
+	config.CacheSize = -1
+	c := NewClient(config)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Resolve(context.Background(), net.ParseIP("81.2.69.160"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests), "A negative CacheSize disables caching")
+}
+
+func TestClientTimeout(t *testing.T) {
+	config, _ := testService(t, 200*time.Millisecond)
+	config.Timeout = 20 * time.Millisecond
+	c := NewClient(config)
+
+	start := time.Now()
+	_, err := c.Resolve(context.Background(), net.ParseIP("81.2.69.160"))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestCache(t *testing.T) {
+	c := newCache(2, time.Hour)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.put("a", &GeoInfo{CountryISO: "GB"})
+	c.put("b", nil)
+	_, ok := c.get("a")
+	require.True(t, ok)
+	c.put("c", &GeoInfo{CountryISO: "SE"})
+
+	_, ok = c.get("b")
+	assert.False(t, ok, "The least recently used result is evicted")
+	info, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "GB", info.CountryISO)
+
+	now = now.Add(2 * time.Hour)
+	_, ok = c.get("a")
+	assert.False(t, ok, "Results expire")
+
+	var disabled *cache
+	disabled.put("a", &GeoInfo{})
+	_, ok = disabled.get("a")
+	assert.False(t, ok)
+}
+
+// staticResolver returns fixed results
+type staticResolver struct {
+	info *GeoInfo
+	err  error
+}
+
+func (s staticResolver) Resolve(ctx context.Context, ip net.IP) (*GeoInfo, error) {
+	return s.info, s.err
+}
+
+func TestChain(t *testing.T) {
+	ip := net.ParseIP("81.2.69.160")
+	gb := &GeoInfo{CountryISO: "GB"}
+	failed := errors.New("lookup failed")
+
+	info, err := Chain(nil, staticResolver{}, staticResolver{info: gb}).Resolve(context.Background(), ip)
+	require.NoError(t, err)
+	assert.Equal(t, gb, info, "Falls back when the address is unknown")
+
+	info, err = Chain(staticResolver{err: failed}, staticResolver{info: gb}).Resolve(context.Background(), ip)
+	require.NoError(t, err)
+	assert.Equal(t, gb, info, "Falls back on errors")
+
+	_, err = Chain(staticResolver{err: failed}, staticResolver{}).Resolve(context.Background(), ip)
+	assert.ErrorIs(t, err, failed)
+
+	info, err = Chain().Resolve(context.Background(), ip)
+	assert.NoError(t, err)
+	assert.Nil(t, info)
+}
+
+func TestRoutable(t *testing.T) {
+	for ip, expected := range map[string]bool{
+		"81.2.69.160":  true,
+		"2a02:1234::1": true,
+		"10.1.2.3":     false,
+		"192.168.0.1":  false,
+		"127.0.0.1":    false,
+		"::1":          false,
+		"fe80::1":      false,
+		"0.0.0.0":      false,
+		"fd00::1":      false,
+		"169.254.1.1":  false,
+	} {
+		assert.Equal(t, expected, Routable(net.ParseIP(ip)), ip)
+	}
+	assert.False(t, Routable(nil))
+}
+
+func TestCountryName(t *testing.T) {
+	assert.Equal(t, "United Kingdom", CountryName("GB"))
+	assert.Equal(t, "Germany", CountryName("de"))
+	assert.Equal(t, "ZZ", CountryName("ZZ"))
+	assert.Len(t, countryNames, 250)
+}
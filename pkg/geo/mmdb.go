@@ -0,0 +1,191 @@
+package geo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DefaultReloadInterval is how often an MMDB checks its file for changes
+const DefaultReloadInterval = time.Minute
+
+// mmdbRecord holds the fields read from GeoIP2/GeoLite2 and DB-IP City or
+// Country databases. Country databases leave the city, subdivisions and
+// location empty.
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// MMDB resolves addresses from a MaxMind or DB-IP database file. The file is
+// reloaded when its size or modification time changes, so updates such as
+// those by geoipupdate take effect without a restart.
+type MMDB struct {
+	path string
+
+	// ReloadInterval is how often Resolve checks the file for changes
+	ReloadInterval time.Duration
+	// OnLookupError, if set, is called whenever a lookup fails
+	OnLookupError func(ip string, err error)
+
+	mu sync.RWMutex
+	// reader is nil once the MMDB is closed
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+	closed  bool
+
+	// reloadMu is held while checking the file, so that only one lookup
+	// does it while the others use the current reader
+	reloadMu  sync.Mutex
+	checkedAt time.Time
+}
+
+// OpenMMDB opens the database file at path
+func OpenMMDB(path string) (*MMDB, error) {
+	m := &MMDB{path: path, ReloadInterval: DefaultReloadInterval, checkedAt: time.Now()}
+	if _, err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload reopens the database file if it changed since it was last opened
+// and reports whether it did. The current database stays in use if the new
+// file can't be opened.
+func (m *MMDB) Reload() (bool, error) {
+	stat, err := os.Stat(m.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat GeoIP database: %w", err)
+	}
+
+	m.mu.RLock()
+	unchanged := m.closed || (m.reader != nil && stat.ModTime().Equal(m.modTime) && stat.Size() == m.size)
+	m.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	// The file is read into memory rather than mapped, so overwriting it in
+	// place can't corrupt lookups in progress
+	buf, err := os.ReadFile(m.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return false, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		reader.Close()
+		return false, nil
+	}
+	old := m.reader
+	m.reader, m.modTime, m.size = reader, stat.ModTime(), stat.Size()
+	m.mu.Unlock()
+
+	// Lookups hold the read lock, so none can still be using old
+	if old != nil {
+		old.Close()
+	}
+	return true, nil
+}
+
+// Resolve implements GeoResolver
+func (m *MMDB) Resolve(ctx context.Context, ip net.IP) (*GeoInfo, error) {
+	m.maybeReload()
+
+	var record mmdbRecord
+	m.mu.RLock()
+	if m.reader == nil {
+		// Closed while requests were still being served
+		m.mu.RUnlock()
+		return nil, nil
+	}
+	err := m.reader.Lookup(ip, &record)
+	m.mu.RUnlock()
+	if err != nil {
+		err = fmt.Errorf("failed to look up %s: %w", ip, err)
+		if m.OnLookupError != nil {
+			m.OnLookupError(ip.String(), err)
+		}
+		return nil, err
+	}
+	if record.Country.ISOCode == "" {
+		return nil, nil
+	}
+
+	info := &GeoInfo{
+		IP:         ip.String(),
+		Country:    record.Country.Names["en"],
+		CountryISO: record.Country.ISOCode,
+		City:       record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		info.RegionName = record.Subdivisions[0].Names["en"]
+		info.RegionCode = record.Subdivisions[0].ISOCode
+	}
+	if record.Location.Latitude != nil && record.Location.Longitude != nil {
+		info.Latitude = strconv.FormatFloat(*record.Location.Latitude, 'f', -1, 64)
+		info.Longitude = strconv.FormatFloat(*record.Location.Longitude, 'f', -1, 64)
+	}
+	return info, nil
+}
+
+// Close closes the database file. Later lookups find nothing rather than
+// failing, since handlers may outlive a timed out shutdown.
+func (m *MMDB) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	reader := m.reader
+	m.reader = nil
+	return reader.Close()
+}
+
+// maybeReload reloads the file if ReloadInterval has passed since the last
+// check. Failures are logged and keep the current database.
+func (m *MMDB) maybeReload() {
+	if !m.reloadMu.TryLock() {
+		return
+	}
+	defer m.reloadMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.checkedAt) < m.ReloadInterval {
+		return
+	}
+	m.checkedAt = now
+	reloaded, err := m.Reload()
+	if err != nil {
+		log.Printf("Keeping current GeoIP database: %v", err)
+	} else if reloaded {
+		log.Printf("Reloaded GeoIP database %s", m.path)
+	}
+}
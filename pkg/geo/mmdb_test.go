@@ -0,0 +1,290 @@
+package geo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNode is a node of the search tree built by writeTestMMDB
+type testNode struct {
+	child [2]*testNode
+	// data holds offset+1 into the data section for records that end in a
+	// network, or 0
+	data [2]int
+}
+
+// writeTestMMDB writes an IPv4 MMDB with 24-bit records that maps each CIDR
+// network to its record, following the MaxMind DB format specification
+func writeTestMMDB(t *testing.T, path string, networks map[string]map[string]interface{}) {
+	t.Helper()
+
+	root := &testNode{}
+	var data bytes.Buffer
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, _ := ipNet.Mask.Size()
+		require.Positive(t, ones)
+		ip := ipNet.IP.To4()
+		bit := func(i int) int { return int(ip[i/8]>>(7-uint(i%8))) & 1 }
+
+		n := root
+		for i := 0; i < ones-1; i++ {
+			b := bit(i)
+			if n.child[b] == nil {
+				n.child[b] = &testNode{}
+			}
+			n = n.child[b]
+		}
+		n.data[bit(ones-1)] = data.Len() + 1
+		data.Write(encodeMMDB(networks[cidr]))
+	}
+
+	// Number nodes breadth first
+	nodes := []*testNode{root}
+	index := map[*testNode]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if c != nil {
+				index[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+
+	nodeCount := len(nodes)
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for side := 0; side < 2; side++ {
+			record := nodeCount
+			switch {
+			case n.child[side] != nil:
+				record = index[n.child[side]]
+			case n.data[side] != 0:
+				record = nodeCount + 16 + n.data[side] - 1
+			}
+			out.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	out.Write(encodeMMDB(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Nyla-Test-City",
+		"description":                 map[string]interface{}{"en": "Nyla test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	}))
+
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0644))
+}
+
+// encodeMMDB encodes a value in the MaxMind DB data section format
+func encodeMMDB(v interface{}) []byte {
+	var b bytes.Buffer
+	control := func(typ, size int) {
+		first, extra := size, []byte(nil)
+		if size >= 29 {
+			first, extra = 29, []byte{byte(size - 29)}
+		}
+		if typ <= 7 {
+			b.WriteByte(byte(typ<<5 | first))
+		} else {
+			b.WriteByte(byte(first))
+			b.WriteByte(byte(typ - 7))
+		}
+		b.Write(extra)
+	}
+	putUint := func(typ int, n uint64, width int) {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, n)
+		buf = bytes.TrimLeft(buf[8-width:], "\x00")
+		control(typ, len(buf))
+		b.Write(buf)
+	}
+
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		b.WriteString(v)
+	case float64:
+		control(3, 8)
+		binary.Write(&b, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		putUint(5, uint64(v), 2)
+	case uint32:
+		putUint(6, uint64(v), 4)
+	case uint64:
+		putUint(9, v, 8)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		control(7, len(keys))
+		for _, k := range keys {
+			b.Write(encodeMMDB(k))
+			b.Write(encodeMMDB(v[k]))
+		}
+	case []interface{}:
+		control(11, len(v))
+		for _, e := range v {
+			b.Write(encodeMMDB(e))
+		}
+	default:
+		panic("unsupported MMDB value")
+	}
+	return b.Bytes()
+}
+
+// cityRecord builds a City database record
+func cityRecord(iso, country, regionISO, region, city string, lat, lon float64) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code": iso,
+			"names":    map[string]interface{}{"en": country},
+		},
+		"subdivisions": []interface{}{map[string]interface{}{
+			"iso_code": regionISO,
+			"names":    map[string]interface{}{"en": region},
+		}},
+		"city":     map[string]interface{}{"names": map[string]interface{}{"en": city}},
+		"location": map[string]interface{}{"latitude": lat, "longitude": lon},
+	}
+}
+
+func TestMMDBResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeTestMMDB(t, path, map[string]map[string]interface{}{
+		"81.2.69.0/24":   cityRecord("GB", "United Kingdom", "ENG", "England", "London", 51.5142, -0.0931),
+		"89.160.20.0/24": {"country": map[string]interface{}{"iso_code": "SE"}},
+	})
+
+	m, err := OpenMMDB(path)
+	require.NoError(t, err)
+	defer m.Close()
+
+	info, err := m.Resolve(context.Background(), net.ParseIP("81.2.69.160"))
+	require.NoError(t, err)
+	assert.Equal(t, &GeoInfo{
+		IP:         "81.2.69.160",
+		Country:    "United Kingdom",
+		CountryISO: "GB",
+		RegionName: "England",
+		RegionCode: "ENG",
+		City:       "London",
+		Latitude:   "51.5142",
+		Longitude:  "-0.0931",
+	}, info)
+
+	t.Run("Country only", func(t *testing.T) {
+		info, err := m.Resolve(context.Background(), net.ParseIP("89.160.20.1"))
+		require.NoError(t, err)
+		require.NotNil(t, info)
+		assert.Equal(t, "SE", info.CountryISO)
+		assert.Empty(t, info.City)
+		assert.Empty(t, info.Latitude)
+	})
+
+	t.Run("Unknown address", func(t *testing.T) {
+		info, err := m.Resolve(context.Background(), net.ParseIP("8.8.8.8"))
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+}
+
+func TestMMDBReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "city.mmdb")
+	writeTestMMDB(t, path, map[string]map[string]interface{}{
+		"81.2.69.0/24": cityRecord("GB", "United Kingdom", "ENG", "England", "London", 51.5, -0.09),
+	})
+
+	m, err := OpenMMDB(path)
+	require.NoError(t, err)
+	defer m.Close()
+	m.ReloadInterval = 0
+
+	// Replace the file as geoipupdate does, with a new modification time
+	next := filepath.Join(dir, "city.mmdb.tmp")
+	writeTestMMDB(t, next, map[string]map[string]interface{}{
+		"81.2.69.0/24": cityRecord("GB", "United Kingdom", "SCT", "Scotland", "Edinburgh", 55.9, -3.2),
+	})
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(next, later, later))
+	require.NoError(t, os.Rename(next, path))
+
+	info, err := m.Resolve(context.Background(), net.ParseIP("81.2.69.1"))
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	assert.Equal(t, "Edinburgh", info.City, "Lookups use the replaced file")
+
+	reloaded, err := m.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "An unchanged file isn't reopened")
+
+	// A broken replacement keeps the current database
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0644))
+	_, err = m.Reload()
+	assert.Error(t, err)
+	info, err = m.Resolve(context.Background(), net.ParseIP("81.2.69.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "Edinburgh", info.City)
+}
+
+func TestMMDBClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeTestMMDB(t, path, map[string]map[string]interface{}{
+		"81.2.69.0/24": cityRecord("GB", "United Kingdom", "ENG", "England", "London", 51.5, -0.09),
+	})
+
+	m, err := OpenMMDB(path)
+	require.NoError(t, err)
+	m.ReloadInterval = 0
+	var failures int
+	m.OnLookupError = func(ip string, err error) { failures++ }
+
+	require.NoError(t, m.Close())
+	assert.NoError(t, m.Close(), "Closing twice is harmless")
+
+	info, err := m.Resolve(context.Background(), net.ParseIP("81.2.69.1"))
+	assert.NoError(t, err)
+	assert.Nil(t, info, "Lookups after Close find nothing")
+	assert.Zero(t, failures)
+
+	reloaded, err := m.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "A closed database isn't reopened")
+}
+
+func TestOpenMMDBErrors(t *testing.T) {
+	_, err := OpenMMDB(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0644))
+	_, err = OpenMMDB(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package geo
+
+import (
+	"context"
+	"net"
+)
+
+// GeoResolver looks up the location of an IP address. Resolve returns a nil
+// GeoInfo without an error when the address has no known location.
+type GeoResolver interface {
+	Resolve(ctx context.Context, ip net.IP) (*GeoInfo, error)
+}
+
+// Chain returns a resolver that tries each resolver in turn until one finds
+// a location. Nil resolvers are skipped. It returns the last error if none
+// found one.
+func Chain(resolvers ...GeoResolver) GeoResolver {
+	var chain chain
+	for _, r := range resolvers {
+		if r != nil {
+			chain = append(chain, r)
+		}
+	}
+	return chain
+}
+
+// chain is the GeoResolver returned by Chain
+type chain []GeoResolver
+
+// Resolve implements GeoResolver
+func (c chain) Resolve(ctx context.Context, ip net.IP) (*GeoInfo, error) {
+	var lastErr error
+	for _, r := range c {
+		info, err := r.Resolve(ctx, ip)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if info != nil {
+			return info, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// Routable reports whether ip is a public address worth resolving. Private,
+// loopback, link-local and unspecified addresses have no location.
+func Routable(ip net.IP) bool {
+	return ip != nil && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mileusna/useragent"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/bots"
+)
+
+// BotStatsResponse is the JSON form of GET /api/v1/stats/bots
+type BotStatsResponse struct {
+	From   time.Time          `json:"from"`
+	To     time.Time          `json:"to"`
+	Values []storage.BotStats `json:"values"`
+}
+
+// filterBot classifies the request and reports whether its events are bot
+// hits. Bot hits never reach sessions or events; in store mode they are kept
+// in bot_hits instead. The raw client IP is only compared against the bot
+// IP ranges and is not stored.
+func (h *Handlers) filterBot(r *http.Request, ua useragent.UserAgent, events []*storage.Event) (bool, error) {
+	if h.Bots == nil || h.BotMode == bots.ModeOff || len(events) == 0 {
+		return false, nil
+	}
+
+	ip, _ := h.requestIP(r)
+	verdict := h.Bots.Check(ua, r.Header.Get("Accept-Language"), ip)
+	if !verdict.IsBot() {
+		return false, nil
+	}
+	if h.OnBot != nil {
+		h.OnBot(verdict.Reason)
+	}
+	if h.BotMode != bots.ModeStore {
+		return true, nil
+	}
+
+	hits := make([]*storage.BotHit, 0, len(events))
+	for _, e := range events {
+		hits = append(hits, &storage.BotHit{
+			Timestamp: e.Timestamp,
+			Type:      e.Type,
+			URL:       e.URL,
+			Name:      verdict.Name,
+			Reason:    verdict.Reason,
+		})
+	}
+	return true, h.DB.InsertBotHits(r.Context(), hits)
+}
+
+// GetStatsBotsV1 lists the bots seen in the from/to range. Hits are only
+// kept when bots.mode is store.
+func (h *Handlers) GetStatsBotsV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetBotStats(r.Context(), from, to, limit)
+	if err != nil {
+		log.Printf("Error getting bot stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load bot stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, BotStatsResponse{From: from, To: to, Values: stats})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		name := s.Name
+		if name == "" {
+			name = "Unknown"
+		}
+		rows = append(rows, []string{
+			name,
+			s.Reason,
+			formatNumber(s.Hits),
+			formatNumber(s.Pages),
+			s.LastSeen.UTC().Format("2006-01-02 15:04"),
+		})
+	}
+	headers := []string{"Bot", "Reason", "Hits", "Pages", "Last Seen"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable(headers, rows, "No bot hits. Set bots.mode to store to keep them.").Render()))
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/bots"
+)
+
+const googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+func TestCollectFiltersBots(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	ranges, err := bots.ParseRanges([]string{"203.0.113.0/24"})
+	require.NoError(t, err)
+	handlers.Bots = bots.NewDetector(ranges)
+	handlers.BotMode = bots.ModeStore
+	var reasons []string
+	handlers.OnBot = func(reason string) { reasons = append(reasons, reason) }
+
+	pixel := func(userAgent, acceptLanguage, forwardedFor string) {
+		q := url.Values{"url": {"https://example.com/"}}
+		req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+		req.Header.Set("User-Agent", userAgent)
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handlers.GetCollectV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, "Bots still get the pixel")
+	}
+
+	pixel(chromeUA, "en-GB,en;q=0.9", "198.51.100.7")
+	pixel(googlebotUA, "", "")
+	pixel(chromeUA, "en", "203.0.113.9")
+	pixel(chromeUA, "", "")
+
+	body, err := json.Marshal(CollectBatchRequest{Events: []CollectEvent{
+		{Type: "pageview", URL: "https://example.com/"},
+		{Type: "pageview", URL: "https://example.com/pricing"},
+	}})
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/collect", bytes.NewReader(body))
+	req.Header.Set("User-Agent", googlebotUA)
+	rec := httptest.NewRecorder()
+	handlers.PostCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, []string{bots.ReasonUserAgent, bots.ReasonIPRange, bots.ReasonNoAcceptLanguage, bots.ReasonUserAgent}, reasons)
+
+	realtime, err := db.GetRealtimeStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, realtime.PageviewsToday, "Only the human hit is an event")
+	assert.Equal(t, 1, realtime.TotalSessions, "Bots never start sessions")
+
+	to := "to=" + time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	t.Run("JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/bots?"+to, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handlers.GetStatsBotsV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp BotStatsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Values, 3, "Googlebot, the datacenter hit and the scripted browser")
+		assert.Equal(t, "Googlebot", resp.Values[0].Name)
+		assert.Equal(t, bots.ReasonUserAgent, resp.Values[0].Reason)
+		assert.Equal(t, 3, resp.Values[0].Hits)
+		assert.Equal(t, 2, resp.Values[0].Pages)
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/bots?"+to, nil)
+		rec := httptest.NewRecorder()
+		handlers.GetStatsBotsV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Googlebot")
+		assert.Contains(t, rec.Body.String(), "Last Seen")
+	})
+}
+
+func TestCollectDropsBots(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	handlers.Bots = bots.NewDetector(nil)
+	handlers.BotMode = bots.ModeDrop
+
+	req := httptest.NewRequest("GET", "/api/v1/collect?url=https://example.com/", nil)
+	req.Header.Set("User-Agent", googlebotUA)
+	rec := httptest.NewRecorder()
+	handlers.GetCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	realtime, err := db.GetRealtimeStats(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, realtime.PageviewsToday)
+
+	now := time.Now()
+	botStats, err := db.GetBotStats(context.Background(), now.Add(-time.Hour), now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, botStats, "Dropped bots leave no trace")
+
+	// The report explains how to keep bot hits
+	req = httptest.NewRequest("GET", "/api/v1/stats/bots", nil)
+	rec = httptest.NewRecorder()
+	handlers.GetStatsBotsV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "bots.mode")
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+const (
+	// maxTimestampPast is how far before server time a client timestamp may be.
+	// It covers trackers that buffer events while offline.
+	maxTimestampPast = 24 * time.Hour
+	// maxTimestampFuture tolerates small client clock skew
+	maxTimestampFuture = 5 * time.Minute
+	// maxMetadataKeys caps the number of custom metadata entries per event
+	maxMetadataKeys = 32
+	// maxMetadataParamBytes caps the size of the GET metadata parameter
+	maxMetadataParamBytes = 4096
+	// maxEventNameLength caps the length of custom event names
+	maxEventNameLength = 128
+	// maxPropertyValueLength caps the length of string property values
+	maxPropertyValueLength = 512
+)
+
+// parseClientTimestamp parses an ISO8601 timestamp sent by a client and
+// rejects values outside the accepted window around now
+func parseClientTimestamp(value string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid datetime format")
+	}
+	if t.Before(now.Add(-maxTimestampPast)) {
+		return time.Time{}, fmt.Errorf("timestamp is more than %s in the past", maxTimestampPast)
+	}
+	if t.After(now.Add(maxTimestampFuture)) {
+		return time.Time{}, fmt.Errorf("timestamp is more than %s in the future", maxTimestampFuture)
+	}
+	return t, nil
+}
+
+// parseMetadataParam decodes the GET metadata parameter, which is either
+// base64-encoded JSON or URL-encoded key/value pairs
+func parseMetadataParam(raw string) (map[string]interface{}, error) {
+	if len(raw) > maxMetadataParamBytes {
+		return nil, fmt.Errorf("metadata exceeds %d bytes", maxMetadataParamBytes)
+	}
+
+	// Trackers may use either base64 alphabet, with or without padding
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		decoded, err := enc.DecodeString(raw)
+		if err != nil {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(decoded, &m); err == nil {
+			return limitMetadata(m)
+		}
+	}
+
+	if !strings.Contains(raw, "=") {
+		return nil, fmt.Errorf("metadata is neither base64 JSON nor key/value pairs")
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key/value metadata: %w", err)
+	}
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if k == "" || len(v) == 0 {
+			continue
+		}
+		m[k] = v[0]
+	}
+	return limitMetadata(m)
+}
+
+// limitMetadata rejects metadata maps with too many keys
+func limitMetadata(m map[string]interface{}) (map[string]interface{}, error) {
+	if len(m) > maxMetadataKeys {
+		return nil, fmt.Errorf("metadata has more than %d keys", maxMetadataKeys)
+	}
+	return m, nil
+}
+
+// mergeMetadata combines client-supplied metadata with server-derived values.
+// Server values win so clients can't overwrite fields such as hostname.
+func mergeMetadata(custom, server map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(custom)+len(server))
+	for k, v := range custom {
+		merged[k] = v
+	}
+	for k, v := range server {
+		merged[k] = v
+	}
+	return merged
+}
+
+// normalizeEventType maps the type and name sent by a client to the stored
+// event type and name. "ping" is the tracker's heartbeat and is recorded as
+// engagement. Any type other than pageview, engagement or event is a custom
+// event named after the type, as sent by earlier trackers.
+func normalizeEventType(eventType, name string) (string, string) {
+	switch eventType {
+	case "ping":
+		return storage.EventTypeEngagement, ""
+	case storage.EventTypePageview, storage.EventTypeEngagement:
+		return eventType, ""
+	case storage.EventTypeCustom:
+		return eventType, name
+	}
+	if name == "" {
+		name = eventType
+	}
+	return storage.EventTypeCustom, name
+}
+
+// validateEventName checks the name of a custom event
+func validateEventName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name is required for custom events")
+	}
+	if len(name) > maxEventNameLength {
+		return fmt.Errorf("name exceeds %d characters", maxEventNameLength)
+	}
+	return nil
+}
+
+// validateProperties checks custom event properties. Values must be strings,
+// numbers or booleans so they can be compared and grouped in queries.
+func validateProperties(props map[string]interface{}) error {
+	if len(props) > maxMetadataKeys {
+		return fmt.Errorf("properties has more than %d keys", maxMetadataKeys)
+	}
+	for key, value := range props {
+		if _, err := storage.PropertyPath(key); err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case string:
+			if len(v) > maxPropertyValueLength {
+				return fmt.Errorf("property %q exceeds %d characters", key, maxPropertyValueLength)
+			}
+		case float64, bool:
+		default:
+			return fmt.Errorf("property %q must be a string, number or boolean", key)
+		}
+	}
+	return nil
+}
+
+// validateCollectEvent checks a single batch event and converts it to a
+// storage event. The returned error has no index set.
+func validateCollectEvent(ce CollectEvent, now time.Time) (*storage.Event, *CollectEventError) {
+	if ce.Type == "" {
+		return nil, &CollectEventError{Field: "type", Reason: "type is required"}
+	}
+
+	timestamp := now
+	if ce.Timestamp != "" {
+		t, err := parseClientTimestamp(ce.Timestamp, now)
+		if err != nil {
+			return nil, &CollectEventError{Field: "timestamp", Reason: err.Error()}
+		}
+		timestamp = t
+	}
+
+	if len(ce.Metadata) > maxMetadataKeys {
+		return nil, &CollectEventError{
+			Field:  "metadata",
+			Reason: fmt.Sprintf("metadata has more than %d keys", maxMetadataKeys),
+		}
+	}
+
+	eventType, name := normalizeEventType(ce.Type, ce.Name)
+	if eventType == storage.EventTypeCustom {
+		if err := validateEventName(name); err != nil {
+			return nil, &CollectEventError{Field: "name", Reason: err.Error()}
+		}
+		if err := validateProperties(ce.Props); err != nil {
+			return nil, &CollectEventError{Field: "props", Reason: err.Error()}
+		}
+	}
+
+	event := &storage.Event{
+		Type:      eventType,
+		Timestamp: timestamp,
+		URL:       ce.URL,
+		Title:     ce.Title,
+		Referrer:  ce.Referrer,
+		Name:      name,
+	}
+	if eventType == storage.EventTypeCustom {
+		event.Properties = ce.Props
+	}
+	return event, nil
+}
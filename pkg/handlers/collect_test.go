@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientTimestamp(t *testing.T) {
+	now := time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		value       string
+		expected    time.Time
+		shouldError bool
+	}{
+		{"Exact server time", "2024-03-14T15:00:00Z", now, false},
+		{"Recent past", "2024-03-14T14:09:26Z", time.Date(2024, 3, 14, 14, 9, 26, 0, time.UTC), false},
+		{"Small clock skew", "2024-03-14T15:03:00Z", time.Date(2024, 3, 14, 15, 3, 0, 0, time.UTC), false},
+		{"Offset timezone", "2024-03-14T16:00:00+01:00", now, false},
+		{"Backdated", "2024-03-01T15:00:00Z", time.Time{}, true},
+		{"Future dated", "2024-03-14T16:00:00Z", time.Time{}, true},
+		{"Not a timestamp", "yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClientTimestamp(tt.value, now)
+			if tt.shouldError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(got), "expected %s, got %s", tt.expected, got)
+		})
+	}
+}
+
+func TestParseMetadataParam(t *testing.T) {
+	jsonMeta := `{"plan":"pro","seats":3}`
+
+	tests := []struct {
+		name        string
+		raw         string
+		expected    map[string]interface{}
+		shouldError bool
+	}{
+		{
+			name:     "Base64 JSON",
+			raw:      base64.StdEncoding.EncodeToString([]byte(jsonMeta)),
+			expected: map[string]interface{}{"plan": "pro", "seats": float64(3)},
+		},
+		{
+			name:     "URL-safe base64 JSON without padding",
+			raw:      base64.RawURLEncoding.EncodeToString([]byte(jsonMeta)),
+			expected: map[string]interface{}{"plan": "pro", "seats": float64(3)},
+		},
+		{
+			name:     "Key/value pairs",
+			raw:      "plan=pro&variant=b",
+			expected: map[string]interface{}{"plan": "pro", "variant": "b"},
+		},
+		{
+			name:        "Garbage",
+			raw:         "not metadata",
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMetadataParam(tt.raw)
+			if tt.shouldError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestMergeMetadataServerWins(t *testing.T) {
+	merged := mergeMetadata(
+		map[string]interface{}{"is_bot": false, "plan": "pro"},
+		map[string]interface{}{"is_bot": true},
+	)
+
+	assert.Equal(t, true, merged["is_bot"], "Clients must not override server-derived fields")
+	assert.Equal(t, "pro", merged["plan"])
+}
+
+func TestValidateCollectEventNormalizesPing(t *testing.T) {
+	event, cerr := validateCollectEvent(CollectEvent{Type: "ping", URL: "https://example.com/"}, time.Now())
+	require.Nil(t, cerr)
+	assert.Equal(t, "engagement", event.Type)
+
+}
+
+func TestValidateCollectEventCustom(t *testing.T) {
+	tests := []struct {
+		name          string
+		event         CollectEvent
+		expectedName  string
+		expectedField string
+	}{
+		{
+			name:         "Named event with properties",
+			event:        CollectEvent{Type: "event", Name: "Signup", Props: map[string]interface{}{"plan": "pro", "seats": float64(3), "trial": true}},
+			expectedName: "Signup",
+		},
+		{
+			name:         "Legacy type becomes the name",
+			event:        CollectEvent{Type: "signup"},
+			expectedName: "signup",
+		},
+		{
+			name:          "Missing name",
+			event:         CollectEvent{Type: "event"},
+			expectedField: "name",
+		},
+		{
+			name:          "Nested property",
+			event:         CollectEvent{Type: "event", Name: "Signup", Props: map[string]interface{}{"plan": map[string]interface{}{"id": 1}}},
+			expectedField: "props",
+		},
+		{
+			name:          "Quoted property name",
+			event:         CollectEvent{Type: "event", Name: "Signup", Props: map[string]interface{}{`a"b`: "x"}},
+			expectedField: "props",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, cerr := validateCollectEvent(tt.event, time.Now())
+			if tt.expectedField != "" {
+				require.NotNil(t, cerr)
+				assert.Equal(t, tt.expectedField, cerr.Field)
+				return
+			}
+			require.Nil(t, cerr)
+			assert.Equal(t, "event", event.Type)
+			assert.Equal(t, tt.expectedName, event.Name)
+			assert.Equal(t, tt.event.Props, event.Properties)
+		})
+	}
+}
+
+func TestValidateCollectEventPageviewDropsProps(t *testing.T) {
+	event, cerr := validateCollectEvent(CollectEvent{Type: "pageview", Name: "x", Props: map[string]interface{}{"a": "b"}}, time.Now())
+	require.Nil(t, cerr)
+	assert.Empty(t, event.Name, "Only custom events carry a name")
+	assert.Nil(t, event.Properties, "Only custom events carry properties")
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/privacy"
+)
+
+const (
+	// consentPolicySetting is the site_config.settings key holding the
+	// consent policy
+	consentPolicySetting = "consent_policy"
+	// consentPolicyTTL is how long the consent policy read from site_config
+	// is reused before it is read again
+	consentPolicyTTL = time.Minute
+)
+
+// consentCache holds the consent policy last read from site_config
+type consentCache struct {
+	mu       sync.Mutex
+	policy   privacy.ConsentPolicy
+	loadedAt time.Time
+}
+
+// PrivacyStatsResponse is the JSON form of GET /api/v1/stats/privacy
+type PrivacyStatsResponse struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	storage.ConsentStats
+	// Share is the fraction of hits sent with an opt-out signal
+	Share float64 `json:"share"`
+}
+
+// consentPolicy returns the consent policy stored in site_config.settings,
+// falling back to DefaultConsent when it is unset or can't be read
+func (h *Handlers) consentPolicy(ctx context.Context) privacy.ConsentPolicy {
+	h.consent.mu.Lock()
+	defer h.consent.mu.Unlock()
+	if h.consent.policy != "" && time.Since(h.consent.loadedAt) < consentPolicyTTL {
+		return h.consent.policy
+	}
+
+	policy := h.DefaultConsent
+	if policy == "" {
+		policy = privacy.ConsentDrop
+	}
+	settings, err := h.DB.GetSiteSettings(ctx)
+	if err != nil {
+		log.Printf("Using default consent policy: %v", err)
+	} else if v, ok := settings[consentPolicySetting].(string); ok {
+		if p, err := privacy.ParseConsentPolicy(v); err == nil {
+			policy = p
+		} else {
+			log.Printf("Using default consent policy: %v", err)
+		}
+	}
+
+	h.consent.policy = policy
+	h.consent.loadedAt = time.Now()
+	return policy
+}
+
+// applyConsent applies the consent policy to events sent with an opt-out
+// signal and returns the action taken, or an empty string when there is no
+// signal. Opted-out hits are only counted per day; anonymized events lose
+// their visitor hash, and with it their session, and the raw user agent.
+func (h *Handlers) applyConsent(r *http.Request, events []*storage.Event) (string, error) {
+	signal := privacy.OptOutSignal(r.Header)
+	if signal == "" {
+		return "", nil
+	}
+
+	var action string
+	switch h.consentPolicy(r.Context()) {
+	case privacy.ConsentIgnore:
+		action = storage.ConsentActionIgnored
+	case privacy.ConsentAnonymize:
+		action = storage.ConsentActionAnonymized
+		for _, e := range events {
+			e.VisitorID = ""
+			delete(e.Metadata, "user_agent")
+		}
+	default:
+		action = storage.ConsentActionDropped
+	}
+
+	// Pings never count as hits
+	hits := 0
+	for _, e := range events {
+		if e.Type != storage.EventTypeEngagement {
+			hits++
+		}
+	}
+	if hits > 0 {
+		if err := h.DB.CountConsent(r.Context(), time.Now(), signal, action, hits); err != nil {
+			return "", err
+		}
+	}
+	return action, nil
+}
+
+// record applies the consent policy to events, locates them and stores them
+// with their sessions
+func (h *Handlers) record(r *http.Request, events []*storage.Event) error {
+	action, err := h.applyConsent(r, events)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case storage.ConsentActionDropped:
+		return nil
+	case storage.ConsentActionAnonymized:
+		// Not even the anonymized IP is used for opted-out hits
+	default:
+		h.locate(r, events)
+	}
+	return h.Sessions.Record(r.Context(), events)
+}
+
+// GetStatsPrivacyV1 reports the share of hits in the from/to range sent with
+// Do Not Track or Global Privacy Control, over whole UTC days
+func (h *Handlers) GetStatsPrivacyV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetConsentStats(r.Context(), from, to)
+	if err != nil {
+		log.Printf("Error getting consent stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load privacy stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, PrivacyStatsResponse{From: from, To: to, ConsentStats: *stats, Share: stats.Share()})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats.Counts))
+	for _, c := range stats.Counts {
+		rows = append(rows, []string{strings.ToUpper(c.Signal), c.Action, formatNumber(c.Hits)})
+	}
+	headers := []string{"Signal", "Action", "Hits"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fragment := elem.Div(nil,
+		elem.P(attrs.Props{"class": "text-sm text-gray-500 mb-2"},
+			elem.Text(formatPercent(stats.Share())+" of "+formatNumber(stats.Hits)+" hits opted out"),
+		),
+		breakdownTable(headers, rows, "No hits with Do Not Track or Global Privacy Control"),
+	)
+	w.Write([]byte(fragment.Render()))
+}
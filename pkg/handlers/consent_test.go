@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/geo"
+	"github.com/sunwolfengineering/nyla-core/pkg/privacy"
+)
+
+func TestCollectConsentPolicy(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	resolver := &stubResolver{locations: map[string]*geo.GeoInfo{
+		"81.2.69.0": {CountryISO: "GB", Country: "United Kingdom"},
+	}}
+	handlers.Geo = resolver
+	ctx := context.Background()
+
+	setPolicy := func(policy privacy.ConsentPolicy) {
+		require.NoError(t, db.SetSiteSetting(ctx, consentPolicySetting, policy))
+		handlers.consent = consentCache{}
+	}
+	pixel := func(header, value string) {
+		q := url.Values{"url": {"https://example.com/"}}
+		req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+		req.Header.Set("User-Agent", chromeUA)
+		req.Header.Set("Accept-Language", "en")
+		req.Header.Set("X-Forwarded-For", "81.2.69.160")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		handlers.GetCollectV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	realtime := func() *storage.RealtimeStats {
+		stats, err := db.GetRealtimeStats(ctx)
+		require.NoError(t, err)
+		return stats
+	}
+
+	// Without a stored policy, opted-out hits are dropped
+	pixel("DNT", "1")
+	pixel("Sec-GPC", "1")
+	assert.Equal(t, 0, realtime().PageviewsToday)
+	assert.Empty(t, resolver.lookups, "Dropped hits aren't located")
+
+	// Anonymized hits are stored without a session or location
+	setPolicy(privacy.ConsentAnonymize)
+	body, err := json.Marshal(CollectBatchRequest{Events: []CollectEvent{
+		{Type: "pageview", URL: "https://example.com/"},
+		{Type: "pageview", URL: "https://example.com/pricing"},
+		{Type: "ping", URL: "https://example.com/pricing"},
+	}})
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/collect", bytes.NewReader(body))
+	req.Header.Set("User-Agent", chromeUA)
+	req.Header.Set("Accept-Language", "en")
+	req.Header.Set("Sec-GPC", "1")
+	rec := httptest.NewRecorder()
+	handlers.PostCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	stats := realtime()
+	assert.Equal(t, 2, stats.PageviewsToday)
+	assert.Equal(t, 0, stats.TotalSessions)
+	assert.Empty(t, resolver.lookups)
+
+	// Ignored signals are recorded like any other hit
+	setPolicy(privacy.ConsentIgnore)
+	pixel("DNT", "1")
+	pixel("", "")
+	stats = realtime()
+	assert.Equal(t, 4, stats.PageviewsToday)
+	assert.Equal(t, 1, stats.TotalSessions)
+	assert.Equal(t, []string{"81.2.69.0", "81.2.69.0"}, resolver.lookups)
+
+	to := "to=" + time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	t.Run("JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/privacy?"+to, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handlers.GetStatsPrivacyV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp PrivacyStatsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, 6, resp.Hits, "Four stored pageviews and two dropped hits")
+		assert.Equal(t, 5, resp.OptedOut)
+		assert.InDelta(t, 5.0/6.0, resp.Share, 0.0001)
+		assert.ElementsMatch(t, []storage.ConsentCount{
+			{Signal: privacy.SignalGPC, Action: storage.ConsentActionAnonymized, Hits: 2},
+			{Signal: privacy.SignalDNT, Action: storage.ConsentActionDropped, Hits: 1},
+			{Signal: privacy.SignalGPC, Action: storage.ConsentActionDropped, Hits: 1},
+			{Signal: privacy.SignalDNT, Action: storage.ConsentActionIgnored, Hits: 1},
+		}, resp.Counts)
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/privacy?"+to, nil)
+		rec := httptest.NewRecorder()
+		handlers.GetStatsPrivacyV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "opted out")
+		assert.Contains(t, rec.Body.String(), "anonymized")
+	})
+}
+
+func TestConsentPolicyFallback(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	assert.Equal(t, privacy.ConsentDrop, handlers.consentPolicy(ctx))
+
+	handlers.consent = consentCache{}
+	handlers.DefaultConsent = privacy.ConsentIgnore
+	assert.Equal(t, privacy.ConsentIgnore, handlers.consentPolicy(ctx))
+
+	require.NoError(t, db.SetSiteSetting(ctx, consentPolicySetting, "hash"))
+	handlers.consent = consentCache{}
+	assert.Equal(t, privacy.ConsentIgnore, handlers.consentPolicy(ctx), "Invalid settings use the default")
+
+	require.NoError(t, db.SetSiteSetting(ctx, consentPolicySetting, privacy.ConsentAnonymize))
+	assert.Equal(t, privacy.ConsentIgnore, handlers.consentPolicy(ctx), "The policy is cached")
+	handlers.consent = consentCache{}
+	assert.Equal(t, privacy.ConsentAnonymize, handlers.consentPolicy(ctx))
+}
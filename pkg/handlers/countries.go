@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/geo"
+)
+
+// maxLocationLength caps the length of stored region and city names
+const maxLocationLength = 128
+
+// CountryStatsResponse is the JSON form of GET /api/v1/stats/countries
+type CountryStatsResponse struct {
+	From   time.Time              `json:"from"`
+	To     time.Time              `json:"to"`
+	Values []storage.CountryStats `json:"values"`
+}
+
+// locate sets the country, region and city of events from the anonymized
+// client IP. Only the location is stored, never the IP. Failed lookups
+// leave the location empty rather than failing the request.
+func (h *Handlers) locate(r *http.Request, events []*storage.Event) {
+	if h.Geo == nil {
+		return
+	}
+	ip := h.anonymizedIP(r)
+	if !geo.Routable(ip) {
+		return
+	}
+
+	info, err := h.Geo.Resolve(r.Context(), ip)
+	if err != nil {
+		log.Printf("GeoIP lookup failed: %v", err)
+		return
+	}
+	if info == nil || len(info.CountryISO) != 2 {
+		return
+	}
+	for _, e := range events {
+		e.Country = strings.ToUpper(info.CountryISO)
+		e.Region = truncate(info.RegionName, maxLocationLength)
+		e.City = truncate(info.City, maxLocationLength)
+	}
+}
+
+// truncate caps s at n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
+
+// GetStatsCountriesV1 breaks down pageviews in the from/to range by country
+func (h *Handlers) GetStatsCountriesV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetCountryStats(r.Context(), from, to, limit)
+	if err != nil {
+		log.Printf("Error getting country stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load country stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, CountryStatsResponse{From: from, To: to, Values: stats})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		rows = append(rows, []string{geo.CountryName(s.Country), formatNumber(s.Visitors), formatNumber(s.Pageviews)})
+	}
+	headers := []string{"Country", "Visitors", "Pageviews"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable(headers, rows, "No pageviews with a known location").Render()))
+}
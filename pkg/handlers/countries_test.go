@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/geo"
+)
+
+// stubResolver locates addresses from a map and records the lookups
+type stubResolver struct {
+	locations map[string]*geo.GeoInfo
+	lookups   []string
+}
+
+func (s *stubResolver) Resolve(ctx context.Context, ip net.IP) (*geo.GeoInfo, error) {
+	s.lookups = append(s.lookups, ip.String())
+	return s.locations[ip.String()], nil
+}
+
+func TestCollectLocatesClients(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	resolver := &stubResolver{locations: map[string]*geo.GeoInfo{
+		"81.2.69.0":   {CountryISO: "GB", Country: "United Kingdom", RegionName: "England", City: "London"},
+		"89.160.20.0": {CountryISO: "se", Country: "Sweden"},
+	}}
+	handlers.Geo = resolver
+
+	pixel := func(forwardedFor string) {
+		q := url.Values{"url": {"https://example.com/"}}
+		req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+		req.Header.Set("User-Agent", chromeUA)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		handlers.GetCollectV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	pixel("81.2.69.160")
+	pixel("10.0.0.7")
+
+	body, err := json.Marshal(CollectBatchRequest{Events: []CollectEvent{
+		{Type: "pageview", URL: "https://example.com/"},
+		{Type: "pageview", URL: "https://example.com/pricing"},
+	}})
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/collect", bytes.NewReader(body))
+	req.Header.Set("User-Agent", iPhoneUA)
+	req.Header.Set("X-Forwarded-For", "89.160.20.112")
+	rec := httptest.NewRecorder()
+	handlers.PostCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, []string{"81.2.69.0", "89.160.20.0"}, resolver.lookups,
+		"Only anonymized public addresses are resolved, once per request")
+
+	to := "to=" + time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	t.Run("JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/countries?"+to, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handlers.GetStatsCountriesV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp CountryStatsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Values, 2)
+		assert.Equal(t, "GB", resp.Values[0].Country)
+		assert.Equal(t, 1, resp.Values[0].Pageviews)
+		assert.Equal(t, "SE", resp.Values[1].Country)
+		assert.Equal(t, 2, resp.Values[1].Pageviews)
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/countries?"+to, nil)
+		rec := httptest.NewRecorder()
+		handlers.GetStatsCountriesV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "United Kingdom")
+		assert.Contains(t, rec.Body.String(), "Sweden")
+	})
+}
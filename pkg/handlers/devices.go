@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// deviceHeadings label the value column of each device breakdown
+var deviceHeadings = map[string]string{
+	storage.DeviceDimensionType:           "Device",
+	storage.DeviceDimensionBrowser:        "Browser",
+	storage.DeviceDimensionBrowserVersion: "Browser Version",
+	storage.DeviceDimensionOS:             "Operating System",
+	storage.DeviceDimensionOSVersion:      "OS Version",
+	storage.DeviceDimensionScreen:         "Screen Size",
+}
+
+// DeviceStatsResponse is the JSON form of GET /api/v1/stats/devices/{dimension}
+type DeviceStatsResponse struct {
+	From      time.Time             `json:"from"`
+	To        time.Time             `json:"to"`
+	Dimension string                `json:"dimension"`
+	Values    []storage.DeviceStats `json:"values"`
+}
+
+// GetStatsDevicesV1 breaks down pageviews in the from/to range by the device
+// dimension in the path: device, browser, browser_version, os, os_version or
+// screen
+func (h *Handlers) GetStatsDevicesV1(w http.ResponseWriter, r *http.Request) {
+	dimension := r.PathValue("dimension")
+	if !storage.ValidDeviceDimension(dimension) {
+		writeError(w, r, formatHTML, invalidParamError("dimension",
+			"must be device, browser, browser_version, os, os_version or screen"))
+		return
+	}
+
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetDeviceStats(r.Context(), storage.DeviceQuery{
+		Dimension: dimension,
+		From:      from,
+		To:        to,
+		Limit:     limit,
+	})
+	if err != nil {
+		log.Printf("Error getting device stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load device stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, DeviceStatsResponse{
+			From: from, To: to, Dimension: dimension, Values: stats,
+		})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		value := s.Value
+		if dimension == storage.DeviceDimensionType || dimension == storage.DeviceDimensionScreen {
+			value = capitalize(value)
+		}
+		rows = append(rows, []string{value, formatNumber(s.Visitors), formatNumber(s.Pageviews)})
+	}
+	headers := []string{deviceHeadings[dimension], "Visitors", "Pageviews"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable(headers, rows, "No pageviews").Render()))
+}
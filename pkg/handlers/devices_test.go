@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	chromeUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36"
+	iPhoneUA = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+)
+
+func TestGetStatsDevicesV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	// Collect a desktop hit by pixel and a phone hit by batch, both with
+	// screen widths
+	q := url.Values{"url": {"https://example.com/"}, "screen": {"1920"}}
+	req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+	req.Header.Set("User-Agent", chromeUA)
+	rec := httptest.NewRecorder()
+	handlers.GetCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := json.Marshal(CollectBatchRequest{Events: []CollectEvent{
+		{Type: "pageview", URL: "https://example.com/", Screen: 390},
+		{Type: "pageview", URL: "https://example.com/pricing", Screen: 390},
+	}})
+	require.NoError(t, err)
+	req = httptest.NewRequest("POST", "/api/v1/collect", bytes.NewReader(body))
+	req.Header.Set("User-Agent", iPhoneUA)
+	rec = httptest.NewRecorder()
+	handlers.PostCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Hits are stored to the second, so end the range after them
+	to := "to=" + time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	stats := func(dimension, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/stats/devices/"+dimension+"?"+to, nil)
+		req.SetPathValue("dimension", dimension)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handlers.GetStatsDevicesV1(rec, req)
+		return rec
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		rec := stats("browser_version", "application/json")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response DeviceStatsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, "browser_version", response.Dimension)
+		require.Len(t, response.Values, 2)
+		assert.Equal(t, "Chrome 120", response.Values[0].Value)
+		assert.Equal(t, "Safari 17", response.Values[1].Value)
+		assert.Equal(t, 2, response.Values[1].Pageviews)
+	})
+
+	t.Run("HTML table", func(t *testing.T) {
+		rec := stats("screen", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `class="analytics-table`)
+		assert.Contains(t, body, "Screen Size")
+		assert.Contains(t, body, "Desktop")
+		assert.Contains(t, body, "Mobile")
+	})
+
+	t.Run("Unknown dimension", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, stats("model", "").Code)
+	})
+}
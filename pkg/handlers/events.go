@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"html"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+const (
+	// defaultBreakdownLimit is the number of rows in breakdown reports
+	defaultBreakdownLimit = 20
+	// maxBreakdownLimit caps the limit parameter of breakdown reports
+	maxBreakdownLimit = 500
+)
+
+// CustomEventsResponse is the JSON form of GET /api/v1/stats/events
+type CustomEventsResponse struct {
+	From   time.Time                  `json:"from"`
+	To     time.Time                  `json:"to"`
+	Events []storage.CustomEventCount `json:"events"`
+}
+
+// EventPropertiesResponse is the JSON form of
+// GET /api/v1/stats/events/{name}/properties/{property}
+type EventPropertiesResponse struct {
+	From     time.Time                    `json:"from"`
+	To       time.Time                    `json:"to"`
+	Name     string                       `json:"name"`
+	Property string                       `json:"property"`
+	Values   []storage.PropertyValueCount `json:"values"`
+}
+
+// GetStatsEventsV1 lists custom events by name with their counts and unique
+// visitors over the from/to range
+func (h *Handlers) GetStatsEventsV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	counts, err := h.DB.GetCustomEvents(r.Context(), from, to, limit)
+	if err != nil {
+		log.Printf("Error getting custom events: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load custom events",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, CustomEventsResponse{From: from, To: to, Events: counts})
+		return
+	}
+
+	rows := make([][]string, 0, len(counts))
+	for _, c := range counts {
+		rows = append(rows, []string{c.Name, formatNumber(c.Events), formatNumber(c.Visitors)})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable([]string{"Event", "Events", "Visitors"}, rows, "No custom events").Render()))
+}
+
+// GetStatsEventPropertiesV1 breaks down the custom events with the given
+// name by the value of a property
+func (h *Handlers) GetStatsEventPropertiesV1(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	property := r.PathValue("property")
+	if _, err := storage.PropertyPath(property); err != nil {
+		writeError(w, r, formatHTML, invalidParamError("property", err.Error()))
+		return
+	}
+
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	values, err := h.DB.GetEventPropertyValues(r.Context(), name, property, from, to, limit)
+	if err != nil {
+		log.Printf("Error getting event properties: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load event properties",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, EventPropertiesResponse{
+			From: from, To: to, Name: name, Property: property, Values: values,
+		})
+		return
+	}
+
+	rows := make([][]string, 0, len(values))
+	for _, v := range values {
+		rows = append(rows, []string{v.Value, formatNumber(v.Events), formatNumber(v.Visitors)})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable([]string{property, "Events", "Visitors"}, rows, "No values").Render()))
+}
+
+// breakdownTable renders a label column followed by right-aligned numeric
+// columns. empty is shown when there are no rows. Labels come from tracked
+// data, so headers and cells are HTML-escaped.
+func breakdownTable(headers []string, rows [][]string, empty string) *elem.Element {
+	if len(rows) == 0 {
+		return elem.P(attrs.Props{attrs.Class: "text-sm text-gray-400"}, elem.Text(empty))
+	}
+
+	cellClass := func(i int) string {
+		if i == 0 {
+			return "px-4 py-2 text-left"
+		}
+		return "px-4 py-2 text-right"
+	}
+
+	head := make([]elem.Node, 0, len(headers))
+	for i, h := range headers {
+		head = append(head, elem.Th(attrs.Props{attrs.Class: cellClass(i)}, elem.Text(html.EscapeString(h))))
+	}
+	body := make([]elem.Node, 0, len(rows))
+	for _, row := range rows {
+		cells := make([]elem.Node, 0, len(row))
+		for i, v := range row {
+			cells = append(cells, elem.Td(attrs.Props{attrs.Class: cellClass(i)}, elem.Text(html.EscapeString(v))))
+		}
+		body = append(body, elem.Tr(nil, cells...))
+	}
+
+	return elem.Table(attrs.Props{attrs.Class: "analytics-table w-full text-sm"},
+		elem.THead(attrs.Props{attrs.Class: "text-gray-500 border-b"}, elem.Tr(nil, head...)),
+		elem.TBody(nil, body...),
+	)
+}
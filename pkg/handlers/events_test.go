@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestGetCollectV1_CustomEvent(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	props := base64.StdEncoding.EncodeToString([]byte(`{"plan":"pro","seats":3}`))
+	req := httptest.NewRequest("GET", "/api/v1/collect?type=event&name=Signup&url=https://example.com/pricing&props="+props, nil)
+	rec := httptest.NewRecorder()
+	handlers.GetCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest("GET", "/api/v1/collect?type=event&url=https://example.com/", nil)
+	rec = httptest.NewRecorder()
+	handlers.GetCollectV1(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "Custom events need a name")
+	assert.Equal(t, ErrCodeInvalidRequest, rec.Header().Get("X-Nyla-Error"))
+
+	now := time.Now()
+	events, err := db.QueryCustomEvents(context.Background(), storage.EventQuery{
+		Name:       "Signup",
+		From:       now.Add(-time.Hour),
+		To:         now.Add(time.Hour),
+		Properties: map[string]string{"plan": "pro"},
+		Limit:      10,
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, float64(3), events[0].Properties["seats"])
+}
+
+func TestGetStatsEventsV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	day := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(context.Background(), []*storage.Event{
+		{Type: storage.EventTypeCustom, Timestamp: day, URL: "/", SessionID: "a", VisitorID: "a", Name: "Signup", Properties: map[string]interface{}{"plan": "pro"}},
+		{Type: storage.EventTypeCustom, Timestamp: day, URL: "/", SessionID: "b", VisitorID: "b", Name: "Signup", Properties: map[string]interface{}{"plan": "<b>free</b>"}},
+		{Type: storage.EventTypeCustom, Timestamp: day, URL: "/", SessionID: "b", VisitorID: "b", Name: "Download"},
+	}))
+
+	t.Run("Names as JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/events?from=2024-03-01&to=2024-03-07", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsEventsV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response CustomEventsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Len(t, response.Events, 2)
+		assert.Equal(t, storage.CustomEventCount{Name: "Signup", Events: 2, Visitors: 2}, response.Events[0])
+	})
+
+	t.Run("Property values as HTML", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/events/Signup/properties/plan?from=2024-03-01&to=2024-03-07", nil)
+		req.SetPathValue("name", "Signup")
+		req.SetPathValue("property", "plan")
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsEventPropertiesV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `class="analytics-table`)
+		assert.Contains(t, body, "pro")
+		assert.Contains(t, body, "&lt;b&gt;free&lt;/b&gt;", "Tracked values must be escaped")
+		assert.NotContains(t, body, "<b>free</b>")
+	})
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/events?limit=0", nil)
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsEventsV1(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// CreateFunnelRequest is the JSON body accepted by POST /api/v1/funnels
+type CreateFunnelRequest struct {
+	Name          string               `json:"name"`
+	Steps         []storage.FunnelStep `json:"steps"`
+	WindowSeconds int                  `json:"window_seconds"`
+}
+
+// GetFunnelsV1 lists the configured funnels as JSON
+func (h *Handlers) GetFunnelsV1(w http.ResponseWriter, r *http.Request) {
+	funnels, err := h.DB.GetFunnels(r.Context())
+	if err != nil {
+		log.Printf("Error getting funnels: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load funnels",
+		})
+		return
+	}
+	if funnels == nil {
+		funnels = []storage.Funnel{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]storage.Funnel{"funnels": funnels})
+}
+
+// PostFunnelsV1 creates a funnel from a JSON body
+func (h *Handlers) PostFunnelsV1(w http.ResponseWriter, r *http.Request) {
+	var req CreateFunnelRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid request body",
+			Details: map[string]interface{}{"reason": err.Error()},
+		})
+		return
+	}
+
+	funnel := &storage.Funnel{Name: req.Name, Steps: req.Steps, WindowSeconds: req.WindowSeconds}
+	if err := funnel.Validate(); err != nil {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid funnel: " + err.Error(),
+			Details: map[string]interface{}{"reason": err.Error()},
+		})
+		return
+	}
+
+	err := h.DB.CreateFunnel(r.Context(), funnel)
+	if errors.Is(err, storage.ErrFunnelExists) {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusConflict,
+			Code:    ErrCodeConflict,
+			Message: "A funnel with this name already exists",
+			Details: map[string]interface{}{"field": "name"},
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating funnel: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to create funnel",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, funnel)
+}
+
+// DeleteFunnelV1 deletes the funnel with the ID in the path
+func (h *Handlers) DeleteFunnelV1(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, formatJSON, invalidParamError("id", "must be an integer"))
+		return
+	}
+
+	err = h.DB.DeleteFunnel(r.Context(), id)
+	if errors.Is(err, storage.ErrFunnelNotFound) {
+		writeError(w, r, formatJSON, funnelNotFoundError())
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting funnel: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to delete funnel",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetStatsFunnelV1 reports how many sessions reached each step of the funnel
+// with the ID in the path over the from/to range. It renders the funnel as
+// HTML by default and JSON when requested via the Accept header.
+func (h *Handlers) GetStatsFunnelV1(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, formatHTML, invalidParamError("id", "must be an integer"))
+		return
+	}
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	report, err := h.DB.GetFunnelReport(r.Context(), id, from, to)
+	if errors.Is(err, storage.ErrFunnelNotFound) {
+		writeError(w, r, formatHTML, funnelNotFoundError())
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting funnel report: %v", err)
+		writeError(w, r, formatHTML, funnelReportError())
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(funnelChart(report).Render()))
+}
+
+// GetStatsFunnelsV1 reports every funnel over the from/to range, for the
+// dashboard's funnels page
+func (h *Handlers) GetStatsFunnelsV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	funnels, err := h.DB.GetFunnels(r.Context())
+	if err != nil {
+		log.Printf("Error getting funnels: %v", err)
+		writeError(w, r, formatHTML, funnelReportError())
+		return
+	}
+
+	reports := make([]*storage.FunnelReport, 0, len(funnels))
+	for _, funnel := range funnels {
+		report, err := h.DB.GetFunnelReport(r.Context(), funnel.ID, from, to)
+		if err != nil {
+			log.Printf("Error getting funnel report: %v", err)
+			writeError(w, r, formatHTML, funnelReportError())
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, map[string][]*storage.FunnelReport{"funnels": reports})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if len(reports) == 0 {
+		w.Write([]byte(elem.P(attrs.Props{attrs.Class: "text-sm text-gray-400"},
+			elem.Text("No funnels configured")).Render()))
+		return
+	}
+	charts := make([]elem.Node, 0, len(reports))
+	for _, report := range reports {
+		charts = append(charts, elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
+			elem.H2(attrs.Props{attrs.Class: "text-lg font-semibold mb-4 text-gray-900"},
+				elem.Text(html.EscapeString(report.Funnel.Name))),
+			funnelChart(report),
+		))
+	}
+	w.Write([]byte(elem.Div(attrs.Props{attrs.Class: "space-y-6"}, charts...).Render()))
+}
+
+// funnelNotFoundError is returned for unknown funnel IDs
+func funnelNotFoundError() *APIError {
+	return &APIError{
+		Status:  http.StatusNotFound,
+		Code:    ErrCodeNotFound,
+		Message: "Funnel not found",
+	}
+}
+
+// funnelReportError is returned when a funnel report can't be computed
+func funnelReportError() *APIError {
+	return &APIError{
+		Status:  http.StatusInternalServerError,
+		Code:    ErrCodeInternal,
+		Message: "Failed to load funnel",
+	}
+}
+
+// funnelChart renders a funnel as horizontal bars sized by the share of
+// sessions entering the funnel that reached each step, with the drop-off
+// between steps
+func funnelChart(report *storage.FunnelReport) *elem.Element {
+	steps := make([]elem.Node, 0, len(report.Steps))
+	for i, step := range report.Steps {
+		label := fmt.Sprintf("%d. %s", i+1, step.DisplayLabel())
+		row := elem.Div(attrs.Props{attrs.Class: "funnel-step"},
+			elem.Div(attrs.Props{attrs.Class: "flex justify-between text-sm mb-1"},
+				elem.Span(attrs.Props{attrs.Class: "font-medium text-gray-900"}, elem.Text(html.EscapeString(label))),
+				elem.Span(attrs.Props{attrs.Class: "text-gray-500"},
+					elem.Text(fmt.Sprintf("%s sessions (%s)", formatNumber(step.Sessions), formatPercent(step.ConversionRate)))),
+			),
+			elem.Div(attrs.Props{attrs.Class: "w-full bg-gray-100 rounded h-6"},
+				elem.Div(attrs.Props{
+					attrs.Class: "bg-indigo-500 h-6 rounded",
+					attrs.Style: fmt.Sprintf("width: %.1f%%", step.ConversionRate*100),
+				}),
+			),
+		)
+		steps = append(steps, row)
+
+		if i+1 < len(report.Steps) {
+			next := report.Steps[i+1]
+			dropRate := 1 - next.StepRate
+			if step.Sessions == 0 {
+				dropRate = 0
+			}
+			steps = append(steps, elem.Div(attrs.Props{attrs.Class: "funnel-dropoff text-xs text-red-600 pl-4"},
+				elem.Text(fmt.Sprintf("%s dropped off (%s)", formatNumber(step.DropOff), formatPercent(dropRate)))))
+		}
+	}
+	return elem.Div(attrs.Props{attrs.Class: "analytics-funnel space-y-2"}, steps...)
+}
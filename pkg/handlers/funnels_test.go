@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestFunnelsV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/funnels", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handlers.PostFunnelsV1(rec, req)
+		return rec
+	}
+
+	rec := create(`{"name":"Signup","window_seconds":3600,"steps":[
+		{"kind":"pageview","match":"/","label":"Landing"},
+		{"kind":"pageview","match":"/pricing"},
+		{"kind":"event","match":"Signup","label":"<i>Signed up</i>"}
+	]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var funnel storage.Funnel
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &funnel))
+	id := strconv.FormatInt(funnel.ID, 10)
+
+	assert.Equal(t, http.StatusConflict, create(`{"name":"Signup","steps":[{"kind":"pageview","match":"/"},{"kind":"pageview","match":"/a"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"name":"One step","steps":[{"kind":"pageview","match":"/"}]}`).Code)
+
+	day := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(context.Background(), []*storage.Event{
+		{Type: storage.EventTypePageview, Timestamp: day, URL: "https://example.com/", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypePageview, Timestamp: day.Add(time.Minute), URL: "https://example.com/pricing", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypeCustom, Timestamp: day.Add(2 * time.Minute), URL: "/pricing", SessionID: "a", VisitorID: "a", Name: "Signup"},
+		{Type: storage.EventTypePageview, Timestamp: day, URL: "https://example.com/", SessionID: "b", VisitorID: "b"},
+	}))
+
+	t.Run("Report as JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/funnels/"+id+"?from=2024-03-01&to=2024-03-07", nil)
+		req.SetPathValue("id", id)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsFunnelV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report storage.FunnelReport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Len(t, report.Steps, 3)
+		assert.Equal(t, 2, report.Steps[0].Sessions)
+		assert.Equal(t, 1, report.Steps[0].DropOff)
+		assert.Equal(t, 1, report.Steps[2].Sessions)
+	})
+
+	t.Run("Dashboard view", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/funnels?from=2024-03-01&to=2024-03-07", nil)
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsFunnelsV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `class="analytics-funnel`)
+		assert.Contains(t, body, "1. Landing")
+		assert.Contains(t, body, "2. /pricing")
+		assert.Contains(t, body, "&lt;i&gt;Signed up&lt;/i&gt;", "Labels must be escaped")
+		assert.Contains(t, body, "1 dropped off (50%)")
+	})
+
+	t.Run("Unknown funnel", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/funnels/999", nil)
+		req.SetPathValue("id", "999")
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsFunnelV1(rec, req)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/v1/funnels/"+id, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handlers.DeleteFunnelV1(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = httptest.NewRecorder()
+		handlers.GetFunnelsV1(rec, httptest.NewRequest("GET", "/api/v1/funnels", nil))
+		assert.JSONEq(t, `{"funnels":[]}`, rec.Body.String())
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// CreateGoalRequest is the JSON body accepted by POST /api/v1/goals
+type CreateGoalRequest struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Match string `json:"match"`
+}
+
+// GetGoalsV1 lists the configured goals as JSON
+func (h *Handlers) GetGoalsV1(w http.ResponseWriter, r *http.Request) {
+	goals, err := h.DB.GetGoals(r.Context())
+	if err != nil {
+		log.Printf("Error getting goals: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load goals",
+		})
+		return
+	}
+	if goals == nil {
+		goals = []storage.Goal{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]storage.Goal{"goals": goals})
+}
+
+// PostGoalsV1 creates a goal from a JSON body
+func (h *Handlers) PostGoalsV1(w http.ResponseWriter, r *http.Request) {
+	var req CreateGoalRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid request body",
+			Details: map[string]interface{}{"reason": err.Error()},
+		})
+		return
+	}
+
+	goal := &storage.Goal{Name: req.Name, Kind: req.Kind, Match: req.Match}
+	if err := goal.Validate(); err != nil {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid goal: " + err.Error(),
+			Details: map[string]interface{}{"reason": err.Error()},
+		})
+		return
+	}
+
+	err := h.DB.CreateGoal(r.Context(), goal)
+	if errors.Is(err, storage.ErrGoalExists) {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusConflict,
+			Code:    ErrCodeConflict,
+			Message: "A goal with this name already exists",
+			Details: map[string]interface{}{"field": "name"},
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating goal: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to create goal",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, goal)
+}
+
+// DeleteGoalV1 deletes the goal with the ID in the path
+func (h *Handlers) DeleteGoalV1(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, formatJSON, invalidParamError("id", "must be an integer"))
+		return
+	}
+
+	err = h.DB.DeleteGoal(r.Context(), id)
+	if errors.Is(err, storage.ErrGoalNotFound) {
+		writeError(w, r, formatJSON, goalNotFoundError())
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting goal: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to delete goal",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetStatsGoalsV1 reports completions and conversion rate of every goal over
+// the from/to range. It renders an HTML table by default and JSON when
+// requested via the Accept header.
+func (h *Handlers) GetStatsGoalsV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	report, err := h.DB.GetGoalStats(r.Context(), from, to)
+	if err != nil {
+		log.Printf("Error getting goal stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load goal stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	rows := make([][]string, 0, len(report.Goals))
+	for _, g := range report.Goals {
+		rows = append(rows, []string{
+			g.Name,
+			formatNumber(g.Completions),
+			formatNumber(g.Conversions),
+			formatPercent(g.ConversionRate),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable([]string{"Goal", "Completions", "Visitors", "Conversion Rate"}, rows, "No goals configured").Render()))
+}
+
+// parseGoalParam reads the optional goal query parameter, returning zero
+// when it is absent
+func parseGoalParam(r *http.Request) (int64, *APIError) {
+	v := r.URL.Query().Get("goal")
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, invalidParamError("goal", "must be a goal ID")
+	}
+	return id, nil
+}
+
+// goalNotFoundError is returned for unknown goal IDs
+func goalNotFoundError() *APIError {
+	return &APIError{
+		Status:  http.StatusNotFound,
+		Code:    ErrCodeNotFound,
+		Message: "Goal not found",
+	}
+}
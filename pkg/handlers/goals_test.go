@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestGoalsV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/goals", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handlers.PostGoalsV1(rec, req)
+		return rec
+	}
+
+	rec := create(`{"name":"Signed up","kind":"event","match":"Signup"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var goal storage.Goal
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &goal))
+	assert.NotZero(t, goal.ID)
+
+	assert.Equal(t, http.StatusConflict, create(`{"name":"Signed up","kind":"event","match":"Other"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"name":"Docs","kind":"pageview","match":"docs"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"name":`).Code)
+	require.Equal(t, http.StatusCreated, create(`{"name":"Pricing","kind":"pageview","match":"/pricing"}`).Code)
+
+	t.Run("List", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handlers.GetGoalsV1(rec, httptest.NewRequest("GET", "/api/v1/goals", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response map[string][]storage.Goal
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Len(t, response["goals"], 2)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		day := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+		require.NoError(t, db.InsertEvents(context.Background(), []*storage.Event{
+			{Type: storage.EventTypePageview, Timestamp: day, URL: "https://example.com/pricing", SessionID: "a", VisitorID: "a"},
+			{Type: storage.EventTypeCustom, Timestamp: day, URL: "/pricing", SessionID: "a", VisitorID: "a", Name: "Signup"},
+			{Type: storage.EventTypePageview, Timestamp: day, URL: "https://example.com/", SessionID: "b", VisitorID: "b"},
+		}))
+
+		req := httptest.NewRequest("GET", "/api/v1/stats/goals?from=2024-03-01&to=2024-03-07", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handlers.GetStatsGoalsV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var report storage.GoalReport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Equal(t, 2, report.Visitors)
+		require.Len(t, report.Goals, 2)
+		for _, g := range report.Goals {
+			assert.Equal(t, 1, g.Completions, g.Name)
+			assert.InDelta(t, 0.5, g.ConversionRate, 0.001, g.Name)
+		}
+
+		req = httptest.NewRequest("GET", "/api/v1/stats/goals?from=2024-03-01&to=2024-03-07", nil)
+		rec = httptest.NewRecorder()
+		handlers.GetStatsGoalsV1(rec, req)
+		assert.Contains(t, rec.Body.String(), "50%")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		id := strconv.FormatInt(goal.ID, 10)
+		req := httptest.NewRequest("DELETE", "/api/v1/goals/"+id, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handlers.DeleteGoalV1(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = httptest.NewRecorder()
+		handlers.DeleteGoalV1(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
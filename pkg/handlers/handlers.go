@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -12,10 +13,15 @@ import (
 	"github.com/chasefleming/elem-go/attrs"
 	"github.com/mileusna/useragent"
 
+	"github.com/sunwolfengineering/nyla-core/internal/sessions"
 	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/bots"
+	"github.com/sunwolfengineering/nyla-core/pkg/clientip"
 	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+	"github.com/sunwolfengineering/nyla-core/pkg/device"
 	"github.com/sunwolfengineering/nyla-core/pkg/geo"
 	"github.com/sunwolfengineering/nyla-core/pkg/hash"
+	"github.com/sunwolfengineering/nyla-core/pkg/privacy"
 )
 
 type CollectorData struct {
@@ -30,8 +36,145 @@ type CollectorPayload struct {
 	Data CollectorData `json:"data"`
 }
 
+// CollectEvent is a single event in a batch collect request
+type CollectEvent struct {
+	Type      string                 `json:"type"`
+	URL       string                 `json:"url"`
+	Title     string                 `json:"title"`
+	Referrer  string                 `json:"referrer"`
+	Timestamp string                 `json:"timestamp"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	// Name and Props describe custom events
+	Name  string                 `json:"name"`
+	Props map[string]interface{} `json:"props"`
+	// Screen is the screen width in CSS pixels
+	Screen int `json:"screen"`
+}
+
+// CollectBatchRequest is the JSON body accepted by POST /api/v1/collect
+type CollectBatchRequest struct {
+	SiteID string         `json:"site_id"`
+	Events []CollectEvent `json:"events"`
+}
+
+// CollectEventError describes why an event in a batch was rejected
+type CollectEventError struct {
+	Index  int    `json:"index"`
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// CollectBatchResponse is returned by POST /api/v1/collect
+type CollectBatchResponse struct {
+	Success   bool                `json:"success"`
+	Processed int                 `json:"processed"`
+	Errors    []CollectEventError `json:"errors,omitempty"`
+}
+
+const (
+	// maxBatchEvents caps the number of events accepted in a single POST
+	maxBatchEvents = 100
+	// maxBatchBodyBytes caps the size of a POST collect body
+	maxBatchBodyBytes = 1 << 20
+)
+
 type Handlers struct {
 	DB *storage.DB
+	// Sessions assigns collected events to sessions and stores them
+	Sessions *sessions.Service
+	// IPMode controls how client IPs are anonymized before use. The zero
+	// value truncates.
+	IPMode privacy.IPMode
+	// StoreUserAgent keeps the raw user agent in event metadata
+	StoreUserAgent bool
+	// Bots classifies hits as bot traffic. Nil disables bot filtering.
+	Bots *bots.Detector
+	// BotMode controls whether bot hits are dropped or stored apart
+	BotMode bots.Mode
+	// OnBot, if set, is called with the reason of each filtered bot hit
+	OnBot func(reason string)
+	// Geo locates clients from their anonymized IP. Nil disables geo
+	// lookups.
+	Geo geo.GeoResolver
+	// ClientIP finds the client address behind trusted proxies. Nil uses
+	// the connecting peer and ignores forwarding headers.
+	ClientIP *clientip.Resolver
+	// DefaultConsent handles hits sent with Do Not Track or Global Privacy
+	// Control when site_config.settings has no consent_policy. The zero
+	// value drops them.
+	DefaultConsent privacy.ConsentPolicy
+	// Scrubber removes personal data from URLs, referrers and titles before
+	// events are stored. Nil stores them as sent.
+	Scrubber *privacy.Scrubber
+	// APIKey authenticates data subject requests under /api/v1/privacy.
+	// Empty disables those endpoints.
+	APIKey string
+
+	consent consentCache
+}
+
+// requestIP returns the raw client IP of the request
+func (h *Handlers) requestIP(r *http.Request) (net.IP, error) {
+	if h.ClientIP == nil {
+		return clientip.RemoteIP(r)
+	}
+	return h.ClientIP.ClientIP(r)
+}
+
+// anonymizedIP returns the anonymized client IP, or nil when the IP is
+// dropped or unavailable. The raw address is never used past this point.
+func (h *Handlers) anonymizedIP(r *http.Request) net.IP {
+	ip, err := h.requestIP(r)
+	if err != nil {
+		return nil
+	}
+	return privacy.AnonymizeIP(ip, h.IPMode)
+}
+
+// clientIP returns the anonymized client IP as a string, or an empty string
+// when the IP is dropped or unavailable
+func (h *Handlers) clientIP(r *http.Request) string {
+	ip := h.anonymizedIP(r)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// scrub removes personal data from the URLs, referrers and titles of events,
+// before they are stored as events or bot hits
+func (h *Handlers) scrub(events []*storage.Event) {
+	if h.Scrubber == nil {
+		return
+	}
+	for _, e := range events {
+		e.URL = h.Scrubber.URL(e.URL)
+		e.Referrer = h.Scrubber.URL(e.Referrer)
+		e.Title = h.Scrubber.Text(e.Title)
+	}
+}
+
+// clientMetadata returns the metadata derived from the request. The raw user
+// agent is only included when StoreUserAgent is set; the device columns hold
+// what reports need from it.
+func (h *Handlers) clientMetadata(r *http.Request) map[string]interface{} {
+	m := map[string]interface{}{
+		"hostname": r.Host,
+	}
+	if h.StoreUserAgent {
+		m["user_agent"] = r.UserAgent()
+	}
+	return m
+}
+
+// visitorID hashes the anonymized client IP, user agent and host with the
+// secret salt for the current day
+func (h *Handlers) visitorID(r *http.Request, now time.Time) (string, error) {
+	salt, err := h.DB.DailySalt(r.Context(), now)
+	if err != nil {
+		return "", err
+	}
+	return hash.GeneratePrivateIDHash(salt, h.clientIP(r), r.UserAgent(), r.Host, constants.DefaultSiteID)
 }
 
 func (h *Handlers) GetCollectV1(w http.ResponseWriter, r *http.Request) {
@@ -39,22 +182,27 @@ func (h *Handlers) GetCollectV1(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site_id")
 	eventType := r.URL.Query().Get("type")
 	url := r.URL.Query().Get("url")
+	title := r.URL.Query().Get("title")
 	referrer := r.URL.Query().Get("referrer")
+	name := r.URL.Query().Get("name")
+	screen := r.URL.Query().Get("screen")
 
 	// Enforce single-site architecture
 	if siteID != "" && siteID != constants.DefaultSiteID {
-		response := map[string]string{
-			"error": "Invalid site_id. This instance only supports site_id='default'",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, formatGIF, invalidSiteIDError())
 		return
 	}
 
 	// Set defaults if not provided
 	if eventType == "" {
-		eventType = "pageview"
+		eventType = storage.EventTypePageview
+	}
+	eventType, name = normalizeEventType(eventType, name)
+	if eventType == storage.EventTypeCustom {
+		if err := validateEventName(name); err != nil {
+			writeError(w, r, formatGIF, invalidParamError("name", err.Error()))
+			return
+		}
 	}
 	if url == "" {
 		url = r.Header.Get("Referer")
@@ -63,49 +211,198 @@ func (h *Handlers) GetCollectV1(w http.ResponseWriter, r *http.Request) {
 		referrer = r.Header.Get("Referer")
 	}
 
-	// Parse user agent for metadata
+	// Client timestamps outside the accepted window fall back to server time
+	// rather than failing the pixel
+	now := time.Now()
+	timestamp := now
+	if ts := r.URL.Query().Get("timestamp"); ts != "" {
+		if t, err := parseClientTimestamp(ts, now); err == nil {
+			timestamp = t
+		} else {
+			log.Printf("Ignoring client timestamp %q: %v", ts, err)
+		}
+	}
+
+	var customMetadata map[string]interface{}
+	if raw := r.URL.Query().Get("metadata"); raw != "" {
+		m, err := parseMetadataParam(raw)
+		if err != nil {
+			log.Printf("Ignoring invalid metadata: %v", err)
+		}
+		customMetadata = m
+	}
+
+	// Properties use the same encodings as metadata
+	var properties map[string]interface{}
+	if raw := r.URL.Query().Get("props"); raw != "" && eventType == storage.EventTypeCustom {
+		m, err := parseMetadataParam(raw)
+		if err == nil {
+			err = validateProperties(m)
+		}
+		if err != nil {
+			log.Printf("Ignoring invalid properties: %v", err)
+		} else {
+			properties = m
+		}
+	}
+
+	// Parse user agent for device columns and metadata
 	ua := useragent.Parse(r.UserAgent())
-	ip, _ := geo.IPFromRequest([]string{"X-Forwarded-For", "X-Real-IP"}, r)
-	sessionID, _ := hash.GeneratePrivateIDHash(ip.String(), r.UserAgent(), r.Host, constants.DefaultSiteID)
+	info := device.FromUserAgent(ua)
+	info.Screen = device.ParseScreen(screen)
+	visitorID, err := h.visitorID(r, time.Now())
+	if err != nil {
+		log.Printf("Error hashing visitor: %v", err)
+		writeError(w, r, formatGIF, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to store event",
+		})
+		return
+	}
 
 	// Create event using new storage API
 	event := &storage.Event{
-		Type:      eventType,
-		Timestamp: time.Now(),
-		URL:       url,
-		Referrer:  referrer,
-		SessionID: sessionID,
-		Metadata: map[string]interface{}{
-			"user_agent":   r.UserAgent(),
-			"hostname":     r.Host,
-			"browser_name": ua.Name,
-			"os_name":      ua.OS,
-			"is_bot":       ua.Bot,
-		},
+		Type:       eventType,
+		Timestamp:  timestamp,
+		URL:        url,
+		Title:      title,
+		Referrer:   referrer,
+		VisitorID:  visitorID,
+		Name:       name,
+		Properties: properties,
+		Device:     info,
+		Metadata:   mergeMetadata(customMetadata, h.clientMetadata(r)),
 	}
 
-	ctx := context.Background()
-	if err := h.DB.InsertEvent(ctx, event); err != nil {
+	events := []*storage.Event{event}
+	h.scrub(events)
+	bot, err := h.filterBot(r, ua, events)
+	if err == nil && !bot {
+		err = h.record(r, events)
+	}
+	if err != nil {
 		log.Printf("Error inserting event: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, formatGIF, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to store event",
+		})
+		return
+	}
+
+	if bot {
+		log.Printf("Bot hit filtered: %s %s", eventType, url)
+	} else {
+		log.Printf("Event added: %s %s", eventType, url)
+	}
+
+	if negotiateFormat(r, formatGIF) == formatJSON {
+		writeJSON(w, http.StatusOK, map[string]bool{"success": true})
 		return
 	}
 
-	log.Printf("Event added: %s %s", eventType, url)
-	
 	// Return 1x1 transparent GIF
-	w.Header().Set("Content-Type", "image/gif")
-	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, proxy-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte{
-		0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
-		0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0xFF, 0xFF, 0xFF, 0x21, 0xF9, 0x04, 0x01, 0x00,
-		0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
-		0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
-		0x01, 0x00, 0x3B,
+	writeGIF(w, http.StatusOK)
+}
+
+// PostCollectV1 accepts a batch of events as JSON. Each event is validated
+// independently; valid events are stored in a single transaction and invalid
+// ones are reported back with their index in the batch.
+func (h *Handlers) PostCollectV1(w http.ResponseWriter, r *http.Request) {
+	var req CollectBatchRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid request body",
+			Details: map[string]interface{}{"reason": err.Error()},
+		})
+		return
+	}
+
+	// Enforce single-site architecture
+	if req.SiteID != "" && req.SiteID != constants.DefaultSiteID {
+		writeError(w, r, formatJSON, invalidSiteIDError())
+		return
+	}
+
+	if len(req.Events) == 0 {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Request must contain at least one event",
+			Details: map[string]interface{}{"field": "events"},
+		})
+		return
+	}
+	if len(req.Events) > maxBatchEvents {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: fmt.Sprintf("Too many events: maximum is %d per request", maxBatchEvents),
+			Details: map[string]interface{}{"field": "events"},
+		})
+		return
+	}
+
+	// All events in a batch come from the same client
+	ua := useragent.Parse(r.UserAgent())
+	info := device.FromUserAgent(ua)
+	now := time.Now()
+	visitorID, err := h.visitorID(r, now)
+	if err != nil {
+		log.Printf("Error hashing visitor: %v", err)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to store events",
+		})
+		return
+	}
+
+	var events []*storage.Event
+	var eventErrors []CollectEventError
+	for i, ce := range req.Events {
+		event, fieldErr := validateCollectEvent(ce, now)
+		if fieldErr != nil {
+			fieldErr.Index = i
+			eventErrors = append(eventErrors, *fieldErr)
+			continue
+		}
+
+		event.VisitorID = visitorID
+		event.Device = info
+		event.Device.Screen = device.ScreenClass(ce.Screen)
+		event.Metadata = mergeMetadata(ce.Metadata, h.clientMetadata(r))
+
+		events = append(events, event)
+	}
+
+	if len(events) > 0 {
+		h.scrub(events)
+		bot, err := h.filterBot(r, ua, events)
+		if err == nil && !bot {
+			err = h.record(r, events)
+		}
+		if err != nil {
+			log.Printf("Error inserting event batch: %v", err)
+			writeError(w, r, formatJSON, &APIError{
+				Status:  http.StatusInternalServerError,
+				Code:    ErrCodeInternal,
+				Message: "Failed to store events",
+			})
+			return
+		}
+	}
+
+	log.Printf("Event batch added: %d processed, %d rejected", len(events), len(eventErrors))
+
+	writeJSON(w, http.StatusOK, CollectBatchResponse{
+		Success:   true,
+		Processed: len(events),
+		Errors:    eventErrors,
 	})
 }
 
@@ -114,8 +411,11 @@ func (h *Handlers) GetStatsRealtimeV1(w http.ResponseWriter, r *http.Request) {
 	stats, err := h.DB.GetRealtimeStats(ctx)
 	if err != nil {
 		log.Printf("Error getting realtime stats: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "<div class='error'>Error: %v</div>", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load realtime stats",
+		})
 		return
 	}
 
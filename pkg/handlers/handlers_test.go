@@ -2,18 +2,26 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/sunwolfengineering/nyla-core/internal/sessions"
 	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/clientip"
 	"github.com/sunwolfengineering/nyla-core/pkg/constants"
+	"github.com/sunwolfengineering/nyla-core/pkg/privacy"
 )
 
 func setupTestHandlers(t *testing.T) (*Handlers, *storage.DB) {
@@ -34,108 +42,41 @@ func setupTestHandlers(t *testing.T) (*Handlers, *storage.DB) {
 	db, err := storage.NewDBWithMigrations(dbPath, migrationsDir)
 	require.NoError(t, err, "Should be able to create test database")
 	
-	handlers := &Handlers{DB: db}
+	handlers := &Handlers{
+		DB:       db,
+		Sessions: sessions.NewService(db, sessions.DefaultTimeout),
+		ClientIP: testClientIP(t),
+	}
 	
 	return handlers, db
 }
 
-// setupTestMigrations creates a temporary migrations directory with the minimal schema
+// testClientIP trusts forwarding headers from httptest's default peer,
+// 192.0.2.1, as if it were a reverse proxy
+func testClientIP(t *testing.T) *clientip.Resolver {
+	trusted, err := clientip.ParseNetworks([]string{"192.0.2.1"})
+	require.NoError(t, err)
+	r, err := clientip.New(trusted, nil)
+	require.NoError(t, err)
+	return r
+}
+
+// setupTestMigrations creates a temporary migrations directory holding a copy
+// of the repository's migrations so tests run against the real schema
 func setupTestMigrations(t *testing.T) string {
 	tempDir, err := os.MkdirTemp("", "test_migrations")
 	require.NoError(t, err)
 	
-	// Create a simplified test migration
-	migration := `
-		CREATE TABLE IF NOT EXISTS schema_migrations (
-			version INTEGER PRIMARY KEY,
-			applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
-		);
-		
-		CREATE TABLE site_config (
-			id TEXT PRIMARY KEY DEFAULT 'default',
-			name TEXT NOT NULL DEFAULT 'My Site',
-			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			settings TEXT NOT NULL DEFAULT '{}',
-			CHECK (id = 'default')
-		);
-		
-		INSERT INTO site_config (id, name) VALUES ('default', 'My Site')
-		ON CONFLICT DO NOTHING;
-		
-		CREATE TABLE events (
-			id INTEGER PRIMARY KEY,
-			site_id TEXT NOT NULL DEFAULT 'default',
-			type TEXT NOT NULL,
-			timestamp TEXT NOT NULL,
-			url TEXT,
-			title TEXT,
-			referrer TEXT,
-			session_id TEXT,
-			metadata TEXT,
-			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY(site_id) REFERENCES site_config(id),
-			CHECK (site_id = 'default')
-		) STRICT;
-		
-		CREATE INDEX idx_events_timestamp ON events(timestamp);
-		CREATE INDEX idx_events_type_timestamp ON events(type, timestamp);
-		CREATE INDEX idx_events_session ON events(session_id, timestamp);
-		CREATE INDEX idx_events_url ON events(url, timestamp);
-		
-		CREATE TABLE sessions (
-			id TEXT PRIMARY KEY,
-			site_id TEXT NOT NULL DEFAULT 'default',
-			started_at TEXT NOT NULL,
-			ended_at TEXT,
-			duration INTEGER,
-			pages_viewed INTEGER DEFAULT 0,
-			entry_page TEXT,
-			exit_page TEXT,
-			referrer TEXT,
-			metadata TEXT,
-			FOREIGN KEY(site_id) REFERENCES site_config(id),
-			CHECK (site_id = 'default')
-		) STRICT;
-		
-		CREATE INDEX idx_sessions_time ON sessions(started_at);
-		CREATE INDEX idx_sessions_duration ON sessions(duration);
-		
-		CREATE TRIGGER update_session_stats
-		AFTER INSERT ON events
-		WHEN NEW.type = 'pageview'
-		BEGIN
-			INSERT INTO sessions (
-				id, 
-				site_id, 
-				started_at, 
-				ended_at,
-				pages_viewed,
-				entry_page
-			) VALUES (
-				NEW.session_id,
-				NEW.site_id,
-				NEW.timestamp,
-				NEW.timestamp,
-				1,
-				NEW.url
-			)
-			ON CONFLICT(id) DO UPDATE SET 
-				pages_viewed = pages_viewed + 1,
-				ended_at = NEW.timestamp,
-				exit_page = NEW.url,
-				duration = CAST(
-					(strftime('%s', NEW.timestamp) - 
-					 strftime('%s', started_at)) AS INTEGER
-				);
-		END;
-		
-		INSERT INTO schema_migrations (version) VALUES (1);
-	`
-	
-	migrationPath := t.TempDir() + "/001_test_schema.sql"
-	err = os.WriteFile(migrationPath, []byte(migration), 0644)
+	files, err := filepath.Glob(filepath.Join("..", "..", "migrations", "*.sql"))
 	require.NoError(t, err)
+	require.NotEmpty(t, files, "Should find repository migrations")
+	
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(tempDir, filepath.Base(file)), content, 0644)
+		require.NoError(t, err)
+	}
 	
 	return tempDir
 }
@@ -150,6 +91,7 @@ func TestGetCollectV1_SingleSiteValidation(t *testing.T) {
 		siteID         string
 		eventType      string
 		expectedStatus int
+		accept         string
 		expectJSON     bool
 		errorMessage   string
 	}{
@@ -167,11 +109,20 @@ func TestGetCollectV1_SingleSiteValidation(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectJSON:     false,
 		},
+		{
+			name:           "Valid default site_id (JSON requested)",
+			siteID:         "default",
+			eventType:      "pageview",
+			expectedStatus: http.StatusOK,
+			accept:         "application/json",
+			expectJSON:     true,
+		},
 		{
 			name:           "Invalid site_id",
 			siteID:         "invalid",
 			eventType:      "pageview",
 			expectedStatus: http.StatusBadRequest,
+			accept:         "application/json",
 			expectJSON:     true,
 			errorMessage:   "Invalid site_id. This instance only supports site_id='default'",
 		},
@@ -180,9 +131,18 @@ func TestGetCollectV1_SingleSiteValidation(t *testing.T) {
 			siteID:         "another-site",
 			eventType:      "pageview",
 			expectedStatus: http.StatusBadRequest,
+			accept:         "application/json",
 			expectJSON:     true,
 			errorMessage:   "Invalid site_id. This instance only supports site_id='default'",
 		},
+		{
+			name:           "Invalid site_id (image beacon gets error GIF)",
+			siteID:         "invalid",
+			eventType:      "pageview",
+			expectedStatus: http.StatusBadRequest,
+			accept:         "image/avif,image/webp,*/*",
+			expectJSON:     false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -205,6 +165,9 @@ func TestGetCollectV1_SingleSiteValidation(t *testing.T) {
 
 			// Create request
 			req := httptest.NewRequest("GET", url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
 			rec := httptest.NewRecorder()
 
 			// Execute handler
@@ -213,18 +176,24 @@ func TestGetCollectV1_SingleSiteValidation(t *testing.T) {
 			// Verify status code
 			assert.Equal(t, tt.expectedStatus, rec.Code, "Status code should match expected")
 
-			if tt.expectJSON {
-				// Verify JSON error response
+			if tt.expectJSON && tt.errorMessage != "" {
+				// Verify structured JSON error response
 				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), "Content-Type should be application/json for error responses")
 				
-				var response map[string]string
+				var response map[string]APIError
 				err := json.Unmarshal(rec.Body.Bytes(), &response)
 				require.NoError(t, err, "Should be able to parse JSON response")
 				
-				assert.Equal(t, tt.errorMessage, response["error"], "Error message should match expected")
+				assert.Equal(t, ErrCodeInvalidSiteID, response["error"].Code, "Error code should match expected")
+				assert.Equal(t, tt.errorMessage, response["error"].Message, "Error message should match expected")
+				assert.Equal(t, "site_id", response["error"].Details["field"], "Error details should name the field")
+			} else if tt.expectJSON {
+				// Verify JSON success response
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				assert.JSONEq(t, `{"success":true}`, rec.Body.String())
 			} else {
-				// Verify GIF response for successful requests
-				assert.Equal(t, "image/gif", rec.Header().Get("Content-Type"), "Content-Type should be image/gif for successful requests")
+				// Verify GIF response for beacon requests
+				assert.Equal(t, "image/gif", rec.Header().Get("Content-Type"), "Content-Type should be image/gif for beacon requests")
 				assert.Equal(t, 43, rec.Body.Len(), "GIF response should be 43 bytes")
 			}
 		})
@@ -248,6 +217,63 @@ func TestGetCollectV1_DefaultSiteIDUsage(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code, "Request with constants.DefaultSiteID should succeed")
 }
 
+func TestGetCollectV1_TitleTimestampMetadata(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	now := time.Now().UTC()
+	past := now.Add(-time.Hour).Truncate(time.Second)
+	collect := func(q url.Values) {
+		req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+		req.Header.Set("User-Agent", chromeUA)
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		handlers.GetCollectV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	collect(url.Values{
+		"url":       {"https://example.com/pricing"},
+		"title":     {"Pricing – Example"},
+		"timestamp": {past.Format(time.RFC3339)},
+		"metadata":  {base64.StdEncoding.EncodeToString([]byte(`{"plan":"pro","hostname":"spoofed.example"}`))},
+	})
+	collect(url.Values{
+		"url":       {"https://example.com/future"},
+		"timestamp": {now.Add(48 * time.Hour).Format(time.RFC3339)},
+		"metadata":  {"plan=team&source=ad"},
+	})
+	collect(url.Values{
+		"url":       {"https://example.com/stale"},
+		"timestamp": {now.Add(-48 * time.Hour).Format(time.RFC3339)},
+		"metadata":  {"not metadata"},
+	})
+
+	export, err := db.ExportData(context.Background(), storage.DataSelector{From: now.Add(-2 * time.Hour)})
+	require.NoError(t, err)
+	events := make(map[string]*storage.Event)
+	for _, e := range export.Events {
+		events[e.URL] = e
+	}
+	require.Len(t, events, 3)
+
+	pricing := events["https://example.com/pricing"]
+	assert.Equal(t, "Pricing – Example", pricing.Title)
+	assert.True(t, past.Equal(pricing.Timestamp), "Timestamps within the window are kept, got %s", pricing.Timestamp)
+	assert.Equal(t, "pro", pricing.Metadata["plan"])
+	assert.Equal(t, "example.com", pricing.Metadata["hostname"], "Server metadata wins over client keys")
+
+	future := events["https://example.com/future"]
+	assert.Empty(t, future.Title)
+	assert.WithinDuration(t, now, future.Timestamp, time.Minute, "Timestamps too far ahead fall back to server time")
+	assert.Equal(t, "team", future.Metadata["plan"])
+	assert.Equal(t, "ad", future.Metadata["source"])
+	assert.Equal(t, "example.com", future.Metadata["hostname"])
+
+	stale := events["https://example.com/stale"]
+	assert.WithinDuration(t, now, stale.Timestamp, time.Minute, "Timestamps too far back fall back to server time")
+	assert.Equal(t, map[string]interface{}{"hostname": "example.com"}, stale.Metadata, "Invalid metadata is dropped")
+}
+
 func TestGetStatsRealtimeV1_ReturnsStats(t *testing.T) {
 	// This test verifies that the stats endpoint works with new storage
 	handlers, db := setupTestHandlers(t)
@@ -282,3 +308,188 @@ func TestCollectorPayload_Structure(t *testing.T) {
 	// Verify the struct is as expected
 	assert.NotNil(t, payload.Data, "Data field should exist")
 }
+
+func TestPostCollectV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	tests := []struct {
+		name              string
+		body              string
+		expectedStatus    int
+		expectedProcessed int
+		expectedErrors    []CollectEventError
+	}{
+		{
+			name: "Valid batch",
+			body: `{"site_id":"default","events":[
+				{"type":"pageview","url":"https://example.com/","title":"Home","timestamp":"` + time.Now().UTC().Format(time.RFC3339) + `"},
+				{"type":"pageview","url":"https://example.com/pricing","metadata":{"screen_size":"1920x1080"}}
+			]}`,
+			expectedStatus:    http.StatusOK,
+			expectedProcessed: 2,
+		},
+		{
+			name: "Partially invalid batch",
+			body: `{"events":[
+				{"type":"pageview","url":"https://example.com/"},
+				{"url":"https://example.com/missing-type"},
+				{"type":"pageview","timestamp":"yesterday"}
+			]}`,
+			expectedStatus:    http.StatusOK,
+			expectedProcessed: 1,
+			expectedErrors: []CollectEventError{
+				{Index: 1, Field: "type", Reason: "type is required"},
+				{Index: 2, Field: "timestamp", Reason: "invalid datetime format"},
+			},
+		},
+		{
+			name:           "Invalid site_id",
+			body:           `{"site_id":"other","events":[{"type":"pageview"}]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Empty batch",
+			body:           `{"events":[]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Malformed JSON",
+			body:           `{"events":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/collect", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			handlers.PostCollectV1(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code, "Status code should match expected")
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response CollectBatchResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+			assert.True(t, response.Success)
+			assert.Equal(t, tt.expectedProcessed, response.Processed)
+			assert.Equal(t, tt.expectedErrors, response.Errors)
+		})
+	}
+}
+
+func TestClientIPAnonymization(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       privacy.IPMode
+		forwarded  string
+		remoteAddr string
+		expected   string
+	}{
+		{"Default truncates IPv4", "", "203.0.113.77", "", "203.0.113.0"},
+		{"Truncates IPv6", privacy.IPModeTruncate, "2001:db8:abcd:12::1", "", "2001:db8:abcd::"},
+		{"Truncates IPv4-mapped remote address", privacy.IPModeTruncate, "", "[::ffff:198.51.100.9]:5000", "198.51.100.0"},
+		{"Drops", privacy.IPModeDrop, "203.0.113.77", "", ""},
+		{"Keeps", privacy.IPModeNone, "203.0.113.77", "", "203.0.113.77"},
+		{"Ignores forwarding headers from untrusted peers", privacy.IPModeNone, "203.0.113.77", "198.51.100.9:5000", "198.51.100.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handlers{IPMode: tt.mode, ClientIP: testClientIP(t)}
+			req := httptest.NewRequest("GET", "/api/v1/collect", nil)
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			assert.Equal(t, tt.expected, h.clientIP(req))
+		})
+	}
+}
+
+func TestClientMetadata(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/collect", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+
+	h := &Handlers{}
+	m := h.clientMetadata(req)
+	assert.NotContains(t, m, "user_agent", "The raw user agent is kept only when enabled")
+	assert.Equal(t, "example.com", m["hostname"])
+
+	h.StoreUserAgent = true
+	m = h.clientMetadata(req)
+	assert.Equal(t, req.UserAgent(), m["user_agent"])
+}
+
+func TestCollectScrubsPII(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	scrubber, err := privacy.NewScrubber(privacy.ScrubOptions{
+		Patterns:      []string{privacy.PatternEmail},
+		QueryDenylist: privacy.DefaultQueryDenylist,
+	})
+	require.NoError(t, err)
+	handlers.Scrubber = scrubber
+
+	q := url.Values{
+		"url":      {"https://example.com/welcome?token=abc&plan=pro"},
+		"title":    {"Welcome, jane@example.com"},
+		"referrer": {"https://mail.example.org/inbox?email=jane@example.com"},
+	}
+	req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+	req.Header.Set("User-Agent", chromeUA)
+	req.Header.Set("Accept-Language", "en")
+	rec := httptest.NewRecorder()
+	handlers.GetCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	pages, err := db.GetPopularPages(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	assert.Equal(t, "https://example.com/welcome?token=REDACTED&plan=pro", pages[0]["url"])
+
+	events := []*storage.Event{{
+		URL:      "/users/jane@example.com",
+		Title:    "Orders for jane@example.com",
+		Referrer: "https://mail.example.org/inbox?email=jane@example.com",
+	}}
+	handlers.scrub(events)
+	assert.Equal(t, "/users/REDACTED", events[0].URL)
+	assert.Equal(t, "Orders for REDACTED", events[0].Title)
+	assert.Equal(t, "https://mail.example.org/inbox?email=REDACTED", events[0].Referrer)
+}
+
+func TestCollectStripQueryKeepsSource(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	scrubber, err := privacy.NewScrubber(privacy.ScrubOptions{StripQuery: true})
+	require.NoError(t, err)
+	handlers.Scrubber = scrubber
+
+	body := `{"events":[{"type":"pageview","url":"https://example.com/launch?ref=producthunt&id=42"}]}`
+	req := httptest.NewRequest("POST", "/api/v1/collect", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", chromeUA)
+	req.Header.Set("Accept-Language", "en")
+	rec := httptest.NewRecorder()
+	handlers.PostCollectV1(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	export, err := db.ExportData(context.Background(), storage.DataSelector{URL: "https://example.com/launch*"})
+	require.NoError(t, err)
+	require.Len(t, export.Events, 1)
+	assert.Equal(t, "https://example.com/launch?ref=producthunt", export.Events[0].URL)
+	assert.Equal(t, "producthunt", export.Events[0].UTM.Source, "Stripping keeps the ref alias of utm_source")
+	require.Len(t, export.Sessions, 1)
+	assert.Equal(t, "producthunt", export.Sessions[0].UTM.Source)
+}
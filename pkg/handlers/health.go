@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// healthCheckTimeout bounds the database queries made by a health check
+const healthCheckTimeout = 2 * time.Second
+
+// Health statuses reported by GET /health
+const (
+	healthStatusHealthy   = "healthy"
+	healthStatusUnhealthy = "unhealthy"
+)
+
+// JobStatusProvider reports the state of background jobs
+type JobStatusProvider interface {
+	Status() []jobs.JobStatus
+}
+
+// HealthHandlers serves the health check endpoint
+type HealthHandlers struct {
+	DB        *storage.DB
+	Jobs      JobStatusProvider
+	Version   string
+	StartedAt time.Time
+	// Now returns the current time; defaults to time.Now
+	Now func() time.Time
+}
+
+// HealthJob is the health of a single background job
+type HealthJob struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// HealthResponse is returned by GET /health
+type HealthResponse struct {
+	Status        string               `json:"status"`
+	Version       string               `json:"version"`
+	Uptime        string               `json:"uptime"`
+	Database      string               `json:"database"`
+	SchemaVersion int                  `json:"schema_version,omitempty"`
+	Metrics       *storage.HealthStats `json:"metrics,omitempty"`
+	Jobs          []HealthJob          `json:"jobs,omitempty"`
+	Errors        []string             `json:"errors,omitempty"`
+}
+
+// GetHealth reports database connectivity, schema version, today's event
+// volume and background job state. It responds 503 when the database is
+// unreachable or a job is stuck.
+func (h *HealthHandlers) GetHealth(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	if h.Now != nil {
+		now = h.Now()
+	}
+
+	resp := HealthResponse{
+		Status:   healthStatusHealthy,
+		Version:  h.Version,
+		Uptime:   now.Sub(h.StartedAt).Truncate(time.Second).String(),
+		Database: "connected",
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	if err := h.DB.Ping(ctx); err != nil {
+		log.Printf("Health check: database ping failed: %v", err)
+		resp.Status = healthStatusUnhealthy
+		resp.Database = "disconnected"
+		resp.Errors = append(resp.Errors, "database unreachable")
+	} else {
+		if version, err := h.DB.SchemaVersion(ctx); err != nil {
+			log.Printf("Health check: %v", err)
+		} else {
+			resp.SchemaVersion = version
+		}
+		if stats, err := h.DB.GetHealthStats(ctx, now); err != nil {
+			log.Printf("Health check: %v", err)
+		} else {
+			resp.Metrics = stats
+		}
+	}
+
+	if h.Jobs != nil {
+		for _, status := range h.Jobs.Status() {
+			job := healthJob(status, now)
+			if job.Status == "stuck" {
+				resp.Status = healthStatusUnhealthy
+				resp.Errors = append(resp.Errors, "job "+status.Name+" is stuck")
+			}
+			resp.Jobs = append(resp.Jobs, job)
+		}
+	}
+
+	code := http.StatusOK
+	if resp.Status != healthStatusHealthy {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, resp)
+}
+
+// healthJob summarizes a job status as pending, running, stuck, failed or ok
+func healthJob(status jobs.JobStatus, now time.Time) HealthJob {
+	job := HealthJob{Name: status.Name, LastError: status.LastError}
+	if !status.LastStarted.IsZero() {
+		lastRun := status.LastStarted.UTC()
+		job.LastRun = &lastRun
+	}
+
+	switch {
+	case status.Stuck(now):
+		job.Status = "stuck"
+	case status.Running:
+		job.Status = "running"
+	case status.Runs == 0:
+		job.Status = "pending"
+	case status.LastError != "":
+		job.Status = "failed"
+	default:
+		job.Status = "ok"
+	}
+	return job
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/jobs"
+)
+
+// staticJobs reports a fixed set of job statuses
+type staticJobs []jobs.JobStatus
+
+func (s staticJobs) Status() []jobs.JobStatus { return s }
+
+func TestGetHealth(t *testing.T) {
+	_, db := setupTestHandlers(t)
+	defer db.Close()
+
+	now := time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		jobs           staticJobs
+		expectedStatus int
+		status         string
+		jobStatus      string
+	}{
+		{
+			name:           "No jobs",
+			expectedStatus: http.StatusOK,
+			status:         "healthy",
+		},
+		{
+			name: "Job completed",
+			jobs: staticJobs{{Name: "rollup", Interval: time.Hour, Runs: 1,
+				LastStarted: now.Add(-10 * time.Minute), LastFinished: now.Add(-9 * time.Minute)}},
+			expectedStatus: http.StatusOK,
+			status:         "healthy",
+			jobStatus:      "ok",
+		},
+		{
+			name: "Job failed",
+			jobs: staticJobs{{Name: "rollup", Interval: time.Hour, Runs: 1, Failures: 1,
+				LastStarted: now.Add(-10 * time.Minute), LastError: "disk full"}},
+			expectedStatus: http.StatusOK,
+			status:         "healthy",
+			jobStatus:      "failed",
+		},
+		{
+			name: "Job running past its interval",
+			jobs: staticJobs{{Name: "rollup", Interval: time.Hour, StuckAfter: 6 * time.Hour, Running: true,
+				LastStarted: now.Add(-2 * time.Hour)}},
+			expectedStatus: http.StatusOK,
+			status:         "healthy",
+			jobStatus:      "running",
+		},
+		{
+			name: "Job stuck",
+			jobs: staticJobs{{Name: "rollup", Interval: time.Hour, StuckAfter: 6 * time.Hour, Running: true,
+				LastStarted: now.Add(-7 * time.Hour)}},
+			expectedStatus: http.StatusServiceUnavailable,
+			status:         "unhealthy",
+			jobStatus:      "stuck",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HealthHandlers{
+				DB:        db,
+				Version:   "1.2.3",
+				StartedAt: now.Add(-24 * time.Hour),
+				Now:       func() time.Time { return now },
+			}
+			if tt.jobs != nil {
+				h.Jobs = tt.jobs
+			}
+
+			req := httptest.NewRequest("GET", "/health", nil)
+			w := httptest.NewRecorder()
+			h.GetHealth(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var resp HealthResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.status, resp.Status)
+			assert.Equal(t, "1.2.3", resp.Version)
+			assert.Equal(t, "24h0m0s", resp.Uptime)
+			assert.Equal(t, "connected", resp.Database)
+			assert.GreaterOrEqual(t, resp.SchemaVersion, 1)
+			require.NotNil(t, resp.Metrics)
+			if tt.jobStatus != "" {
+				require.Len(t, resp.Jobs, 1)
+				assert.Equal(t, tt.jobStatus, resp.Jobs[0].Status)
+			}
+		})
+	}
+}
+
+func TestGetHealthDatabaseDown(t *testing.T) {
+	_, db := setupTestHandlers(t)
+	require.NoError(t, db.Close())
+
+	h := &HealthHandlers{DB: db, Version: "dev", StartedAt: time.Now()}
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	h.GetHealth(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var resp HealthResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "unhealthy", resp.Status)
+	assert.Equal(t, "disconnected", resp.Database)
+	assert.Nil(t, resp.Metrics)
+}
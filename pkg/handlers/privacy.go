@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// requireAPIKey checks the request's bearer token against APIKey and writes
+// an error unless it matches. Without a configured key the endpoint is
+// disabled, since it would expose personal data to anyone.
+func (h *Handlers) requireAPIKey(w http.ResponseWriter, r *http.Request) bool {
+	if h.APIKey == "" {
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusForbidden,
+			Code:    ErrCodeForbidden,
+			Message: "Set security.api_key to enable this endpoint",
+		})
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.APIKey)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="nyla"`)
+		writeError(w, r, formatJSON, &APIError{
+			Status:  http.StatusUnauthorized,
+			Code:    ErrCodeUnauthorized,
+			Message: "A valid API key is required",
+		})
+		return false
+	}
+	return true
+}
+
+// parseDataSelector reads the session_id, url, from and to query parameters
+// of a data subject request. A date-only to includes that whole day.
+func parseDataSelector(r *http.Request) (storage.DataSelector, *APIError) {
+	q := r.URL.Query()
+	sel := storage.DataSelector{SessionID: q.Get("session_id"), URL: q.Get("url")}
+	if v := q.Get("from"); v != "" {
+		t, _, err := parseTimeParam(v)
+		if err != nil {
+			return sel, invalidParamError("from", err.Error())
+		}
+		sel.From = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, dateOnly, err := parseTimeParam(v)
+		if err != nil {
+			return sel, invalidParamError("to", err.Error())
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		sel.To = t
+	}
+	if err := sel.Validate(); err != nil {
+		return sel, &APIError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "Invalid selector: " + err.Error(),
+			Details: map[string]interface{}{"reason": err.Error()},
+		}
+	}
+	return sel, nil
+}
+
+// privacyRequest authenticates a data subject request and parses its
+// selector, writing an error and returning false if either fails
+func (h *Handlers) privacyRequest(w http.ResponseWriter, r *http.Request) (storage.DataSelector, bool) {
+	if !h.requireAPIKey(w, r) {
+		return storage.DataSelector{}, false
+	}
+	sel, apiErr := parseDataSelector(r)
+	if apiErr != nil {
+		writeError(w, r, formatJSON, apiErr)
+		return sel, false
+	}
+	return sel, true
+}
+
+// privacyError writes the error of a failed data subject request
+func privacyError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	log.Printf("Error in privacy %s: %v", action, err)
+	writeError(w, r, formatJSON, &APIError{
+		Status:  http.StatusInternalServerError,
+		Code:    ErrCodeInternal,
+		Message: fmt.Sprintf("Failed to %s data", action),
+	})
+}
+
+// GetPrivacyDataV1 counts the events and sessions matching a session ID,
+// URL pattern or time range, so a data subject request can be checked
+// before exporting or deleting
+func (h *Handlers) GetPrivacyDataV1(w http.ResponseWriter, r *http.Request) {
+	sel, ok := h.privacyRequest(w, r)
+	if !ok {
+		return
+	}
+	match, err := h.DB.FindData(r.Context(), sel)
+	if err != nil {
+		privacyError(w, r, storage.PrivacyActionFind, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, match)
+}
+
+// GetPrivacyExportV1 downloads the matching events and sessions as JSON
+func (h *Handlers) GetPrivacyExportV1(w http.ResponseWriter, r *http.Request) {
+	sel, ok := h.privacyRequest(w, r)
+	if !ok {
+		return
+	}
+	export, err := h.DB.ExportData(r.Context(), sel)
+	if err != nil {
+		privacyError(w, r, storage.PrivacyActionExport, err)
+		return
+	}
+	filename := "nyla-export-" + export.ExportedAt.Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	writeJSON(w, http.StatusOK, export)
+}
+
+// DeletePrivacyDataV1 deletes the matching events and sessions and
+// subtracts them from the affected daily aggregates
+func (h *Handlers) DeletePrivacyDataV1(w http.ResponseWriter, r *http.Request) {
+	sel, ok := h.privacyRequest(w, r)
+	if !ok {
+		return
+	}
+	match, err := h.DB.DeleteData(r.Context(), sel)
+	if err != nil {
+		privacyError(w, r, storage.PrivacyActionDelete, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, match)
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestPrivacyDataV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+	handlers.APIKey = "nyla_key_test"
+
+	day := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(context.Background(), []*storage.Event{
+		{Type: storage.EventTypePageview, Timestamp: day, URL: "https://example.com/users/jane", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypePageview, Timestamp: day.Add(time.Minute), URL: "https://example.com/pricing", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypePageview, Timestamp: day, URL: "https://example.com/", SessionID: "b", VisitorID: "b"},
+	}))
+
+	serve := func(handler http.HandlerFunc, method, target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	t.Run("Authentication", func(t *testing.T) {
+		rec := serve(handlers.GetPrivacyDataV1, "GET", "/api/v1/privacy/data?session_id=a", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+		assert.Equal(t, http.StatusUnauthorized, serve(handlers.GetPrivacyDataV1, "GET", "/api/v1/privacy/data?session_id=a", "wrong").Code)
+
+		disabled := &Handlers{DB: db}
+		rec = serve(disabled.DeletePrivacyDataV1, "DELETE", "/api/v1/privacy/data?session_id=a", "")
+		assert.Equal(t, http.StatusForbidden, rec.Code, "Without an API key the endpoints are disabled")
+
+		logs, err := db.GetPrivacyLogs(context.Background(), "", 10)
+		require.NoError(t, err)
+		assert.Empty(t, logs, "Rejected requests are not logged")
+	})
+
+	t.Run("Invalid selectors", func(t *testing.T) {
+		for _, target := range []string{
+			"/api/v1/privacy/data",
+			"/api/v1/privacy/data?from=yesterday",
+			"/api/v1/privacy/data?from=2024-03-05&to=2024-03-04",
+		} {
+			assert.Equal(t, http.StatusBadRequest, serve(handlers.GetPrivacyDataV1, "GET", target, handlers.APIKey).Code, target)
+		}
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		rec := serve(handlers.GetPrivacyDataV1, "GET", "/api/v1/privacy/data?url=/users/*&to=2024-03-04", handlers.APIKey)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var match storage.DataMatch
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &match))
+		assert.Equal(t, 2, match.Events, "A date-only to includes the whole day")
+		assert.Equal(t, 1, match.Sessions)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		rec := serve(handlers.GetPrivacyExportV1, "GET", "/api/v1/privacy/export?session_id=a", handlers.APIKey)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+		var export storage.DataExport
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &export))
+		require.Len(t, export.Sessions, 1)
+		assert.Len(t, export.Events, 2)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		rec := serve(handlers.DeletePrivacyDataV1, "DELETE", "/api/v1/privacy/data?session_id=a", handlers.APIKey)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var match storage.DataMatch
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &match))
+		assert.Equal(t, 2, match.Events)
+
+		session, err := db.GetSessionByID(context.Background(), "a")
+		require.NoError(t, err)
+		assert.Nil(t, session)
+
+		logs, err := db.GetPrivacyLogs(context.Background(), "", 10)
+		require.NoError(t, err)
+		actions := make([]string, len(logs))
+		for i, l := range logs {
+			actions[i] = l.Action
+		}
+		assert.Equal(t, []string{"delete", "export", "find"}, actions)
+	})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+)
+
+// Error codes returned in structured JSON errors
+const (
+	ErrCodeInvalidRequest = "invalid_request"
+	ErrCodeInvalidSiteID  = "invalid_site_id"
+	ErrCodeInternal       = "internal_error"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeConflict       = "conflict"
+	ErrCodeUnauthorized   = "unauthorized"
+	ErrCodeForbidden      = "forbidden"
+)
+
+// responseFormat is the representation chosen for a response
+type responseFormat int
+
+const (
+	formatGIF responseFormat = iota
+	formatHTML
+	formatJSON
+)
+
+// transparentGIF is a 1x1 transparent GIF used as the tracking pixel. The same
+// pixel is served on errors so image beacons never render a broken image.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
+	0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xFF, 0xFF, 0xFF, 0x21, 0xF9, 0x04, 0x01, 0x00,
+	0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
+	0x01, 0x00, 0x3B,
+}
+
+// APIError is a structured error rendered as JSON, an HTML fragment or an
+// error GIF depending on what the client accepts
+type APIError struct {
+	Status  int                    `json:"-"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// negotiateFormat returns formatJSON when the client explicitly accepts
+// application/json, and def otherwise
+func negotiateFormat(r *http.Request, def responseFormat) responseFormat {
+	if acceptsJSON(r) {
+		return formatJSON
+	}
+	return def
+}
+
+// acceptsJSON reports whether the Accept header lists application/json with a
+// non-zero quality
+func acceptsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != "application/json" {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeGIF writes the tracking pixel with headers that prevent caching
+func writeGIF(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, proxy-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.WriteHeader(status)
+	w.Write(transparentGIF)
+}
+
+// writeError renders apiErr in the negotiated format. def is the format used
+// when the client does not ask for JSON.
+func writeError(w http.ResponseWriter, r *http.Request, def responseFormat, apiErr *APIError) {
+	switch negotiateFormat(r, def) {
+	case formatJSON:
+		writeJSON(w, apiErr.Status, map[string]*APIError{"error": apiErr})
+	case formatGIF:
+		w.Header().Set("X-Nyla-Error", apiErr.Code)
+		writeGIF(w, apiErr.Status)
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(apiErr.Status)
+		w.Write([]byte(errorFragment(apiErr).Render()))
+	}
+}
+
+// errorFragment renders the dismissable error-message HTML fragment
+func errorFragment(apiErr *APIError) *elem.Element {
+	return elem.Div(attrs.Props{attrs.Class: "error-message", attrs.Role: "alert"},
+		elem.H4(nil, elem.Text(errorTitle(apiErr.Status))),
+		elem.P(nil, elem.Text(apiErr.Message)),
+		elem.Button(attrs.Props{"onclick": "this.parentElement.remove()"}, elem.Text("Dismiss")),
+	)
+}
+
+// errorTitle returns the heading shown in HTML error fragments
+func errorTitle(status int) string {
+	switch {
+	case status == http.StatusBadRequest:
+		return "Invalid Request"
+	case status >= 500:
+		return "Server Error"
+	default:
+		return http.StatusText(status)
+	}
+}
+
+// invalidSiteIDError is returned when a request names a site other than the
+// single default site
+func invalidSiteIDError() *APIError {
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    ErrCodeInvalidSiteID,
+		Message: "Invalid site_id. This instance only supports site_id='default'",
+		Details: map[string]interface{}{
+			"field":  "site_id",
+			"reason": "must be 'default'",
+		},
+	}
+}
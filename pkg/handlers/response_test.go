@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		def      responseFormat
+		expected responseFormat
+	}{
+		{"No Accept header", "", formatGIF, formatGIF},
+		{"Browser image request", "image/avif,image/webp,image/apng,*/*;q=0.8", formatGIF, formatGIF},
+		{"JSON requested", "application/json", formatGIF, formatJSON},
+		{"JSON among others", "text/html, application/json;q=0.9", formatHTML, formatJSON},
+		{"JSON explicitly refused", "application/json;q=0", formatHTML, formatHTML},
+		{"HTMX request", "text/html, */*", formatHTML, formatHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			assert.Equal(t, tt.expected, negotiateFormat(req, tt.def))
+		})
+	}
+}
+
+func TestWriteErrorHTMLFragment(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/stats/realtime", nil)
+	rec := httptest.NewRecorder()
+
+	writeError(rec, req, formatHTML, &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    ErrCodeInvalidRequest,
+		Message: "The timestamp format is invalid",
+	})
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `class="error-message"`)
+	assert.Contains(t, rec.Body.String(), `role="alert"`)
+	assert.Contains(t, rec.Body.String(), "<h4>Invalid Request</h4>")
+	assert.Contains(t, rec.Body.String(), "The timestamp format is invalid")
+}
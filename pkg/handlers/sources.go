@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/referrer"
+)
+
+// SourcesResponse is the JSON form of GET /api/v1/stats/sources and
+// GET /api/v1/stats/channels
+type SourcesResponse struct {
+	From    time.Time                  `json:"from"`
+	To      time.Time                  `json:"to"`
+	Channel string                     `json:"channel,omitempty"`
+	GoalID  int64                      `json:"goal_id,omitempty"`
+	Values  []storage.AttributionStats `json:"values"`
+}
+
+// GetStatsSourcesV1 lists the sources of sessions started in the from/to
+// range, most visitors first. The channel parameter keeps one channel and
+// conversions count any goal unless the goal parameter names one.
+func (h *Handlers) GetStatsSourcesV1(w http.ResponseWriter, r *http.Request) {
+	channel := r.URL.Query().Get("channel")
+	if channel != "" && !referrer.ValidChannel(channel) {
+		writeError(w, r, formatHTML, invalidParamError("channel",
+			"must be direct, search, social, email or referral"))
+		return
+	}
+
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	goalID, apiErr := parseGoalParam(r)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetSourceStats(r.Context(), storage.SourceQuery{
+		From:    from,
+		To:      to,
+		Channel: channel,
+		GoalID:  goalID,
+		Limit:   limit,
+	})
+	if errors.Is(err, storage.ErrGoalNotFound) {
+		writeError(w, r, formatHTML, goalNotFoundError())
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting source stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load sources",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, SourcesResponse{
+			From: from, To: to, Channel: channel, GoalID: goalID, Values: stats,
+		})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		source := s.Value
+		if source == "" {
+			source = "Direct"
+		}
+		rows = append(rows, append([]string{source, capitalize(s.Channel)}, attributionCells(s)...))
+	}
+	headers := []string{"Source", "Channel", "Visitors", "Pageviews", "Conversions", "Conversion Rate"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable(headers, rows, "No visits").Render()))
+}
+
+// GetStatsChannelsV1 groups sessions started in the from/to range by
+// channel. Conversions count any goal unless the goal parameter names one.
+func (h *Handlers) GetStatsChannelsV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	goalID, apiErr := parseGoalParam(r)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetChannelStats(r.Context(), from, to, goalID)
+	if errors.Is(err, storage.ErrGoalNotFound) {
+		writeError(w, r, formatHTML, goalNotFoundError())
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting channel stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load channels",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, SourcesResponse{From: from, To: to, GoalID: goalID, Values: stats})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		rows = append(rows, append([]string{capitalize(s.Value)}, attributionCells(s)...))
+	}
+	headers := []string{"Channel", "Visitors", "Pageviews", "Conversions", "Conversion Rate"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable(headers, rows, "No visits").Render()))
+}
+
+// attributionCells renders the numeric columns of an attribution report
+func attributionCells(s storage.AttributionStats) []string {
+	return []string{
+		formatNumber(s.Visitors),
+		formatNumber(s.Pageviews),
+		formatNumber(s.Conversions),
+		formatPercent(s.ConversionRate),
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStatsSourcesV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	// Collect hits from three visitors so referrers are classified at ingestion
+	collect := func(userAgent, page, referrer string) {
+		q := url.Values{"url": {page}, "referrer": {referrer}}
+		req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		handlers.GetCollectV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	collect("visitor-a", "https://example.com/", "https://www.google.com/search?q=nyla")
+	collect("visitor-a", "https://example.com/pricing", "https://example.com/")
+	collect("visitor-b", "https://example.com/", "https://t.co/abc")
+	collect("visitor-c", "https://example.com/", "")
+
+	// Hits are stored to the second, so end the range after them
+	to := "to=" + time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	get := func(path, query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path+"?"+to+"&"+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		if path == "/api/v1/stats/channels" {
+			handlers.GetStatsChannelsV1(rec, req)
+		} else {
+			handlers.GetStatsSourcesV1(rec, req)
+		}
+		return rec
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		rec := get("/api/v1/stats/sources", "", "application/json")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response SourcesResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Len(t, response.Values, 3)
+		assert.Equal(t, "Google", response.Values[1].Value)
+		assert.Equal(t, "search", response.Values[1].Channel)
+		assert.Equal(t, 2, response.Values[1].Pageviews, "Internal referrers keep the session's source")
+	})
+
+	t.Run("Channel filter", func(t *testing.T) {
+		rec := get("/api/v1/stats/sources", "channel=social", "application/json")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response SourcesResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, "social", response.Channel)
+		require.Len(t, response.Values, 1)
+		assert.Equal(t, "Twitter", response.Values[0].Value)
+	})
+
+	t.Run("HTML table", func(t *testing.T) {
+		rec := get("/api/v1/stats/sources", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `class="analytics-table`)
+		assert.Contains(t, body, "Direct")
+		assert.Contains(t, body, "Social")
+	})
+
+	t.Run("Channels", func(t *testing.T) {
+		rec := get("/api/v1/stats/channels", "", "application/json")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response SourcesResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		require.Len(t, response.Values, 3)
+		for _, v := range response.Values {
+			assert.Equal(t, 1, v.Visitors)
+		}
+	})
+
+	t.Run("Unknown channel", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/stats/sources", "channel=paid", "").Code)
+	})
+
+	t.Run("Unknown goal", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/v1/stats/channels", "goal=42", "application/json").Code)
+	})
+}
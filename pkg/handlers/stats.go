@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+// defaultHistoricalRange is the window used when from is omitted
+const defaultHistoricalRange = 30 * 24 * time.Hour
+
+// GetStatsHistoricalV1 returns a time series of pageviews, unique visitors,
+// sessions and session engagement. It renders an HTML table (or chart with format=chart) by default
+// and JSON when requested via the Accept header.
+func (h *Handlers) GetStatsHistoricalV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	resolution := storage.ResolutionDay
+	if v := r.URL.Query().Get("resolution"); v != "" {
+		res, err := storage.ParseResolution(v)
+		if err != nil {
+			writeError(w, r, formatHTML, invalidParamError("resolution", err.Error()))
+			return
+		}
+		resolution = res
+	}
+
+	if n := resolution.BucketCount(from, to); n > storage.MaxTimeSeriesBuckets {
+		writeError(w, r, formatHTML, invalidParamError("resolution",
+			fmt.Sprintf("range spans more than %d %s buckets; use a coarser resolution", storage.MaxTimeSeriesBuckets, resolution)))
+		return
+	}
+
+	chartFormat := false
+	switch r.URL.Query().Get("format") {
+	case "", "table":
+	case "chart":
+		chartFormat = true
+	default:
+		writeError(w, r, formatHTML, invalidParamError("format", "must be table or chart"))
+		return
+	}
+
+	stats, err := h.DB.GetHistoricalStats(r.Context(), from, to, resolution)
+	if err != nil {
+		log.Printf("Error getting historical stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load historical stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, stats)
+		return
+	}
+
+	var fragment *elem.Element
+	if chartFormat {
+		fragment = historicalChart(stats)
+	} else {
+		fragment = historicalTable(stats)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(fragment.Render()))
+}
+
+// EngagementStats summarises sessions over a time range
+type EngagementStats struct {
+	From               time.Time `json:"from"`
+	To                 time.Time `json:"to"`
+	Sessions           int       `json:"sessions"`
+	BounceRate         float64   `json:"bounce_rate"`
+	AvgSessionDuration float64   `json:"avg_session_duration"`
+	PagesPerSession    float64   `json:"pages_per_session"`
+}
+
+// GetStatsEngagementV1 returns bounce rate, average session duration and
+// pages per session over the from/to range. It renders metric cards by
+// default and JSON when requested via the Accept header.
+func (h *Handlers) GetStatsEngagementV1(w http.ResponseWriter, r *http.Request) {
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	// Monthly buckets keep the query cheap; only the totals are used
+	stats, err := h.DB.GetHistoricalStats(r.Context(), from, to, storage.ResolutionMonth)
+	if err != nil {
+		log.Printf("Error getting engagement stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load engagement stats",
+		})
+		return
+	}
+
+	engagement := EngagementStats{
+		From:               from,
+		To:                 to,
+		Sessions:           stats.Totals.Sessions,
+		BounceRate:         stats.Totals.BounceRate,
+		AvgSessionDuration: stats.Totals.AvgSessionDuration,
+		PagesPerSession:    stats.Totals.PagesPerSession,
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, engagement)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(engagementCards(engagement).Render()))
+}
+
+// parseTimeRange reads the from/to query parameters. Both accept RFC3339
+// timestamps or YYYY-MM-DD dates; a date-only to is inclusive of that day.
+// to defaults to now and from to 30 days before to.
+func parseTimeRange(r *http.Request, now time.Time) (time.Time, time.Time, *APIError) {
+	to := now
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, dateOnly, err := parseTimeParam(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, invalidParamError("to", err.Error())
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultHistoricalRange)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, _, err := parseTimeParam(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, invalidParamError("from", err.Error())
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, invalidParamError("from", "from must be before to")
+	}
+	return from, to, nil
+}
+
+// parseLimitParam reads the limit query parameter, defaulting to def and
+// capped at max
+func parseLimitParam(r *http.Request, def, max int) (int, *APIError) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, invalidParamError("limit", "must be a positive integer")
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
+
+// parseTimeParam parses an RFC3339 timestamp or a YYYY-MM-DD date in UTC
+func parseTimeParam(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid datetime format")
+}
+
+// invalidParamError reports a bad query parameter
+func invalidParamError(field, reason string) *APIError {
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    ErrCodeInvalidRequest,
+		Message: fmt.Sprintf("Invalid %s parameter: %s", field, reason),
+		Details: map[string]interface{}{
+			"field":  field,
+			"reason": reason,
+		},
+	}
+}
+
+// bucketLabel formats a bucket start for display
+func bucketLabel(t time.Time, resolution storage.Resolution) string {
+	switch resolution {
+	case storage.ResolutionHour:
+		return t.Format("2006-01-02 15:00")
+	case storage.ResolutionMonth:
+		return t.Format("2006-01")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// formatNumber renders n with thousands separators
+func formatNumber(n int) string {
+	if n < 0 {
+		return "-" + formatNumber(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// capitalize upper-cases the first letter of a lowercase name, such as a
+// channel or device type, for display
+func capitalize(name string) string {
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// formatPercent renders a 0-1 ratio as a whole percentage
+func formatPercent(ratio float64) string {
+	return fmt.Sprintf("%.0f%%", ratio*100)
+}
+
+// formatDuration renders seconds as minutes and seconds, e.g. "2m 05s"
+func formatDuration(seconds float64) string {
+	d := time.Duration(seconds+0.5) * time.Second
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	return fmt.Sprintf("%dm %02ds", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// metricCard renders a single dashboard metric
+func metricCard(label, value string) *elem.Element {
+	return elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
+		elem.Div(attrs.Props{attrs.Class: "text-sm text-gray-500"}, elem.Text(label)),
+		elem.Div(attrs.Props{attrs.Class: "text-2xl font-bold text-indigo-700 mt-2"}, elem.Text(value)),
+	)
+}
+
+// engagementCards renders the engagement metrics as a row of cards
+func engagementCards(stats EngagementStats) *elem.Element {
+	return elem.Div(attrs.Props{attrs.Class: "engagement-cards grid grid-cols-1 md:grid-cols-3 gap-6"},
+		metricCard("Bounce Rate", formatPercent(stats.BounceRate)),
+		metricCard("Avg. Session Duration", formatDuration(stats.AvgSessionDuration)),
+		metricCard("Pages / Session", fmt.Sprintf("%.1f", stats.PagesPerSession)),
+	)
+}
+
+// historicalTable renders the time series as an analytics table
+func historicalTable(stats *storage.HistoricalStats) *elem.Element {
+	rows := make([]elem.Node, 0, len(stats.Points))
+	for _, p := range stats.Points {
+		rows = append(rows, elem.Tr(nil,
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2"}, elem.Text(bucketLabel(p.Start, stats.Resolution))),
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatNumber(p.Pageviews))),
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatNumber(p.UniqueVisitors))),
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatNumber(p.Sessions))),
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatPercent(p.BounceRate))),
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatDuration(p.AvgSessionDuration))),
+			elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(fmt.Sprintf("%.1f", p.PagesPerSession))),
+		))
+	}
+
+	return elem.Table(attrs.Props{attrs.Class: "analytics-table w-full text-sm"},
+		elem.THead(attrs.Props{attrs.Class: "text-gray-500 border-b"},
+			elem.Tr(nil,
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-left"}, elem.Text("Date")),
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text("Pageviews")),
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text("Visitors")),
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text("Sessions")),
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text("Bounce Rate")),
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text("Avg. Duration")),
+				elem.Th(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text("Pages / Session")),
+			),
+		),
+		elem.TBody(nil, rows...),
+		elem.TFoot(attrs.Props{attrs.Class: "font-semibold border-t"},
+			elem.Tr(nil,
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2"}, elem.Text("Total")),
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatNumber(stats.Totals.Pageviews))),
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatNumber(stats.Totals.UniqueVisitors))),
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatNumber(stats.Totals.Sessions))),
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatPercent(stats.Totals.BounceRate))),
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(formatDuration(stats.Totals.AvgSessionDuration))),
+				elem.Td(attrs.Props{attrs.Class: "px-4 py-2 text-right"}, elem.Text(fmt.Sprintf("%.1f", stats.Totals.PagesPerSession))),
+			),
+		),
+	)
+}
+
+// historicalChart renders pageviews as a bar chart built from plain divs so
+// the dashboard needs no charting library
+func historicalChart(stats *storage.HistoricalStats) *elem.Element {
+	max := 0
+	for _, p := range stats.Points {
+		if p.Pageviews > max {
+			max = p.Pageviews
+		}
+	}
+
+	bars := make([]elem.Node, 0, len(stats.Points))
+	for _, p := range stats.Points {
+		height := 0
+		if max > 0 {
+			height = p.Pageviews * 100 / max
+		}
+		label := fmt.Sprintf("%s: %s pageviews, %s visitors",
+			bucketLabel(p.Start, stats.Resolution), formatNumber(p.Pageviews), formatNumber(p.UniqueVisitors))
+		bars = append(bars, elem.Div(attrs.Props{
+			attrs.Class: "flex-1 bg-indigo-500 hover:bg-indigo-700 rounded-t",
+			attrs.Style: fmt.Sprintf("height: %d%%", height),
+			attrs.Title: label,
+		}))
+	}
+
+	return elem.Div(attrs.Props{attrs.Class: "analytics-chart w-full h-full flex flex-col"},
+		elem.Div(attrs.Props{attrs.Class: "text-sm text-gray-500 mb-2"},
+			elem.Text(fmt.Sprintf("Pageviews per %s", stats.Resolution))),
+		elem.Div(attrs.Props{attrs.Class: "flex-1 flex items-end gap-1", "role": "img", "aria-label": "Pageviews chart"},
+			bars...),
+	)
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+)
+
+func TestGetStatsHistoricalV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	tests := []struct {
+		name           string
+		query          string
+		accept         string
+		expectedStatus int
+		contains       string
+	}{
+		{
+			name:           "Default table",
+			query:          "from=2024-03-01&to=2024-03-07",
+			expectedStatus: http.StatusOK,
+			contains:       `class="analytics-table`,
+		},
+		{
+			name:           "Chart format",
+			query:          "from=2024-03-01&to=2024-03-07&format=chart",
+			expectedStatus: http.StatusOK,
+			contains:       `class="analytics-chart`,
+		},
+		{
+			name:           "Invalid resolution",
+			query:          "resolution=year",
+			expectedStatus: http.StatusBadRequest,
+			contains:       "error-message",
+		},
+		{
+			name:           "Invalid timestamp",
+			query:          "from=last-week",
+			expectedStatus: http.StatusBadRequest,
+			contains:       "error-message",
+		},
+		{
+			name:           "Too many buckets",
+			query:          "from=2020-01-01&to=2024-01-01&resolution=hour",
+			expectedStatus: http.StatusBadRequest,
+			contains:       "coarser resolution",
+		},
+		{
+			name:           "JSON error",
+			query:          "from=2024-03-07&to=2024-03-01",
+			accept:         "application/json",
+			expectedStatus: http.StatusBadRequest,
+			contains:       `"code":"invalid_request"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/stats/historical?"+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+
+			handlers.GetStatsHistoricalV1(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.contains)
+		})
+	}
+}
+
+func TestGetStatsHistoricalV1_JSON(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	req := httptest.NewRequest("GET", "/api/v1/stats/historical?from=2024-03-01&to=2024-03-07&resolution=day", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+
+	handlers.GetStatsHistoricalV1(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var stats storage.HistoricalStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, storage.ResolutionDay, stats.Resolution)
+	assert.Len(t, stats.Points, 7, "Date-only to should include that day")
+}
+
+func TestGetStatsEngagementV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	day := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.InsertEvents(context.Background(), []*storage.Event{
+		{Type: storage.EventTypePageview, Timestamp: day, URL: "/", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypePageview, Timestamp: day.Add(time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypeEngagement, Timestamp: day.Add(3 * time.Minute), URL: "/docs", SessionID: "a", VisitorID: "a"},
+		{Type: storage.EventTypePageview, Timestamp: day, URL: "/", SessionID: "b", VisitorID: "b"},
+	}))
+
+	t.Run("JSON", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/engagement?from=2024-03-01&to=2024-03-07", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsEngagementV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		var stats EngagementStats
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+		assert.Equal(t, 2, stats.Sessions)
+		assert.InDelta(t, 0.5, stats.BounceRate, 0.001)
+		assert.InDelta(t, 90, stats.AvgSessionDuration, 0.001, "Sessions of 180s and 0s")
+		assert.InDelta(t, 1.5, stats.PagesPerSession, 0.001)
+	})
+
+	t.Run("HTML cards", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/engagement?from=2024-03-01&to=2024-03-07", nil)
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsEngagementV1(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `class="engagement-cards`)
+		assert.Contains(t, body, "50%")
+		assert.Contains(t, body, "1m 30s")
+		assert.Contains(t, body, "1.5")
+	})
+
+	t.Run("Invalid range", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/stats/engagement?from=2024-03-07&to=2024-03-01", nil)
+		rec := httptest.NewRecorder()
+
+		handlers.GetStatsEngagementV1(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "0s", formatDuration(0))
+	assert.Equal(t, "45s", formatDuration(44.6))
+	assert.Equal(t, "2m 05s", formatDuration(125))
+	assert.Equal(t, "61m 00s", formatDuration(3660))
+}
+
+func TestFormatNumber(t *testing.T) {
+	assert.Equal(t, "0", formatNumber(0))
+	assert.Equal(t, "999", formatNumber(999))
+	assert.Equal(t, "1,234", formatNumber(1234))
+	assert.Equal(t, "1,234,567", formatNumber(1234567))
+	assert.Equal(t, "-1,000", formatNumber(-1000))
+}
@@ -12,8 +12,147 @@ type UIHandlers struct {
 	APIBaseURL string
 }
 
+// navLink is an entry in the dashboard sidebar
+type navLink struct {
+	Label string
+	Href  string
+}
+
+// sidebarLinks are the dashboard pages, in sidebar order
+var sidebarLinks = []navLink{
+	{Label: "Overview", Href: "/"},
+	{Label: "Funnels", Href: "/funnels"},
+	{Label: "Pages", Href: "#"},
+	{Label: "Visitors", Href: "#"},
+	{Label: "Settings", Href: "#"},
+}
+
 func (h *UIHandlers) DashboardHandler(w http.ResponseWriter, r *http.Request) {
 	statsURL := h.APIBaseURL + "/v1/stats/realtime"
+	chartURL := h.APIBaseURL + "/v1/stats/historical?format=chart"
+	engagementURL := h.APIBaseURL + "/v1/stats/engagement"
+	goalsURL := h.APIBaseURL + "/v1/stats/goals"
+	eventsURL := h.APIBaseURL + "/v1/stats/events"
+	utmURL := h.APIBaseURL + "/v1/stats/utm/"
+	sourcesURL := h.APIBaseURL + "/v1/stats/sources"
+	channelsURL := h.APIBaseURL + "/v1/stats/channels"
+	devicesURL := h.APIBaseURL + "/v1/stats/devices/"
+	countriesURL := h.APIBaseURL + "/v1/stats/countries"
+	botsURL := h.APIBaseURL + "/v1/stats/bots"
+	privacyURL := h.APIBaseURL + "/v1/stats/privacy"
+	writePage(w, "Dashboard", "Overview",
+		elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-3 gap-6 mb-8"},
+			// Metric Cards
+			elem.Div(attrs.Props{
+				attrs.Class:    "bg-white rounded-lg shadow p-6",
+				htmx.HXGet:     statsURL,
+				htmx.HXTrigger: "load, every 30s",
+				htmx.HXSwap:    "innerHTML",
+			},
+				elem.Text("Loading..."),
+			),
+			elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
+				elem.Div(attrs.Props{attrs.Class: "text-sm text-gray-500"}, elem.Text("Unique Visitors")),
+				elem.Div(attrs.Props{attrs.Class: "text-2xl font-bold text-indigo-700 mt-2"}, elem.Text("--")),
+			),
+			elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
+				elem.Div(attrs.Props{attrs.Class: "text-sm text-gray-500"}, elem.Text("Active Users")),
+				elem.Div(attrs.Props{attrs.Class: "text-2xl font-bold text-indigo-700 mt-2"}, elem.Text("--")),
+			),
+		),
+		// Engagement cards (last 30 days)
+		elem.Div(attrs.Props{
+			attrs.Class:    "mb-8",
+			htmx.HXGet:     engagementURL,
+			htmx.HXTrigger: "load, every 5m",
+			htmx.HXSwap:    "innerHTML",
+		},
+			elem.Text("Loading..."),
+		),
+		// Traffic chart (last 30 days)
+		elem.Div(attrs.Props{
+			attrs.Class:    "bg-white rounded-lg shadow p-6 h-64 flex items-center justify-center text-gray-400",
+			htmx.HXGet:     chartURL,
+			htmx.HXTrigger: "load, every 5m",
+			htmx.HXSwap:    "innerHTML",
+		},
+			elem.Text("Loading..."),
+		),
+		// Goals and custom events (last 30 days)
+		elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-2 gap-6 mt-8"},
+			panel("Goals", goalsURL),
+			panel("Custom Events", eventsURL),
+		),
+		// Referrer sources and channels (last 30 days)
+		elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-2 gap-6 mt-8"},
+			panel("Top Sources", sourcesURL),
+			panel("Channels", channelsURL),
+		),
+		// Campaign attribution (last 30 days)
+		elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-3 gap-6 mt-8"},
+			panel("UTM Sources", utmURL+"source"),
+			panel("UTM Mediums", utmURL+"medium"),
+			panel("UTM Campaigns", utmURL+"campaign"),
+		),
+		// Devices, browsers, operating systems and screen sizes (last 30 days)
+		elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-2 gap-6 mt-8"},
+			panel("Devices", devicesURL+"device"),
+			panel("Browsers", devicesURL+"browser"),
+			panel("Operating Systems", devicesURL+"os"),
+			panel("Screen Sizes", devicesURL+"screen"),
+		),
+		// Countries, filtered bot traffic and opted-out hits (last 30 days)
+		elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-2 gap-6 mt-8"},
+			panel("Countries", countriesURL),
+			panel("Bot Traffic", botsURL),
+			panel("Privacy", privacyURL),
+		),
+	)
+}
+
+// FunnelsHandler renders every funnel's results over the last 30 days
+func (h *UIHandlers) FunnelsHandler(w http.ResponseWriter, r *http.Request) {
+	writePage(w, "Funnels", "Funnels",
+		elem.Div(attrs.Props{
+			htmx.HXGet:     h.APIBaseURL + "/v1/stats/funnels",
+			htmx.HXTrigger: "load, every 5m",
+			htmx.HXSwap:    "innerHTML",
+		},
+			elem.Text("Loading..."),
+		),
+	)
+}
+
+// panel renders a titled dashboard card whose body is loaded from url
+func panel(title, url string) *elem.Element {
+	return elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
+		elem.H2(attrs.Props{attrs.Class: "text-lg font-semibold mb-4 text-gray-900"}, elem.Text(title)),
+		elem.Div(attrs.Props{
+			htmx.HXGet:     url,
+			htmx.HXTrigger: "load, every 5m",
+			htmx.HXSwap:    "innerHTML",
+		},
+			elem.Text("Loading..."),
+		),
+	)
+}
+
+// writePage renders a dashboard page with the shared header and sidebar.
+// active is the label of the sidebar link to highlight.
+func writePage(w http.ResponseWriter, title, active string, content ...elem.Node) {
+	links := make([]elem.Node, 0, len(sidebarLinks))
+	for _, link := range sidebarLinks {
+		class := "block text-gray-600 hover:text-indigo-700"
+		if link.Label == active {
+			class = "block text-indigo-700 font-semibold"
+		}
+		links = append(links, elem.A(attrs.Props{attrs.Href: link.Href, attrs.Class: class}, elem.Text(link.Label)))
+	}
+
+	main := append([]elem.Node{
+		elem.H1(attrs.Props{attrs.Class: "text-3xl font-bold mb-6 text-gray-900"}, elem.Text(title)),
+	}, content...)
+
 	html := elem.Html(attrs.Props{attrs.Lang: "en"},
 		elem.Head(nil,
 			elem.Meta(attrs.Props{attrs.Charset: "UTF-8"}),
@@ -21,7 +160,7 @@ func (h *UIHandlers) DashboardHandler(w http.ResponseWriter, r *http.Request) {
 				attrs.Name:    "viewport",
 				attrs.Content: "width=device-width, initial-scale=1.0",
 			}),
-			elem.Title(nil, elem.Text("Nyla Analytics Dashboard")),
+			elem.Title(nil, elem.Text("Nyla Analytics "+title)),
 			elem.Script(attrs.Props{attrs.Src: "https://unpkg.com/htmx.org@1.9.12"}),
 			elem.Script(attrs.Props{attrs.Src: "https://cdn.jsdelivr.net/npm/@tailwindcss/browser@4"}),
 		),
@@ -30,7 +169,7 @@ func (h *UIHandlers) DashboardHandler(w http.ResponseWriter, r *http.Request) {
 			elem.Header(attrs.Props{attrs.Class: "bg-white shadow px-6 py-4 flex items-center justify-between"},
 				elem.Div(attrs.Props{attrs.Class: "text-2xl font-bold text-indigo-700"}, elem.Text("Nyla Analytics")),
 				elem.Nav(nil,
-					elem.A(attrs.Props{attrs.Href: "#", attrs.Class: "text-gray-600 hover:text-indigo-700 px-3"}, elem.Text("Dashboard")),
+					elem.A(attrs.Props{attrs.Href: "/", attrs.Class: "text-gray-600 hover:text-indigo-700 px-3"}, elem.Text("Dashboard")),
 					elem.A(attrs.Props{attrs.Href: "#", attrs.Class: "text-gray-600 hover:text-indigo-700 px-3"}, elem.Text("Settings")),
 				),
 			),
@@ -38,40 +177,10 @@ func (h *UIHandlers) DashboardHandler(w http.ResponseWriter, r *http.Request) {
 			elem.Div(attrs.Props{attrs.Class: "flex"},
 				// Sidebar
 				elem.Aside(attrs.Props{attrs.Class: "w-64 bg-white border-r min-h-screen p-6 hidden md:block"},
-					elem.Nav(attrs.Props{attrs.Class: "space-y-4"},
-						elem.A(attrs.Props{attrs.Href: "#", attrs.Class: "block text-indigo-700 font-semibold"}, elem.Text("Overview")),
-						elem.A(attrs.Props{attrs.Href: "#", attrs.Class: "block text-gray-600 hover:text-indigo-700"}, elem.Text("Pages")),
-						elem.A(attrs.Props{attrs.Href: "#", attrs.Class: "block text-gray-600 hover:text-indigo-700"}, elem.Text("Visitors")),
-						elem.A(attrs.Props{attrs.Href: "#", attrs.Class: "block text-gray-600 hover:text-indigo-700"}, elem.Text("Settings")),
-					),
+					elem.Nav(attrs.Props{attrs.Class: "space-y-4"}, links...),
 				),
 				// Main content
-				elem.Main(attrs.Props{attrs.Class: "flex-1 p-8"},
-					elem.H1(attrs.Props{attrs.Class: "text-3xl font-bold mb-6 text-gray-900"}, elem.Text("Dashboard")),
-					elem.Div(attrs.Props{attrs.Class: "grid grid-cols-1 md:grid-cols-3 gap-6 mb-8"},
-						// Metric Cards
-						elem.Div(attrs.Props{
-							attrs.Class:    "bg-white rounded-lg shadow p-6",
-							htmx.HXGet:     statsURL,
-							htmx.HXTrigger: "load, every 30s",
-							htmx.HXSwap:    "innerHTML",
-						},
-							elem.Text("Loading..."),
-						),
-						elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
-							elem.Div(attrs.Props{attrs.Class: "text-sm text-gray-500"}, elem.Text("Unique Visitors")),
-							elem.Div(attrs.Props{attrs.Class: "text-2xl font-bold text-indigo-700 mt-2"}, elem.Text("--")),
-						),
-						elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6"},
-							elem.Div(attrs.Props{attrs.Class: "text-sm text-gray-500"}, elem.Text("Active Users")),
-							elem.Div(attrs.Props{attrs.Class: "text-2xl font-bold text-indigo-700 mt-2"}, elem.Text("--")),
-						),
-					),
-					// Chart placeholder
-					elem.Div(attrs.Props{attrs.Class: "bg-white rounded-lg shadow p-6 h-64 flex items-center justify-center text-gray-400"},
-						elem.Text("[Traffic Chart Placeholder]"),
-					),
-				),
+				elem.Main(attrs.Props{attrs.Class: "flex-1 p-8"}, main...),
 			),
 		),
 	).Render()
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sunwolfengineering/nyla-core/internal/storage"
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// utmHeadings label the value column of each UTM breakdown
+var utmHeadings = map[string]string{
+	utm.FieldSource:   "Source",
+	utm.FieldMedium:   "Medium",
+	utm.FieldCampaign: "Campaign",
+	utm.FieldTerm:     "Term",
+	utm.FieldContent:  "Content",
+}
+
+// UTMStatsResponse is the JSON form of GET /api/v1/stats/utm/{dimension}
+type UTMStatsResponse struct {
+	From      time.Time                  `json:"from"`
+	To        time.Time                  `json:"to"`
+	Dimension string                     `json:"dimension"`
+	GoalID    int64                      `json:"goal_id,omitempty"`
+	Values    []storage.AttributionStats `json:"values"`
+}
+
+// GetStatsUTMV1 breaks down sessions started in the from/to range by the UTM
+// parameter in the path (source, medium, campaign, term or content) of their
+// entry page. Conversions count any goal unless the goal parameter names one.
+func (h *Handlers) GetStatsUTMV1(w http.ResponseWriter, r *http.Request) {
+	dimension := r.PathValue("dimension")
+	if !storage.ValidUTMDimension(dimension) {
+		writeError(w, r, formatHTML, invalidParamError("dimension",
+			"must be source, medium, campaign, term or content"))
+		return
+	}
+
+	from, to, apiErr := parseTimeRange(r, time.Now())
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	limit, apiErr := parseLimitParam(r, defaultBreakdownLimit, maxBreakdownLimit)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+	goalID, apiErr := parseGoalParam(r)
+	if apiErr != nil {
+		writeError(w, r, formatHTML, apiErr)
+		return
+	}
+
+	stats, err := h.DB.GetUTMStats(r.Context(), storage.UTMQuery{
+		Dimension: dimension,
+		From:      from,
+		To:        to,
+		GoalID:    goalID,
+		Limit:     limit,
+	})
+	if errors.Is(err, storage.ErrGoalNotFound) {
+		writeError(w, r, formatHTML, goalNotFoundError())
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting UTM stats: %v", err)
+		writeError(w, r, formatHTML, &APIError{
+			Status:  http.StatusInternalServerError,
+			Code:    ErrCodeInternal,
+			Message: "Failed to load UTM stats",
+		})
+		return
+	}
+
+	if negotiateFormat(r, formatHTML) == formatJSON {
+		writeJSON(w, http.StatusOK, UTMStatsResponse{
+			From: from, To: to, Dimension: dimension, GoalID: goalID, Values: stats,
+		})
+		return
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, s := range stats {
+		rows = append(rows, []string{
+			s.Value,
+			formatNumber(s.Visitors),
+			formatNumber(s.Pageviews),
+			formatNumber(s.Conversions),
+			formatPercent(s.ConversionRate),
+		})
+	}
+	headers := []string{utmHeadings[dimension], "Visitors", "Pageviews", "Conversions", "Conversion Rate"}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(breakdownTable(headers, rows, "No campaign traffic").Render()))
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: GPL-3.0-only
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStatsUTMV1(t *testing.T) {
+	handlers, db := setupTestHandlers(t)
+	defer db.Close()
+
+	// Collect hits from two visitors so UTM parameters are parsed at ingestion
+	collect := func(userAgent, page string) {
+		q := url.Values{"url": {page}}
+		req := httptest.NewRequest("GET", "/api/v1/collect?"+q.Encode(), nil)
+		req.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		handlers.GetCollectV1(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	collect("visitor-a", "https://example.com/?utm_source=news&utm_medium=email&utm_campaign=spring")
+	collect("visitor-a", "https://example.com/pricing")
+	collect("visitor-b", "https://example.com/?ref=<b>hunt</b>")
+
+	// Hits are stored to the second, so end the range after them
+	to := "to=" + time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	stats := func(dimension, query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/stats/utm/"+dimension+"?"+to+"&"+query, nil)
+		req.SetPathValue("dimension", dimension)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handlers.GetStatsUTMV1(rec, req)
+		return rec
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		rec := stats("source", "", "application/json")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response UTMStatsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, "source", response.Dimension)
+		require.Len(t, response.Values, 2)
+		assert.Equal(t, "<b>hunt</b>", response.Values[0].Value)
+		assert.Equal(t, "news", response.Values[1].Value)
+		assert.Equal(t, 2, response.Values[1].Pageviews)
+	})
+
+	t.Run("HTML table", func(t *testing.T) {
+		rec := stats("campaign", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `class="analytics-table`)
+		assert.Contains(t, body, "Campaign")
+		assert.Contains(t, body, "spring")
+	})
+
+	t.Run("Escapes values", func(t *testing.T) {
+		rec := stats("source", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "<b>hunt")
+		assert.Contains(t, rec.Body.String(), "&lt;b&gt;hunt")
+	})
+
+	t.Run("Unknown dimension", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, stats("referrer", "", "").Code)
+	})
+
+	t.Run("Invalid goal", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, stats("source", "goal=abc", "").Code)
+	})
+
+	t.Run("Unknown goal", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, stats("source", "goal=42", "application/json").Code)
+	})
+}
@@ -1,28 +1,28 @@
 package hash
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"time"
+	"errors"
+	"strings"
 )
 
-// generateSalt generates a salt string based on the given IP and site ID.
-func GenerateSalt(ip, siteID string) string {
-	currentDate := time.Now().Format("20060102")
-	return ip + "_" + siteID + "_" + currentDate
-}
-
 // GeneratePrivateIDHash generates a private ID hash based on the given inputs.
-func GeneratePrivateIDHash(ip, userAgent, hostname, siteID string) (string, error) {
-	salt := GenerateSalt(ip, siteID)
-	data := salt + userAgent + hostname + siteID
+// It is an HMAC-SHA256 keyed with a secret daily salt, so the hash can't be
+// recomputed from the inputs alone and can't be linked back to a visitor once
+// the salt is destroyed.
+func GeneratePrivateIDHash(salt []byte, ip, userAgent, hostname, siteID string) (string, error) {
+	if len(salt) == 0 {
+		return "", errors.New("salt must not be empty")
+	}
 
-	hasher := sha256.New()
-	_, err := hasher.Write([]byte(data))
-	if err != nil {
+	mac := hmac.New(sha256.New, salt)
+	// Separate fields so different inputs can't concatenate to the same data
+	data := strings.Join([]string{ip, userAgent, hostname, siteID}, "\x00")
+	if _, err := mac.Write([]byte(data)); err != nil {
 		return "", err
 	}
 
-	hash := hex.EncodeToString(hasher.Sum(nil))
-	return hash, nil
+	return hex.EncodeToString(mac.Sum(nil)), nil
 }
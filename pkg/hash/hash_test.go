@@ -0,0 +1,31 @@
+package hash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePrivateIDHash(t *testing.T) {
+	salt := []byte("0123456789abcdef0123456789abcdef")
+
+	id, err := GeneratePrivateIDHash(salt, "203.0.113.0", "Mozilla/5.0", "example.com", "default")
+	require.NoError(t, err)
+	assert.Len(t, id, 64)
+
+	again, err := GeneratePrivateIDHash(salt, "203.0.113.0", "Mozilla/5.0", "example.com", "default")
+	require.NoError(t, err)
+	assert.Equal(t, id, again, "Same inputs and salt give the same ID")
+
+	otherSalt, err := GeneratePrivateIDHash([]byte("another secret salt"), "203.0.113.0", "Mozilla/5.0", "example.com", "default")
+	require.NoError(t, err)
+	assert.NotEqual(t, id, otherSalt, "A new salt unlinks the ID")
+
+	shifted, err := GeneratePrivateIDHash(salt, "203.0.113.0Mozilla", "/5.0", "example.com", "default")
+	require.NoError(t, err)
+	assert.NotEqual(t, id, shifted, "Field boundaries are part of the hash")
+
+	_, err = GeneratePrivateIDHash(nil, "203.0.113.0", "Mozilla/5.0", "example.com", "default")
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package privacy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ConsentPolicy controls how hits carrying an opt-out signal are handled
+type ConsentPolicy string
+
+const (
+	// ConsentDrop discards opted-out hits; only their number is kept
+	ConsentDrop ConsentPolicy = "drop"
+	// ConsentAnonymize stores opted-out hits without a visitor hash,
+	// session or location, so they count toward page totals only
+	ConsentAnonymize ConsentPolicy = "anonymize"
+	// ConsentIgnore records opted-out hits like any other
+	ConsentIgnore ConsentPolicy = "ignore"
+)
+
+// Opt-out signals, as reported by OptOutSignal
+const (
+	// SignalGPC is Global Privacy Control, sent as Sec-GPC: 1
+	SignalGPC = "gpc"
+	// SignalDNT is Do Not Track, sent as DNT: 1
+	SignalDNT = "dnt"
+)
+
+// ParseConsentPolicy parses a consent policy
+func ParseConsentPolicy(s string) (ConsentPolicy, error) {
+	switch p := ConsentPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case ConsentDrop, ConsentAnonymize, ConsentIgnore:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown consent policy %q (want drop, anonymize or ignore)", s)
+}
+
+// OptOutSignal returns the opt-out signal sent with a request, or an empty
+// string if there is none. GPC is reported when both are sent, as it is the
+// one with legal standing in some jurisdictions.
+func OptOutSignal(h http.Header) string {
+	if strings.TrimSpace(h.Get("Sec-GPC")) == "1" {
+		return SignalGPC
+	}
+	if strings.TrimSpace(h.Get("DNT")) == "1" {
+		return SignalDNT
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package privacy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConsentPolicy(t *testing.T) {
+	for input, expected := range map[string]ConsentPolicy{
+		"drop":       ConsentDrop,
+		" Anonymize": ConsentAnonymize,
+		"IGNORE":     ConsentIgnore,
+	} {
+		p, err := ParseConsentPolicy(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, p, input)
+	}
+
+	_, err := ParseConsentPolicy("")
+	assert.Error(t, err)
+	_, err = ParseConsentPolicy("hash")
+	assert.Error(t, err)
+}
+
+func TestOptOutSignal(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+	}{
+		{"No signal", nil, ""},
+		{"Do Not Track", map[string]string{"DNT": "1"}, SignalDNT},
+		{"Tracking allowed", map[string]string{"DNT": "0"}, ""},
+		{"Global Privacy Control", map[string]string{"Sec-GPC": "1"}, SignalGPC},
+		{"Both prefer GPC", map[string]string{"DNT": "1", "Sec-GPC": "1"}, SignalGPC},
+		{"Unknown GPC value", map[string]string{"Sec-GPC": "true"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			assert.Equal(t, tt.expected, OptOutSignal(h))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Package privacy implements the data minimization applied to hits before
+// they are hashed, looked up or stored
+package privacy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPMode controls how client IPs are anonymized
+type IPMode string
+
+const (
+	// IPModeTruncate zeroes the host part of the address: IPv4 to /24 and
+	// IPv6 to /48
+	IPModeTruncate IPMode = "truncate"
+	// IPModeDrop discards the address entirely
+	IPModeDrop IPMode = "drop"
+	// IPModeNone keeps the full address
+	IPModeNone IPMode = "none"
+)
+
+var (
+	ipv4Mask = net.CIDRMask(24, 32)
+	ipv6Mask = net.CIDRMask(48, 128)
+)
+
+// ParseIPMode parses an IP anonymization mode. The boolean values accepted by
+// earlier releases map to truncate (true) and none (false).
+func ParseIPMode(s string) (IPMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "truncate", "true", "on", "1", "":
+		return IPModeTruncate, nil
+	case "drop":
+		return IPModeDrop, nil
+	case "none", "false", "off", "0":
+		return IPModeNone, nil
+	}
+	return "", fmt.Errorf("unknown IP anonymization mode %q (want truncate, drop or none)", s)
+}
+
+// AnonymizeIP applies mode to ip. IPv4-mapped IPv6 addresses are treated as
+// IPv4 and returned in 4-byte form. It returns nil when the IP is dropped or
+// ip is nil.
+func AnonymizeIP(ip net.IP, mode IPMode) net.IP {
+	if ip == nil {
+		return nil
+	}
+	switch mode {
+	case IPModeNone:
+		return ip
+	case IPModeDrop:
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(ipv4Mask)
+	}
+	return ip.Mask(ipv6Mask)
+}
+
+// AnonymizeIPString is AnonymizeIP for an address in string form. It returns
+// an empty string when the IP is dropped or cannot be parsed.
+func AnonymizeIPString(ip string, mode IPMode) string {
+	anonymized := AnonymizeIP(net.ParseIP(ip), mode)
+	if anonymized == nil {
+		return ""
+	}
+	return anonymized.String()
+}
@@ -0,0 +1,62 @@
+package privacy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		mode     IPMode
+		expected string
+	}{
+		{"IPv4 truncated to /24", "203.0.113.77", IPModeTruncate, "203.0.113.0"},
+		{"IPv6 truncated to /48", "2001:db8:abcd:12:34::1", IPModeTruncate, "2001:db8:abcd::"},
+		{"IPv4-mapped truncated as IPv4", "::ffff:198.51.100.200", IPModeTruncate, "198.51.100.0"},
+		{"Loopback IPv6", "::1", IPModeTruncate, "::"},
+		{"IPv4 dropped", "203.0.113.77", IPModeDrop, ""},
+		{"IPv6 dropped", "2001:db8::1", IPModeDrop, ""},
+		{"IPv4 kept", "203.0.113.77", IPModeNone, "203.0.113.77"},
+		{"IPv6 kept", "2001:db8::1", IPModeNone, "2001:db8::1"},
+		{"Invalid", "not-an-ip", IPModeTruncate, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, AnonymizeIPString(tt.ip, tt.mode))
+		})
+	}
+}
+
+func TestAnonymizeIPDoesNotModifyInput(t *testing.T) {
+	ip := net.ParseIP("203.0.113.77")
+	AnonymizeIP(ip, IPModeTruncate)
+	assert.Equal(t, "203.0.113.77", ip.String())
+}
+
+func TestParseIPMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected IPMode
+	}{
+		{"truncate", IPModeTruncate},
+		{"TRUE", IPModeTruncate},
+		{"", IPModeTruncate},
+		{"drop", IPModeDrop},
+		{"none", IPModeNone},
+		{"false", IPModeNone},
+	}
+	for _, tt := range tests {
+		mode, err := ParseIPMode(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, mode, tt.input)
+	}
+
+	_, err := ParseIPMode("hash")
+	assert.Error(t, err)
+}
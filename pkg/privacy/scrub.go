@@ -0,0 +1,291 @@
+package privacy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// Redacted replaces personal data removed by a Scrubber
+const Redacted = "REDACTED"
+
+// PII patterns a Scrubber can detect
+const (
+	PatternEmail      = "email"
+	PatternPhone      = "phone"
+	PatternCreditCard = "credit_card"
+)
+
+// DefaultQueryDenylist names the query parameters redacted by default
+var DefaultQueryDenylist = []string{"email", "token", "key", "password", "secret", "auth"}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// Card numbers are 13 to 19 digits, optionally grouped by spaces or
+	// dashes, and must pass the Luhn check
+	cardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// Phone numbers are 9 to 15 digits that start with + or are grouped
+	// by spaces, dashes or parentheses. Dots aren't separators, so versions
+	// and IP addresses aren't mistaken for numbers.
+	phonePattern = regexp.MustCompile(`(?:\+|\(|\b)\d[\d ()-]{7,}\d\b`)
+	datePrefix   = regexp.MustCompile(`^\d{4}[-/]\d{1,2}[-/]\d{1,2}`)
+)
+
+// ScrubOptions configures a Scrubber
+type ScrubOptions struct {
+	// Patterns names the detectors to run on paths, query values,
+	// fragments and titles: email, phone and credit_card
+	Patterns []string
+	// QueryDenylist names query parameters whose values are always
+	// redacted. A name matches whole words of a parameter, so key matches
+	// api_key and apiKey but not keyword.
+	QueryDenylist []string
+	// QueryAllowlist names query parameters exempt from the denylist and
+	// kept by StripQuery. Their values are still checked for Patterns.
+	QueryAllowlist []string
+	// StripQuery removes every query parameter except utm_*, the other
+	// source parameters read by utm.FromQuery, such as ref, and the
+	// allowlist
+	StripQuery bool
+}
+
+// Scrubber removes personal data from URLs and titles before they are stored
+type Scrubber struct {
+	email, phone, card bool
+	denied             map[string]bool
+	allowed            map[string]bool
+	stripQuery         bool
+}
+
+// NewScrubber creates a scrubber. Unknown patterns are an error.
+func NewScrubber(opts ScrubOptions) (*Scrubber, error) {
+	s := &Scrubber{
+		denied:     make(map[string]bool),
+		allowed:    make(map[string]bool),
+		stripQuery: opts.StripQuery,
+	}
+	for _, p := range opts.Patterns {
+		switch p {
+		case PatternEmail:
+			s.email = true
+		case PatternPhone:
+			s.phone = true
+		case PatternCreditCard:
+			s.card = true
+		default:
+			return nil, fmt.Errorf("unknown pattern %q (want email, phone or credit_card)", p)
+		}
+	}
+	for _, name := range opts.QueryDenylist {
+		s.denied[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	for _, name := range opts.QueryAllowlist {
+		s.allowed[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return s, nil
+}
+
+// Text redacts the enabled patterns in free text such as a page title
+func (s *Scrubber) Text(text string) string {
+	if text == "" {
+		return text
+	}
+	if s.card {
+		text = cardPattern.ReplaceAllStringFunc(text, func(m string) string {
+			if luhn(m) {
+				return Redacted
+			}
+			return m
+		})
+	}
+	if s.email {
+		text = emailPattern.ReplaceAllString(text, Redacted)
+	}
+	if s.phone {
+		text = phonePattern.ReplaceAllStringFunc(text, func(m string) string {
+			if isPhone(m) {
+				return Redacted
+			}
+			return m
+		})
+	}
+	return text
+}
+
+// URL removes credentials, applies the query denylist and StripQuery, and
+// redacts the enabled patterns in the path, query values and fragment. URLs
+// without personal data are returned unchanged.
+func (s *Scrubber) URL(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return s.Text(raw)
+	}
+
+	changed := false
+	if u.User != nil {
+		u.User = nil
+		changed = true
+	}
+	if path := s.Text(u.Path); path != u.Path {
+		u.Path, u.RawPath = path, ""
+		changed = true
+	}
+	if query := s.query(u.RawQuery, s.stripQuery); query != u.RawQuery {
+		u.RawQuery = query
+		changed = true
+	}
+	if fragment := s.fragment(u.Fragment); fragment != u.Fragment {
+		u.Fragment, u.RawFragment = fragment, ""
+		changed = true
+	}
+	if !changed {
+		return raw
+	}
+	return u.String()
+}
+
+// query scrubs a raw query string, keeping the order and encoding of
+// untouched parameters
+func (s *Scrubber) query(raw string, strip bool) string {
+	if raw == "" {
+		return raw
+	}
+	var kept []string
+	for _, part := range strings.Split(raw, "&") {
+		if part == "" {
+			continue
+		}
+		rawName, rawValue, _ := strings.Cut(part, "=")
+		name := unescape(rawName)
+		allowed := s.allowed[strings.ToLower(name)]
+		if strip && !allowed && !strings.HasPrefix(strings.ToLower(name), "utm_") && !utm.IsSourceParam(name) {
+			continue
+		}
+
+		if scrubbed := s.Text(name); scrubbed != name {
+			rawName = url.QueryEscape(scrubbed)
+			part = rawName
+			if rawValue != "" {
+				part += "=" + rawValue
+			}
+		}
+		if !allowed && s.isDenied(name) {
+			kept = append(kept, rawName+"="+Redacted)
+			continue
+		}
+		if value := unescape(rawValue); value != "" {
+			if scrubbed := s.Text(value); scrubbed != value {
+				part = rawName + "=" + url.QueryEscape(scrubbed)
+			}
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
+
+// fragment scrubs a URL fragment. Fragments holding parameters, such as
+// #access_token=... or #/reset?token=..., get the query denylist too.
+func (s *Scrubber) fragment(fragment string) string {
+	if i := strings.Index(fragment, "?"); i >= 0 {
+		return s.Text(fragment[:i]) + "?" + s.query(fragment[i+1:], false)
+	}
+	if strings.Contains(fragment, "=") {
+		return s.query(fragment, false)
+	}
+	return s.Text(fragment)
+}
+
+// isDenied reports whether a word of the parameter name is on the denylist
+func (s *Scrubber) isDenied(name string) bool {
+	if len(s.denied) == 0 {
+		return false
+	}
+	if s.denied[strings.ToLower(name)] {
+		return true
+	}
+	for _, word := range words(name) {
+		if s.denied[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// words splits a parameter name into lowercase words at punctuation and
+// camelCase boundaries
+func words(name string) []string {
+	var result []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			result = append(result, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]):
+			flush()
+		}
+		current = append(current, r)
+	}
+	flush()
+	return result
+}
+
+// unescape decodes a query component, keeping it as is if malformed
+func unescape(v string) string {
+	if decoded, err := url.QueryUnescape(v); err == nil {
+		return decoded
+	}
+	return v
+}
+
+// digits returns the decimal digits in s
+func digits(s string) []int {
+	var ds []int
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			ds = append(ds, int(r-'0'))
+		}
+	}
+	return ds
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum
+func luhn(s string) bool {
+	ds := digits(s)
+	sum := 0
+	for i := range ds {
+		d := ds[len(ds)-1-i]
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// isPhone reports whether a phone pattern match looks like a phone number
+// rather than a date or a bare number
+func isPhone(m string) bool {
+	n := len(digits(m))
+	if n < 9 || n > 15 || datePrefix.MatchString(m) {
+		return false
+	}
+	return strings.HasPrefix(m, "+") || strings.ContainsAny(m, " ()-")
+}
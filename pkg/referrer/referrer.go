@@ -0,0 +1,230 @@
+// Package referrer classifies where visits come from. Referrer URLs are
+// reduced to a host, self-referrals are dropped and known hosts are mapped
+// to a source name and channel using an embedded list that deployments can
+// extend with their own file.
+package referrer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+// Channels group sources by how visitors arrived
+const (
+	ChannelDirect   = "direct"
+	ChannelSearch   = "search"
+	ChannelSocial   = "social"
+	ChannelEmail    = "email"
+	ChannelReferral = "referral"
+)
+
+// ValidChannel reports whether channel is one of the Channel constants
+func ValidChannel(channel string) bool {
+	switch channel {
+	case ChannelDirect, ChannelSearch, ChannelSocial, ChannelEmail, ChannelReferral:
+		return true
+	}
+	return false
+}
+
+//go:embed sources.json
+var defaultSources []byte
+
+// mediumChannels maps utm_medium values to the channel they imply. A medium
+// takes precedence over the referrer since email clients and apps often send
+// none.
+var mediumChannels = map[string]string{
+	"email":          ChannelEmail,
+	"e-mail":         ChannelEmail,
+	"mail":           ChannelEmail,
+	"newsletter":     ChannelEmail,
+	"social":         ChannelSocial,
+	"social-media":   ChannelSocial,
+	"social-network": ChannelSocial,
+	"organic":        ChannelSearch,
+}
+
+// Source is a known referrer
+type Source struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+}
+
+// List maps referrer hosts, without a www. prefix, to known sources
+type List map[string]Source
+
+// ParseList parses a source list: a JSON object keyed by channel (search,
+// social, email or referral) whose values map source names to their hosts
+func ParseList(data []byte) (List, error) {
+	var raw map[string]map[string][]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse referrer sources: %w", err)
+	}
+
+	list := make(List)
+	for channel, sources := range raw {
+		switch channel {
+		case ChannelSearch, ChannelSocial, ChannelEmail, ChannelReferral:
+		default:
+			return nil, fmt.Errorf("unknown referrer channel %q", channel)
+		}
+		for name, hosts := range sources {
+			for _, host := range hosts {
+				list[normalizeHost(host)] = Source{Name: name, Channel: channel}
+			}
+		}
+	}
+	return list, nil
+}
+
+// DefaultList returns the embedded source list
+func DefaultList() List {
+	list, err := ParseList(defaultSources)
+	if err != nil {
+		panic(err)
+	}
+	return list
+}
+
+// LoadList returns the embedded source list extended with the list in the
+// file at path. Hosts in the file replace embedded entries.
+func LoadList(path string) (List, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read referrer sources: %w", err)
+	}
+	extra, err := ParseList(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	list := DefaultList()
+	for host, source := range extra {
+		list[host] = source
+	}
+	return list, nil
+}
+
+// Lookup returns the source of host or of its closest listed parent domain,
+// so l.facebook.com matches facebook.com
+func (l List) Lookup(host string) (Source, bool) {
+	for host = normalizeHost(host); host != ""; {
+		if source, ok := l[host]; ok {
+			return source, true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return Source{}, false
+}
+
+// Result is the classification of a hit's referrer
+type Result struct {
+	// Host is the referrer's host without www., or empty for direct and
+	// internal hits
+	Host string
+	// Source names a known referrer, falling back to Host and then to the
+	// utm_source. It is empty for direct hits.
+	Source string
+	// Channel is one of the Channel constants
+	Channel string
+	// Internal reports that the referrer was one of the site's own pages
+	// and was dropped
+	Internal bool
+}
+
+// Classifier classifies referrers against a source list and the site's own
+// domains. It is safe for concurrent use.
+type Classifier struct {
+	sources List
+	domains []string
+}
+
+// NewClassifier creates a classifier. Referrers from domains, or their
+// subdomains, are internal.
+func NewClassifier(sources List, domains []string) *Classifier {
+	c := &Classifier{sources: sources}
+	for _, d := range domains {
+		if d = normalizeHost(d); d != "" {
+			c.domains = append(c.domains, d)
+		}
+	}
+	return c
+}
+
+// Classify classifies the referrer of a hit on pageURL. A referrer from the
+// page's own host or one of the site's domains is internal.
+func (c *Classifier) Classify(referrerURL, pageURL string, params utm.Params) Result {
+	var result Result
+	if host := Host(referrerURL); host != "" {
+		if c.IsInternal(host) || host == Host(pageURL) {
+			result.Internal = true
+		} else {
+			result.Host = host
+		}
+	}
+
+	if source, ok := c.sources.Lookup(result.Host); ok {
+		result.Source = source.Name
+		result.Channel = source.Channel
+	} else if result.Host != "" {
+		result.Source = result.Host
+		result.Channel = ChannelReferral
+	} else if params.Source != "" {
+		result.Source = params.Source
+		result.Channel = ChannelReferral
+	} else {
+		result.Channel = ChannelDirect
+	}
+
+	if channel, ok := mediumChannels[strings.ToLower(params.Medium)]; ok {
+		result.Channel = channel
+	}
+	return result
+}
+
+// IsInternal reports whether host is one of the site's domains or a
+// subdomain of one
+func (c *Classifier) IsInternal(host string) bool {
+	host = normalizeHost(host)
+	for _, d := range c.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Host returns the lower-cased host of a referrer URL without port or www.
+// prefix. URLs without a scheme, such as example.com/page, are accepted.
+func Host(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if u.Host == "" && u.Scheme == "" && !strings.HasPrefix(rawURL, "/") {
+		if u, err = url.Parse("//" + rawURL); err != nil {
+			return ""
+		}
+	}
+	return normalizeHost(u.Hostname())
+}
+
+// normalizeHost lower-cases host and strips a www. prefix and trailing dot
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	return strings.TrimPrefix(host, "www.")
+}
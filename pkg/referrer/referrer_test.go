@@ -0,0 +1,159 @@
+package referrer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunwolfengineering/nyla-core/pkg/utm"
+)
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{"Absolute URL", "https://News.YCombinator.com/item?id=1", "news.ycombinator.com"},
+		{"www stripped", "https://www.google.com/", "google.com"},
+		{"Port stripped", "http://example.com:8080/page", "example.com"},
+		{"Without scheme", "example.com/page", "example.com"},
+		{"App referrer", "android-app://com.google.android.gm", "com.google.android.gm"},
+		{"Relative path", "/pricing", ""},
+		{"Empty", "", ""},
+		{"Invalid", "http://[::1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Host(tt.url))
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	c := NewClassifier(DefaultList(), []string{"example.com", "www.example.org"})
+	page := "https://example.com/pricing"
+
+	tests := []struct {
+		name     string
+		referrer string
+		page     string
+		params   utm.Params
+		expected Result
+	}{
+		{
+			name:     "Search engine",
+			referrer: "https://www.google.co.uk/",
+			expected: Result{Host: "google.co.uk", Source: "Google", Channel: ChannelSearch},
+		},
+		{
+			name:     "Subdomain of known host",
+			referrer: "https://l.facebook.com/l.php?u=x",
+			expected: Result{Host: "l.facebook.com", Source: "Facebook", Channel: ChannelSocial},
+		},
+		{
+			name:     "Exact host wins over parent",
+			referrer: "https://mail.google.com/mail/u/0/",
+			expected: Result{Host: "mail.google.com", Source: "Gmail", Channel: ChannelEmail},
+		},
+		{
+			name:     "Unknown host",
+			referrer: "https://blog.partner.io/post",
+			expected: Result{Host: "blog.partner.io", Source: "blog.partner.io", Channel: ChannelReferral},
+		},
+		{
+			name:     "Direct",
+			expected: Result{Channel: ChannelDirect},
+		},
+		{
+			name:     "Configured domain",
+			referrer: "https://docs.example.com/start",
+			expected: Result{Channel: ChannelDirect, Internal: true},
+		},
+		{
+			name:     "Configured domain with www",
+			referrer: "https://example.org/",
+			expected: Result{Channel: ChannelDirect, Internal: true},
+		},
+		{
+			name:     "Page host",
+			referrer: "https://staging.test/",
+			page:     "https://staging.test/pricing",
+			expected: Result{Channel: ChannelDirect, Internal: true},
+		},
+		{
+			name:     "Lookalike domain",
+			referrer: "https://notexample.com/",
+			expected: Result{Host: "notexample.com", Source: "notexample.com", Channel: ChannelReferral},
+		},
+		{
+			name:     "UTM source without referrer",
+			params:   utm.Params{Source: "newsletter"},
+			expected: Result{Source: "newsletter", Channel: ChannelReferral},
+		},
+		{
+			name:     "Email medium",
+			params:   utm.Params{Source: "spring-news", Medium: "Email"},
+			expected: Result{Source: "spring-news", Channel: ChannelEmail},
+		},
+		{
+			name:     "Medium overrides referrer channel",
+			referrer: "https://t.co/abc",
+			params:   utm.Params{Medium: "email"},
+			expected: Result{Host: "t.co", Source: "Twitter", Channel: ChannelEmail},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.page
+			if p == "" {
+				p = page
+			}
+			assert.Equal(t, tt.expected, c.Classify(tt.referrer, p, tt.params))
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	list, err := ParseList([]byte(`{"social": {"Example Social": ["WWW.Social.Example"]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, Source{Name: "Example Social", Channel: ChannelSocial}, list["social.example"])
+
+	_, err = ParseList([]byte(`{"direct": {"X": ["x.com"]}}`))
+	assert.Error(t, err, "Hosts can't be direct")
+
+	_, err = ParseList([]byte(`[`))
+	assert.Error(t, err)
+}
+
+func TestLoadList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sources.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"referral": {"Partner": ["partner.io"]},
+		"social": {"GitHub Discussions": ["github.com"]}
+	}`), 0644))
+
+	list, err := LoadList(path)
+	require.NoError(t, err)
+
+	source, ok := list.Lookup("partner.io")
+	assert.True(t, ok)
+	assert.Equal(t, "Partner", source.Name)
+	assert.Equal(t, ChannelSocial, list["github.com"].Channel, "File entries replace embedded ones")
+	_, ok = list.Lookup("google.com")
+	assert.True(t, ok, "Embedded entries are kept")
+
+	_, err = LoadList(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestValidChannel(t *testing.T) {
+	assert.True(t, ValidChannel(ChannelDirect))
+	assert.True(t, ValidChannel(ChannelEmail))
+	assert.False(t, ValidChannel("paid"))
+}
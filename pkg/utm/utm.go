@@ -0,0 +1,100 @@
+// Package utm extracts campaign attribution parameters from landing page URLs
+package utm
+
+import (
+	"net/url"
+	"strings"
+)
+
+// MaxValueLength caps the length of a stored parameter value
+const MaxValueLength = 256
+
+// Fields name the parameters of Params, without the utm_ prefix
+const (
+	FieldSource   = "source"
+	FieldMedium   = "medium"
+	FieldCampaign = "campaign"
+	FieldTerm     = "term"
+	FieldContent  = "content"
+)
+
+// sourceParams are the query parameters that set Source, in order of
+// precedence. ref and source are used by links that don't follow the UTM
+// convention, such as ?ref=producthunt.
+var sourceParams = []string{"utm_source", "ref", "source"}
+
+// IsSourceParam reports whether the query parameter name sets Source
+func IsSourceParam(name string) bool {
+	for _, p := range sourceParams {
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Params are the UTM parameters of a URL
+type Params struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// Parse returns the UTM parameters in the query string of rawURL, which may
+// be absolute or relative. Unparseable URLs have no parameters.
+func Parse(rawURL string) Params {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Params{}
+	}
+	return FromQuery(u.Query())
+}
+
+// FromQuery returns the UTM parameters in q
+func FromQuery(q url.Values) Params {
+	var p Params
+	for _, name := range sourceParams {
+		if p.Source = clean(q.Get(name)); p.Source != "" {
+			break
+		}
+	}
+	p.Medium = clean(q.Get("utm_medium"))
+	p.Campaign = clean(q.Get("utm_campaign"))
+	p.Term = clean(q.Get("utm_term"))
+	p.Content = clean(q.Get("utm_content"))
+	return p
+}
+
+// IsZero reports whether no parameter is set
+func (p Params) IsZero() bool {
+	return p == Params{}
+}
+
+// Get returns the value of the named field, or an empty string for unknown
+// fields
+func (p Params) Get(field string) string {
+	switch field {
+	case FieldSource:
+		return p.Source
+	case FieldMedium:
+		return p.Medium
+	case FieldCampaign:
+		return p.Campaign
+	case FieldTerm:
+		return p.Term
+	case FieldContent:
+		return p.Content
+	}
+	return ""
+}
+
+// clean trims whitespace and truncates overly long values
+func clean(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) > MaxValueLength {
+		v = strings.ToValidUTF8(v[:MaxValueLength], "")
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package utm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		expected Params
+	}{
+		{
+			name: "All parameters",
+			url:  "https://example.com/?utm_source=news&utm_medium=email&utm_campaign=spring&utm_term=shoes&utm_content=header",
+			expected: Params{
+				Source: "news", Medium: "email", Campaign: "spring", Term: "shoes", Content: "header",
+			},
+		},
+		{
+			name:     "Relative URL",
+			url:      "/pricing?utm_source=twitter&utm_medium=social",
+			expected: Params{Source: "twitter", Medium: "social"},
+		},
+		{
+			name:     "ref alias",
+			url:      "/?ref=producthunt",
+			expected: Params{Source: "producthunt"},
+		},
+		{
+			name:     "source alias",
+			url:      "/?source=partner&utm_campaign=launch",
+			expected: Params{Source: "partner", Campaign: "launch"},
+		},
+		{
+			name:     "utm_source wins over aliases",
+			url:      "/?ref=a&source=b&utm_source=c",
+			expected: Params{Source: "c"},
+		},
+		{
+			name:     "Empty utm_source falls back to alias",
+			url:      "/?utm_source=&ref=a",
+			expected: Params{Source: "a"},
+		},
+		{
+			name:     "Whitespace trimmed",
+			url:      "/?utm_source=%20news%20",
+			expected: Params{Source: "news"},
+		},
+		{"No parameters", "https://example.com/docs?page=2", Params{}},
+		{"Invalid URL", "://bad", Params{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Parse(tt.url))
+		})
+	}
+}
+
+func TestParseTruncatesLongValues(t *testing.T) {
+	p := Parse("/?utm_campaign=" + strings.Repeat("x", MaxValueLength+10))
+	assert.Len(t, p.Campaign, MaxValueLength)
+}
+
+func TestParamsGet(t *testing.T) {
+	p := Params{Source: "s", Medium: "m", Campaign: "c", Term: "t", Content: "x"}
+	assert.Equal(t, "s", p.Get(FieldSource))
+	assert.Equal(t, "m", p.Get(FieldMedium))
+	assert.Equal(t, "c", p.Get(FieldCampaign))
+	assert.Equal(t, "t", p.Get(FieldTerm))
+	assert.Equal(t, "x", p.Get(FieldContent))
+	assert.Equal(t, "", p.Get("unknown"))
+}
+
+func TestParamsIsZero(t *testing.T) {
+	assert.True(t, Params{}.IsZero())
+	assert.False(t, Params{Term: "t"}.IsZero())
+}
+
+func TestIsSourceParam(t *testing.T) {
+	for _, name := range []string{"utm_source", "ref", "source"} {
+		assert.True(t, IsSourceParam(name), name)
+	}
+	assert.False(t, IsSourceParam("utm_medium"))
+	assert.False(t, IsSourceParam("id"))
+}